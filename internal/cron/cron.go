@@ -0,0 +1,251 @@
+// Package cron parses standard cron expressions and finds the times which they match.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How far Next and Prev look for a matching time before giving up, eg. for "0 0 30 2 *".
+const searchDays = 5 * 366
+
+// Descriptors which can be used instead of the five fields.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Range of values allowed in a field, and the names which can be used instead of numbers.
+type bounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = bounds{name: "minute", min: 0, max: 59}
+	hours   = bounds{name: "hour", min: 0, max: 23}
+	days    = bounds{name: "day of month", min: 1, max: 31}
+	months  = bounds{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday can be 0 or 7.
+	weekdays = bounds{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// Schedule of times matched by a cron expression.
+type Schedule struct {
+	minute, hour, day, month, weekday uint64
+
+	// When both the day of month and day of week are restricted, a day matches if either does.
+	anyDay, anyWeekday bool
+}
+
+// Parse a cron expression with five fields (minute, hour, day of month, month and day of week),
+// or one of the descriptors eg. @daily.
+func Parse(spec string) (*Schedule, error) {
+	if expanded, ok := descriptors[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields but found %d: %q", len(fields), spec)
+	}
+
+	var (
+		schedule = &Schedule{
+			anyDay:     strings.HasPrefix(fields[2], "*"),
+			anyWeekday: strings.HasPrefix(fields[4], "*"),
+		}
+		err error
+	)
+
+	for i, field := range []struct {
+		bits   *uint64
+		bounds bounds
+	}{
+		{&schedule.minute, minutes},
+		{&schedule.hour, hours},
+		{&schedule.day, days},
+		{&schedule.month, months},
+		{&schedule.weekday, weekdays},
+	} {
+		*field.bits, err = parseField(fields[i], field.bounds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Sunday is stored as 0.
+	if schedule.weekday&(1<<7) != 0 {
+		schedule.weekday |= 1
+	}
+
+	return schedule, nil
+}
+
+// Helper function to parse a field into the set of values which it matches.
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		var (
+			expression = part
+			step       = 1
+		)
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			value, err := strconv.Atoi(part[i+1:])
+			if err != nil || value <= 0 {
+				return 0, fmt.Errorf("invalid step in %s: %q", b.name, part)
+			}
+
+			expression, step = part[:i], value
+		}
+
+		start, end := b.min, b.max
+
+		if expression != "*" {
+			var err error
+
+			bounds := strings.SplitN(expression, "-", 2)
+
+			start, err = parseValue(bounds[0], b)
+			if err != nil {
+				return 0, err
+			}
+
+			end = start
+
+			if len(bounds) == 2 {
+				end, err = parseValue(bounds[1], b)
+				if err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// eg. "5/15" means every 15 starting at 5.
+				end = b.max
+			}
+
+			if end < start {
+				return 0, fmt.Errorf("invalid range in %s: %q", b.name, part)
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// Helper function to parse a single value, which can be a number or a name.
+func parseValue(value string, b bounds) (int, error) {
+	if number, ok := b.names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < b.min || number > b.max {
+		return 0, fmt.Errorf("invalid %s: %q", b.name, value)
+	}
+
+	return number, nil
+}
+
+// Next returns the first time after t which the schedule matches, or the zero time if it doesn't match
+// anything in the next few years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	start := t.Truncate(time.Minute).Add(time.Minute)
+
+	year, month, day := start.Date()
+
+	for i := 0; i < searchDays; i++ {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, start.Location())
+
+		if !s.matchDate(date) {
+			continue
+		}
+
+		for hour := 0; hour < 24; hour++ {
+			for minute := 0; minute < 60; minute++ {
+				if !s.matchTime(hour, minute) {
+					continue
+				}
+
+				next := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, start.Location())
+				if !next.Before(start) {
+					return next
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// Prev returns the last time at or before t which the schedule matched, or the zero time if it didn't
+// match anything in the last few years.
+func (s *Schedule) Prev(t time.Time) time.Time {
+	end := t.Truncate(time.Minute)
+
+	year, month, day := end.Date()
+
+	for i := 0; i < searchDays; i++ {
+		date := time.Date(year, month, day-i, 0, 0, 0, 0, end.Location())
+
+		if !s.matchDate(date) {
+			continue
+		}
+
+		for hour := 23; hour >= 0; hour-- {
+			for minute := 59; minute >= 0; minute-- {
+				if !s.matchTime(hour, minute) {
+					continue
+				}
+
+				prev := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, end.Location())
+				if !prev.After(end) {
+					return prev
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// Helper function to check if the schedule matches a date.
+func (s *Schedule) matchDate(date time.Time) bool {
+	if s.month&(1<<uint(date.Month())) == 0 {
+		return false
+	}
+
+	var (
+		day     = s.day&(1<<uint(date.Day())) != 0
+		weekday = s.weekday&(1<<uint(date.Weekday())) != 0
+	)
+
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// Helper function to check if the schedule matches a time of day.
+func (s *Schedule) matchTime(hour, minute int) bool {
+	return s.hour&(1<<uint(hour)) != 0 && s.minute&(1<<uint(minute)) != 0
+}
@@ -0,0 +1,76 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Monday 6th January 2020, 10:30 UTC.
+var testNow = time.Date(2020, time.January, 6, 10, 30, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	for spec, want := range map[string]string{
+		"0 8 * *":         `expected 5 fields but found 4: "0 8 * *"`,
+		"60 * * * *":      `invalid minute: "60"`,
+		"0 8-6 * * *":     `invalid range in hour: "8-6"`,
+		"0 8 0 * *":       `invalid day of month: "0"`,
+		"0 8 * foo *":     `invalid month: "foo"`,
+		"*/0 * * * *":     `invalid step in minute: "*/0"`,
+		"0 8 * * mon-fri": "",
+		"@daily":          "",
+	} {
+		_, err := Parse(spec)
+		if want == "" {
+			assert.Nil(t, err, spec)
+		} else {
+			assert.EqualError(t, err, want, spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	for spec, want := range map[string]time.Time{
+		"0 8 * * 1-5":  time.Date(2020, time.January, 7, 8, 0, 0, 0, time.UTC),
+		"30 10 * * *":  time.Date(2020, time.January, 7, 10, 30, 0, 0, time.UTC),
+		"*/15 * * * *": time.Date(2020, time.January, 6, 10, 45, 0, 0, time.UTC),
+		"0 18 * * fri": time.Date(2020, time.January, 10, 18, 0, 0, 0, time.UTC),
+		"0 0 1 * *":    time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":   time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":    time.Date(2020, time.January, 12, 0, 0, 0, 0, time.UTC),
+		// Either the day of month or the day of week.
+		"0 0 15 * sat": time.Date(2020, time.January, 11, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":   {},
+	} {
+		schedule, err := Parse(spec)
+		if assert.Nil(t, err, spec) {
+			assert.Equal(t, want, schedule.Next(testNow), spec)
+		}
+	}
+}
+
+func TestPrev(t *testing.T) {
+	for spec, want := range map[string]time.Time{
+		"0 8 * * 1-5":  time.Date(2020, time.January, 6, 8, 0, 0, 0, time.UTC),
+		"30 10 * * *":  time.Date(2020, time.January, 6, 10, 30, 0, 0, time.UTC),
+		"0 18 * * 1-5": time.Date(2020, time.January, 3, 18, 0, 0, 0, time.UTC),
+		"@yearly":      time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":   {},
+	} {
+		schedule, err := Parse(spec)
+		if assert.Nil(t, err, spec) {
+			assert.Equal(t, want, schedule.Prev(testNow), spec)
+		}
+	}
+}
+
+func TestLocation(t *testing.T) {
+	location := time.FixedZone("AEDT", 11*60*60)
+
+	schedule, err := Parse("0 8 * * *")
+	assert.Nil(t, err)
+
+	// 10:30 UTC is 21:30 in Sydney.
+	assert.Equal(t, time.Date(2020, time.January, 7, 8, 0, 0, 0, location), schedule.Next(testNow.In(location)))
+}
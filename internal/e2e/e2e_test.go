@@ -0,0 +1,291 @@
+package e2e
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
+	"github.com/previousnext/k8s-aws-efs/internal/emulator"
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner"
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+const (
+	// Name of the provisioner under test.
+	provisionerName = "efs.aws.skpr.io/generalPurpose"
+	// How long to wait for the controller to act.
+	timeout = 10 * time.Second
+)
+
+// Environment which runs the provision controller against the EFS emulator.
+type environment struct {
+	clientset kubernetes.Interface
+	cloud     *mock.Client
+}
+
+// Helper function to start the EFS emulator, provisioner and provision controller.
+func setup(t *testing.T, stop chan struct{}, objects ...runtime.Object) *environment {
+	params := mock.DefaultParams()
+	params.FileSystemCreateDelay = 5 * time.Second
+	params.MountTargetCreateDelay = 5 * time.Second
+
+	cloud := mock.New(mock.WithParams(params))
+
+	// Simulated time passes much faster than the wall clock.
+	go wait.Until(func() {
+		cloud.Advance(time.Second)
+	}, 10*time.Millisecond, stop)
+
+	server := httptest.NewServer(emulator.New(cloud))
+	go func() {
+		<-stop
+		server.Close()
+	}()
+
+	client := efsclient.New(efs.New(session.Must(session.NewSession(awsConfig(server.URL)))), efsclient.Params{
+		RateLimit:  100,
+		RateBurst:  100,
+		MaxRetries: 3,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+
+	clientset := fake.NewSimpleClientset(objects...)
+
+	p, err := provisioner.New(client, provisioner.Params{
+		Region:        "ap-southeast-2",
+		Format:        "{{ .PVC.ObjectMeta.Namespace }}-{{ .PVName }}",
+		Performance:   efs.PerformanceModeGeneralPurpose,
+		SecurityGroup: "sg-xxxxxxxx",
+		Subnets: []string{
+			"subnet-xxxxxxxx",
+			"subnet-yyyyyyyy",
+		},
+		PollInterval: 10 * time.Millisecond,
+		WaitTimeout:  time.Minute,
+	}, provisioner.WithName(provisionerName), provisioner.WithKubernetes(clientset))
+	assert.Nil(t, err)
+
+	go p.Run(stop)
+
+	// The controller can't be stopped, so every test gets its own clientset.
+	pc := controller.NewProvisionController(clientset, provisionerName, p, "v1.17.0",
+		controller.LeaderElection(false),
+		controller.ResyncPeriod(time.Second),
+		controller.CreateProvisionedPVInterval(10*time.Millisecond),
+	)
+
+	go pc.Run(stop)
+
+	return &environment{
+		clientset: clientset,
+		cloud:     cloud,
+	}
+}
+
+// Helper function to release a volume and change its reclaim policy, so the controller deletes it.
+//
+// Volumes are retained until an administrator changes the reclaim policy, and are only
+// deleted once they have been released by their claim.
+func (e *environment) release(t *testing.T, volume *corev1.PersistentVolume) {
+	volume.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimDelete
+	volume.Status.Phase = corev1.VolumeReleased
+
+	_, err := e.clientset.CoreV1().PersistentVolumes().Update(volume)
+	assert.Nil(t, err)
+}
+
+// Helper function to return the AWS config which points the SDK at the emulator.
+func awsConfig(endpoint string) *aws.Config {
+	return aws.NewConfig().
+		WithEndpoint(endpoint).
+		WithRegion("ap-southeast-2").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithMaxRetries(0)
+}
+
+// Helper function to return a storage class which uses the provisioner under test.
+func storageClass() *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "efs",
+		},
+		Provisioner: provisionerName,
+	}
+}
+
+// Helper function to return a claim for the storage class.
+func claim(namespace, name string) *corev1.PersistentVolumeClaim {
+	class := "efs"
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       types.UID(namespace + "-" + name),
+			Annotations: map[string]string{
+				"volume.beta.kubernetes.io/storage-provisioner": provisionerName,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &class,
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteMany,
+			},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse("1Gi"),
+				},
+			},
+		},
+	}
+}
+
+// Helper function to wait for the volume provisioned for a claim.
+func (e *environment) waitForVolume(t *testing.T) *corev1.PersistentVolume {
+	var volume *corev1.PersistentVolume
+
+	err := wait.PollImmediate(10*time.Millisecond, timeout, func() (bool, error) {
+		list, err := e.clientset.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if len(list.Items) == 0 {
+			return false, nil
+		}
+
+		volume = &list.Items[0]
+
+		return true, nil
+	})
+	assert.Nil(t, err, "volume was not provisioned")
+
+	return volume
+}
+
+func TestProvisionAndDelete(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	env := setup(t, stop, storageClass(), claim("test", "data"))
+
+	volume := env.waitForVolume(t)
+	if volume == nil {
+		return
+	}
+
+	// The volume is bound to the claim which requested it.
+	if assert.NotNil(t, volume.Spec.ClaimRef) {
+		assert.Equal(t, "test", volume.Spec.ClaimRef.Namespace)
+		assert.Equal(t, "data", volume.Spec.ClaimRef.Name)
+		assert.Equal(t, "test-data", string(volume.Spec.ClaimRef.UID))
+	}
+
+	assert.Equal(t, "efs", volume.Spec.StorageClassName)
+	assert.Equal(t, provisionerName, volume.Annotations["pv.kubernetes.io/provisioned-by"])
+	assert.Equal(t, corev1.PersistentVolumeReclaimRetain, volume.Spec.PersistentVolumeReclaimPolicy)
+
+	if assert.NotNil(t, volume.Spec.NFS) {
+		assert.Equal(t, "fs-00000001.efs.ap-southeast-2.amazonaws.com", volume.Spec.NFS.Server)
+	}
+
+	// The filesystem and its mount targets were created through the emulator.
+	fs, ok := env.cloud.FileSystem("fs-00000001")
+	if assert.True(t, ok) {
+		assert.Equal(t, efs.LifeCycleStateAvailable, fs.State)
+	}
+
+	targets := env.cloud.MountTargets("fs-00000001")
+	assert.Len(t, targets, 2)
+
+	for _, target := range targets {
+		assert.Equal(t, efs.LifeCycleStateAvailable, target.State)
+	}
+
+	env.release(t, volume)
+
+	err := wait.PollImmediate(10*time.Millisecond, timeout, func() (bool, error) {
+		_, err := env.clientset.CoreV1().PersistentVolumes().Get(volume.Name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return true, nil
+		}
+
+		return false, err
+	})
+	assert.Nil(t, err, "volume was not deleted")
+}
+
+func TestDeleteProtected(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			Annotations: map[string]string{
+				provisioner.AnnotationProtect: "true",
+			},
+		},
+	}
+
+	env := setup(t, stop, storageClass(), namespace, claim("test", "data"))
+
+	volume := env.waitForVolume(t)
+	if volume == nil {
+		return
+	}
+
+	env.release(t, volume)
+
+	// Give the controller a chance to (incorrectly) delete the volume.
+	time.Sleep(100 * time.Millisecond)
+
+	_, err := env.clientset.CoreV1().PersistentVolumes().Get(volume.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, env.cloud.CallCount("DeleteFileSystem"))
+	assert.Equal(t, 0, env.cloud.CallCount("DeleteMountTarget"))
+}
+
+func TestProvisionIgnoresOtherProvisioners(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	other := claim("test", "other")
+	other.Annotations["volume.beta.kubernetes.io/storage-provisioner"] = "efs.aws.skpr.io/maxIO"
+
+	env := setup(t, stop, storageClass(), other, claim("test", "data"))
+
+	volume := env.waitForVolume(t)
+	if volume == nil {
+		return
+	}
+
+	assert.Equal(t, "data", volume.Spec.ClaimRef.Name)
+
+	// Give the controller a chance to (incorrectly) provision the other claim.
+	time.Sleep(100 * time.Millisecond)
+
+	list, err := env.clientset.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, 1, env.cloud.CallCount("CreateFileSystem"))
+}
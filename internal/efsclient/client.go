@@ -0,0 +1,128 @@
+package efsclient
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/efs/efsiface"
+	"github.com/golang/glog"
+	"golang.org/x/time/rate"
+)
+
+// Every method is wrapped rather than embedding the EFS API, so that none of them skip the rate limit and retries.
+var _ efsiface.EFSAPI = &Client{}
+
+// Client which wraps the EFS API with a client side rate limit and retries.
+type Client struct {
+	client  efsiface.EFSAPI
+	params  Params
+	limiter *rate.Limiter
+}
+
+// Params for rate limiting and retrying requests.
+type Params struct {
+	RateLimit  float64       `envconfig:"AWS_RATE_LIMIT"  default:"5"`
+	RateBurst  int           `envconfig:"AWS_RATE_BURST"  default:"10"`
+	MaxRetries int           `envconfig:"AWS_MAX_RETRIES" default:"8"`
+	MinBackoff time.Duration `envconfig:"AWS_MIN_BACKOFF" default:"500ms"`
+	MaxBackoff time.Duration `envconfig:"AWS_MAX_BACKOFF" default:"30s"`
+}
+
+// New client which rate limits and retries requests to the EFS API.
+//
+// The rate limit is shared by every request made through this client, so it
+// should be the only client used to talk to EFS.
+func New(client efsiface.EFSAPI, params Params) *Client {
+	return &Client{
+		client:  client,
+		params:  params,
+		limiter: rate.NewLimiter(rate.Limit(params.RateLimit), params.RateBurst),
+	}
+}
+
+// Helper function to run a request, retrying with backoff if it fails with a retryable error.
+func (c *Client) do(operation string, request func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := c.limiter.Wait(context.Background())
+		if err != nil {
+			return err
+		}
+
+		err = request()
+		if err == nil {
+			return nil
+		}
+
+		if ClassifyOperation(operation, err) != ClassRetryable || attempt >= c.params.MaxRetries {
+			return err
+		}
+
+		delay := c.backoff(attempt)
+
+		glog.V(2).Infof("Retrying request in %s after error: %s", delay, err)
+
+		time.Sleep(delay)
+	}
+}
+
+// Wrap another AWS service client eg. EC2 or CloudWatch, so that every request it sends is rate limited and
+// retried in the same way as requests to EFS. The service gets a rate limit of its own.
+func Wrap(service *client.Client, params Params) {
+	c := New(nil, params)
+
+	service.Handlers.Sign.PushFront(c.wait)
+	service.Retryer = retryer{c}
+}
+
+// Helper function to apply the rate limit and retries to a request which is sent by the SDK, eg. when paging.
+func (c *Client) option(r *request.Request) {
+	// Requests are signed before every attempt.
+	r.Handlers.Sign.PushFront(c.wait)
+	r.Retryer = retryer{c}
+}
+
+// Helper function to wait for the rate limit before a request is sent by the SDK.
+func (c *Client) wait(r *request.Request) {
+	err := c.limiter.Wait(r.Context())
+	if err != nil {
+		r.Error = err
+	}
+}
+
+// Retries requests sent by the SDK in the same way as the requests made by the client.
+type retryer struct {
+	client *Client
+}
+
+// RetryRules returns the backoff before a request is retried.
+func (r retryer) RetryRules(req *request.Request) time.Duration {
+	return r.client.backoff(req.RetryCount)
+}
+
+// ShouldRetry if the request failed with a retryable error.
+func (r retryer) ShouldRetry(req *request.Request) bool {
+	return ClassifyOperation(req.Operation.Name, req.Error) == ClassRetryable
+}
+
+// MaxRetries of a request.
+func (r retryer) MaxRetries() int {
+	return r.client.params.MaxRetries
+}
+
+// Helper function to return an exponential backoff with "full jitter" for an attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.params.MinBackoff << uint(attempt)
+
+	if delay <= 0 || delay > c.params.MaxBackoff {
+		delay = c.params.MaxBackoff
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)))
+}
@@ -0,0 +1,204 @@
+package efsclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/aws/aws-sdk-go/service/efs/efsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// Stub which fails a number of times before succeeding.
+type stub struct {
+	efsiface.EFSAPI
+	errs  []error
+	calls int
+}
+
+func (s *stub) DescribeFileSystems(input *efs.DescribeFileSystemsInput) (*efs.DescribeFileSystemsOutput, error) {
+	s.calls++
+
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, err
+	}
+
+	return &efs.DescribeFileSystemsOutput{}, nil
+}
+
+func (s *stub) UpdateFileSystem(input *efs.UpdateFileSystemInput) (*efs.UpdateFileSystemOutput, error) {
+	s.calls++
+
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, err
+	}
+
+	return &efs.UpdateFileSystemOutput{}, nil
+}
+
+func testParams() Params {
+	return Params{
+		RateLimit:  1000,
+		RateBurst:  1000,
+		MaxRetries: 3,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond * 5,
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	s := &stub{
+		errs: []error{
+			awserr.New("ThrottlingException", "Rate exceeded", nil),
+			awserr.New(efs.ErrCodeTooManyRequests, "Too many requests", nil),
+		},
+	}
+
+	_, err := New(s, testParams()).DescribeFileSystems(&efs.DescribeFileSystemsInput{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, s.calls)
+}
+
+func TestClientMaxRetries(t *testing.T) {
+	s := &stub{}
+
+	for i := 0; i < 10; i++ {
+		s.errs = append(s.errs, awserr.New("ThrottlingException", "Rate exceeded", nil))
+	}
+
+	_, err := New(s, testParams()).DescribeFileSystems(&efs.DescribeFileSystemsInput{})
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, s.calls)
+}
+
+func TestClientTerminal(t *testing.T) {
+	s := &stub{
+		errs: []error{
+			awserr.New(efs.ErrCodeSubnetNotFound, "Subnet not found", nil),
+		},
+	}
+
+	_, err := New(s, testParams()).DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		FileSystemId: aws.String("fs-xxxxxxxx"),
+	})
+	assert.True(t, IsCode(err, efs.ErrCodeSubnetNotFound))
+	assert.Equal(t, 1, s.calls)
+}
+
+func TestClientUpdateTooManyRequests(t *testing.T) {
+	s := &stub{
+		errs: []error{
+			awserr.New(efs.ErrCodeTooManyRequests, "Throughput can't be decreased yet", nil),
+		},
+	}
+
+	// The throughput can't be decreased again for hours, so there is no point retrying.
+	_, err := New(s, testParams()).UpdateFileSystem(&efs.UpdateFileSystemInput{
+		FileSystemId: aws.String("fs-xxxxxxxx"),
+	})
+	assert.True(t, IsCode(err, efs.ErrCodeTooManyRequests))
+	assert.Equal(t, 1, s.calls)
+}
+
+func TestClientPagesRetries(t *testing.T) {
+	var calls int
+
+	// Throttles the first request, so the SDK has to retry it.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		if calls == 1 {
+			w.Header().Set("X-Amzn-ErrorType", "ThrottlingException")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"message": "Rate exceeded"}`)
+
+			return
+		}
+
+		fmt.Fprint(w, `{"FileSystems": []}`)
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("ap-southeast-2"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+
+	var pages int
+
+	err := New(efs.New(sess), testParams()).DescribeFileSystemsPages(&efs.DescribeFileSystemsInput{}, func(*efs.DescribeFileSystemsOutput, bool) bool {
+		pages++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pages)
+	assert.Equal(t, 2, calls)
+}
+
+func TestWrap(t *testing.T) {
+	var calls int
+
+	// Throttles the first request, so it has to be retried.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>RequestLimitExceeded</Code><Message>Request limit exceeded.</Message></Error></Errors></Response>`)
+
+			return
+		}
+
+		fmt.Fprint(w, `<DescribeSecurityGroupsResponse><securityGroupInfo></securityGroupInfo></DescribeSecurityGroupsResponse>`)
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("ap-southeast-2"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+
+	client := ec2.New(sess)
+	Wrap(client.Client, testParams())
+
+	_, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestClassify(t *testing.T) {
+	assert.Equal(t, ClassNone, Classify(nil))
+	assert.Equal(t, ClassRetryable, Classify(awserr.New("ThrottlingException", "", nil)))
+	assert.Equal(t, ClassRetryable, Classify(awserr.New(efs.ErrCodeInternalServerError, "", nil)))
+	assert.Equal(t, ClassAlreadyExists, Classify(awserr.New(efs.ErrCodeFileSystemAlreadyExists, "", nil)))
+	assert.Equal(t, ClassAlreadyExists, Classify(awserr.New(efs.ErrCodeMountTargetConflict, "", nil)))
+	assert.Equal(t, ClassTerminal, Classify(awserr.New(efs.ErrCodeFileSystemLimitExceeded, "", nil)))
+	assert.Equal(t, ClassTerminal, Classify(errors.New("unknown")))
+
+	assert.Equal(t, ClassRetryable, ClassifyOperation("DescribeFileSystems", awserr.New(efs.ErrCodeTooManyRequests, "", nil)))
+	assert.Equal(t, ClassTerminal, ClassifyOperation("UpdateFileSystem", awserr.New(efs.ErrCodeTooManyRequests, "", nil)))
+	assert.Equal(t, ClassRetryable, ClassifyOperation("UpdateFileSystem", awserr.New("ThrottlingException", "", nil)))
+}
+
+func TestMessage(t *testing.T) {
+	assert.Equal(t, "failed", Message(errors.New("failed")))
+	assert.Equal(t, "FileSystemNotFound: not found", Message(awserr.New(efs.ErrCodeFileSystemNotFound, "not found", nil)))
+	assert.Contains(t, Message(awserr.New(efs.ErrCodeFileSystemLimitExceeded, "limit", nil)), "request a limit increase")
+}
@@ -0,0 +1,124 @@
+package efsclient
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// Class of an error returned by the EFS API.
+type Class string
+
+const (
+	// ClassNone is returned when there is no error.
+	ClassNone Class = ""
+
+	// ClassRetryable is returned for errors which may succeed if the request is tried again eg. throttling.
+	ClassRetryable Class = "Retryable"
+
+	// ClassAlreadyExists is returned for errors which show the resource being created already exists.
+	ClassAlreadyExists Class = "AlreadyExists"
+
+	// ClassTerminal is returned for errors which will not succeed without someone taking action.
+	ClassTerminal Class = "Terminal"
+)
+
+// Error codes which are worth retrying, in addition to the ones the AWS SDK considers retryable.
+var retryable = map[string]bool{
+	efs.ErrCodeTooManyRequests:                   true,
+	efs.ErrCodeInternalServerError:               true,
+	efs.ErrCodeDependencyTimeout:                 true,
+	efs.ErrCodeIncorrectFileSystemLifeCycleState: true,
+	efs.ErrCodeIncorrectMountTargetState:         true,
+}
+
+// Error codes which are terminal for an operation, even though they are retryable for others.
+var terminal = map[string]map[string]bool{
+	// UpdateFileSystem fails with TooManyRequests while the throughput can't be decreased yet, which lasts hours.
+	"UpdateFileSystem": {
+		efs.ErrCodeTooManyRequests: true,
+	},
+}
+
+// Error codes which show that the resource being created already exists.
+var alreadyExists = map[string]bool{
+	efs.ErrCodeFileSystemAlreadyExists:  true,
+	efs.ErrCodeAccessPointAlreadyExists: true,
+	efs.ErrCodeMountTargetConflict:      true,
+}
+
+// Actionable messages for terminal errors.
+var messages = map[string]string{
+	efs.ErrCodeFileSystemLimitExceeded:        "The AWS account has reached its limit of EFS filesystems. Remove unused filesystems or request a limit increase.",
+	efs.ErrCodeSubnetNotFound:                 "A configured subnet does not exist. Check the subnets the provisioner is configured with.",
+	efs.ErrCodeSecurityGroupNotFound:          "A configured security group does not exist. Check the security groups the provisioner is configured with.",
+	efs.ErrCodeSecurityGroupLimitExceeded:     "Too many security groups have been configured for a mount target (the maximum is 5).",
+	efs.ErrCodeNoFreeAddressesInSubnet:        "A subnet has no free IP addresses for a mount target. Free up addresses or configure a different subnet.",
+	efs.ErrCodeNetworkInterfaceLimitExceeded:  "The AWS account has reached its limit of network interfaces. Request a limit increase.",
+	efs.ErrCodeUnsupportedAvailabilityZone:    "EFS is not available in the availability zone of a configured subnet. Remove the subnet from the configuration.",
+	efs.ErrCodeThroughputLimitExceeded:        "The requested throughput exceeds the limit for the filesystem.",
+	efs.ErrCodeInsufficientThroughputCapacity: "AWS does not have enough capacity to provision the requested throughput. Try again later or request less throughput.",
+	efs.ErrCodeIpAddressInUse:                 "The IP address requested for a mount target is already in use.",
+	efs.ErrCodeBadRequest:                     "The request was rejected by EFS. Check the provisioner and storage class configuration.",
+	efs.ErrCodeInvalidPolicyException:         "The filesystem policy is invalid. Check the policy configuration.",
+	"AccessDeniedException":                   "The provisioner does not have permission to perform this action. Check its IAM policy.",
+	"UnrecognizedClientException":             "The provisioner's AWS credentials are invalid.",
+}
+
+// Classify an error returned by the EFS API.
+func Classify(err error) Class {
+	if err == nil {
+		return ClassNone
+	}
+
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return ClassTerminal
+	}
+
+	if alreadyExists[aerr.Code()] {
+		return ClassAlreadyExists
+	}
+
+	if retryable[aerr.Code()] || request.IsErrorThrottle(err) || request.IsErrorRetryable(err) {
+		return ClassRetryable
+	}
+
+	return ClassTerminal
+}
+
+// ClassifyOperation classifies an error returned by an operation of the EFS API eg. "UpdateFileSystem".
+func ClassifyOperation(operation string, err error) Class {
+	if aerr, ok := err.(awserr.Error); ok && terminal[operation][aerr.Code()] {
+		return ClassTerminal
+	}
+
+	return Classify(err)
+}
+
+// IsCode returns true if the error has the AWS error code.
+func IsCode(err error, code string) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == code
+	}
+
+	return false
+}
+
+// Message describes an error, including the AWS error code and what can be done about it.
+func Message(err error) string {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err.Error()
+	}
+
+	message := fmt.Sprintf("%s: %s", aerr.Code(), aerr.Message())
+
+	if advice, ok := messages[aerr.Code()]; ok {
+		message = fmt.Sprintf("%s %s", message, advice)
+	}
+
+	return message
+}
@@ -0,0 +1,576 @@
+package efsclient
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// CreateAccessPoint with rate limiting and retries.
+func (c *Client) CreateAccessPoint(input *efs.CreateAccessPointInput) (output *efs.CreateAccessPointOutput, err error) {
+	err = c.do("CreateAccessPoint", func() error {
+		output, err = c.client.CreateAccessPoint(input)
+		return err
+	})
+
+	return output, err
+}
+
+// CreateAccessPointWithContext with rate limiting and retries.
+func (c *Client) CreateAccessPointWithContext(ctx aws.Context, input *efs.CreateAccessPointInput, options ...request.Option) (*efs.CreateAccessPointOutput, error) {
+	return c.client.CreateAccessPointWithContext(ctx, input, append(options, c.option)...)
+}
+
+// CreateAccessPointRequest with rate limiting and retries applied to the request.
+func (c *Client) CreateAccessPointRequest(input *efs.CreateAccessPointInput) (*request.Request, *efs.CreateAccessPointOutput) {
+	req, output := c.client.CreateAccessPointRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// CreateFileSystem with rate limiting and retries.
+func (c *Client) CreateFileSystem(input *efs.CreateFileSystemInput) (output *efs.FileSystemDescription, err error) {
+	err = c.do("CreateFileSystem", func() error {
+		output, err = c.client.CreateFileSystem(input)
+		return err
+	})
+
+	return output, err
+}
+
+// CreateFileSystemWithContext with rate limiting and retries.
+func (c *Client) CreateFileSystemWithContext(ctx aws.Context, input *efs.CreateFileSystemInput, options ...request.Option) (*efs.FileSystemDescription, error) {
+	return c.client.CreateFileSystemWithContext(ctx, input, append(options, c.option)...)
+}
+
+// CreateFileSystemRequest with rate limiting and retries applied to the request.
+func (c *Client) CreateFileSystemRequest(input *efs.CreateFileSystemInput) (*request.Request, *efs.FileSystemDescription) {
+	req, output := c.client.CreateFileSystemRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// CreateMountTarget with rate limiting and retries.
+func (c *Client) CreateMountTarget(input *efs.CreateMountTargetInput) (output *efs.MountTargetDescription, err error) {
+	err = c.do("CreateMountTarget", func() error {
+		output, err = c.client.CreateMountTarget(input)
+		return err
+	})
+
+	return output, err
+}
+
+// CreateMountTargetWithContext with rate limiting and retries.
+func (c *Client) CreateMountTargetWithContext(ctx aws.Context, input *efs.CreateMountTargetInput, options ...request.Option) (*efs.MountTargetDescription, error) {
+	return c.client.CreateMountTargetWithContext(ctx, input, append(options, c.option)...)
+}
+
+// CreateMountTargetRequest with rate limiting and retries applied to the request.
+func (c *Client) CreateMountTargetRequest(input *efs.CreateMountTargetInput) (*request.Request, *efs.MountTargetDescription) {
+	req, output := c.client.CreateMountTargetRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// CreateTags with rate limiting and retries.
+func (c *Client) CreateTags(input *efs.CreateTagsInput) (output *efs.CreateTagsOutput, err error) {
+	err = c.do("CreateTags", func() error {
+		output, err = c.client.CreateTags(input)
+		return err
+	})
+
+	return output, err
+}
+
+// CreateTagsWithContext with rate limiting and retries.
+func (c *Client) CreateTagsWithContext(ctx aws.Context, input *efs.CreateTagsInput, options ...request.Option) (*efs.CreateTagsOutput, error) {
+	return c.client.CreateTagsWithContext(ctx, input, append(options, c.option)...)
+}
+
+// CreateTagsRequest with rate limiting and retries applied to the request.
+func (c *Client) CreateTagsRequest(input *efs.CreateTagsInput) (*request.Request, *efs.CreateTagsOutput) {
+	req, output := c.client.CreateTagsRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DeleteAccessPoint with rate limiting and retries.
+func (c *Client) DeleteAccessPoint(input *efs.DeleteAccessPointInput) (output *efs.DeleteAccessPointOutput, err error) {
+	err = c.do("DeleteAccessPoint", func() error {
+		output, err = c.client.DeleteAccessPoint(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DeleteAccessPointWithContext with rate limiting and retries.
+func (c *Client) DeleteAccessPointWithContext(ctx aws.Context, input *efs.DeleteAccessPointInput, options ...request.Option) (*efs.DeleteAccessPointOutput, error) {
+	return c.client.DeleteAccessPointWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DeleteAccessPointRequest with rate limiting and retries applied to the request.
+func (c *Client) DeleteAccessPointRequest(input *efs.DeleteAccessPointInput) (*request.Request, *efs.DeleteAccessPointOutput) {
+	req, output := c.client.DeleteAccessPointRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DeleteFileSystem with rate limiting and retries.
+func (c *Client) DeleteFileSystem(input *efs.DeleteFileSystemInput) (output *efs.DeleteFileSystemOutput, err error) {
+	err = c.do("DeleteFileSystem", func() error {
+		output, err = c.client.DeleteFileSystem(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DeleteFileSystemWithContext with rate limiting and retries.
+func (c *Client) DeleteFileSystemWithContext(ctx aws.Context, input *efs.DeleteFileSystemInput, options ...request.Option) (*efs.DeleteFileSystemOutput, error) {
+	return c.client.DeleteFileSystemWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DeleteFileSystemRequest with rate limiting and retries applied to the request.
+func (c *Client) DeleteFileSystemRequest(input *efs.DeleteFileSystemInput) (*request.Request, *efs.DeleteFileSystemOutput) {
+	req, output := c.client.DeleteFileSystemRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DeleteFileSystemPolicy with rate limiting and retries.
+func (c *Client) DeleteFileSystemPolicy(input *efs.DeleteFileSystemPolicyInput) (output *efs.DeleteFileSystemPolicyOutput, err error) {
+	err = c.do("DeleteFileSystemPolicy", func() error {
+		output, err = c.client.DeleteFileSystemPolicy(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DeleteFileSystemPolicyWithContext with rate limiting and retries.
+func (c *Client) DeleteFileSystemPolicyWithContext(ctx aws.Context, input *efs.DeleteFileSystemPolicyInput, options ...request.Option) (*efs.DeleteFileSystemPolicyOutput, error) {
+	return c.client.DeleteFileSystemPolicyWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DeleteFileSystemPolicyRequest with rate limiting and retries applied to the request.
+func (c *Client) DeleteFileSystemPolicyRequest(input *efs.DeleteFileSystemPolicyInput) (*request.Request, *efs.DeleteFileSystemPolicyOutput) {
+	req, output := c.client.DeleteFileSystemPolicyRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DeleteMountTarget with rate limiting and retries.
+func (c *Client) DeleteMountTarget(input *efs.DeleteMountTargetInput) (output *efs.DeleteMountTargetOutput, err error) {
+	err = c.do("DeleteMountTarget", func() error {
+		output, err = c.client.DeleteMountTarget(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DeleteMountTargetWithContext with rate limiting and retries.
+func (c *Client) DeleteMountTargetWithContext(ctx aws.Context, input *efs.DeleteMountTargetInput, options ...request.Option) (*efs.DeleteMountTargetOutput, error) {
+	return c.client.DeleteMountTargetWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DeleteMountTargetRequest with rate limiting and retries applied to the request.
+func (c *Client) DeleteMountTargetRequest(input *efs.DeleteMountTargetInput) (*request.Request, *efs.DeleteMountTargetOutput) {
+	req, output := c.client.DeleteMountTargetRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DeleteTags with rate limiting and retries.
+func (c *Client) DeleteTags(input *efs.DeleteTagsInput) (output *efs.DeleteTagsOutput, err error) {
+	err = c.do("DeleteTags", func() error {
+		output, err = c.client.DeleteTags(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DeleteTagsWithContext with rate limiting and retries.
+func (c *Client) DeleteTagsWithContext(ctx aws.Context, input *efs.DeleteTagsInput, options ...request.Option) (*efs.DeleteTagsOutput, error) {
+	return c.client.DeleteTagsWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DeleteTagsRequest with rate limiting and retries applied to the request.
+func (c *Client) DeleteTagsRequest(input *efs.DeleteTagsInput) (*request.Request, *efs.DeleteTagsOutput) {
+	req, output := c.client.DeleteTagsRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DescribeAccessPoints with rate limiting and retries.
+func (c *Client) DescribeAccessPoints(input *efs.DescribeAccessPointsInput) (output *efs.DescribeAccessPointsOutput, err error) {
+	err = c.do("DescribeAccessPoints", func() error {
+		output, err = c.client.DescribeAccessPoints(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DescribeAccessPointsWithContext with rate limiting and retries.
+func (c *Client) DescribeAccessPointsWithContext(ctx aws.Context, input *efs.DescribeAccessPointsInput, options ...request.Option) (*efs.DescribeAccessPointsOutput, error) {
+	return c.client.DescribeAccessPointsWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DescribeAccessPointsRequest with rate limiting and retries applied to the request.
+func (c *Client) DescribeAccessPointsRequest(input *efs.DescribeAccessPointsInput) (*request.Request, *efs.DescribeAccessPointsOutput) {
+	req, output := c.client.DescribeAccessPointsRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DescribeAccessPointsPages with rate limiting and retries for each page.
+func (c *Client) DescribeAccessPointsPages(input *efs.DescribeAccessPointsInput, fn func(*efs.DescribeAccessPointsOutput, bool) bool) error {
+	return c.DescribeAccessPointsPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// DescribeAccessPointsPagesWithContext with rate limiting and retries for each page.
+func (c *Client) DescribeAccessPointsPagesWithContext(ctx aws.Context, input *efs.DescribeAccessPointsInput, fn func(*efs.DescribeAccessPointsOutput, bool) bool, options ...request.Option) error {
+	return c.client.DescribeAccessPointsPagesWithContext(ctx, input, fn, append(options, c.option)...)
+}
+
+// DescribeFileSystemPolicy with rate limiting and retries.
+func (c *Client) DescribeFileSystemPolicy(input *efs.DescribeFileSystemPolicyInput) (output *efs.DescribeFileSystemPolicyOutput, err error) {
+	err = c.do("DescribeFileSystemPolicy", func() error {
+		output, err = c.client.DescribeFileSystemPolicy(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DescribeFileSystemPolicyWithContext with rate limiting and retries.
+func (c *Client) DescribeFileSystemPolicyWithContext(ctx aws.Context, input *efs.DescribeFileSystemPolicyInput, options ...request.Option) (*efs.DescribeFileSystemPolicyOutput, error) {
+	return c.client.DescribeFileSystemPolicyWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DescribeFileSystemPolicyRequest with rate limiting and retries applied to the request.
+func (c *Client) DescribeFileSystemPolicyRequest(input *efs.DescribeFileSystemPolicyInput) (*request.Request, *efs.DescribeFileSystemPolicyOutput) {
+	req, output := c.client.DescribeFileSystemPolicyRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DescribeFileSystems with rate limiting and retries.
+func (c *Client) DescribeFileSystems(input *efs.DescribeFileSystemsInput) (output *efs.DescribeFileSystemsOutput, err error) {
+	err = c.do("DescribeFileSystems", func() error {
+		output, err = c.client.DescribeFileSystems(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DescribeFileSystemsWithContext with rate limiting and retries.
+func (c *Client) DescribeFileSystemsWithContext(ctx aws.Context, input *efs.DescribeFileSystemsInput, options ...request.Option) (*efs.DescribeFileSystemsOutput, error) {
+	return c.client.DescribeFileSystemsWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DescribeFileSystemsRequest with rate limiting and retries applied to the request.
+func (c *Client) DescribeFileSystemsRequest(input *efs.DescribeFileSystemsInput) (*request.Request, *efs.DescribeFileSystemsOutput) {
+	req, output := c.client.DescribeFileSystemsRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DescribeFileSystemsPages with rate limiting and retries for each page.
+func (c *Client) DescribeFileSystemsPages(input *efs.DescribeFileSystemsInput, fn func(*efs.DescribeFileSystemsOutput, bool) bool) error {
+	return c.DescribeFileSystemsPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// DescribeFileSystemsPagesWithContext with rate limiting and retries for each page.
+func (c *Client) DescribeFileSystemsPagesWithContext(ctx aws.Context, input *efs.DescribeFileSystemsInput, fn func(*efs.DescribeFileSystemsOutput, bool) bool, options ...request.Option) error {
+	return c.client.DescribeFileSystemsPagesWithContext(ctx, input, fn, append(options, c.option)...)
+}
+
+// DescribeLifecycleConfiguration with rate limiting and retries.
+func (c *Client) DescribeLifecycleConfiguration(input *efs.DescribeLifecycleConfigurationInput) (output *efs.DescribeLifecycleConfigurationOutput, err error) {
+	err = c.do("DescribeLifecycleConfiguration", func() error {
+		output, err = c.client.DescribeLifecycleConfiguration(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DescribeLifecycleConfigurationWithContext with rate limiting and retries.
+func (c *Client) DescribeLifecycleConfigurationWithContext(ctx aws.Context, input *efs.DescribeLifecycleConfigurationInput, options ...request.Option) (*efs.DescribeLifecycleConfigurationOutput, error) {
+	return c.client.DescribeLifecycleConfigurationWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DescribeLifecycleConfigurationRequest with rate limiting and retries applied to the request.
+func (c *Client) DescribeLifecycleConfigurationRequest(input *efs.DescribeLifecycleConfigurationInput) (*request.Request, *efs.DescribeLifecycleConfigurationOutput) {
+	req, output := c.client.DescribeLifecycleConfigurationRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DescribeMountTargetSecurityGroups with rate limiting and retries.
+func (c *Client) DescribeMountTargetSecurityGroups(input *efs.DescribeMountTargetSecurityGroupsInput) (output *efs.DescribeMountTargetSecurityGroupsOutput, err error) {
+	err = c.do("DescribeMountTargetSecurityGroups", func() error {
+		output, err = c.client.DescribeMountTargetSecurityGroups(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DescribeMountTargetSecurityGroupsWithContext with rate limiting and retries.
+func (c *Client) DescribeMountTargetSecurityGroupsWithContext(ctx aws.Context, input *efs.DescribeMountTargetSecurityGroupsInput, options ...request.Option) (*efs.DescribeMountTargetSecurityGroupsOutput, error) {
+	return c.client.DescribeMountTargetSecurityGroupsWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DescribeMountTargetSecurityGroupsRequest with rate limiting and retries applied to the request.
+func (c *Client) DescribeMountTargetSecurityGroupsRequest(input *efs.DescribeMountTargetSecurityGroupsInput) (*request.Request, *efs.DescribeMountTargetSecurityGroupsOutput) {
+	req, output := c.client.DescribeMountTargetSecurityGroupsRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DescribeMountTargets with rate limiting and retries.
+func (c *Client) DescribeMountTargets(input *efs.DescribeMountTargetsInput) (output *efs.DescribeMountTargetsOutput, err error) {
+	err = c.do("DescribeMountTargets", func() error {
+		output, err = c.client.DescribeMountTargets(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DescribeMountTargetsWithContext with rate limiting and retries.
+func (c *Client) DescribeMountTargetsWithContext(ctx aws.Context, input *efs.DescribeMountTargetsInput, options ...request.Option) (*efs.DescribeMountTargetsOutput, error) {
+	return c.client.DescribeMountTargetsWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DescribeMountTargetsRequest with rate limiting and retries applied to the request.
+func (c *Client) DescribeMountTargetsRequest(input *efs.DescribeMountTargetsInput) (*request.Request, *efs.DescribeMountTargetsOutput) {
+	req, output := c.client.DescribeMountTargetsRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DescribeTags with rate limiting and retries.
+func (c *Client) DescribeTags(input *efs.DescribeTagsInput) (output *efs.DescribeTagsOutput, err error) {
+	err = c.do("DescribeTags", func() error {
+		output, err = c.client.DescribeTags(input)
+		return err
+	})
+
+	return output, err
+}
+
+// DescribeTagsWithContext with rate limiting and retries.
+func (c *Client) DescribeTagsWithContext(ctx aws.Context, input *efs.DescribeTagsInput, options ...request.Option) (*efs.DescribeTagsOutput, error) {
+	return c.client.DescribeTagsWithContext(ctx, input, append(options, c.option)...)
+}
+
+// DescribeTagsRequest with rate limiting and retries applied to the request.
+func (c *Client) DescribeTagsRequest(input *efs.DescribeTagsInput) (*request.Request, *efs.DescribeTagsOutput) {
+	req, output := c.client.DescribeTagsRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// DescribeTagsPages with rate limiting and retries for each page.
+func (c *Client) DescribeTagsPages(input *efs.DescribeTagsInput, fn func(*efs.DescribeTagsOutput, bool) bool) error {
+	return c.DescribeTagsPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// DescribeTagsPagesWithContext with rate limiting and retries for each page.
+func (c *Client) DescribeTagsPagesWithContext(ctx aws.Context, input *efs.DescribeTagsInput, fn func(*efs.DescribeTagsOutput, bool) bool, options ...request.Option) error {
+	return c.client.DescribeTagsPagesWithContext(ctx, input, fn, append(options, c.option)...)
+}
+
+// ListTagsForResource with rate limiting and retries.
+func (c *Client) ListTagsForResource(input *efs.ListTagsForResourceInput) (output *efs.ListTagsForResourceOutput, err error) {
+	err = c.do("ListTagsForResource", func() error {
+		output, err = c.client.ListTagsForResource(input)
+		return err
+	})
+
+	return output, err
+}
+
+// ListTagsForResourceWithContext with rate limiting and retries.
+func (c *Client) ListTagsForResourceWithContext(ctx aws.Context, input *efs.ListTagsForResourceInput, options ...request.Option) (*efs.ListTagsForResourceOutput, error) {
+	return c.client.ListTagsForResourceWithContext(ctx, input, append(options, c.option)...)
+}
+
+// ListTagsForResourceRequest with rate limiting and retries applied to the request.
+func (c *Client) ListTagsForResourceRequest(input *efs.ListTagsForResourceInput) (*request.Request, *efs.ListTagsForResourceOutput) {
+	req, output := c.client.ListTagsForResourceRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// ListTagsForResourcePages with rate limiting and retries for each page.
+func (c *Client) ListTagsForResourcePages(input *efs.ListTagsForResourceInput, fn func(*efs.ListTagsForResourceOutput, bool) bool) error {
+	return c.ListTagsForResourcePagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// ListTagsForResourcePagesWithContext with rate limiting and retries for each page.
+func (c *Client) ListTagsForResourcePagesWithContext(ctx aws.Context, input *efs.ListTagsForResourceInput, fn func(*efs.ListTagsForResourceOutput, bool) bool, options ...request.Option) error {
+	return c.client.ListTagsForResourcePagesWithContext(ctx, input, fn, append(options, c.option)...)
+}
+
+// ModifyMountTargetSecurityGroups with rate limiting and retries.
+func (c *Client) ModifyMountTargetSecurityGroups(input *efs.ModifyMountTargetSecurityGroupsInput) (output *efs.ModifyMountTargetSecurityGroupsOutput, err error) {
+	err = c.do("ModifyMountTargetSecurityGroups", func() error {
+		output, err = c.client.ModifyMountTargetSecurityGroups(input)
+		return err
+	})
+
+	return output, err
+}
+
+// ModifyMountTargetSecurityGroupsWithContext with rate limiting and retries.
+func (c *Client) ModifyMountTargetSecurityGroupsWithContext(ctx aws.Context, input *efs.ModifyMountTargetSecurityGroupsInput, options ...request.Option) (*efs.ModifyMountTargetSecurityGroupsOutput, error) {
+	return c.client.ModifyMountTargetSecurityGroupsWithContext(ctx, input, append(options, c.option)...)
+}
+
+// ModifyMountTargetSecurityGroupsRequest with rate limiting and retries applied to the request.
+func (c *Client) ModifyMountTargetSecurityGroupsRequest(input *efs.ModifyMountTargetSecurityGroupsInput) (*request.Request, *efs.ModifyMountTargetSecurityGroupsOutput) {
+	req, output := c.client.ModifyMountTargetSecurityGroupsRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// PutFileSystemPolicy with rate limiting and retries.
+func (c *Client) PutFileSystemPolicy(input *efs.PutFileSystemPolicyInput) (output *efs.PutFileSystemPolicyOutput, err error) {
+	err = c.do("PutFileSystemPolicy", func() error {
+		output, err = c.client.PutFileSystemPolicy(input)
+		return err
+	})
+
+	return output, err
+}
+
+// PutFileSystemPolicyWithContext with rate limiting and retries.
+func (c *Client) PutFileSystemPolicyWithContext(ctx aws.Context, input *efs.PutFileSystemPolicyInput, options ...request.Option) (*efs.PutFileSystemPolicyOutput, error) {
+	return c.client.PutFileSystemPolicyWithContext(ctx, input, append(options, c.option)...)
+}
+
+// PutFileSystemPolicyRequest with rate limiting and retries applied to the request.
+func (c *Client) PutFileSystemPolicyRequest(input *efs.PutFileSystemPolicyInput) (*request.Request, *efs.PutFileSystemPolicyOutput) {
+	req, output := c.client.PutFileSystemPolicyRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// PutLifecycleConfiguration with rate limiting and retries.
+func (c *Client) PutLifecycleConfiguration(input *efs.PutLifecycleConfigurationInput) (output *efs.PutLifecycleConfigurationOutput, err error) {
+	err = c.do("PutLifecycleConfiguration", func() error {
+		output, err = c.client.PutLifecycleConfiguration(input)
+		return err
+	})
+
+	return output, err
+}
+
+// PutLifecycleConfigurationWithContext with rate limiting and retries.
+func (c *Client) PutLifecycleConfigurationWithContext(ctx aws.Context, input *efs.PutLifecycleConfigurationInput, options ...request.Option) (*efs.PutLifecycleConfigurationOutput, error) {
+	return c.client.PutLifecycleConfigurationWithContext(ctx, input, append(options, c.option)...)
+}
+
+// PutLifecycleConfigurationRequest with rate limiting and retries applied to the request.
+func (c *Client) PutLifecycleConfigurationRequest(input *efs.PutLifecycleConfigurationInput) (*request.Request, *efs.PutLifecycleConfigurationOutput) {
+	req, output := c.client.PutLifecycleConfigurationRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// TagResource with rate limiting and retries.
+func (c *Client) TagResource(input *efs.TagResourceInput) (output *efs.TagResourceOutput, err error) {
+	err = c.do("TagResource", func() error {
+		output, err = c.client.TagResource(input)
+		return err
+	})
+
+	return output, err
+}
+
+// TagResourceWithContext with rate limiting and retries.
+func (c *Client) TagResourceWithContext(ctx aws.Context, input *efs.TagResourceInput, options ...request.Option) (*efs.TagResourceOutput, error) {
+	return c.client.TagResourceWithContext(ctx, input, append(options, c.option)...)
+}
+
+// TagResourceRequest with rate limiting and retries applied to the request.
+func (c *Client) TagResourceRequest(input *efs.TagResourceInput) (*request.Request, *efs.TagResourceOutput) {
+	req, output := c.client.TagResourceRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// UntagResource with rate limiting and retries.
+func (c *Client) UntagResource(input *efs.UntagResourceInput) (output *efs.UntagResourceOutput, err error) {
+	err = c.do("UntagResource", func() error {
+		output, err = c.client.UntagResource(input)
+		return err
+	})
+
+	return output, err
+}
+
+// UntagResourceWithContext with rate limiting and retries.
+func (c *Client) UntagResourceWithContext(ctx aws.Context, input *efs.UntagResourceInput, options ...request.Option) (*efs.UntagResourceOutput, error) {
+	return c.client.UntagResourceWithContext(ctx, input, append(options, c.option)...)
+}
+
+// UntagResourceRequest with rate limiting and retries applied to the request.
+func (c *Client) UntagResourceRequest(input *efs.UntagResourceInput) (*request.Request, *efs.UntagResourceOutput) {
+	req, output := c.client.UntagResourceRequest(input)
+	c.option(req)
+
+	return req, output
+}
+
+// UpdateFileSystem with rate limiting and retries.
+func (c *Client) UpdateFileSystem(input *efs.UpdateFileSystemInput) (output *efs.UpdateFileSystemOutput, err error) {
+	err = c.do("UpdateFileSystem", func() error {
+		output, err = c.client.UpdateFileSystem(input)
+		return err
+	})
+
+	return output, err
+}
+
+// UpdateFileSystemWithContext with rate limiting and retries.
+func (c *Client) UpdateFileSystemWithContext(ctx aws.Context, input *efs.UpdateFileSystemInput, options ...request.Option) (*efs.UpdateFileSystemOutput, error) {
+	return c.client.UpdateFileSystemWithContext(ctx, input, append(options, c.option)...)
+}
+
+// UpdateFileSystemRequest with rate limiting and retries applied to the request.
+func (c *Client) UpdateFileSystemRequest(input *efs.UpdateFileSystemInput) (*request.Request, *efs.UpdateFileSystemOutput) {
+	req, output := c.client.UpdateFileSystemRequest(input)
+	c.option(req)
+
+	return req, output
+}
@@ -0,0 +1,248 @@
+package emulator
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/aws/aws-sdk-go/service/efs/efsiface"
+	"github.com/golang/glog"
+)
+
+// Route which maps a request to an EFS operation.
+type route struct {
+	method    string
+	path      []string
+	operation string
+	status    int
+}
+
+// Routes for the EFS REST API (version 2015-02-01), copied from the AWS SDK.
+var routes = []route{
+	newRoute("POST", "/2015-02-01/access-points", "CreateAccessPoint", http.StatusOK),
+	newRoute("GET", "/2015-02-01/access-points", "DescribeAccessPoints", http.StatusOK),
+	newRoute("DELETE", "/2015-02-01/access-points/{AccessPointId}", "DeleteAccessPoint", http.StatusNoContent),
+	newRoute("POST", "/2015-02-01/file-systems", "CreateFileSystem", http.StatusCreated),
+	newRoute("GET", "/2015-02-01/file-systems", "DescribeFileSystems", http.StatusOK),
+	newRoute("PUT", "/2015-02-01/file-systems/{FileSystemId}", "UpdateFileSystem", http.StatusAccepted),
+	newRoute("DELETE", "/2015-02-01/file-systems/{FileSystemId}", "DeleteFileSystem", http.StatusNoContent),
+	newRoute("PUT", "/2015-02-01/file-systems/{FileSystemId}/policy", "PutFileSystemPolicy", http.StatusOK),
+	newRoute("GET", "/2015-02-01/file-systems/{FileSystemId}/policy", "DescribeFileSystemPolicy", http.StatusOK),
+	newRoute("DELETE", "/2015-02-01/file-systems/{FileSystemId}/policy", "DeleteFileSystemPolicy", http.StatusOK),
+	newRoute("PUT", "/2015-02-01/file-systems/{FileSystemId}/lifecycle-configuration", "PutLifecycleConfiguration", http.StatusOK),
+	newRoute("GET", "/2015-02-01/file-systems/{FileSystemId}/lifecycle-configuration", "DescribeLifecycleConfiguration", http.StatusOK),
+	newRoute("POST", "/2015-02-01/mount-targets", "CreateMountTarget", http.StatusOK),
+	newRoute("GET", "/2015-02-01/mount-targets", "DescribeMountTargets", http.StatusOK),
+	newRoute("DELETE", "/2015-02-01/mount-targets/{MountTargetId}", "DeleteMountTarget", http.StatusNoContent),
+	newRoute("GET", "/2015-02-01/mount-targets/{MountTargetId}/security-groups", "DescribeMountTargetSecurityGroups", http.StatusOK),
+	newRoute("PUT", "/2015-02-01/mount-targets/{MountTargetId}/security-groups", "ModifyMountTargetSecurityGroups", http.StatusNoContent),
+	newRoute("POST", "/2015-02-01/create-tags/{FileSystemId}", "CreateTags", http.StatusNoContent),
+	newRoute("POST", "/2015-02-01/delete-tags/{FileSystemId}", "DeleteTags", http.StatusNoContent),
+	newRoute("GET", "/2015-02-01/tags/{FileSystemId}/", "DescribeTags", http.StatusOK),
+	newRoute("POST", "/2015-02-01/resource-tags/{ResourceId}", "TagResource", http.StatusOK),
+	newRoute("DELETE", "/2015-02-01/resource-tags/{ResourceId}", "UntagResource", http.StatusOK),
+	newRoute("GET", "/2015-02-01/resource-tags/{ResourceId}", "ListTagsForResource", http.StatusOK),
+}
+
+// Status codes returned by AWS for each error code. Anything else is a bad request.
+var statuses = map[string]int{
+	efs.ErrCodeAccessPointAlreadyExists:          http.StatusConflict,
+	efs.ErrCodeAccessPointLimitExceeded:          http.StatusForbidden,
+	efs.ErrCodeAccessPointNotFound:               http.StatusNotFound,
+	efs.ErrCodeDependencyTimeout:                 http.StatusGatewayTimeout,
+	efs.ErrCodeFileSystemAlreadyExists:           http.StatusConflict,
+	efs.ErrCodeFileSystemInUse:                   http.StatusConflict,
+	efs.ErrCodeFileSystemLimitExceeded:           http.StatusForbidden,
+	efs.ErrCodeFileSystemNotFound:                http.StatusNotFound,
+	efs.ErrCodeIncorrectFileSystemLifeCycleState: http.StatusConflict,
+	efs.ErrCodeIncorrectMountTargetState:         http.StatusConflict,
+	efs.ErrCodeInternalServerError:               http.StatusInternalServerError,
+	efs.ErrCodeMountTargetConflict:               http.StatusConflict,
+	efs.ErrCodeMountTargetNotFound:               http.StatusNotFound,
+	efs.ErrCodeNetworkInterfaceLimitExceeded:     http.StatusConflict,
+	efs.ErrCodeNoFreeAddressesInSubnet:           http.StatusConflict,
+	efs.ErrCodePolicyNotFound:                    http.StatusNotFound,
+	efs.ErrCodeTooManyRequests:                   http.StatusTooManyRequests,
+}
+
+// Helper function to declare a route.
+func newRoute(method, path, operation string, status int) route {
+	return route{
+		method:    method,
+		path:      strings.Split(path, "/"),
+		operation: operation,
+		status:    status,
+	}
+}
+
+// Helper function to match a request path against a route, returning the path parameters.
+func (r route) match(method string, path []string) (map[string]string, bool) {
+	if r.method != method || len(r.path) != len(path) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+
+	for i, segment := range r.path {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params[strings.Trim(segment, "{}")] = path[i]
+			continue
+		}
+
+		if segment != path[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// Emulator which serves the EFS REST API from another implementation of the API,
+// so the AWS SDK can be pointed at it using a custom endpoint.
+type Emulator struct {
+	client efsiface.EFSAPI
+}
+
+// New emulator backed by a client eg. the in memory mock.
+func New(client efsiface.EFSAPI) *Emulator {
+	return &Emulator{
+		client: client,
+	}
+}
+
+// ServeHTTP decodes a request into the input of an EFS operation, calls the operation
+// and encodes the output (or error) the same way AWS does.
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(r.URL.Path, "/")
+
+	for _, route := range routes {
+		params, ok := route.match(r.Method, path)
+		if !ok {
+			continue
+		}
+
+		glog.V(2).Infof("%s %s: %s", r.Method, r.URL.Path, route.operation)
+
+		method := reflect.ValueOf(e.client).MethodByName(route.operation)
+		input := reflect.New(method.Type().In(0).Elem())
+
+		err := bind(input.Interface(), r, params)
+		if err != nil {
+			writeError(w, awserr.New(efs.ErrCodeBadRequest, err.Error(), nil))
+			return
+		}
+
+		results := method.Call([]reflect.Value{input})
+		if err, ok := results[1].Interface().(error); ok && err != nil {
+			writeError(w, err)
+			return
+		}
+
+		body, err := jsonutil.BuildJSON(results[0].Interface())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(route.status)
+
+		if route.status != http.StatusNoContent {
+			w.Write(body)
+		}
+
+		return
+	}
+
+	writeError(w, awserr.New(efs.ErrCodeBadRequest, fmt.Sprintf("Unknown operation: %s %s", r.Method, r.URL.Path), nil))
+}
+
+// Helper function to populate an operation input from the request path, query string and body.
+func bind(input interface{}, r *http.Request, params map[string]string) error {
+	var body bytes.Buffer
+
+	_, err := body.ReadFrom(r.Body)
+	if err != nil {
+		return err
+	}
+
+	err = jsonutil.UnmarshalJSON(input, &body)
+	if err != nil {
+		return fmt.Errorf("failed to decode body: %w", err)
+	}
+
+	value := reflect.ValueOf(input).Elem()
+	query := r.URL.Query()
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := field.Tag.Get("locationName")
+
+		var raw string
+
+		switch field.Tag.Get("location") {
+		case "uri":
+			raw = params[name]
+		case "querystring":
+			raw = query.Get(name)
+		default:
+			continue
+		}
+
+		if raw == "" {
+			continue
+		}
+
+		switch field.Type {
+		case reflect.TypeOf((*string)(nil)):
+			value.Field(i).Set(reflect.ValueOf(&raw))
+		case reflect.TypeOf((*int64)(nil)):
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s is not a number: %s", name, raw)
+			}
+
+			value.Field(i).Set(reflect.ValueOf(&n))
+		default:
+			return fmt.Errorf("unsupported parameter type for %s: %s", name, field.Type)
+		}
+	}
+
+	return nil
+}
+
+// Helper function to write an error in the format expected by the AWS SDK.
+func writeError(w http.ResponseWriter, err error) {
+	code := efs.ErrCodeInternalServerError
+	message := err.Error()
+
+	if aerr, ok := err.(awserr.Error); ok {
+		code = aerr.Code()
+		message = aerr.Message()
+	}
+
+	status, ok := statuses[code]
+	if !ok {
+		status = http.StatusBadRequest
+	}
+
+	body, _ := jsonutil.BuildJSON(&struct {
+		ErrorCode *string `locationName:"ErrorCode" type:"string"`
+		Message   *string `locationName:"Message" type:"string"`
+	}{
+		ErrorCode: &code,
+		Message:   &message,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-Errortype", code)
+	w.Header().Set("X-Amzn-Errormessage", message)
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,87 @@
+package emulator
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+func TestEmulator(t *testing.T) {
+	server := httptest.NewServer(New(mock.New()))
+	defer server.Close()
+
+	client := efs.New(session.Must(session.NewSession(aws.NewConfig().
+		WithEndpoint(server.URL).
+		WithRegion("ap-southeast-2").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")).
+		WithMaxRetries(0))))
+
+	fs, err := client.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+		Tags: []*efs.Tag{
+			{Key: aws.String("Name"), Value: aws.String("test")},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "fs-00000001", *fs.FileSystemId)
+	assert.Equal(t, "test", *fs.Name)
+	assert.NotNil(t, fs.CreationTime)
+
+	describe, err := client.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.Nil(t, err)
+	assert.Len(t, describe.FileSystems, 1)
+	assert.Equal(t, efs.LifeCycleStateAvailable, *describe.FileSystems[0].LifeCycleState)
+
+	target, err := client.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId:   fs.FileSystemId,
+		SubnetId:       aws.String("subnet-xxxxxxxx"),
+		SecurityGroups: aws.StringSlice([]string{"sg-xxxxxxxx"}),
+	})
+	assert.Nil(t, err)
+
+	groups, err := client.DescribeMountTargetSecurityGroups(&efs.DescribeMountTargetSecurityGroupsInput{
+		MountTargetId: target.MountTargetId,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sg-xxxxxxxx"}, aws.StringValueSlice(groups.SecurityGroups))
+
+	tags, err := client.DescribeTags(&efs.DescribeTagsInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, tags.Tags, 1)
+
+	// Errors are returned with the same codes as AWS.
+	_, err = client.DeleteFileSystem(&efs.DeleteFileSystemInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assert.Equal(t, efs.ErrCodeFileSystemInUse, err.(awserr.Error).Code())
+
+	_, err = client.DeleteMountTarget(&efs.DeleteMountTargetInput{
+		MountTargetId: target.MountTargetId,
+	})
+	assert.Nil(t, err)
+
+	_, err = client.DeleteFileSystem(&efs.DeleteFileSystemInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assert.Nil(t, err)
+
+	_, err = client.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, efs.ErrCodeFileSystemNotFound, err.(awserr.Error).Code())
+		assert.Equal(t, "File system 'fs-00000001' does not exist.", err.(awserr.Error).Message())
+	}
+}
@@ -0,0 +1,262 @@
+package provisioner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// TagKeyAutoscaled is the tag on a filesystem which records when the autoscaler switched it to provisioned throughput.
+const TagKeyAutoscaled = "efs.aws.skpr.io/autoscaled"
+
+const (
+	// Actions reported by the AutoscaleActionsTotal metric.
+	autoscaleProvisioned = "provisioned"
+	autoscaleBursting    = "bursting"
+	autoscaleDeferred    = "deferred"
+)
+
+// Number of bytes in a GiB.
+const gibibyte = 1 << 30
+
+// BurstCredits of a filesystem, from its BurstCreditBalance and PermittedThroughput metrics.
+type BurstCredits struct {
+	// Balance of burst credits in bytes.
+	Balance float64
+	// PermittedThroughput in bytes per second.
+	PermittedThroughput float64
+}
+
+// MetricsSource returns the latest burst credits of filesystems.
+type MetricsSource interface {
+	// BurstCredits of a filesystem, or false if it doesn't have any metrics yet.
+	BurstCredits(id string) (BurstCredits, bool, error)
+}
+
+// StaticMetrics is a MetricsSource which returns the burst credits it was given, eg. for local development.
+type StaticMetrics struct {
+	mu      sync.Mutex
+	credits map[string]BurstCredits
+}
+
+// NewStaticMetrics returns a MetricsSource without any metrics.
+func NewStaticMetrics() *StaticMetrics {
+	return &StaticMetrics{
+		credits: make(map[string]BurstCredits),
+	}
+}
+
+// Set the burst credits of a filesystem.
+func (m *StaticMetrics) Set(id string, credits BurstCredits) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.credits[id] = credits
+}
+
+// BurstCredits of a filesystem, or false if they haven't been set.
+func (m *StaticMetrics) BurstCredits(id string) (BurstCredits, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credits, ok := m.credits[id]
+
+	return credits, ok, nil
+}
+
+// Autoscale switches bursting filesystems to provisioned throughput before they run out of burst credits,
+// and switches them back once their credits have recovered.
+func (p *Provisioner) Autoscale() error {
+	// Filesystems which no longer exist shouldn't keep reporting their credits.
+	FilesystemBurstCreditBalance.Reset()
+	FilesystemPermittedThroughput.Reset()
+
+	// Claims are listed once per pass, to check which filesystems have their throughput set by their claim.
+	var claims *volumeClaims
+
+	if p.kubernetes != nil {
+		var err error
+
+		claims, err = p.listClaims()
+		if err != nil {
+			return err
+		}
+	}
+
+	var failed []string
+
+	for _, fs := range p.cache.Filesystems() {
+		if aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateAvailable {
+			continue
+		}
+
+		err := p.autoscale(fs, claims)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", aws.StringValue(fs.FileSystemId), err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to autoscale %d filesystems: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Helper function to autoscale the throughput of a single filesystem.
+func (p *Provisioner) autoscale(fs *efs.FileSystemDescription, claims *volumeClaims) error {
+	id := aws.StringValue(fs.FileSystemId)
+
+	credits, ok, err := p.metrics.BurstCredits(id)
+	if err != nil {
+		return fmt.Errorf("failed to get burst credits: %s", err)
+	}
+
+	if !ok {
+		return nil
+	}
+
+	FilesystemBurstCreditBalance.WithLabelValues(id).Set(credits.Balance)
+	FilesystemPermittedThroughput.WithLabelValues(id).Set(credits.PermittedThroughput)
+
+	var (
+		current       = currentThroughput(fs)
+		_, autoscaled = tagValue(fs.Tags, TagKeyAutoscaled)
+		balance       = resource.NewQuantity(int64(credits.Balance), resource.BinarySI)
+		low           = p.params.AutoscaleLowCredits * gibibyte
+		high          = p.params.AutoscaleHighCredits * gibibyte
+		desired       Throughput
+	)
+
+	switch {
+	case current.Mode == efs.ThroughputModeBursting && credits.Balance < low:
+		desired = Throughput{Mode: efs.ThroughputModeProvisioned, Provisioned: p.params.AutoscaleThroughput}
+	case current.Mode == efs.ThroughputModeProvisioned && autoscaled && credits.Balance >= high:
+		desired = Throughput{Mode: efs.ThroughputModeBursting}
+	case current.Mode == efs.ThroughputModeBursting && autoscaled && credits.Balance >= high:
+		// The filesystem was tagged, but never switched to provisioned throughput.
+		return p.untagAutoscaled(id)
+	default:
+		return nil
+	}
+
+	// Schedules and StorageClasses which set the throughput of a filesystem take precedence.
+	if throughputManaged(fs, claims) {
+		return nil
+	}
+
+	claim := claimReference(fs)
+
+	// The tag is written first, so a filesystem is never left on provisioned throughput without it.
+	if desired.Mode == efs.ThroughputModeProvisioned && !autoscaled {
+		_, err = p.client.TagResource(&efs.TagResourceInput{
+			ResourceId: aws.String(id),
+			Tags: []*efs.Tag{
+				{
+					Key:   aws.String(TagKeyAutoscaled),
+					Value: aws.String(p.now().UTC().Format(time.RFC3339)),
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record that throughput was autoscaled: %s", err)
+		}
+	}
+
+	changed, err := p.updateThroughput(fs, desired)
+	if _, ok := err.(*QuotaError); ok {
+		// The claim has already been warned about the quota.
+		glog.Infof("Not switching filesystem %s to %s: %s", id, desired, err)
+		return nil
+	}
+	if _, ok := err.(*ThroughputDeferredError); ok {
+		AutoscaleActionsTotal.WithLabelValues(autoscaleDeferred).Inc()
+
+		// Running out of credits needs attention, while switching back can wait for the next pass.
+		if desired.Mode == efs.ThroughputModeProvisioned {
+			p.event(claim, corev1.EventTypeWarning, EventReasonBurstCreditsLow, "Filesystem %s has %s of burst credits left, but can't be switched to %s: %s", id, balance, desired, err)
+		} else {
+			glog.Infof("Not switching filesystem %s back to %s: %s", id, desired, err)
+		}
+
+		return nil
+	}
+	if err != nil {
+		p.event(claim, corev1.EventTypeWarning, EventReasonThroughputFailed, "Failed to change throughput of filesystem %s to %s: %s", id, desired, err)
+		return fmt.Errorf("failed to update throughput: %s", err)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if desired.Mode == efs.ThroughputModeProvisioned {
+		glog.Infof("Switched filesystem %s to %s as its burst credits fell to %s", id, desired, balance)
+
+		AutoscaleActionsTotal.WithLabelValues(autoscaleProvisioned).Inc()
+
+		p.event(claim, corev1.EventTypeNormal, EventReasonThroughputAutoscaled, "Switched filesystem %s to %s as its burst credits fell to %s", id, desired, balance)
+
+		return nil
+	}
+
+	err = p.untagAutoscaled(id)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Switched filesystem %s back to %s as its burst credits recovered to %s", id, desired, balance)
+
+	AutoscaleActionsTotal.WithLabelValues(autoscaleBursting).Inc()
+
+	p.event(claim, corev1.EventTypeNormal, EventReasonThroughputAutoscaled, "Switched filesystem %s back to %s as its burst credits recovered to %s", id, desired, balance)
+
+	return nil
+}
+
+// Helper function to record that the throughput of a filesystem is no longer autoscaled.
+func (p *Provisioner) untagAutoscaled(id string) error {
+	_, err := p.client.UntagResource(&efs.UntagResourceInput{
+		ResourceId: aws.String(id),
+		TagKeys:    []*string{aws.String(TagKeyAutoscaled)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record that throughput is no longer autoscaled: %s", err)
+	}
+
+	return nil
+}
+
+// Helper function to check if the throughput of a filesystem is set by the schedule or StorageClass of its claim.
+func throughputManaged(fs *efs.FileSystemDescription, claims *volumeClaims) bool {
+	if claims == nil {
+		return false
+	}
+
+	pvc := claims.Filesystem(fs)
+	if pvc == nil {
+		return false
+	}
+
+	class := claims.StorageClass(pvc)
+
+	// Invalid schedules still show that the claim wants to manage its throughput.
+	if schedule, err := throughputSchedule(pvc, class); schedule != nil || err != nil {
+		return true
+	}
+
+	if class != nil {
+		if _, ok := class.Parameters[ParameterThroughputPerTiB]; ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,227 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return params which autoscale throughput.
+func testAutoscaleParams() Params {
+	params := testParams()
+	params.Autoscale = true
+	params.AutoscaleLowCredits = 512
+	params.AutoscaleHighCredits = 1024
+	params.AutoscaleThroughput = 100
+
+	return params
+}
+
+func TestNewAutoscale(t *testing.T) {
+	_, err := New(mock.New(), testAutoscaleParams())
+	assert.EqualError(t, err, "a metrics source is required to autoscale throughput")
+
+	params := testAutoscaleParams()
+	params.AutoscaleLowCredits = 2048
+
+	_, err = New(mock.New(), params, WithMetrics(NewStaticMetrics()))
+	assert.EqualError(t, err, "the low burst credit threshold must be below the high threshold")
+
+	params = testAutoscaleParams()
+	params.AutoscaleThroughput = 0
+
+	_, err = New(mock.New(), params, WithMetrics(NewStaticMetrics()))
+	assert.EqualError(t, err, "autoscaled throughput must be between 1 and 1024 MiB/s")
+}
+
+func TestAutoscale(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	var (
+		metrics  = NewStaticMetrics()
+		recorder = record.NewFakeRecorder(100)
+	)
+
+	provisioner, err := New(client, testAutoscaleParams(), WithMetrics(metrics), WithRecorder(recorder), WithClock(client.Clock().Now))
+	assert.Nil(t, err)
+
+	// Helper function to refresh the cache and autoscale.
+	autoscale := func() {
+		err := provisioner.cache.Refresh()
+		assert.Nil(t, err)
+
+		err = provisioner.Autoscale()
+		assert.Nil(t, err)
+	}
+
+	// Nothing happens until there are metrics.
+	autoscale()
+	assert.Empty(t, testEvents(recorder))
+
+	metrics.Set(id, BurstCredits{Balance: 1024 * gibibyte, PermittedThroughput: 100 * 1024 * 1024})
+
+	autoscale()
+	assert.Empty(t, testEvents(recorder))
+
+	metrics.Set(id, BurstCredits{Balance: 100 * gibibyte, PermittedThroughput: 100 * 1024 * 1024})
+
+	autoscale()
+	assert.Equal(t, []string{
+		"Normal ThroughputAutoscaled Switched filesystem fs-00000001 to provisioned (100 MiB/s) as its burst credits fell to 100Gi",
+	}, testEvents(recorder))
+
+	fs, _ := client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeProvisioned, fs.ThroughputMode)
+	assert.Equal(t, float64(100), fs.ProvisionedThroughput)
+	assert.Contains(t, fs.Tags, mock.Tag{Key: TagKeyAutoscaled, Value: "2020-01-01T00:00:00Z"})
+
+	// Switching to provisioned throughput changed the mode, so it can't be switched back for a day.
+	metrics.Set(id, BurstCredits{Balance: 2000 * gibibyte, PermittedThroughput: 100 * 1024 * 1024})
+
+	autoscale()
+	assert.Empty(t, testEvents(recorder))
+
+	fs, _ = client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeProvisioned, fs.ThroughputMode)
+
+	client.Advance(ThroughputDecreaseCooldown + time.Minute)
+
+	autoscale()
+	assert.Equal(t, []string{
+		"Normal ThroughputAutoscaled Switched filesystem fs-00000001 back to bursting as its burst credits recovered to 2000Gi",
+	}, testEvents(recorder))
+
+	fs, _ = client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeBursting, fs.ThroughputMode)
+	assert.NotContains(t, fs.Tags, mock.Tag{Key: TagKeyAutoscaled, Value: "2020-01-01T00:00:00Z"})
+
+	// Running out of credits again so soon can't be fixed, so the claim is warned instead.
+	metrics.Set(id, BurstCredits{Balance: 100 * gibibyte, PermittedThroughput: 100 * 1024 * 1024})
+
+	autoscale()
+	assert.Equal(t, []string{
+		"Warning BurstCreditsLow Filesystem fs-00000001 has 100Gi of burst credits left, but can't be switched to provisioned (100 MiB/s): throughput can't be decreased or switched between modes until 2020-01-03T00:01:00Z",
+	}, testEvents(recorder))
+}
+
+func TestAutoscaleManaged(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	// Throughput which was provisioned by someone else is left alone.
+	testClaimFilesystem(t, client, "namespace", "provisioned", 10)
+
+	kubernetes := fake.NewSimpleClientset(testClaim("namespace", "test", map[string]string{
+		AnnotationThroughputSchedule: "0 8 * * * 100; 0 18 * * * bursting",
+	}))
+
+	metrics := NewStaticMetrics()
+
+	provisioner, err := New(client, testAutoscaleParams(), WithMetrics(metrics), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	for _, fs := range provisioner.cache.Filesystems() {
+		metrics.Set(*fs.FileSystemId, BurstCredits{Balance: 2000 * gibibyte})
+	}
+
+	// The schedule of the claim takes precedence.
+	metrics.Set(id, BurstCredits{Balance: 0})
+
+	err = provisioner.Autoscale()
+	assert.Nil(t, err)
+
+	for _, described := range provisioner.cache.Filesystems() {
+		fs, _ := client.FileSystem(*described.FileSystemId)
+
+		if fs.ID == id {
+			assert.Equal(t, efs.ThroughputModeBursting, fs.ThroughputMode)
+		} else {
+			assert.Equal(t, efs.ThroughputModeProvisioned, fs.ThroughputMode)
+		}
+	}
+}
+
+func TestAutoscaleTagsFirst(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	metrics := NewStaticMetrics()
+
+	provisioner, err := New(client, testAutoscaleParams(), WithMetrics(metrics), WithClock(client.Clock().Now))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	metrics.Set(id, BurstCredits{Balance: 100 * gibibyte})
+
+	// Throughput isn't switched unless the filesystem could be tagged, otherwise it would never be switched back.
+	client.FailNext("TagResource", awserr.New(efs.ErrCodeInternalServerError, "Internal error", nil))
+
+	err = provisioner.Autoscale()
+	assert.EqualError(t, err, "failed to autoscale 1 filesystems: fs-00000001: failed to record that throughput was autoscaled: InternalServerError: Internal error")
+	assert.Equal(t, 0, client.CallCount("UpdateFileSystem"))
+
+	// A filesystem which was tagged but couldn't be switched is untagged once its credits recover.
+	client.FailNext("UpdateFileSystem", awserr.New(efs.ErrCodeInternalServerError, "Internal error", nil))
+
+	err = provisioner.Autoscale()
+	assert.Error(t, err)
+
+	fs, _ := client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeBursting, fs.ThroughputMode)
+	assert.Contains(t, fs.Tags, mock.Tag{Key: TagKeyAutoscaled, Value: "2020-01-01T00:00:00Z"})
+
+	metrics.Set(id, BurstCredits{Balance: 2000 * gibibyte})
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Autoscale()
+	assert.Nil(t, err)
+
+	fs, _ = client.FileSystem(id)
+	assert.NotContains(t, fs.Tags, mock.Tag{Key: TagKeyAutoscaled, Value: "2020-01-01T00:00:00Z"})
+}
+
+func TestAutoscaleListsClaimsOnce(t *testing.T) {
+	client := mock.New()
+
+	testClaimFilesystem(t, client, "namespace", "first", 0)
+	testClaimFilesystem(t, client, "namespace", "second", 0)
+
+	kubernetes := fake.NewSimpleClientset()
+	metrics := NewStaticMetrics()
+
+	provisioner, err := New(client, testAutoscaleParams(), WithMetrics(metrics), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	for _, fs := range provisioner.cache.Filesystems() {
+		metrics.Set(*fs.FileSystemId, BurstCredits{Balance: 0})
+	}
+
+	err = provisioner.Autoscale()
+	assert.Nil(t, err)
+
+	for _, action := range kubernetes.Actions() {
+		assert.Equal(t, "list", action.GetVerb(), "%s %s", action.GetVerb(), action.GetResource().Resource)
+	}
+	assert.Len(t, kubernetes.Actions(), 2)
+}
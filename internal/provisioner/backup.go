@@ -0,0 +1,414 @@
+package provisioner
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/previousnext/k8s-aws-efs/internal/cron"
+)
+
+const (
+	// AnnotationBackupSchedule is the annotation on a claim which backs it up when its cron expression matches.
+	AnnotationBackupSchedule = "efs.aws.skpr.io/backup-schedule"
+
+	// AnnotationBackupRetention is the annotation on a claim which sets how many of its backups are kept.
+	AnnotationBackupRetention = "efs.aws.skpr.io/backup-retention"
+
+	// AnnotationRestoreBackup is the annotation on a claim which restores a backup of the claim in its data source,
+	// rather than cloning it. The value is the name of the backup, or "latest".
+	AnnotationRestoreBackup = "efs.aws.skpr.io/restore-backup"
+)
+
+const (
+	// AnnotationBackups is the annotation on a volume which records its backups, the oldest first.
+	AnnotationBackups = "efs.aws.skpr.io/backups"
+
+	// AnnotationBackupLast is the annotation on a volume which records when its last backup was scheduled.
+	AnnotationBackupLast = "efs.aws.skpr.io/backup-last"
+
+	// AnnotationRestoredBackup is the annotation on a volume which records the backup it was restored from.
+	AnnotationRestoredBackup = "efs.aws.skpr.io/restored-backup"
+)
+
+const (
+	// LabelBackupOf is the label on backup Jobs which records the UID of the volume they are backing up,
+	// as volume names can be longer than a label allows.
+	LabelBackupOf = "efs.aws.skpr.io/backup-of"
+
+	// LabelBackup is the label on backup Jobs which records the name of the backup.
+	LabelBackup = "efs.aws.skpr.io/backup"
+)
+
+// BackupLatest restores the latest backup of a claim.
+const BackupLatest = "latest"
+
+// Backups are named after the time they were scheduled, which also sorts them.
+const backupFormat = "20060102T150405Z"
+
+const (
+	// Purpose of Jobs which back up claims.
+	copyPurposeBackup = "backup"
+
+	// Purpose of Jobs which remove old backups.
+	copyPurposePrune = "prune"
+)
+
+// Removes a backup from the backup filesystem.
+const pruneScript = `set -e
+rm -rf "/target/${TARGET_PATH}"
+`
+
+// Annotations on volumes which are managed by backups.
+var backupAnnotations = []string{
+	AnnotationBackups,
+	AnnotationBackupLast,
+}
+
+// Helper function to return the directory of a backup on the backup filesystem.
+func backupPath(namespace, claim, backup string) string {
+	return path.Join(namespace, claim, backup)
+}
+
+// Helper function to return the backups of a volume, the oldest first.
+func volumeBackups(volume *corev1.PersistentVolume) []string {
+	var backups []string
+
+	for _, backup := range strings.Split(volume.ObjectMeta.Annotations[AnnotationBackups], ",") {
+		if backup != "" {
+			backups = append(backups, backup)
+		}
+	}
+
+	return backups
+}
+
+// Helper function to return the data of a backup which a claim should be restored from.
+func (p *Provisioner) restoreSource(namespace, name, backup string) (*cloneSource, error) {
+	if p.params.BackupFilesystem == "" {
+		return nil, fmt.Errorf("a backup filesystem is required to restore backups")
+	}
+
+	if backup == BackupLatest {
+		volume, err := p.sourceVolume(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+
+		backups := volumeBackups(volume)
+		if len(backups) == 0 {
+			return nil, fmt.Errorf("claim %s/%s has no backups", namespace, name)
+		}
+
+		backup = backups[len(backups)-1]
+	}
+
+	// Backups can be restored after their claim was deleted, so they can't be checked until they are copied.
+	_, err := time.Parse(backupFormat, backup)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup %q: expected %q or a name like %s", backup, BackupLatest, time.Time{}.Format(backupFormat))
+	}
+
+	claim := fmt.Sprintf("%s/%s", namespace, name)
+
+	// Only the backups of the claim are exported to the namespace it is restored into.
+	export := p.export(p.params.BackupFilesystem)
+	export.Path = "/" + path.Join(namespace, name)
+
+	return &cloneSource{
+		Description: fmt.Sprintf("backup %s of claim %s", backup, claim),
+		Claim:       claim,
+		Backup:      backup,
+		NFS:         export,
+		Path:        backup,
+	}, nil
+}
+
+// Backup copies claims with a backup schedule into dated directories on the backup filesystem when their
+// schedule matches, and removes their oldest backups once they have more than they keep.
+func (p *Provisioner) Backup() error {
+	if p.kubernetes == nil || p.params.BackupFilesystem == "" {
+		return nil
+	}
+
+	volumes, err := p.listVolumes()
+	if err != nil {
+		return err
+	}
+
+	claims, err := p.listClaims()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+
+	for _, volume := range volumes {
+		if volume.Status.Phase != corev1.VolumeBound {
+			continue
+		}
+
+		pvc := claims.Claim(volume)
+		if pvc == nil {
+			continue
+		}
+
+		err := p.backupVolume(volume, pvc)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", volume.ObjectMeta.Name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to back up %d volumes: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Helper function to record the backups of a volume which finished, then start those which are due.
+func (p *Provisioner) backupVolume(volume *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim) error {
+	var (
+		backups = volumeBackups(volume)
+		state   = make(map[string]string)
+	)
+
+	if last, ok := volume.ObjectMeta.Annotations[AnnotationBackupLast]; ok {
+		state[AnnotationBackupLast] = last
+	}
+
+	jobs, err := p.kubernetes.BatchV1().Jobs(p.params.BackupNamespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", LabelBackupOf, volume.ObjectMeta.UID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %s", err)
+	}
+
+	var (
+		running  bool
+		pruning  = make(map[string]bool)
+		finished []finishedJob
+	)
+
+	for _, job := range jobs.Items {
+		job := job
+
+		var (
+			backup  = job.ObjectMeta.Labels[LabelBackup]
+			purpose = job.ObjectMeta.Labels[LabelCopy]
+			report  func()
+		)
+
+		_, complete := jobCondition(&job, batchv1.JobComplete)
+		message, failed := jobCondition(&job, batchv1.JobFailed)
+
+		switch {
+		case complete && purpose == copyPurposeBackup:
+			backups = append(backups, backup)
+			report = func() {
+				p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonBackupSucceeded, "Backed up volume %s as %s", volume.ObjectMeta.Name, backup)
+			}
+		case complete && purpose == copyPurposePrune:
+			backups = removeBackup(backups, backup)
+			report = func() {
+				glog.Infof("Removed backup %s of volume %s", backup, volume.ObjectMeta.Name)
+			}
+		case failed && purpose == copyPurposeBackup:
+			report = func() {
+				p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonBackupFailed, "Failed to back up volume %s as %s: %s", volume.ObjectMeta.Name, backup, message)
+			}
+		case failed:
+			report = func() {
+				p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonBackupFailed, "Failed to remove backup %s of volume %s: %s", backup, volume.ObjectMeta.Name, message)
+			}
+		case purpose == copyPurposePrune:
+			pruning[backup] = true
+			continue
+		default:
+			running = true
+			continue
+		}
+
+		// Failed backups aren't retried, the next backup is left to the schedule.
+		finished = append(finished, finishedJob{Job: &job, Report: report})
+	}
+
+	sort.Strings(backups)
+
+	backups = uniqueBackups(backups)
+
+	if len(backups) > 0 {
+		state[AnnotationBackups] = strings.Join(backups, ",")
+	}
+
+	// Backups which were already taken are kept when the schedule is removed.
+	spec, ok := pvc.ObjectMeta.Annotations[AnnotationBackupSchedule]
+	if !ok {
+		return p.setBackupState(volume, state, finished, nil)
+	}
+
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return p.setBackupState(volume, state, finished, fmt.Errorf("invalid backup schedule: %s", err))
+	}
+
+	retention := p.params.BackupRetention
+
+	if value, ok := pvc.ObjectMeta.Annotations[AnnotationBackupRetention]; ok {
+		retention, err = strconv.Atoi(value)
+		if err != nil || retention < 0 {
+			return p.setBackupState(volume, state, finished, fmt.Errorf("invalid backup retention: %s", value))
+		}
+	}
+
+	scheduled := schedule.Prev(p.now().In(p.location))
+
+	last, _ := time.Parse(time.RFC3339, state[AnnotationBackupLast])
+
+	if !running && !scheduled.IsZero() && scheduled.After(last) {
+		err := p.startBackup(volume, pvc, scheduled)
+		if err != nil {
+			return p.setBackupState(volume, state, finished, err)
+		}
+
+		state[AnnotationBackupLast] = scheduled.UTC().Format(time.RFC3339)
+	}
+
+	// A retention of zero keeps every backup.
+	if retention > 0 && len(backups) > retention {
+		for _, backup := range backups[:len(backups)-retention] {
+			if pruning[backup] {
+				continue
+			}
+
+			err := p.pruneBackup(volume, backup)
+			if err != nil {
+				return p.setBackupState(volume, state, finished, err)
+			}
+		}
+	}
+
+	return p.setBackupState(volume, state, finished, nil)
+}
+
+// Helper function to start a Job which backs up a volume.
+func (p *Provisioner) startBackup(volume *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim, scheduled time.Time) error {
+	var (
+		ref    = volume.Spec.ClaimRef
+		backup = scheduled.UTC().Format(backupFormat)
+	)
+
+	job, created, err := p.putJob(p.copyJob(copySpec{
+		Namespace: p.params.BackupNamespace,
+		Name:      fmt.Sprintf("efs-backup-%s-%d", volume.ObjectMeta.UID, scheduled.Unix()),
+		Purpose:   copyPurposeBackup,
+		Labels: map[string]string{
+			LabelBackupOf: string(volume.ObjectMeta.UID),
+			LabelBackup:   backup,
+		},
+		Source:     volume.Spec.NFS,
+		Target:     p.export(p.params.BackupFilesystem),
+		TargetPath: backupPath(ref.Namespace, ref.Name, backup),
+	}))
+	if err != nil {
+		p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonBackupFailed, "Failed to start backing up volume %s as %s: %s", volume.ObjectMeta.Name, backup, err)
+		return err
+	}
+
+	if created {
+		p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonBackupStarted, "Backing up volume %s as %s with job %s/%s", volume.ObjectMeta.Name, backup, job.ObjectMeta.Namespace, job.ObjectMeta.Name)
+	}
+
+	return nil
+}
+
+// Helper function to start a Job which removes a backup of a volume.
+func (p *Provisioner) pruneBackup(volume *corev1.PersistentVolume, backup string) error {
+	ref := volume.Spec.ClaimRef
+
+	scheduled, err := time.Parse(backupFormat, backup)
+	if err != nil {
+		return fmt.Errorf("invalid backup %q: %s", backup, err)
+	}
+
+	glog.Infof("Removing backup %s of volume %s", backup, volume.ObjectMeta.Name)
+
+	_, _, err = p.putJob(p.copyJob(copySpec{
+		Namespace: p.params.BackupNamespace,
+		Name:      fmt.Sprintf("efs-prune-%s-%d", volume.ObjectMeta.UID, scheduled.Unix()),
+		Purpose:   copyPurposePrune,
+		Labels: map[string]string{
+			LabelBackupOf: string(volume.ObjectMeta.UID),
+			LabelBackup:   backup,
+		},
+		Target:     p.export(p.params.BackupFilesystem),
+		TargetPath: backupPath(ref.Namespace, ref.Name, backup),
+		Script:     pruneScript,
+	}))
+
+	return err
+}
+
+// A backup or prune Job which finished, and how to report it once it has been recorded.
+type finishedJob struct {
+	Job    *batchv1.Job
+	Report func()
+}
+
+// Helper function to record the backups of a volume, returning the error which stopped them.
+//
+// Jobs which finished are only reported and deleted once the volume records what they did, otherwise a
+// failed update would forget their backups.
+func (p *Provisioner) setBackupState(volume *corev1.PersistentVolume, state map[string]string, finished []finishedJob, cause error) error {
+	if setMetadata(&volume.ObjectMeta.Annotations, backupAnnotations, state) {
+		_, err := p.kubernetes.CoreV1().PersistentVolumes().Update(volume)
+		if err != nil {
+			return fmt.Errorf("failed to update volume: %s", err)
+		}
+	}
+
+	for _, job := range finished {
+		job.Report()
+
+		err := p.deleteJob(job.Job)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cause
+}
+
+// Helper function to remove a backup from a list of backups.
+func removeBackup(backups []string, backup string) []string {
+	var remaining []string
+
+	for _, existing := range backups {
+		if existing != backup {
+			remaining = append(remaining, existing)
+		}
+	}
+
+	return remaining
+}
+
+// Helper function to remove duplicates from a sorted list of backups.
+func uniqueBackups(backups []string) []string {
+	var unique []string
+
+	for i, backup := range backups {
+		if i == 0 || backups[i-1] != backup {
+			unique = append(unique, backup)
+		}
+	}
+
+	return unique
+}
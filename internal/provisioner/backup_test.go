@@ -0,0 +1,275 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return params which back up volumes.
+func testBackupParams() Params {
+	params := testParams()
+	params.BackupFilesystem = "fs-backup"
+	params.BackupNamespace = "efs-system"
+	params.BackupRetention = 7
+
+	return params
+}
+
+func TestNewBackupFilesystem(t *testing.T) {
+	_, err := New(mock.New(), testBackupParams())
+	assert.EqualError(t, err, "a Kubernetes client is required to back up volumes")
+
+	params := testBackupParams()
+	params.BackupNamespace = ""
+
+	_, err = New(mock.New(), params, WithKubernetes(fake.NewSimpleClientset()))
+	assert.EqualError(t, err, "a namespace is required to run backup jobs in")
+}
+
+func TestBackup(t *testing.T) {
+	client := mock.New()
+
+	pvc, volume := testBoundClaim("namespace", "production", "fs-00000001")
+	volume.ObjectMeta.UID = "7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11"
+	pvc.ObjectMeta.Annotations = map[string]string{
+		AnnotationBackupSchedule:  "0 2 * * *",
+		AnnotationBackupRetention: "2",
+	}
+
+	var (
+		kubernetes = fake.NewSimpleClientset(pvc, volume)
+		recorder   = record.NewFakeRecorder(100)
+	)
+
+	// Backups run in the provisioner's namespace, not the claim's.
+	jobs := kubernetes.BatchV1().Jobs("efs-system")
+
+	provisioner, err := New(client, testBackupParams(), WithKubernetes(kubernetes), WithRecorder(recorder), WithClock(client.Clock().Now))
+	assert.Nil(t, err)
+
+	// Helper function to back up volumes and return the annotations recorded on the volume.
+	backup := func() map[string]string {
+		err := provisioner.Backup()
+		assert.Nil(t, err)
+
+		updated, err := kubernetes.CoreV1().PersistentVolumes().Get("fs-00000001", metav1.GetOptions{})
+		assert.Nil(t, err)
+
+		state := make(map[string]string)
+
+		for _, key := range backupAnnotations {
+			if value, ok := updated.ObjectMeta.Annotations[key]; ok {
+				state[key] = value
+			}
+		}
+
+		return state
+	}
+
+	// Helper function to mark a job as complete.
+	complete := func(name string) {
+		job, err := jobs.Get(name, metav1.GetOptions{})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		job.Status.Conditions = []batchv1.JobCondition{
+			{
+				Type:   batchv1.JobComplete,
+				Status: corev1.ConditionTrue,
+			},
+		}
+
+		_, err = jobs.UpdateStatus(job)
+		assert.Nil(t, err)
+	}
+
+	// The last time the schedule matched was the morning before.
+	assert.Equal(t, map[string]string{
+		AnnotationBackupLast: "2019-12-31T02:00:00Z",
+	}, backup())
+	assert.Equal(t, []string{
+		"Normal BackupStarted Backing up volume fs-00000001 as 20191231T020000Z with job efs-system/efs-backup-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577757600",
+	}, testEvents(recorder))
+
+	job, err := jobs.Get("efs-backup-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577757600", metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	spec := job.Spec.Template.Spec
+	assert.Equal(t, "fs-00000001.efs.ap-southeast-2.amazonaws.com", spec.Volumes[0].NFS.Server)
+	assert.Equal(t, "fs-backup.efs.ap-southeast-2.amazonaws.com", spec.Volumes[1].NFS.Server)
+	assert.Contains(t, spec.Containers[0].Env, corev1.EnvVar{Name: "TARGET_PATH", Value: "namespace/production/20191231T020000Z"})
+
+	// Nothing is started while the backup is running.
+	backup()
+	assert.Empty(t, testEvents(recorder))
+
+	complete("efs-backup-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577757600")
+
+	assert.Equal(t, map[string]string{
+		AnnotationBackups:    "20191231T020000Z",
+		AnnotationBackupLast: "2019-12-31T02:00:00Z",
+	}, backup())
+	assert.Equal(t, []string{
+		"Normal BackupSucceeded Backed up volume fs-00000001 as 20191231T020000Z",
+	}, testEvents(recorder))
+
+	_, err = jobs.Get("efs-backup-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577757600", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+
+	// Back up on the next two days.
+	for _, name := range []string{"efs-backup-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577844000", "efs-backup-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577930400"} {
+		client.Advance(24 * time.Hour)
+
+		backup()
+		complete(name)
+	}
+
+	// The oldest backup is removed once there are more than the claim keeps.
+	assert.Equal(t, map[string]string{
+		AnnotationBackups:    "20191231T020000Z,20200101T020000Z,20200102T020000Z",
+		AnnotationBackupLast: "2020-01-02T02:00:00Z",
+	}, backup())
+
+	prune, err := jobs.Get("efs-prune-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577757600", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, prune.Spec.Template.Spec.Volumes, 1)
+	assert.Contains(t, prune.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "TARGET_PATH", Value: "namespace/production/20191231T020000Z"})
+
+	complete("efs-prune-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577757600")
+
+	assert.Equal(t, map[string]string{
+		AnnotationBackups:    "20200101T020000Z,20200102T020000Z",
+		AnnotationBackupLast: "2020-01-02T02:00:00Z",
+	}, backup())
+}
+
+func TestBackupUpdateConflict(t *testing.T) {
+	client := mock.New()
+
+	pvc, volume := testBoundClaim("namespace", "production", "fs-00000001")
+	volume.ObjectMeta.UID = "7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11"
+	pvc.ObjectMeta.Annotations = map[string]string{
+		AnnotationBackupSchedule: "0 2 * * *",
+	}
+
+	var (
+		kubernetes = fake.NewSimpleClientset(pvc, volume)
+		recorder   = record.NewFakeRecorder(100)
+		name       = "efs-backup-7c3e0c2e-9d4b-4a57-8d1c-3f0c5a6b2e11-1577757600"
+	)
+
+	jobs := kubernetes.BatchV1().Jobs("efs-system")
+
+	provisioner, err := New(client, testBackupParams(), WithKubernetes(kubernetes), WithRecorder(recorder), WithClock(client.Clock().Now))
+	assert.Nil(t, err)
+
+	err = provisioner.Backup()
+	assert.Nil(t, err)
+
+	testEvents(recorder)
+
+	job, err := jobs.Get(name, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	job.Status.Conditions = []batchv1.JobCondition{
+		{
+			Type:   batchv1.JobComplete,
+			Status: corev1.ConditionTrue,
+		},
+	}
+
+	_, err = jobs.UpdateStatus(job)
+	assert.Nil(t, err)
+
+	// The volume can't be updated, so the job is kept until its backup has been recorded.
+	kubernetes.PrependReactor("update", "persistentvolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewConflict(corev1.Resource("persistentvolumes"), "fs-00000001", nil)
+	})
+
+	err = provisioner.Backup()
+	assert.Error(t, err)
+	assert.Empty(t, testEvents(recorder))
+
+	_, err = jobs.Get(name, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	kubernetes.ReactionChain = kubernetes.ReactionChain[1:]
+
+	err = provisioner.Backup()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"Normal BackupSucceeded Backed up volume fs-00000001 as 20191231T020000Z",
+	}, testEvents(recorder))
+
+	updated, err := kubernetes.CoreV1().PersistentVolumes().Get("fs-00000001", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "20191231T020000Z", updated.ObjectMeta.Annotations[AnnotationBackups])
+
+	_, err = jobs.Get(name, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestRestoreSource(t *testing.T) {
+	pvc, volume := testBoundClaim("namespace", "production", "fs-00000001")
+	volume.ObjectMeta.Annotations[AnnotationBackups] = "20200101T020000Z,20200102T020000Z"
+
+	kubernetes := fake.NewSimpleClientset(pvc, volume)
+
+	provisioner, err := New(mock.New(), testBackupParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	// Helper function to return a claim which restores a backup of another claim.
+	restore := func(source, backup string) *corev1.PersistentVolumeClaim {
+		pvc := testCloneOptions("namespace", "staging", source).PVC
+		pvc.ObjectMeta.Annotations = map[string]string{
+			AnnotationRestoreBackup: backup,
+		}
+
+		return pvc
+	}
+
+	source, err := provisioner.dataSource(restore("production", BackupLatest))
+	assert.Nil(t, err)
+	assert.Equal(t, &cloneSource{
+		Description: "backup 20200102T020000Z of claim namespace/production",
+		Claim:       "namespace/production",
+		Backup:      "20200102T020000Z",
+		NFS: corev1.NFSVolumeSource{
+			Server: "fs-backup.efs.ap-southeast-2.amazonaws.com",
+			Path:   "/namespace/production",
+		},
+		Path: "20200102T020000Z",
+	}, source)
+
+	// Backups can be restored after their claim was deleted.
+	source, err = provisioner.dataSource(restore("deleted", "20200101T020000Z"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/namespace/deleted", source.NFS.Path)
+	assert.Equal(t, "20200101T020000Z", source.Path)
+
+	_, err = provisioner.dataSource(restore("production", "yesterday"))
+	assert.EqualError(t, err, `invalid backup "yesterday": expected "latest" or a name like 00010101T000000Z`)
+
+	_, err = provisioner.dataSource(restore("deleted", BackupLatest))
+	assert.EqualError(t, err, "claim namespace/deleted does not exist")
+
+	// Backups can't be restored without the backup filesystem.
+	provisioner, err = New(mock.New(), testParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	_, err = provisioner.dataSource(restore("production", BackupLatest))
+	assert.EqualError(t, err, "a backup filesystem is required to restore backups")
+}
@@ -0,0 +1,389 @@
+package provisioner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/aws/aws-sdk-go/service/efs/efsiface"
+	"github.com/golang/glog"
+)
+
+// Cache of the filesystems and mount targets owned by the provisioner.
+//
+// The cache is refreshed by a single background poller so that the number of
+// calls made to the EFS API stays constant, no matter how many claims are waiting
+// for their filesystem to become available.
+type Cache struct {
+	client   efsiface.EFSAPI
+	owner    string
+	interval time.Duration
+
+	mu          sync.RWMutex
+	filesystems map[string]*efs.FileSystemDescription
+	total       int
+	mounts      map[string][]*efs.MountTargetDescription
+	watched     map[string]int
+	updated     chan struct{}
+	// Security groups of mount targets and policies of filesystems, which are only described again after
+	// a resync as they don't change unless someone changes them.
+	groups   map[string][]string
+	policies map[string]string
+	// Error from the last refresh, which explains why anyone waiting on it timed out.
+	err error
+}
+
+// NewCache for tracking the state of filesystems tagged as owned by the provisioner.
+func NewCache(client efsiface.EFSAPI, owner string, interval time.Duration) *Cache {
+	return &Cache{
+		client:      client,
+		owner:       owner,
+		interval:    interval,
+		filesystems: make(map[string]*efs.FileSystemDescription),
+		mounts:      make(map[string][]*efs.MountTargetDescription),
+		watched:     make(map[string]int),
+		updated:     make(chan struct{}),
+		groups:      make(map[string][]string),
+		policies:    make(map[string]string),
+	}
+}
+
+// Run the poller until the stop channel is closed.
+func (c *Cache) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		err := c.Refresh()
+		if err != nil {
+			glog.Errorf("Failed to refresh filesystem cache: %s", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh the state of all owned (and watched) filesystems and their mount targets.
+func (c *Cache) Refresh() error {
+	var (
+		filesystems = make(map[string]*efs.FileSystemDescription)
+		total       int
+		marker      *string
+	)
+
+	for {
+		describe, err := c.client.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+			Marker: marker,
+		})
+		if err != nil {
+			return c.failed(err)
+		}
+
+		for _, fs := range describe.FileSystems {
+			if aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateDeleted {
+				total++
+			}
+
+			if c.isOwned(fs) || c.isWatched(*fs.FileSystemId) {
+				filesystems[*fs.FileSystemId] = fs
+			}
+		}
+
+		if describe.NextMarker == nil {
+			break
+		}
+
+		marker = describe.NextMarker
+	}
+
+	var (
+		mounts = make(map[string][]*efs.MountTargetDescription)
+		groups = make(map[string][]string)
+	)
+
+	for id, fs := range filesystems {
+		// Mount targets are only looked up when they might have changed, this avoids
+		// an extra API call per filesystem once everything has settled.
+		if cached, ok := c.cachedMounts(fs); ok {
+			mounts[id] = cached
+			c.cachedGroups(cached, groups)
+			continue
+		}
+
+		targets, err := describeMountTargets(c.client, id)
+		if err != nil {
+			return c.failed(err)
+		}
+
+		mounts[id] = targets
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filesystems = filesystems
+	c.total = total
+	c.mounts = mounts
+	c.groups = groups
+	c.err = nil
+
+	for id := range c.policies {
+		if _, ok := filesystems[id]; !ok {
+			delete(c.policies, id)
+		}
+	}
+
+	// Let everyone who is waiting on a change know that there is new information.
+	close(c.updated)
+	c.updated = make(chan struct{})
+
+	return nil
+}
+
+// Helper function to record that a refresh failed. Everyone waiting on it keeps waiting for the next one.
+func (c *Cache) failed(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+
+	return err
+}
+
+// Filesystem returns the cached description of a filesystem.
+func (c *Cache) Filesystem(id string) (*efs.FileSystemDescription, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fs, ok := c.filesystems[id]
+
+	return fs, ok
+}
+
+// Filesystems returns the cached descriptions of all filesystems.
+func (c *Cache) Filesystems() []*efs.FileSystemDescription {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var list []*efs.FileSystemDescription
+
+	for _, fs := range c.filesystems {
+		list = append(list, fs)
+	}
+
+	return list
+}
+
+// Total returns the number of filesystems in the account, including those which aren't owned.
+func (c *Cache) Total() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.total
+}
+
+// MountTargets returns the cached mount targets of a filesystem.
+func (c *Cache) MountTargets(id string) []*efs.MountTargetDescription {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.mounts[id]
+}
+
+// SecurityGroups returns the cached security groups of a mount target.
+func (c *Cache) SecurityGroups(id string) ([]string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	groups, ok := c.groups[id]
+
+	return groups, ok
+}
+
+// SetSecurityGroups of a mount target, after they have been described or changed.
+func (c *Cache) SetSecurityGroups(id string, groups []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.groups[id] = groups
+}
+
+// Policy returns the cached policy of a filesystem, which is empty if it has none.
+func (c *Cache) Policy(id string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	policy, ok := c.policies[id]
+
+	return policy, ok
+}
+
+// SetPolicy of a filesystem, after it has been described or changed.
+func (c *Cache) SetPolicy(id, policy string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.policies[id] = policy
+}
+
+// Resync forgets the cached security groups and policies, so they are described again.
+func (c *Cache) Resync() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.groups = make(map[string][]string)
+	c.policies = make(map[string]string)
+}
+
+// Invalidate the cached mount targets of a filesystem after they have been changed, so
+// they are looked up again on the next refresh.
+func (c *Cache) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.mounts, id)
+}
+
+// Watch a filesystem, ensuring that it is tracked even if it is not tagged as owned
+// by this provisioner. The returned function must be called once it is no longer needed.
+func (c *Cache) Watch(id string) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.watched[id]++
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.watched[id]--
+
+		if c.watched[id] <= 0 {
+			delete(c.watched, id)
+		}
+	}
+}
+
+// Wait for the condition to be met, checking it each time the cache is refreshed.
+//
+// Gives up once the timeout has passed, so callers can return an error and be retried rather
+// than waiting forever. A refresh which fails is tried again on the next tick.
+func (c *Cache) Wait(timeout time.Duration, condition func() (bool, error)) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		c.mu.RLock()
+		updated := c.updated
+		c.mu.RUnlock()
+
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-updated:
+		case <-deadline.C:
+			c.mu.RLock()
+			err = c.err
+			c.mu.RUnlock()
+
+			if err != nil {
+				return fmt.Errorf("timed out after %s, the last refresh failed: %s", timeout, err)
+			}
+
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+	}
+}
+
+// Helper function to check if a filesystem is tagged as owned by this provisioner.
+func (c *Cache) isOwned(fs *efs.FileSystemDescription) bool {
+	owner, ok := tagValue(fs.Tags, TagKeyOwner)
+
+	return ok && owner == c.owner
+}
+
+// Helper function to check if a filesystem is being watched.
+func (c *Cache) isWatched(id string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.watched[id]
+
+	return ok
+}
+
+// Helper function to return the cached mount targets of a filesystem, if they are up to date.
+func (c *Cache) cachedMounts(fs *efs.FileSystemDescription) ([]*efs.MountTargetDescription, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	targets, ok := c.mounts[*fs.FileSystemId]
+	if !ok {
+		return nil, false
+	}
+
+	if int64(len(targets)) != aws.Int64Value(fs.NumberOfMountTargets) {
+		return nil, false
+	}
+
+	for _, target := range targets {
+		if aws.StringValue(target.LifeCycleState) != efs.LifeCycleStateAvailable {
+			return nil, false
+		}
+	}
+
+	return targets, true
+}
+
+// Helper function to copy the cached security groups of mount targets which haven't changed.
+func (c *Cache) cachedGroups(targets []*efs.MountTargetDescription, groups map[string][]string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, target := range targets {
+		id := aws.StringValue(target.MountTargetId)
+
+		if cached, ok := c.groups[id]; ok {
+			groups[id] = cached
+		}
+	}
+}
+
+// Helper function to list all the mount targets of a filesystem.
+func describeMountTargets(svc efsiface.EFSAPI, id string) ([]*efs.MountTargetDescription, error) {
+	var (
+		targets []*efs.MountTargetDescription
+		marker  *string
+	)
+
+	for {
+		describe, err := svc.DescribeMountTargets(&efs.DescribeMountTargetsInput{
+			FileSystemId: aws.String(id),
+			Marker:       marker,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, describe.MountTargets...)
+
+		if describe.NextMarker == nil {
+			return targets, nil
+		}
+
+		marker = describe.NextMarker
+	}
+}
@@ -0,0 +1,125 @@
+package provisioner
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+func TestCacheRefresh(t *testing.T) {
+	client := mock.New()
+
+	var owned []string
+
+	// Enough filesystems to require paging through the results.
+	for i := 0; i < 150; i++ {
+		fs, err := client.CreateFileSystem(&efs.CreateFileSystemInput{
+			CreationToken:   aws.String(fmt.Sprintf("owned-%d", i)),
+			PerformanceMode: aws.String(efs.PerformanceModeGeneralPurpose),
+		})
+		assert.Nil(t, err)
+
+		_, err = syncTags(client, *fs.FileSystemId, map[string]string{TagKeyOwner: "efs.aws.skpr.io/generalPurpose"})
+		assert.Nil(t, err)
+
+		owned = append(owned, *fs.FileSystemId)
+	}
+
+	notOwned, err := client.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken:   aws.String("not-owned"),
+		PerformanceMode: aws.String(efs.PerformanceModeGeneralPurpose),
+	})
+	assert.Nil(t, err)
+
+	_, err = client.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId: aws.String(owned[1]),
+		SubnetId:     aws.String("subnet-xxxxxxxx"),
+	})
+	assert.Nil(t, err)
+
+	cache := NewCache(client, "efs.aws.skpr.io/generalPurpose", time.Minute)
+
+	err = cache.Refresh()
+	assert.Nil(t, err)
+
+	assert.Len(t, cache.Filesystems(), 150)
+	assert.Equal(t, 151, cache.Total())
+	assert.Len(t, cache.MountTargets(owned[1]), 1)
+
+	_, ok := cache.Filesystem(*notOwned.FileSystemId)
+	assert.False(t, ok)
+
+	// Filesystems which are not owned are tracked while they are being watched.
+	unwatch := cache.Watch(*notOwned.FileSystemId)
+
+	err = cache.Refresh()
+	assert.Nil(t, err)
+
+	_, ok = cache.Filesystem(*notOwned.FileSystemId)
+	assert.True(t, ok)
+
+	unwatch()
+
+	err = cache.Refresh()
+	assert.Nil(t, err)
+
+	_, ok = cache.Filesystem(*notOwned.FileSystemId)
+	assert.False(t, ok)
+}
+
+func TestCacheWait(t *testing.T) {
+	client := mock.New()
+
+	cache := NewCache(client, "efs.aws.skpr.io/generalPurpose", time.Millisecond)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go cache.Run(stop)
+
+	fs, err := client.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken:   aws.String("test"),
+		PerformanceMode: aws.String(efs.PerformanceModeGeneralPurpose),
+	})
+	assert.Nil(t, err)
+
+	go syncTags(client, *fs.FileSystemId, map[string]string{TagKeyOwner: "efs.aws.skpr.io/generalPurpose"})
+
+	err = cache.Wait(time.Minute, func() (bool, error) {
+		_, ok := cache.Filesystem(*fs.FileSystemId)
+		return ok, nil
+	})
+	assert.Nil(t, err)
+
+	err = cache.Wait(time.Minute, func() (bool, error) {
+		return false, fmt.Errorf("failed")
+	})
+	assert.NotNil(t, err)
+
+	err = cache.Wait(10*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	assert.EqualError(t, err, "timed out after 10ms")
+
+	// A refresh which fails doesn't stop anyone waiting, although it explains why they timed out.
+	client.FailNext("DescribeFileSystems", awserr.New(efs.ErrCodeInternalServerError, "failed", nil))
+
+	err = cache.Wait(time.Minute, func() (bool, error) {
+		return client.CallCount("DescribeFileSystems") > 10, nil
+	})
+	assert.Nil(t, err)
+
+	client.Fail("DescribeFileSystems", awserr.New(efs.ErrCodeInternalServerError, "failed", nil))
+
+	err = cache.Wait(10*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	assert.EqualError(t, err, "timed out after 10ms, the last refresh failed: InternalServerError: failed")
+}
@@ -0,0 +1,149 @@
+package provisioner
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CapacityEnforcementSoft marks claims which use more than they requested with an annotation and condition.
+	CapacityEnforcementSoft = "soft"
+
+	// CapacityEnforcementReadOnly also mounts their volumes read-only, until the request is raised.
+	CapacityEnforcementReadOnly = "read-only"
+)
+
+// AnnotationCapacityExceeded is the annotation on a claim which is set while it uses more than it requested.
+const AnnotationCapacityExceeded = "efs.aws.skpr.io/capacity-exceeded"
+
+// ConditionCapacityExceeded is the condition on a claim which is set while it uses more than it requested.
+const ConditionCapacityExceeded corev1.PersistentVolumeClaimConditionType = "CapacityExceeded"
+
+// Mount option which makes a volume read-only.
+const mountOptionReadOnly = "ro"
+
+// Helper function to mark a claim (and optionally its volume) when it uses more than it requested,
+// and to remove the marks once it no longer does.
+func (p *Provisioner) enforceCapacity(volume *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim, used int64, request resource.Quantity) error {
+	exceeded := !request.IsZero() && used > request.Value()
+
+	if exceeded != (pvc.ObjectMeta.Annotations[AnnotationCapacityExceeded] == "true") {
+		err := p.markClaim(pvc, exceeded, used, request)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.params.CapacityEnforcement != CapacityEnforcementReadOnly {
+		return nil
+	}
+
+	options := volume.ObjectMeta.Annotations[MountOptionAnnotation]
+	if hasMountOption(options, mountOptionReadOnly) == exceeded {
+		return nil
+	}
+
+	volume.ObjectMeta.Annotations[MountOptionAnnotation] = setMountOption(options, mountOptionReadOnly, exceeded)
+
+	_, err := p.kubernetes.CoreV1().PersistentVolumes().Update(volume)
+	if err != nil {
+		return fmt.Errorf("failed to update mount options: %s", err)
+	}
+
+	if exceeded {
+		p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonVolumeReadOnly, "Volume %s will be mounted read-only until the claim requests at least %s", volume.ObjectMeta.Name, resource.NewQuantity(used, resource.BinarySI))
+	} else {
+		p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonVolumeReadWrite, "Volume %s will be mounted read-write", volume.ObjectMeta.Name)
+	}
+
+	return nil
+}
+
+// Helper function to set or remove the annotation and condition which mark a claim as using more than it requested.
+func (p *Provisioner) markClaim(pvc *corev1.PersistentVolumeClaim, exceeded bool, used int64, request resource.Quantity) error {
+	if exceeded {
+		if pvc.ObjectMeta.Annotations == nil {
+			pvc.ObjectMeta.Annotations = make(map[string]string)
+		}
+
+		pvc.ObjectMeta.Annotations[AnnotationCapacityExceeded] = "true"
+	} else {
+		delete(pvc.ObjectMeta.Annotations, AnnotationCapacityExceeded)
+	}
+
+	updated, err := p.kubernetes.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Update(pvc)
+	if err != nil {
+		return fmt.Errorf("failed to update claim: %s", err)
+	}
+
+	var conditions []corev1.PersistentVolumeClaimCondition
+
+	for _, condition := range updated.Status.Conditions {
+		if condition.Type != ConditionCapacityExceeded {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	message := fmt.Sprintf("Uses %s of the %s requested", resource.NewQuantity(used, resource.BinarySI), request.String())
+
+	if exceeded {
+		conditions = append(conditions, corev1.PersistentVolumeClaimCondition{
+			Type:               ConditionCapacityExceeded,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.Now(),
+			Reason:             EventReasonCapacityExceeded,
+			Message:            message,
+		})
+	}
+
+	updated.Status.Conditions = conditions
+
+	_, err = p.kubernetes.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).UpdateStatus(updated)
+	if err != nil {
+		return fmt.Errorf("failed to update claim status: %s", err)
+	}
+
+	if exceeded {
+		p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonCapacityExceeded, "%s, raise the request to stop enforcing it", message)
+	} else {
+		p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonCapacityRestored, "%s", message)
+	}
+
+	return nil
+}
+
+// Helper function to check if a comma separated list of mount options contains an option.
+func hasMountOption(options, option string) bool {
+	for _, existing := range strings.Split(options, ",") {
+		if strings.TrimSpace(existing) == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Helper function to add or remove an option from a comma separated list of mount options.
+func setMountOption(options, option string, enabled bool) string {
+	var list []string
+
+	for _, existing := range strings.Split(options, ",") {
+		existing = strings.TrimSpace(existing)
+
+		if existing == "" || existing == option {
+			continue
+		}
+
+		list = append(list, existing)
+	}
+
+	if enabled {
+		list = append(list, option)
+	}
+
+	return strings.Join(list, ",")
+}
@@ -0,0 +1,147 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+func TestNewCapacityEnforcement(t *testing.T) {
+	params := testParams()
+	params.CapacityEnforcement = CapacityEnforcementSoft
+
+	_, err := New(mock.New(), params)
+	assert.EqualError(t, err, "a Kubernetes client is required to enforce capacity")
+
+	params.CapacityEnforcement = "hard"
+
+	_, err = New(mock.New(), params, WithKubernetes(fake.NewSimpleClientset()))
+	assert.EqualError(t, err, "unknown capacity enforcement mode: hard")
+}
+
+func TestEnforceCapacity(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+	volume.ObjectMeta.Annotations[MountOptionAnnotation] = "nfsvers=4.1,hard"
+
+	kubernetes := fake.NewSimpleClientset(volume, testSizedClaim("namespace", "test", "1Gi"))
+
+	recorder := record.NewFakeRecorder(100)
+
+	params := testParams()
+	params.CapacityEnforcement = CapacityEnforcementReadOnly
+
+	provisioner, err := New(client, params, WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	client.SetSize(id, 2*1024*1024*1024, 0)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.CollectUsage()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"Warning UsageExceeded Filesystem fs-00000001 uses 2Gi, more than the 1Gi requested",
+		"Warning CapacityExceeded Uses 2Gi of the 1Gi requested, raise the request to stop enforcing it",
+		"Warning VolumeReadOnly Volume fs-00000001 will be mounted read-only until the claim requests at least 2Gi",
+	}, testEvents(recorder))
+
+	pvc, err := kubernetes.CoreV1().PersistentVolumeClaims("namespace").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "true", pvc.ObjectMeta.Annotations[AnnotationCapacityExceeded])
+
+	if assert.Len(t, pvc.Status.Conditions, 1) {
+		assert.Equal(t, ConditionCapacityExceeded, pvc.Status.Conditions[0].Type)
+		assert.Equal(t, corev1.ConditionTrue, pvc.Status.Conditions[0].Status)
+	}
+
+	updated, err := kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "nfsvers=4.1,hard,ro", updated.ObjectMeta.Annotations[MountOptionAnnotation])
+
+	// Nothing changes while the claim still uses more than it requested.
+	err = provisioner.CollectUsage()
+	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+
+	// Raising the request stops enforcing it.
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("4Gi")
+
+	_, err = kubernetes.CoreV1().PersistentVolumeClaims("namespace").Update(pvc)
+	assert.Nil(t, err)
+
+	err = provisioner.CollectUsage()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"Normal CapacityRestored Uses 2Gi of the 4Gi requested",
+		"Normal VolumeReadWrite Volume fs-00000001 will be mounted read-write",
+	}, testEvents(recorder))
+
+	pvc, err = kubernetes.CoreV1().PersistentVolumeClaims("namespace").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.NotContains(t, pvc.ObjectMeta.Annotations, AnnotationCapacityExceeded)
+	assert.Empty(t, pvc.Status.Conditions)
+
+	updated, err = kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "nfsvers=4.1,hard", updated.ObjectMeta.Annotations[MountOptionAnnotation])
+}
+
+func TestEnforceCapacitySoft(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+	volume.ObjectMeta.Annotations[MountOptionAnnotation] = "nfsvers=4.1"
+
+	kubernetes := fake.NewSimpleClientset(volume, testSizedClaim("namespace", "test", "1Gi"))
+
+	params := testParams()
+	params.CapacityEnforcement = CapacityEnforcementSoft
+
+	provisioner, err := New(client, params, WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	client.SetSize(id, 2*1024*1024*1024, 0)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.CollectUsage()
+	assert.Nil(t, err)
+
+	pvc, err := kubernetes.CoreV1().PersistentVolumeClaims("namespace").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "true", pvc.ObjectMeta.Annotations[AnnotationCapacityExceeded])
+
+	// Volumes are only made read-only when asked to.
+	updated, err := kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "nfsvers=4.1", updated.ObjectMeta.Annotations[MountOptionAnnotation])
+}
+
+func TestSetMountOption(t *testing.T) {
+	assert.Equal(t, "nfsvers=4.1,ro", setMountOption("nfsvers=4.1", "ro", true))
+	assert.Equal(t, "nfsvers=4.1,ro", setMountOption("nfsvers=4.1,ro", "ro", true))
+	assert.Equal(t, "nfsvers=4.1,hard", setMountOption("nfsvers=4.1,ro,hard", "ro", false))
+	assert.Equal(t, "ro", setMountOption("", "ro", true))
+
+	assert.True(t, hasMountOption("nfsvers=4.1, ro", "ro"))
+	assert.False(t, hasMountOption("nfsvers=4.1,rsize=1048576", "ro"))
+}
@@ -0,0 +1,168 @@
+package provisioner
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+)
+
+// AnnotationClonedFrom is the annotation on a volume which records the claim it was cloned from.
+const AnnotationClonedFrom = "efs.aws.skpr.io/cloned-from"
+
+// Purpose of Jobs which clone claims.
+const copyPurposeClone = "clone"
+
+// Data which a claim is populated with.
+type cloneSource struct {
+	// Describes where the data comes from eg. "claim namespace/production".
+	Description string
+	// Claim which the data comes from, as namespace/name.
+	Claim string
+	// Backup of the claim which is being restored, if any.
+	Backup string
+	NFS    corev1.NFSVolumeSource
+	Path   string
+}
+
+// Helper function to return the data which a claim should be populated with, or nil if it doesn't
+// have a data source.
+func (p *Provisioner) dataSource(pvc *corev1.PersistentVolumeClaim) (*cloneSource, error) {
+	source := pvc.Spec.DataSource
+	if source == nil {
+		return nil, nil
+	}
+
+	if aws.StringValue(source.APIGroup) != "" || source.Kind != "PersistentVolumeClaim" {
+		return nil, fmt.Errorf("unsupported data source: %s %s", source.Kind, source.Name)
+	}
+
+	if p.kubernetes == nil {
+		return nil, fmt.Errorf("a Kubernetes client is required to clone claims")
+	}
+
+	if backup, ok := pvc.ObjectMeta.Annotations[AnnotationRestoreBackup]; ok {
+		return p.restoreSource(pvc.ObjectMeta.Namespace, source.Name, backup)
+	}
+
+	volume, err := p.sourceVolume(pvc.ObjectMeta.Namespace, source.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := fmt.Sprintf("%s/%s", pvc.ObjectMeta.Namespace, source.Name)
+
+	return &cloneSource{
+		Description: fmt.Sprintf("claim %s", claim),
+		Claim:       claim,
+		NFS:         *volume.Spec.NFS,
+	}, nil
+}
+
+// Helper function to return the volume of a claim which data can be copied from.
+func (p *Provisioner) sourceVolume(namespace, name string) (*corev1.PersistentVolume, error) {
+	claim, err := p.kubernetes.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("claim %s/%s does not exist", namespace, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get claim %s/%s: %s", namespace, name, err)
+	}
+
+	if claim.Status.Phase != corev1.ClaimBound || claim.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("claim %s/%s is not bound", namespace, name)
+	}
+
+	volume, err := p.kubernetes.CoreV1().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume of claim %s/%s: %s", namespace, name, err)
+	}
+
+	if volume.ObjectMeta.Annotations[annProvisionedBy] != p.name || volume.Spec.NFS == nil {
+		return nil, fmt.Errorf("claim %s/%s was not provisioned by %s", namespace, name, p.name)
+	}
+
+	return volume, nil
+}
+
+// Helper function to copy the data of a claim's data source into its filesystem, then return its volume.
+func (p *Provisioner) populate(options controller.ProvisionOptions, fs *efs.FileSystemDescription, source *cloneSource) (*corev1.PersistentVolume, error) {
+	if source != nil {
+		err := p.clone(options, fs, source)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	volume, err := p.volume(options, fs)
+	if err != nil {
+		return nil, err
+	}
+
+	if source != nil {
+		volume.ObjectMeta.Annotations[AnnotationClonedFrom] = source.Claim
+
+		if source.Backup != "" {
+			volume.ObjectMeta.Annotations[AnnotationRestoredBackup] = source.Backup
+		}
+	}
+
+	return volume, nil
+}
+
+// Helper function to copy data into a filesystem with a Job.
+//
+// The Job isn't waited on, so that a copy doesn't hold up other claims. An error is returned while it
+// is running, and the controller keeps the claim pending and tries again until it has succeeded.
+//
+// Jobs which failed are left for their logs to be inspected, and must be deleted for the copy to be tried again.
+func (p *Provisioner) clone(options controller.ProvisionOptions, fs *efs.FileSystemDescription, source *cloneSource) error {
+	var (
+		pvc  = options.PVC
+		id   = aws.StringValue(fs.FileSystemId)
+		from = source.Description
+	)
+
+	job, created, err := p.putJob(p.copyJob(copySpec{
+		Namespace:  pvc.ObjectMeta.Namespace,
+		Name:       fmt.Sprintf("efs-clone-%s", options.PVName),
+		Purpose:    copyPurposeClone,
+		Source:     &source.NFS,
+		SourcePath: source.Path,
+		Target:     p.export(id),
+	}))
+	if err != nil {
+		p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonCloneFailed, "Failed to start copying data from %s: %s", from, err)
+		return err
+	}
+
+	if created {
+		p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonCloneStarted, "Copying data from %s into filesystem %s with job %s", from, id, job.ObjectMeta.Name)
+	}
+
+	if message, ok := jobCondition(job, batchv1.JobFailed); ok {
+		p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonCloneFailed, "Failed to copy data from %s, delete job %s to try again: %s", from, job.ObjectMeta.Name, message)
+		return fmt.Errorf("failed to copy data from %s: job %s failed: %s", from, job.ObjectMeta.Name, message)
+	}
+
+	if _, ok := jobCondition(job, batchv1.JobComplete); !ok {
+		err := p.reportCopyFailures(job, func(failures int32) {
+			p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonCloneRetrying, "Copying data from %s failed %d times, retrying", from, failures)
+		})
+		if err != nil {
+			glog.Errorf("Failed to record failures of job %s: %s", job.ObjectMeta.Name, err)
+		}
+
+		return fmt.Errorf("waiting for job %s to copy data from %s into filesystem %s", job.ObjectMeta.Name, from, id)
+	}
+
+	p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonCloneSucceeded, "Copied data from %s into filesystem %s", from, id)
+
+	return p.deleteJob(job)
+}
@@ -0,0 +1,232 @@
+package provisioner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a claim with annotations.
+func testClaim(namespace, name string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: annotations,
+		},
+	}
+}
+
+// Helper function to return a bound claim, and the volume it is bound to.
+func testBoundClaim(namespace, name, id string) (*corev1.PersistentVolumeClaim, *corev1.PersistentVolume) {
+	pvc := testClaim(namespace, name, nil)
+	pvc.Spec.VolumeName = id
+	pvc.Status.Phase = corev1.ClaimBound
+
+	volume := testReleasedVolume(id)
+	volume.Spec.ClaimRef = &corev1.ObjectReference{
+		Namespace: namespace,
+		Name:      name,
+	}
+	volume.Status.Phase = corev1.VolumeBound
+
+	return pvc, volume
+}
+
+// Helper function to return provisioning options for a claim which is cloned from another claim.
+func testCloneOptions(namespace, name, source string) controller.ProvisionOptions {
+	options := testOptions(namespace, name)
+	options.PVC.Spec.DataSource = &corev1.TypedLocalObjectReference{
+		Kind: "PersistentVolumeClaim",
+		Name: source,
+	}
+
+	return options
+}
+
+func TestCloneSource(t *testing.T) {
+	pvc, volume := testBoundClaim("namespace", "production", "fs-00000001")
+
+	foreign := testReleasedVolume("foreign")
+	foreign.ObjectMeta.Annotations[annProvisionedBy] = "kubernetes.io/aws-ebs"
+
+	kubernetes := fake.NewSimpleClientset(pvc, volume, foreign, testClaim("namespace", "pending", nil), &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "namespace",
+			Name:      "ebs",
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			VolumeName: "foreign",
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase: corev1.ClaimBound,
+		},
+	})
+
+	provisioner, err := New(mock.New(), testParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	source, err := provisioner.dataSource(testOptions("namespace", "test").PVC)
+	assert.Nil(t, err)
+	assert.Nil(t, source)
+
+	source, err = provisioner.dataSource(testCloneOptions("namespace", "staging", "production").PVC)
+	assert.Nil(t, err)
+	assert.Equal(t, "claim namespace/production", source.Description)
+	assert.Equal(t, "fs-00000001.efs.ap-southeast-2.amazonaws.com", source.NFS.Server)
+
+	for name, want := range map[string]string{
+		"missing": "claim namespace/missing does not exist",
+		"pending": "claim namespace/pending is not bound",
+		"ebs":     "claim namespace/ebs was not provisioned by efs.aws.skpr.io/generalPurpose",
+	} {
+		_, err := provisioner.dataSource(testCloneOptions("namespace", "staging", name).PVC)
+		assert.EqualError(t, err, want, name)
+	}
+
+	options := testCloneOptions("namespace", "staging", "snapshot")
+	options.PVC.Spec.DataSource.APIGroup = &[]string{"snapshot.storage.k8s.io"}[0]
+	options.PVC.Spec.DataSource.Kind = "VolumeSnapshot"
+
+	_, err = provisioner.dataSource(options.PVC)
+	assert.EqualError(t, err, "unsupported data source: VolumeSnapshot snapshot")
+}
+
+func TestProvisionClone(t *testing.T) {
+	client := mock.New()
+
+	pvc, volume := testBoundClaim("namespace", "production", "fs-production")
+
+	params := testParams()
+	params.CopyImage = "rsync"
+
+	var (
+		kubernetes = fake.NewSimpleClientset(pvc, volume)
+		recorder   = record.NewFakeRecorder(100)
+	)
+
+	provisioner, err := New(client, params, WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	options := testCloneOptions("namespace", "staging", "production")
+
+	// The claim stays pending while the copy is running.
+	_, err = provisioner.Provision(options)
+	assert.EqualError(t, err, "waiting for job efs-clone-staging to copy data from claim namespace/production into filesystem fs-00000001")
+
+	jobs := kubernetes.BatchV1().Jobs("namespace")
+
+	job, err := jobs.Get("efs-clone-staging", metav1.GetOptions{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	spec := job.Spec.Template.Spec
+	assert.Equal(t, "fs-production.efs.ap-southeast-2.amazonaws.com", spec.Volumes[0].NFS.Server)
+	assert.True(t, spec.Volumes[0].NFS.ReadOnly)
+	assert.Equal(t, "fs-00000001.efs.ap-southeast-2.amazonaws.com", spec.Volumes[1].NFS.Server)
+	assert.False(t, spec.Volumes[1].NFS.ReadOnly)
+	assert.Equal(t, "rsync", spec.Containers[0].Image)
+
+	assert.Contains(t, testEvents(recorder), "Normal CloneStarted Copying data from claim namespace/production into filesystem fs-00000001 with job efs-clone-staging")
+
+	// Failures are reported once while the copy is retried.
+	job.Status.Failed = 1
+
+	_, err = jobs.UpdateStatus(job)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = provisioner.Provision(options)
+		assert.NotNil(t, err)
+	}
+
+	var retrying int
+
+	for _, event := range testEvents(recorder) {
+		if strings.HasPrefix(event, "Warning CloneRetrying") {
+			assert.Equal(t, "Warning CloneRetrying Copying data from claim namespace/production failed 1 times, retrying", event)
+			retrying++
+		}
+	}
+
+	assert.Equal(t, 1, retrying)
+
+	job, err = jobs.Get("efs-clone-staging", metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	job.Status.Conditions = []batchv1.JobCondition{
+		{
+			Type:   batchv1.JobComplete,
+			Status: corev1.ConditionTrue,
+		},
+	}
+
+	_, err = jobs.UpdateStatus(job)
+	assert.Nil(t, err)
+
+	volume, err = provisioner.Provision(options)
+	assert.Nil(t, err)
+	assert.Equal(t, "namespace/production", volume.ObjectMeta.Annotations[AnnotationClonedFrom])
+	assert.Equal(t, "fs-00000001.efs.ap-southeast-2.amazonaws.com", volume.Spec.NFS.Server)
+
+	assert.Contains(t, testEvents(recorder), "Normal CloneSucceeded Copied data from claim namespace/production into filesystem fs-00000001")
+
+	// The job is cleaned up once it succeeds.
+	_, err = jobs.Get("efs-clone-staging", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestProvisionCloneFailed(t *testing.T) {
+	client := mock.New()
+
+	pvc, volume := testBoundClaim("namespace", "production", "fs-production")
+
+	// A copy which failed before is left alone until it is deleted.
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "namespace",
+			Name:      "efs-clone-staging",
+		},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{
+					Type:    batchv1.JobFailed,
+					Status:  corev1.ConditionTrue,
+					Message: "Job has reached the specified backoff limit",
+				},
+			},
+		},
+	}
+
+	kubernetes := fake.NewSimpleClientset(pvc, volume, job)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	_, err = provisioner.Provision(testCloneOptions("namespace", "staging", "production"))
+	assert.EqualError(t, err, "failed to copy data from claim namespace/production: job efs-clone-staging failed: Job has reached the specified backoff limit")
+
+	_, err = kubernetes.BatchV1().Jobs("namespace").Get("efs-clone-staging", metav1.GetOptions{})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,102 @@
+package provisioner
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+const (
+	// Identifiers of the queries for each metric.
+	queryBurstCreditBalance  = "burstCreditBalance"
+	queryPermittedThroughput = "permittedThroughput"
+
+	// EFS publishes its metrics every minute, but they can take a few minutes to arrive.
+	metricsPeriod = 60
+	metricsWindow = 15 * time.Minute
+)
+
+var _ MetricsSource = &CloudWatchMetrics{}
+
+// CloudWatchMetrics reads the burst credits of filesystems from their CloudWatch metrics.
+type CloudWatchMetrics struct {
+	client cloudwatchiface.CloudWatchAPI
+	now    func() time.Time
+}
+
+// NewCloudWatchMetrics returns a MetricsSource which reads from CloudWatch.
+func NewCloudWatchMetrics(client cloudwatchiface.CloudWatchAPI) *CloudWatchMetrics {
+	return &CloudWatchMetrics{
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// BurstCredits of a filesystem from the latest BurstCreditBalance and PermittedThroughput metrics,
+// or false if either hasn't been published yet.
+func (m *CloudWatchMetrics) BurstCredits(id string) (BurstCredits, bool, error) {
+	var (
+		end   = m.now()
+		start = end.Add(-metricsWindow)
+	)
+
+	output, err := m.client.GetMetricData(&cloudwatch.GetMetricDataInput{
+		StartTime: aws.Time(start),
+		EndTime:   aws.Time(end),
+		ScanBy:    aws.String(cloudwatch.ScanByTimestampDescending),
+		MetricDataQueries: []*cloudwatch.MetricDataQuery{
+			metricQuery(queryBurstCreditBalance, id, "BurstCreditBalance", cloudwatch.StatisticMinimum),
+			metricQuery(queryPermittedThroughput, id, "PermittedThroughput", cloudwatch.StatisticAverage),
+		},
+	})
+	if err != nil {
+		return BurstCredits{}, false, err
+	}
+
+	values := make(map[string]float64)
+
+	for _, result := range output.MetricDataResults {
+		// Results are the newest first.
+		if len(result.Values) > 0 {
+			values[aws.StringValue(result.Id)] = aws.Float64Value(result.Values[0])
+		}
+	}
+
+	balance, ok := values[queryBurstCreditBalance]
+	if !ok {
+		return BurstCredits{}, false, nil
+	}
+
+	permitted, ok := values[queryPermittedThroughput]
+	if !ok {
+		return BurstCredits{}, false, nil
+	}
+
+	return BurstCredits{
+		Balance:             balance,
+		PermittedThroughput: permitted,
+	}, true, nil
+}
+
+// Helper function to query a metric of a filesystem.
+func metricQuery(id, filesystem, metric, stat string) *cloudwatch.MetricDataQuery {
+	return &cloudwatch.MetricDataQuery{
+		Id: aws.String(id),
+		MetricStat: &cloudwatch.MetricStat{
+			Metric: &cloudwatch.Metric{
+				Namespace:  aws.String("AWS/EFS"),
+				MetricName: aws.String(metric),
+				Dimensions: []*cloudwatch.Dimension{
+					{
+						Name:  aws.String("FileSystemId"),
+						Value: aws.String(filesystem),
+					},
+				},
+			},
+			Period: aws.Int64(metricsPeriod),
+			Stat:   aws.String(stat),
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// Stub which returns the same metric data for every query.
+type cloudwatchStub struct {
+	cloudwatchiface.CloudWatchAPI
+	input  *cloudwatch.GetMetricDataInput
+	output *cloudwatch.GetMetricDataOutput
+}
+
+func (s *cloudwatchStub) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	s.input = input
+	return s.output, nil
+}
+
+func TestCloudWatchMetrics(t *testing.T) {
+	stub := &cloudwatchStub{
+		output: &cloudwatch.GetMetricDataOutput{
+			MetricDataResults: []*cloudwatch.MetricDataResult{
+				{
+					Id:     aws.String(queryBurstCreditBalance),
+					Values: aws.Float64Slice([]float64{100, 200}),
+				},
+				{
+					Id: aws.String(queryPermittedThroughput),
+				},
+			},
+		},
+	}
+
+	metrics := NewCloudWatchMetrics(stub)
+	metrics.now = func() time.Time {
+		return time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	// Both metrics are needed.
+	_, ok, err := metrics.BurstCredits("fs-00000001")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, time.Date(2019, time.December, 31, 23, 45, 0, 0, time.UTC), aws.TimeValue(stub.input.StartTime))
+
+	if assert.Len(t, stub.input.MetricDataQueries, 2) {
+		stat := stub.input.MetricDataQueries[0].MetricStat
+		assert.Equal(t, "AWS/EFS", aws.StringValue(stat.Metric.Namespace))
+		assert.Equal(t, "BurstCreditBalance", aws.StringValue(stat.Metric.MetricName))
+		assert.Equal(t, "fs-00000001", aws.StringValue(stat.Metric.Dimensions[0].Value))
+	}
+
+	stub.output.MetricDataResults[1].Values = aws.Float64Slice([]float64{1024})
+
+	credits, ok, err := metrics.BurstCredits("fs-00000001")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, BurstCredits{Balance: 100, PermittedThroughput: 1024}, credits)
+}
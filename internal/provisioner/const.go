@@ -3,3 +3,152 @@ package provisioner
 // MountOptionAnnotation is the annotation on a PV object that specifies a
 // comma separated list of mount options
 const MountOptionAnnotation = "volume.beta.kubernetes.io/mount-options"
+
+// AnnotationProtect is the annotation on a volume, claim or namespace which stops filesystems from being deleted.
+const AnnotationProtect = "efs.aws.skpr.io/protect"
+
+// AnnotationDeletionProtected is the annotation on a volume which records why it was last kept from being deleted.
+const AnnotationDeletionProtected = "efs.aws.skpr.io/deletion-protected"
+
+// TagKeyOwner is the tag on a filesystem which records the provisioner that owns it.
+const TagKeyOwner = "efs.aws.skpr.io/provisioner"
+
+const (
+	// TagKeyClaimNamespace is the tag on a filesystem which records the namespace of the claim it was provisioned for.
+	TagKeyClaimNamespace = "efs.aws.skpr.io/claim-namespace"
+
+	// TagKeyClaimName is the tag on a filesystem which records the name of the claim it was provisioned for.
+	TagKeyClaimName = "efs.aws.skpr.io/claim-name"
+
+	// TagKeyProtect is the tag on a filesystem which stops it from being deleted.
+	TagKeyProtect = "efs.aws.skpr.io/protect"
+
+	// TagKeyManagedTags is the tag on a filesystem which records the keys of the tags that
+	// were added from the configuration or the claim's annotations.
+	TagKeyManagedTags = "efs.aws.skpr.io/managed-tags"
+
+	// TagKeyWarmPool is the tag on a filesystem which records the StorageClass whose warm pool it is waiting in.
+	TagKeyWarmPool = "efs.aws.skpr.io/warm-pool"
+
+	// TagKeySecurityGroupScope is the tag on a managed security group which records the filesystem or namespace it is for.
+	TagKeySecurityGroupScope = "efs.aws.skpr.io/security-group-scope"
+)
+
+const (
+	// EventReasonFilesystemCreating is emitted when a filesystem is created for a claim.
+	EventReasonFilesystemCreating = "FilesystemCreating"
+
+	// EventReasonFilesystemTagging is emitted when a filesystem is being tagged.
+	EventReasonFilesystemTagging = "FilesystemTagging"
+
+	// EventReasonTagsReconciled is emitted when the tags of a filesystem were updated to match the configuration.
+	EventReasonTagsReconciled = "TagsReconciled"
+
+	// EventReasonFilesystemClaimed is emitted when a claim is given a filesystem from the warm pool.
+	EventReasonFilesystemClaimed = "FilesystemClaimed"
+
+	// EventReasonCloneStarted is emitted when a Job was created to copy data from the claim a claim is cloned from.
+	EventReasonCloneStarted = "CloneStarted"
+
+	// EventReasonCloneRetrying is emitted when a Job which copies data from the claim a claim is cloned from failed, and will be retried.
+	EventReasonCloneRetrying = "CloneRetrying"
+
+	// EventReasonCloneSucceeded is emitted when data was copied from the claim a claim is cloned from.
+	EventReasonCloneSucceeded = "CloneSucceeded"
+
+	// EventReasonCloneFailed is emitted when data could not be copied from the claim a claim is cloned from.
+	EventReasonCloneFailed = "CloneFailed"
+
+	// EventReasonBackupStarted is emitted when a Job was created to back up a claim.
+	EventReasonBackupStarted = "BackupStarted"
+
+	// EventReasonBackupSucceeded is emitted when a claim was backed up.
+	EventReasonBackupSucceeded = "BackupSucceeded"
+
+	// EventReasonBackupFailed is emitted when a claim could not be backed up, or an old backup could not be removed.
+	EventReasonBackupFailed = "BackupFailed"
+
+	// EventReasonFilesystemAvailable is emitted when a filesystem has become available.
+	EventReasonFilesystemAvailable = "FilesystemAvailable"
+
+	// EventReasonFilesystemFailed is emitted when a filesystem could not be created or tagged.
+	EventReasonFilesystemFailed = "FilesystemFailed"
+
+	// EventReasonFilesystemMismatch is emitted when an existing filesystem does not have the requested settings.
+	EventReasonFilesystemMismatch = "FilesystemMismatch"
+
+	// EventReasonNamespaceNotAllowed is emitted when a claim is in a namespace which may not provision filesystems.
+	EventReasonNamespaceNotAllowed = "NamespaceNotAllowed"
+
+	// EventReasonQuotaExceeded is emitted when provisioning a claim would exceed a quota.
+	EventReasonQuotaExceeded = "QuotaExceeded"
+
+	// EventReasonLabelsRequired is emitted when a claim does not have the labels required by its StorageClass.
+	EventReasonLabelsRequired = "LabelsRequired"
+
+	// EventReasonUsageExceeded is emitted when a filesystem uses more than the storage its claim requested.
+	EventReasonUsageExceeded = "UsageExceeded"
+
+	// EventReasonCapacityExceeded is emitted when a claim is marked as using more than it requested.
+	EventReasonCapacityExceeded = "CapacityExceeded"
+
+	// EventReasonCapacityRestored is emitted when a claim no longer uses more than it requested.
+	EventReasonCapacityRestored = "CapacityRestored"
+
+	// EventReasonVolumeReadOnly is emitted when a volume is switched to read-only because its claim uses more than it requested.
+	EventReasonVolumeReadOnly = "VolumeReadOnly"
+
+	// EventReasonVolumeReadWrite is emitted when a volume is switched back to read-write.
+	EventReasonVolumeReadWrite = "VolumeReadWrite"
+
+	// EventReasonVolumeResized is emitted when the new size of a claim has been accepted.
+	EventReasonVolumeResized = "VolumeResized"
+
+	// EventReasonThroughputUpdated is emitted when the provisioned throughput of a filesystem was changed to match its claim.
+	EventReasonThroughputUpdated = "ThroughputUpdated"
+
+	// EventReasonThroughputFailed is emitted when the provisioned throughput of a filesystem could not be changed.
+	EventReasonThroughputFailed = "ThroughputFailed"
+
+	// EventReasonThroughputDeferred is emitted when a scheduled decrease of throughput has to wait for EFS to allow it.
+	EventReasonThroughputDeferred = "ThroughputDeferred"
+
+	// EventReasonThroughputScheduleInvalid is emitted when the throughput schedule of a claim could not be parsed.
+	EventReasonThroughputScheduleInvalid = "ThroughputScheduleInvalid"
+
+	// EventReasonThroughputAutoscaled is emitted when a filesystem is switched between bursting and provisioned throughput by the autoscaler.
+	EventReasonThroughputAutoscaled = "ThroughputAutoscaled"
+
+	// EventReasonBurstCreditsLow is emitted when a filesystem is running out of burst credits, but can't be switched to provisioned throughput.
+	EventReasonBurstCreditsLow = "BurstCreditsLow"
+
+	// EventReasonDeletionProtected is emitted when a volume is not deleted because it is protected.
+	EventReasonDeletionProtected = "DeletionProtected"
+
+	// EventReasonFilesystemDeleted is emitted when the filesystem backing a volume has been deleted.
+	EventReasonFilesystemDeleted = "FilesystemDeleted"
+
+	// EventReasonSecurityGroupFailed is emitted when a managed security group could not be created or deleted.
+	EventReasonSecurityGroupFailed = "SecurityGroupFailed"
+
+	// EventReasonPolicyApplied is emitted when the configured policy has been applied to a filesystem.
+	EventReasonPolicyApplied = "PolicyApplied"
+
+	// EventReasonPolicyFailed is emitted when the configured policy could not be applied to a filesystem.
+	EventReasonPolicyFailed = "PolicyFailed"
+
+	// EventReasonPolicyReconciled is emitted when the policy of a filesystem had drifted and was reapplied.
+	EventReasonPolicyReconciled = "PolicyReconciled"
+
+	// EventReasonMountTargetAvailable is emitted when a mount target has become available.
+	EventReasonMountTargetAvailable = "MountTargetAvailable"
+
+	// EventReasonMountTargetFailed is emitted when a mount target could not be created.
+	EventReasonMountTargetFailed = "MountTargetFailed"
+
+	// EventReasonMountTargetReconciled is emitted when a mount target is changed to match the configuration.
+	EventReasonMountTargetReconciled = "MountTargetReconciled"
+
+	// EventReasonMountTargetDrift is emitted when a mount target does not match the configuration and is left alone.
+	EventReasonMountTargetDrift = "MountTargetDrift"
+)
@@ -0,0 +1,211 @@
+package provisioner
+
+import (
+	"fmt"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelCopy is the label on Jobs which copy data between filesystems, which records what they are copying for.
+const LabelCopy = "efs.aws.skpr.io/copy"
+
+// Annotation on a copy Job which records how many of its failures have been reported.
+const annCopyFailures = "efs.aws.skpr.io/copy-failures"
+
+// Copies the source into the target, preserving ownership, permissions and hard links.
+const copyScript = `set -e
+mkdir -p "/target/${TARGET_PATH}"
+rsync -aH --numeric-ids --stats "/source/${SOURCE_PATH}/" "/target/${TARGET_PATH}/"
+`
+
+// Copy of data from one NFS export to another.
+type copySpec struct {
+	Namespace string
+	Name      string
+	// Purpose of the copy eg. "clone", which is recorded in the LabelCopy label.
+	Purpose string
+	// Labels added to the Job, as well as LabelCopy.
+	Labels map[string]string
+	// Source is optional for Jobs which only change the target eg. to remove a directory.
+	Source *corev1.NFSVolumeSource
+	Target corev1.NFSVolumeSource
+	// Directories within the source and target to copy from and to.
+	SourcePath string
+	TargetPath string
+	// Script which the Job runs, which defaults to copying the source into the target.
+	Script string
+}
+
+// Helper function to return a Job which copies data between filesystems.
+func (p *Provisioner) copyJob(spec copySpec) *batchv1.Job {
+	var (
+		labels = map[string]string{
+			LabelCopy: spec.Purpose,
+		}
+		backoff = int32(p.params.CopyBackoffLimit)
+		root    = int64(0)
+		script  = spec.Script
+	)
+
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+
+	if script == "" {
+		script = copyScript
+	}
+
+	target := spec.Target
+	target.ReadOnly = false
+
+	var (
+		mounts = []corev1.VolumeMount{
+			{Name: "target", MountPath: "/target"},
+		}
+		volumes = []corev1.Volume{
+			{
+				Name:         "target",
+				VolumeSource: corev1.VolumeSource{NFS: &target},
+			},
+		}
+	)
+
+	if spec.Source != nil {
+		source := *spec.Source
+		source.ReadOnly = true
+
+		mounts = append([]corev1.VolumeMount{
+			{Name: "source", MountPath: "/source", ReadOnly: true},
+		}, mounts...)
+
+		volumes = append([]corev1.Volume{
+			{
+				Name:         "source",
+				VolumeSource: corev1.VolumeSource{NFS: &source},
+			},
+		}, volumes...)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: spec.Namespace,
+			Name:      spec.Name,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoff,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					// Ownership can only be preserved by root.
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsUser: &root,
+					},
+					Containers: []corev1.Container{
+						{
+							Name:    "copy",
+							Image:   p.params.CopyImage,
+							Command: []string{"/bin/sh", "-c", script},
+							// The paths are passed through the environment so they don't need to be quoted.
+							Env: []corev1.EnvVar{
+								{Name: "SOURCE_PATH", Value: spec.SourcePath},
+								{Name: "TARGET_PATH", Value: spec.TargetPath},
+							},
+							VolumeMounts: mounts,
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
+
+// Helper function to return the NFS export of the root of a filesystem.
+func (p *Provisioner) export(id string) corev1.NFSVolumeSource {
+	return corev1.NFSVolumeSource{
+		Server: fmt.Sprintf("%s.efs.%s.amazonaws.com", id, p.params.Region),
+		Path:   "/",
+	}
+}
+
+// Helper function to create a Job, unless it already exists.
+//
+// Returns the Job, and true if it was created.
+func (p *Provisioner) putJob(job *batchv1.Job) (*batchv1.Job, bool, error) {
+	jobs := p.kubernetes.BatchV1().Jobs(job.ObjectMeta.Namespace)
+
+	existing, err := jobs.Get(job.ObjectMeta.Name, metav1.GetOptions{})
+	if err == nil {
+		return existing, false, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, false, fmt.Errorf("failed to get job %s: %s", job.ObjectMeta.Name, err)
+	}
+
+	created, err := jobs.Create(job)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create job %s: %s", job.ObjectMeta.Name, err)
+	}
+
+	return created, true, nil
+}
+
+// Helper function to delete a Job, along with its pods.
+func (p *Provisioner) deleteJob(job *batchv1.Job) error {
+	propagation := metav1.DeletePropagationBackground
+
+	err := p.kubernetes.BatchV1().Jobs(job.ObjectMeta.Namespace).Delete(job.ObjectMeta.Name, &metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete job %s: %s", job.ObjectMeta.Name, err)
+	}
+
+	return nil
+}
+
+// Helper function to report the failures of a Job which is being retried, once for each new failure.
+func (p *Provisioner) reportCopyFailures(job *batchv1.Job, report func(failures int32)) error {
+	reported, _ := strconv.Atoi(job.ObjectMeta.Annotations[annCopyFailures])
+	if job.Status.Failed <= int32(reported) {
+		return nil
+	}
+
+	report(job.Status.Failed)
+
+	job = job.DeepCopy()
+
+	if job.ObjectMeta.Annotations == nil {
+		job.ObjectMeta.Annotations = make(map[string]string)
+	}
+
+	job.ObjectMeta.Annotations[annCopyFailures] = strconv.Itoa(int(job.Status.Failed))
+
+	_, err := p.kubernetes.BatchV1().Jobs(job.ObjectMeta.Namespace).Update(job)
+	if err != nil {
+		return fmt.Errorf("failed to update job %s: %s", job.ObjectMeta.Name, err)
+	}
+
+	return nil
+}
+
+// Helper function to check if a Job has a condition eg. Complete or Failed.
+//
+// Returns the message of the condition, if it has one.
+func jobCondition(job *batchv1.Job, kind batchv1.JobConditionType) (string, bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == kind && condition.Status == corev1.ConditionTrue {
+			return condition.Message, true
+		}
+	}
+
+	return "", false
+}
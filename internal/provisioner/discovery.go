@@ -0,0 +1,359 @@
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DiscoveryModeTags discovers the subnets and security groups which are tagged for the cluster.
+	DiscoveryModeTags = "tags"
+
+	// DiscoveryModeNodes discovers the subnets and security groups of the cluster's nodes.
+	DiscoveryModeNodes = "nodes"
+)
+
+// Kinds of resources reported by the DiscoveredResources metric.
+const (
+	discoveredVPC           = "vpc"
+	discoveredSubnet        = "subnet"
+	discoveredSecurityGroup = "security_group"
+)
+
+// Discovery is the network which mount targets are created in, as discovered from the cluster.
+type Discovery struct {
+	VpcID          string
+	Subnets        []string
+	SecurityGroups []string
+
+	// Availability zones of the subnets, when they were discovered from nodes.
+	zones map[string]string
+}
+
+// Helper function to check if two discoveries found the same resources.
+func (d Discovery) equal(other Discovery) bool {
+	return d.VpcID == other.VpcID && sameStrings(d.Subnets, other.Subnets) && sameStrings(d.SecurityGroups, other.SecurityGroups)
+}
+
+// Discover the subnets and security groups for mount targets, replacing the previously
+// discovered set. The previous set is kept if discovery fails.
+func (p *Provisioner) Discover() error {
+	var (
+		discovery Discovery
+		err       error
+	)
+
+	switch p.params.Discovery {
+	case DiscoveryModeTags:
+		discovery, err = p.discoverTags()
+	case DiscoveryModeNodes:
+		discovery, err = p.discoverNodes()
+	default:
+		return nil
+	}
+
+	if err == nil && len(discovery.Subnets) == 0 {
+		err = fmt.Errorf("no subnets were found")
+	}
+
+	if err != nil {
+		DiscoveryErrorsTotal.Inc()
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && p.discovery.equal(discovery) {
+		return nil
+	}
+
+	glog.Infof("Discovered subnets %s and security groups %s in VPC %s", strings.Join(discovery.Subnets, ", "), strings.Join(discovery.SecurityGroups, ", "), discovery.VpcID)
+
+	p.discovery = &discovery
+	p.vpc = discovery.VpcID
+
+	DiscoveredResources.Reset()
+	DiscoveredResources.WithLabelValues(discoveredVPC, discovery.VpcID).Set(1)
+
+	for _, subnet := range discovery.Subnets {
+		DiscoveredResources.WithLabelValues(discoveredSubnet, subnet).Set(1)
+	}
+
+	for _, group := range discovery.SecurityGroups {
+		DiscoveredResources.WithLabelValues(discoveredSecurityGroup, group).Set(1)
+	}
+
+	return nil
+}
+
+// Helper function to return the subnets which mount targets are created in.
+func (p *Provisioner) subnets() []string {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+
+	if discovery == nil {
+		return p.params.Subnets
+	}
+
+	// Nodes come and go, so an availability zone without any nodes keeps the subnet its mount targets are in,
+	// rather than having them pruned.
+	if discovery.zones != nil && p.cache != nil {
+		return keepSubnets(discovery.zones, p.subnetZones())
+	}
+
+	return discovery.Subnets
+}
+
+// Helper function to return the security groups which were discovered, if any.
+func (p *Provisioner) discoveredSecurityGroups() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery == nil {
+		return nil
+	}
+
+	return p.discovery.SecurityGroups
+}
+
+// Helper function to discover the subnets and security groups tagged with the cluster tag.
+func (p *Provisioner) discoverTags() (Discovery, error) {
+	tag := clusterTagKey(p.params.ClusterName)
+
+	describe, err := p.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{tag})},
+		},
+	})
+	if err != nil {
+		return Discovery{}, fmt.Errorf("failed to describe subnets: %s", err)
+	}
+
+	vpc, subnets, err := zonalSubnets(describe.Subnets)
+	if err != nil {
+		return Discovery{}, err
+	}
+
+	groups, err := p.ec2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{vpc})},
+			{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{tag})},
+		},
+	})
+	if err != nil {
+		return Discovery{}, fmt.Errorf("failed to describe security groups: %s", err)
+	}
+
+	discovery := Discovery{
+		VpcID:   vpc,
+		Subnets: subnets,
+	}
+
+	for _, group := range groups.SecurityGroups {
+		discovery.SecurityGroups = append(discovery.SecurityGroups, aws.StringValue(group.GroupId))
+	}
+
+	sort.Strings(discovery.SecurityGroups)
+
+	return discovery, nil
+}
+
+// Helper function to discover the subnets and security groups which the cluster's nodes are in.
+func (p *Provisioner) discoverNodes() (Discovery, error) {
+	nodes, err := p.kubernetes.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return Discovery{}, fmt.Errorf("failed to list nodes: %s", err)
+	}
+
+	var ids []string
+
+	for _, node := range nodes.Items {
+		id, ok := instanceID(node.Spec.ProviderID)
+		if !ok {
+			glog.Warningf("Skipping node %s with unknown provider ID: %s", node.ObjectMeta.Name, node.Spec.ProviderID)
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return Discovery{}, fmt.Errorf("no nodes are running on EC2")
+	}
+
+	var (
+		instances []*ec2.Instance
+		input     = &ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice(ids),
+		}
+	)
+
+	for {
+		describe, err := p.ec2.DescribeInstances(input)
+		if err != nil {
+			return Discovery{}, fmt.Errorf("failed to describe instances: %s", err)
+		}
+
+		for _, reservation := range describe.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+
+		if aws.StringValue(describe.NextToken) == "" {
+			break
+		}
+
+		input.NextToken = describe.NextToken
+	}
+
+	var (
+		subnets []*ec2.Subnet
+		groups  []string
+	)
+
+	for _, instance := range instances {
+		subnet := &ec2.Subnet{
+			SubnetId: instance.SubnetId,
+			VpcId:    instance.VpcId,
+		}
+
+		if instance.Placement != nil {
+			subnet.AvailabilityZone = instance.Placement.AvailabilityZone
+		}
+
+		subnets = append(subnets, subnet)
+
+		for _, group := range instance.SecurityGroups {
+			if !containsString(groups, aws.StringValue(group.GroupId)) {
+				groups = append(groups, aws.StringValue(group.GroupId))
+			}
+		}
+	}
+
+	vpc, zonal, err := zonalSubnets(subnets)
+	if err != nil {
+		return Discovery{}, err
+	}
+
+	zones := make(map[string]string)
+
+	for _, subnet := range subnets {
+		if containsString(zonal, aws.StringValue(subnet.SubnetId)) {
+			zones[aws.StringValue(subnet.SubnetId)] = aws.StringValue(subnet.AvailabilityZone)
+		}
+	}
+
+	sort.Strings(groups)
+
+	return Discovery{
+		VpcID:          vpc,
+		Subnets:        zonal,
+		SecurityGroups: groups,
+		zones:          zones,
+	}, nil
+}
+
+// Helper function to combine the subnets discovered from nodes with the subnets which filesystems already have
+// mount targets in (both mapped to their availability zone). A subnet with mount targets is preferred in its
+// availability zone, so that existing mount targets are never replaced or pruned because of where nodes are running.
+func keepSubnets(discovered, existing map[string]string) []string {
+	zones := make(map[string]string)
+
+	for subnet, zone := range discovered {
+		zones[zone] = subnet
+	}
+
+	kept := make(map[string]bool)
+
+	for subnet, zone := range existing {
+		if current, ok := zones[zone]; !ok || !kept[zone] || subnet < current {
+			zones[zone] = subnet
+			kept[zone] = true
+		}
+	}
+
+	var list []string
+
+	for _, subnet := range zones {
+		list = append(list, subnet)
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// Helper function to pick a single subnet in each availability zone, as a filesystem can
+// only have one mount target per zone. The first subnet (by ID) in each zone is used.
+func zonalSubnets(subnets []*ec2.Subnet) (string, []string, error) {
+	var (
+		vpcs  []string
+		zones = make(map[string]string)
+	)
+
+	for _, subnet := range subnets {
+		var (
+			id   = aws.StringValue(subnet.SubnetId)
+			vpc  = aws.StringValue(subnet.VpcId)
+			zone = aws.StringValue(subnet.AvailabilityZone)
+		)
+
+		if !containsString(vpcs, vpc) {
+			vpcs = append(vpcs, vpc)
+		}
+
+		if existing, ok := zones[zone]; !ok || id < existing {
+			zones[zone] = id
+		}
+	}
+
+	if len(vpcs) > 1 {
+		sort.Strings(vpcs)
+		return "", nil, fmt.Errorf("subnets were found in multiple VPCs: %s", strings.Join(vpcs, ", "))
+	}
+
+	var list []string
+
+	for _, id := range zones {
+		list = append(list, id)
+	}
+
+	sort.Strings(list)
+
+	var vpc string
+
+	if len(vpcs) > 0 {
+		vpc = vpcs[0]
+	}
+
+	return vpc, list, nil
+}
+
+// Helper function to return the tag which marks resources as belonging to a cluster.
+func clusterTagKey(cluster string) string {
+	return fmt.Sprintf("kubernetes.io/cluster/%s", cluster)
+}
+
+// Helper function to return the EC2 instance ID from a node's provider ID,
+// eg. aws:///ap-southeast-2a/i-0123456789abcdef0
+func instanceID(providerID string) (string, bool) {
+	if !strings.HasPrefix(providerID, "aws://") {
+		return "", false
+	}
+
+	parts := strings.Split(providerID, "/")
+
+	id := parts[len(parts)-1]
+	if !strings.HasPrefix(id, "i-") {
+		return "", false
+	}
+
+	return id, true
+}
@@ -0,0 +1,222 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a fake EC2 API with subnets tagged for the "test" cluster.
+func testDiscoveryEC2() *mock.EC2 {
+	owned := mock.Tag{Key: "kubernetes.io/cluster/test", Value: "owned"}
+
+	return mock.NewEC2(
+		mock.WithEC2Subnet("subnet-a2", "vpc-xxxxxxxx", "ap-southeast-2a", owned),
+		mock.WithEC2Subnet("subnet-a1", "vpc-xxxxxxxx", "ap-southeast-2a", owned),
+		mock.WithEC2Subnet("subnet-b1", "vpc-xxxxxxxx", "ap-southeast-2b", owned),
+		mock.WithEC2Subnet("subnet-c1", "vpc-xxxxxxxx", "ap-southeast-2c"),
+		mock.WithEC2Subnet("subnet-other", "vpc-yyyyyyyy", "ap-southeast-2a"),
+		mock.WithEC2SecurityGroup("sg-cluster", "cluster", "vpc-xxxxxxxx", owned),
+		mock.WithEC2SecurityGroup("sg-other", "other", "vpc-xxxxxxxx"),
+		mock.WithEC2Instance("i-00000001", "subnet-a2", "sg-nodes"),
+		mock.WithEC2Instance("i-00000002", "subnet-b1", "sg-nodes", "sg-extra"),
+		mock.WithEC2Instance("i-00000003", "subnet-other", "sg-nodes"),
+		mock.WithEC2Instance("i-00000004", "subnet-a1", "sg-nodes"),
+	)
+}
+
+// Helper function to return params for discovering subnets.
+func testDiscoveryParams(mode string) Params {
+	params := testParams()
+	params.Subnets = nil
+	params.SecurityGroup = ""
+	params.Discovery = mode
+	params.ClusterName = "test"
+
+	return params
+}
+
+// Helper function to return a node running on an EC2 instance.
+func testNode(name, providerID string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: corev1.NodeSpec{
+			ProviderID: providerID,
+		},
+	}
+}
+
+func TestNewDiscovery(t *testing.T) {
+	params := testParams()
+	params.Subnets = nil
+
+	_, err := New(mock.New(), params)
+	assert.EqualError(t, err, "subnets are required unless they are discovered")
+
+	_, err = New(mock.New(), testDiscoveryParams(DiscoveryModeTags))
+	assert.EqualError(t, err, "an EC2 client is required to discover subnets")
+
+	params = testDiscoveryParams(DiscoveryModeTags)
+	params.ClusterName = ""
+
+	_, err = New(mock.New(), params, WithEC2(testDiscoveryEC2()))
+	assert.EqualError(t, err, "the cluster name is required to discover subnets by tag")
+
+	_, err = New(mock.New(), testDiscoveryParams(DiscoveryModeNodes), WithEC2(testDiscoveryEC2()))
+	assert.EqualError(t, err, "a Kubernetes client is required to discover subnets from nodes")
+
+	params = testDiscoveryParams(DiscoveryModeTags)
+	params.ClusterName = "missing"
+
+	_, err = New(mock.New(), params, WithEC2(testDiscoveryEC2()))
+	assert.EqualError(t, err, "failed to discover subnets: no subnets were found")
+
+	_, err = New(mock.New(), testDiscoveryParams("dns"), WithEC2(testDiscoveryEC2()))
+	assert.EqualError(t, err, "unknown discovery mode: dns")
+}
+
+func TestDiscoverTags(t *testing.T) {
+	client := mock.New()
+	cloud := testDiscoveryEC2()
+
+	provisioner, err := New(client, testDiscoveryParams(DiscoveryModeTags), WithEC2(cloud))
+	assert.Nil(t, err)
+
+	// A single subnet is used in each availability zone.
+	assert.Equal(t, []string{"subnet-a1", "subnet-b1"}, provisioner.subnets())
+	assert.Equal(t, []string{"sg-cluster"}, provisioner.staticSecurityGroups())
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(DiscoveredResources.WithLabelValues(discoveredVPC, "vpc-xxxxxxxx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(DiscoveredResources.WithLabelValues(discoveredSubnet, "subnet-a1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(DiscoveredResources.WithLabelValues(discoveredSecurityGroup, "sg-cluster")))
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	volume, err := provisioner.Provision(testOptions("namespace", "test"))
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"subnet-a1": {"sg-cluster"},
+		"subnet-b1": {"sg-cluster"},
+	}, testMountTargets(client, volume.ObjectMeta.Name))
+
+	// A subnet which is tagged later is picked up by the next discovery, and then by reconciling.
+	_, err = cloud.CreateTags(&ec2.CreateTagsInput{
+		Resources: aws.StringSlice([]string{"subnet-c1"}),
+		Tags:      []*ec2.Tag{{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("shared")}},
+	})
+	assert.Nil(t, err)
+
+	err = provisioner.Discover()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"subnet-a1", "subnet-b1", "subnet-c1"}, provisioner.subnets())
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Len(t, testMountTargets(client, volume.ObjectMeta.Name), 3)
+}
+
+func TestDiscoverNodes(t *testing.T) {
+	cloud := testDiscoveryEC2()
+
+	kubernetes := fake.NewSimpleClientset(
+		testNode("a", "aws:///ap-southeast-2a/i-00000001"),
+		testNode("b", "aws:///ap-southeast-2b/i-00000002"),
+		testNode("fargate", "fargate:///ap-southeast-2a/fargate-ip-10-0-0-1"),
+	)
+
+	params := testDiscoveryParams(DiscoveryModeNodes)
+	params.SecurityGroup = "sg-xxxxxxxxxxxx"
+
+	provisioner, err := New(mock.New(), params, WithEC2(cloud), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"subnet-a2", "subnet-b1"}, provisioner.subnets())
+	assert.Equal(t, []string{"sg-xxxxxxxxxxxx", "sg-extra", "sg-nodes"}, provisioner.staticSecurityGroups())
+
+	vpc, err := provisioner.vpcID()
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-xxxxxxxx", vpc)
+
+	// Nodes which span multiple VPCs are refused, and the previous discovery is kept.
+	_, err = kubernetes.CoreV1().Nodes().Create(testNode("c", "aws:///ap-southeast-2a/i-00000003"))
+	assert.Nil(t, err)
+
+	err = provisioner.Discover()
+	assert.EqualError(t, err, "subnets were found in multiple VPCs: vpc-xxxxxxxx, vpc-yyyyyyyy")
+	assert.Equal(t, []string{"subnet-a2", "subnet-b1"}, provisioner.subnets())
+}
+
+func TestDiscoverNodesKeepsMountTargets(t *testing.T) {
+	client := mock.New(mock.WithSubnet("subnet-a2", "ap-southeast-2a"), mock.WithSubnet("subnet-b1", "ap-southeast-2b"))
+
+	kubernetes := fake.NewSimpleClientset(
+		testNode("a", "aws:///ap-southeast-2a/i-00000001"),
+		testNode("b", "aws:///ap-southeast-2b/i-00000002"),
+	)
+
+	params := testDiscoveryParams(DiscoveryModeNodes)
+	params.PruneMountTargets = true
+
+	provisioner, err := New(client, params, WithEC2(testDiscoveryEC2()), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	volume, err := provisioner.Provision(testOptions("namespace", "test"))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	// The only node in a zone goes away, and another node starts in a different subnet of the other zone.
+	err = kubernetes.CoreV1().Nodes().Delete("b", &metav1.DeleteOptions{})
+	assert.Nil(t, err)
+
+	_, err = kubernetes.CoreV1().Nodes().Create(testNode("d", "aws:///ap-southeast-2a/i-00000004"))
+	assert.Nil(t, err)
+
+	err = provisioner.Discover()
+	assert.Nil(t, err)
+
+	// The subnets which have mount targets are kept, so none of them are pruned.
+	assert.Equal(t, []string{"subnet-a2", "subnet-b1"}, provisioner.subnets())
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"subnet-a2": {"sg-nodes"},
+		"subnet-b1": {"sg-nodes"},
+	}, testMountTargets(client, volume.ObjectMeta.Name))
+}
+
+func TestInstanceID(t *testing.T) {
+	for providerID, expected := range map[string]string{
+		"aws:///ap-southeast-2a/i-0123456789abcdef0": "i-0123456789abcdef0",
+		"aws:////i-0123456789abcdef0":                "i-0123456789abcdef0",
+		"aws:///ap-southeast-2a/fargate-10.0.0.1":    "",
+		"gce://project/zone/instance":                "",
+		"":                                           "",
+	} {
+		id, ok := instanceID(providerID)
+		assert.Equal(t, expected, id, providerID)
+		assert.Equal(t, expected != "", ok, providerID)
+	}
+}
@@ -0,0 +1,232 @@
+package provisioner
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// ParameterThroughputPerTiB is the StorageClass parameter which provisions throughput in proportion to the
+// storage a claim requests, eg. throughputPerTiB: "100" provisions 10 MiB/s for a claim of 100Gi.
+const ParameterThroughputPerTiB = "throughputPerTiB"
+
+// Number of bytes in a TiB.
+const tebibyte = 1 << 40
+
+// Resize accepts the new size of claims which were expanded, and keeps the provisioned throughput of
+// their filesystems in proportion to their size when their StorageClass asks for it.
+//
+// EFS filesystems grow as they are used, so there is nothing to resize. Expansion is accepted straight away.
+func (p *Provisioner) Resize() error {
+	if p.kubernetes == nil {
+		return nil
+	}
+
+	volumes, err := p.listVolumes()
+	if err != nil {
+		return err
+	}
+
+	claims, err := p.listClaims()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+
+	for _, volume := range volumes {
+		if volume.Status.Phase != corev1.VolumeBound {
+			continue
+		}
+
+		pvc := claims.Claim(volume)
+		if pvc == nil {
+			continue
+		}
+
+		err := p.resizeVolume(volume, pvc, claims.StorageClass(pvc))
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", volume.ObjectMeta.Name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to resize %d volumes: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Helper function to resize a single volume and the throughput of its filesystem.
+func (p *Provisioner) resizeVolume(volume *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim, class *storagev1.StorageClass) error {
+	if class == nil {
+		return nil
+	}
+
+	if class.AllowVolumeExpansion != nil && *class.AllowVolumeExpansion {
+		err := p.expandClaim(volume, pvc)
+		if err != nil {
+			return err
+		}
+	}
+
+	ratio, ok, err := throughputPerTiB(class)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return nil
+	}
+
+	// Schedules take over the throughput of their filesystems.
+	schedule, err := throughputSchedule(pvc, class)
+	if schedule != nil || err != nil {
+		return nil
+	}
+
+	id := filesystemID(volume)
+
+	fs, ok := p.cache.Filesystem(id)
+	if !ok || aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateAvailable {
+		return nil
+	}
+
+	request := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+
+	desired := Throughput{
+		Mode:        efs.ThroughputModeProvisioned,
+		Provisioned: requestedThroughput(request.Value(), ratio),
+	}
+
+	changed, err := p.updateThroughput(fs, desired)
+	if _, ok := err.(*ThroughputDeferredError); ok {
+		// This is checked again on the next pass, so there's no need to fill the claim with events.
+		glog.Infof("Not changing throughput of filesystem %s to %s: %s", id, desired, err)
+		return nil
+	}
+	if _, ok := err.(*QuotaError); ok {
+		// The claim has already been warned about the quota.
+		glog.Infof("Not changing throughput of filesystem %s to %s: %s", id, desired, err)
+		return nil
+	}
+	if err != nil {
+		p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonThroughputFailed, "Failed to change throughput of filesystem %s to %s: %s", id, desired, err)
+		return fmt.Errorf("failed to update throughput: %s", err)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	glog.Infof("Changed throughput of filesystem %s from %s to %s", id, currentThroughput(fs), desired)
+
+	ReconcileActionsTotal.WithLabelValues(actionUpdateThroughput).Inc()
+
+	p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonThroughputUpdated, "Changed throughput of filesystem %s from %s to %s for %s", id, currentThroughput(fs), desired, request.String())
+
+	return nil
+}
+
+// Helper function to accept the new size of a claim, by raising the capacity of its volume and claim
+// to match the request and clearing the conditions which say that it is still being resized.
+func (p *Provisioner) expandClaim(volume *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim) error {
+	request, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if !ok || request.IsZero() {
+		return nil
+	}
+
+	capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+
+	if capacity.Cmp(request) >= 0 && !resizing(pvc) {
+		return nil
+	}
+
+	// Volumes are provisioned with a capacity which is larger than any request, so they usually don't need raising.
+	current := volume.Spec.Capacity[corev1.ResourceStorage]
+
+	if current.Cmp(request) < 0 {
+		if volume.Spec.Capacity == nil {
+			volume.Spec.Capacity = make(corev1.ResourceList)
+		}
+
+		volume.Spec.Capacity[corev1.ResourceStorage] = request
+
+		_, err := p.kubernetes.CoreV1().PersistentVolumes().Update(volume)
+		if err != nil {
+			return fmt.Errorf("failed to update volume capacity: %s", err)
+		}
+	}
+
+	if pvc.Status.Capacity == nil {
+		pvc.Status.Capacity = make(corev1.ResourceList)
+	}
+
+	if capacity.Cmp(request) < 0 {
+		pvc.Status.Capacity[corev1.ResourceStorage] = request
+	}
+
+	var conditions []corev1.PersistentVolumeClaimCondition
+
+	for _, condition := range pvc.Status.Conditions {
+		if !resizeCondition(condition.Type) {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	pvc.Status.Conditions = conditions
+
+	_, err := p.kubernetes.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).UpdateStatus(pvc)
+	if err != nil {
+		return fmt.Errorf("failed to update claim status: %s", err)
+	}
+
+	glog.Infof("Accepted resize of claim %s/%s to %s", pvc.ObjectMeta.Namespace, pvc.ObjectMeta.Name, request.String())
+
+	p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonVolumeResized, "Volume %s resized to %s", volume.ObjectMeta.Name, request.String())
+
+	return nil
+}
+
+// Helper function to check if a claim is waiting to be resized.
+func resizing(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, condition := range pvc.Status.Conditions {
+		if resizeCondition(condition.Type) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Helper function to check if a condition is set on claims while they are being resized.
+func resizeCondition(condition corev1.PersistentVolumeClaimConditionType) bool {
+	return condition == corev1.PersistentVolumeClaimResizing || condition == corev1.PersistentVolumeClaimFileSystemResizePending
+}
+
+// Helper function to return the throughput (MiB/s) per TiB requested by a StorageClass, if it has one.
+func throughputPerTiB(class *storagev1.StorageClass) (float64, bool, error) {
+	value, ok := class.Parameters[ParameterThroughputPerTiB]
+	if !ok {
+		return 0, false, nil
+	}
+
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio <= 0 {
+		return 0, false, fmt.Errorf("invalid %s parameter: %s", ParameterThroughputPerTiB, value)
+	}
+
+	return ratio, true, nil
+}
+
+// Helper function to return the throughput (MiB/s) for a request, rounded up to a whole MiB/s.
+func requestedThroughput(bytes int64, ratio float64) float64 {
+	return clampThroughput(math.Ceil(float64(bytes) / tebibyte * ratio))
+}
@@ -0,0 +1,225 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a StorageClass which allows expansion.
+func testExpandableClass(parameters map[string]string) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "efs",
+		},
+		Provisioner:          "efs.aws.skpr.io/generalPurpose",
+		Parameters:           parameters,
+		AllowVolumeExpansion: &[]bool{true}[0],
+	}
+}
+
+// Helper function to return a claim which is being expanded from 1Gi.
+func testExpandedClaim(size string) *corev1.PersistentVolumeClaim {
+	pvc := testSizedClaim("namespace", "test", size)
+	pvc.Spec.StorageClassName = &[]string{"efs"}[0]
+	pvc.Status.Capacity = corev1.ResourceList{
+		corev1.ResourceStorage: resource.MustParse("1Gi"),
+	}
+	pvc.Status.Conditions = []corev1.PersistentVolumeClaimCondition{
+		{
+			Type:   corev1.PersistentVolumeClaimResizing,
+			Status: corev1.ConditionTrue,
+		},
+	}
+
+	return pvc
+}
+
+func TestResize(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+	volume.Spec.Capacity = corev1.ResourceList{
+		corev1.ResourceStorage: resource.MustParse("1Gi"),
+	}
+
+	kubernetes := fake.NewSimpleClientset(volume, testExpandedClaim("100Gi"), testExpandableClass(map[string]string{
+		ParameterThroughputPerTiB: "100",
+	}))
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder), WithClock(client.Clock().Now))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Resize()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"Normal VolumeResized Volume fs-00000001 resized to 100Gi",
+		"Normal ThroughputUpdated Changed throughput of filesystem fs-00000001 from bursting to provisioned (10 MiB/s) for 100Gi",
+	}, testEvents(recorder))
+
+	pvc, err := kubernetes.CoreV1().PersistentVolumeClaims("namespace").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, resource.MustParse("100Gi"), pvc.Status.Capacity[corev1.ResourceStorage])
+	assert.Empty(t, pvc.Status.Conditions)
+
+	updated, err := kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, resource.MustParse("100Gi"), updated.Spec.Capacity[corev1.ResourceStorage])
+
+	fs, _ := client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeProvisioned, fs.ThroughputMode)
+	assert.Equal(t, float64(10), fs.ProvisionedThroughput)
+
+	// Nothing changes once the claim has been resized.
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Resize()
+	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+
+	// Changing the throughput mode counts as a decrease, so lowering the ratio has to wait.
+	_, err = kubernetes.StorageV1().StorageClasses().Update(testExpandableClass(map[string]string{
+		ParameterThroughputPerTiB: "50",
+	}))
+	assert.Nil(t, err)
+
+	err = provisioner.Resize()
+	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+
+	fs, _ = client.FileSystem(id)
+	assert.Equal(t, float64(10), fs.ProvisionedThroughput)
+
+	client.Advance(ThroughputDecreaseCooldown + time.Minute)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Resize()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"Normal ThroughputUpdated Changed throughput of filesystem fs-00000001 from provisioned (10 MiB/s) to provisioned (5 MiB/s) for 100Gi",
+	}, testEvents(recorder))
+
+	fs, _ = client.FileSystem(id)
+	assert.Equal(t, float64(5), fs.ProvisionedThroughput)
+}
+
+func TestResizeNotExpandable(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+
+	class := testExpandableClass(nil)
+	class.AllowVolumeExpansion = nil
+
+	kubernetes := fake.NewSimpleClientset(volume, testExpandedClaim("100Gi"), class)
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Resize()
+	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+
+	pvc, err := kubernetes.CoreV1().PersistentVolumeClaims("namespace").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, resource.MustParse("1Gi"), pvc.Status.Capacity[corev1.ResourceStorage])
+
+	fs, _ := client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeBursting, fs.ThroughputMode)
+}
+
+func TestResizeInvalidRatio(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+
+	kubernetes := fake.NewSimpleClientset(volume, testExpandedClaim("100Gi"), testExpandableClass(map[string]string{
+		ParameterThroughputPerTiB: "lots",
+	}))
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	err = provisioner.Resize()
+	assert.EqualError(t, err, "failed to resize 1 volumes: fs-00000001: invalid throughputPerTiB parameter: lots")
+}
+
+func TestResizeThroughputQuota(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+
+	kubernetes := fake.NewSimpleClientset(volume, testExpandedClaim("100Gi"), testExpandableClass(map[string]string{
+		ParameterThroughputPerTiB: "100",
+	}))
+
+	params := testParams()
+	params.MaxThroughputPerNamespace = 5
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	// The claim is only warned once, even though its throughput is checked on every pass.
+	for i := 0; i < 2; i++ {
+		err = provisioner.Resize()
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, []string{
+		"Normal VolumeResized Volume fs-00000001 resized to 100Gi",
+		"Warning QuotaExceeded Not changing throughput of filesystem fs-00000001 to provisioned (10 MiB/s): namespace namespace already has 0 MiB/s of provisioned throughput, 10 MiB/s more would exceed its limit of 5 MiB/s",
+	}, testEvents(recorder))
+
+	fs, _ := client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeBursting, fs.ThroughputMode)
+	assert.Equal(t, 0, client.CallCount("UpdateFileSystem"))
+}
+
+func TestRequestedThroughput(t *testing.T) {
+	assert.Equal(t, float64(10), requestedThroughput(100*1024*1024*1024, 100))
+	assert.Equal(t, float64(100), requestedThroughput(1024*1024*1024*1024, 100))
+	assert.Equal(t, float64(MinProvisionedThroughput), requestedThroughput(0, 100))
+	assert.Equal(t, float64(MaxProvisionedThroughput), requestedThroughput(100*1024*1024*1024*1024, 100))
+}
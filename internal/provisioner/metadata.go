@@ -0,0 +1,181 @@
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// AnnotationFilesystemID is the annotation (and label) on a volume which records the ID of its filesystem.
+	AnnotationFilesystemID = "efs.aws.skpr.io/filesystem-id"
+
+	// AnnotationPerformanceMode is the annotation (and label) on a volume which records the performance mode of its filesystem.
+	AnnotationPerformanceMode = "efs.aws.skpr.io/performance-mode"
+
+	// AnnotationThroughputMode is the annotation (and label) on a volume which records the throughput mode of its filesystem.
+	AnnotationThroughputMode = "efs.aws.skpr.io/throughput-mode"
+
+	// AnnotationProvisionedThroughput is the annotation on a volume which records the provisioned throughput (MiB/s) of its filesystem.
+	AnnotationProvisionedThroughput = "efs.aws.skpr.io/provisioned-throughput"
+
+	// AnnotationEncrypted is the annotation (and label) on a volume which records whether its filesystem is encrypted.
+	AnnotationEncrypted = "efs.aws.skpr.io/encrypted"
+
+	// AnnotationKmsKeyID is the annotation on a volume which records the KMS key its filesystem is encrypted with.
+	AnnotationKmsKeyID = "efs.aws.skpr.io/kms-key-id"
+
+	// AnnotationRegion is the annotation (and label) on a volume which records the region of its filesystem.
+	AnnotationRegion = "efs.aws.skpr.io/region"
+
+	// AnnotationCreationToken is the annotation on a volume which records the CreationToken of its filesystem.
+	AnnotationCreationToken = "efs.aws.skpr.io/creation-token"
+
+	// AnnotationMountTargets is the annotation on a volume which records the IDs of its filesystem's mount targets.
+	AnnotationMountTargets = "efs.aws.skpr.io/mount-targets"
+)
+
+// Annotations which describe the filesystem backing a volume.
+var metadataAnnotations = []string{
+	AnnotationFilesystemID,
+	AnnotationPerformanceMode,
+	AnnotationThroughputMode,
+	AnnotationProvisionedThroughput,
+	AnnotationEncrypted,
+	AnnotationKmsKeyID,
+	AnnotationRegion,
+	AnnotationCreationToken,
+	AnnotationMountTargets,
+}
+
+// Labels which describe the filesystem backing a volume, their values are always valid label values.
+var metadataLabels = []string{
+	AnnotationFilesystemID,
+	AnnotationPerformanceMode,
+	AnnotationThroughputMode,
+	AnnotationEncrypted,
+	AnnotationRegion,
+}
+
+// Helper function to return the labels and annotations which describe the filesystem backing a volume.
+func volumeMetadata(fs *efs.FileSystemDescription, targets []*efs.MountTargetDescription, region string) (map[string]string, map[string]string) {
+	annotations := map[string]string{
+		AnnotationFilesystemID:    aws.StringValue(fs.FileSystemId),
+		AnnotationPerformanceMode: aws.StringValue(fs.PerformanceMode),
+		AnnotationThroughputMode:  aws.StringValue(fs.ThroughputMode),
+		AnnotationEncrypted:       strconv.FormatBool(aws.BoolValue(fs.Encrypted)),
+		AnnotationRegion:          region,
+		AnnotationCreationToken:   aws.StringValue(fs.CreationToken),
+	}
+
+	if aws.StringValue(fs.ThroughputMode) == efs.ThroughputModeProvisioned {
+		annotations[AnnotationProvisionedThroughput] = strconv.FormatFloat(aws.Float64Value(fs.ProvisionedThroughputInMibps), 'f', -1, 64)
+	}
+
+	if fs.KmsKeyId != nil {
+		annotations[AnnotationKmsKeyID] = aws.StringValue(fs.KmsKeyId)
+	}
+
+	var ids []string
+
+	for _, target := range targets {
+		ids = append(ids, aws.StringValue(target.MountTargetId))
+	}
+
+	if len(ids) > 0 {
+		sort.Strings(ids)
+		annotations[AnnotationMountTargets] = strings.Join(ids, ",")
+	}
+
+	labels := make(map[string]string)
+
+	for _, key := range metadataLabels {
+		if value := annotations[key]; value != "" {
+			labels[key] = value
+		}
+	}
+
+	return labels, annotations
+}
+
+// Helper function to update the labels and annotations of a volume to describe its filesystem.
+//
+// Returns true if the volume was changed.
+func setVolumeMetadata(meta *metav1.ObjectMeta, labels, annotations map[string]string) bool {
+	changed := setMetadata(&meta.Labels, metadataLabels, labels)
+
+	if setMetadata(&meta.Annotations, metadataAnnotations, annotations) {
+		changed = true
+	}
+
+	return changed
+}
+
+// Helper function to set the managed keys of a map, removing those which no longer have a value.
+func setMetadata(current *map[string]string, keys []string, desired map[string]string) bool {
+	var changed bool
+
+	for _, key := range keys {
+		value, ok := desired[key]
+		existing, exists := (*current)[key]
+
+		switch {
+		case ok && (!exists || existing != value):
+			if *current == nil {
+				*current = make(map[string]string)
+			}
+
+			(*current)[key] = value
+			changed = true
+		case !ok && exists:
+			delete(*current, key)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// Helper function to keep the labels and annotations of provisioned volumes in sync with their filesystems,
+// eg. after their throughput has been changed.
+func (p *Provisioner) reconcileVolumes(volumes []*corev1.PersistentVolume) error {
+	var failed []string
+
+	for _, volume := range volumes {
+		id := filesystemID(volume)
+
+		fs, ok := p.cache.Filesystem(id)
+		if !ok || aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateAvailable {
+			continue
+		}
+
+		labels, annotations := volumeMetadata(fs, p.cache.MountTargets(id), p.params.Region)
+
+		if !setVolumeMetadata(&volume.ObjectMeta, labels, annotations) {
+			continue
+		}
+
+		_, err := p.kubernetes.CoreV1().PersistentVolumes().Update(volume)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", volume.ObjectMeta.Name, err))
+			continue
+		}
+
+		glog.Infof("Updated metadata of volume %s for filesystem %s", volume.ObjectMeta.Name, id)
+
+		ReconcileActionsTotal.WithLabelValues(actionUpdateVolume).Inc()
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to update %d volumes: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
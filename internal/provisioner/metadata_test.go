@@ -0,0 +1,109 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+func TestVolumeMetadata(t *testing.T) {
+	fs := &efs.FileSystemDescription{
+		FileSystemId:                 aws.String("fs-00000001"),
+		CreationToken:                aws.String("namespace-test"),
+		PerformanceMode:              aws.String(efs.PerformanceModeMaxIo),
+		ThroughputMode:               aws.String(efs.ThroughputModeProvisioned),
+		ProvisionedThroughputInMibps: aws.Float64(12.5),
+		Encrypted:                    aws.Bool(true),
+		KmsKeyId:                     aws.String("arn:aws:kms:ap-southeast-2:123456789012:key/abcd"),
+	}
+
+	targets := []*efs.MountTargetDescription{
+		{MountTargetId: aws.String("fsmt-00000003")},
+		{MountTargetId: aws.String("fsmt-00000002")},
+	}
+
+	labels, annotations := volumeMetadata(fs, targets, "ap-southeast-2")
+
+	assert.Equal(t, map[string]string{
+		AnnotationFilesystemID:    "fs-00000001",
+		AnnotationPerformanceMode: "maxIO",
+		AnnotationThroughputMode:  "provisioned",
+		AnnotationEncrypted:       "true",
+		AnnotationRegion:          "ap-southeast-2",
+	}, labels)
+
+	assert.Equal(t, map[string]string{
+		AnnotationFilesystemID:          "fs-00000001",
+		AnnotationPerformanceMode:       "maxIO",
+		AnnotationThroughputMode:        "provisioned",
+		AnnotationProvisionedThroughput: "12.5",
+		AnnotationEncrypted:             "true",
+		AnnotationKmsKeyID:              "arn:aws:kms:ap-southeast-2:123456789012:key/abcd",
+		AnnotationRegion:                "ap-southeast-2",
+		AnnotationCreationToken:         "namespace-test",
+		AnnotationMountTargets:          "fsmt-00000002,fsmt-00000003",
+	}, annotations)
+}
+
+func TestReconcileVolumes(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+	volume.ObjectMeta.Annotations[AnnotationProvisionedThroughput] = "1"
+	volume.ObjectMeta.Annotations[AnnotationKmsKeyID] = "stale"
+	volume.ObjectMeta.Annotations["example.com/unrelated"] = "value"
+
+	other := testReleasedVolume("fs-00000099")
+	other.ObjectMeta.Name = "other"
+	other.ObjectMeta.Annotations[annProvisionedBy] = "efs.aws.skpr.io/maxIO"
+
+	kubernetes := fake.NewSimpleClientset(volume, other)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	_, err = client.UpdateFileSystem(&efs.UpdateFileSystemInput{
+		FileSystemId:                 aws.String(id),
+		ThroughputMode:               aws.String(efs.ThroughputModeProvisioned),
+		ProvisionedThroughputInMibps: aws.Float64(10),
+	})
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	updated, err := kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "provisioned", updated.ObjectMeta.Labels[AnnotationThroughputMode])
+	assert.Equal(t, "10", updated.ObjectMeta.Annotations[AnnotationProvisionedThroughput])
+	assert.Equal(t, "value", updated.ObjectMeta.Annotations["example.com/unrelated"])
+
+	// Values which no longer apply are removed.
+	assert.NotContains(t, updated.ObjectMeta.Annotations, AnnotationKmsKeyID)
+
+	// Volumes can be found by the attributes of their filesystems.
+	list, err := kubernetes.CoreV1().PersistentVolumes().List(metav1.ListOptions{
+		LabelSelector: AnnotationThroughputMode + "=provisioned",
+	})
+	assert.Nil(t, err)
+	assert.Len(t, list.Items, 1)
+
+	// Volumes of other provisioners are left alone.
+	unchanged, err := kubernetes.CoreV1().PersistentVolumes().Get("other", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, unchanged.ObjectMeta.Labels)
+}
@@ -0,0 +1,124 @@
+package provisioner
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsNamespace is the prometheus namespace of metrics exported by the provisioner.
+const MetricsNamespace = "efs_provisioner"
+
+var (
+	// MountTargetDrift is the number of mount targets which did not match the configuration
+	// when a filesystem was last reconciled.
+	MountTargetDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "mount_target_drift",
+			Help:      "Number of mount targets which did not match the configuration when the filesystem was last reconciled.",
+		},
+		[]string{"filesystem_id", "kind"},
+	)
+
+	// ReconcileActionsTotal is the number of changes made to mount targets while reconciling.
+	ReconcileActionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "reconcile_actions_total",
+			Help:      "Number of changes made to mount targets while reconciling filesystems.",
+		},
+		[]string{"action"},
+	)
+
+	// ReconcileErrorsTotal is the number of filesystems which could not be reconciled.
+	ReconcileErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "reconcile_errors_total",
+			Help:      "Number of times a filesystem could not be reconciled.",
+		},
+	)
+
+	// FilesystemUsedBytes is the metered size of each owned filesystem, by storage class.
+	FilesystemUsedBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "filesystem_used_bytes",
+			Help:      "Metered size of a filesystem in bytes, in total and in the Standard and Infrequent Access storage classes.",
+		},
+		[]string{"filesystem_id", "namespace", "claim", "storage_class"},
+	)
+
+	// FilesystemBurstCreditBalance is the latest burst credit balance of each owned filesystem.
+	FilesystemBurstCreditBalance = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "filesystem_burst_credit_balance_bytes",
+			Help:      "Latest burst credit balance of a filesystem in bytes, when throughput is autoscaled.",
+		},
+		[]string{"filesystem_id"},
+	)
+
+	// FilesystemPermittedThroughput is the latest permitted throughput of each owned filesystem.
+	FilesystemPermittedThroughput = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "filesystem_permitted_throughput_bytes",
+			Help:      "Latest throughput a filesystem is permitted in bytes per second, when throughput is autoscaled.",
+		},
+		[]string{"filesystem_id"},
+	)
+
+	// AutoscaleActionsTotal is the number of times the autoscaler changed (or couldn't change) the throughput mode of a filesystem.
+	AutoscaleActionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "autoscale_actions_total",
+			Help:      "Number of times filesystems were switched to provisioned or bursting throughput, or the switch was deferred.",
+		},
+		[]string{"action"},
+	)
+
+	// WarmPoolFilesystems is the number of unclaimed filesystems in the warm pool of each storage class.
+	WarmPoolFilesystems = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "warm_pool_filesystems",
+			Help:      "Number of filesystems in the warm pool of a storage class which are ready to be claimed, or still pending.",
+		},
+		[]string{"storage_class", "state"},
+	)
+
+	// DiscoveredResources is the VPC, subnets and security groups which were last discovered.
+	DiscoveredResources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "discovered_resources",
+			Help:      "VPC, subnets and security groups which were last discovered for mount targets.",
+		},
+		[]string{"kind", "id"},
+	)
+
+	// DiscoveryErrorsTotal is the number of times subnets and security groups could not be discovered.
+	DiscoveryErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "discovery_errors_total",
+			Help:      "Number of times subnets and security groups could not be discovered.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		MountTargetDrift,
+		ReconcileActionsTotal,
+		ReconcileErrorsTotal,
+		FilesystemUsedBytes,
+		FilesystemBurstCreditBalance,
+		FilesystemPermittedThroughput,
+		AutoscaleActionsTotal,
+		WarmPoolFilesystems,
+		DiscoveredResources,
+		DiscoveryErrorsTotal,
+	)
+}
@@ -0,0 +1,198 @@
+package mock
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// AccessPoint used for in memory mock storage.
+type AccessPoint struct {
+	ID            string
+	ARN           string
+	FileSystemID  string
+	ClientToken   string
+	Tags          []Tag
+	PosixUser     *efs.PosixUser
+	RootDirectory *efs.RootDirectory
+}
+
+// CreateAccessPoint mock.
+func (m *Client) CreateAccessPoint(input *efs.CreateAccessPointInput) (output *efs.CreateAccessPointOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("CreateAccessPoint", input, err) }()
+
+	if err := m.begin("CreateAccessPoint", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.State != efs.LifeCycleStateAvailable {
+		return nil, newError(efs.ErrCodeIncorrectFileSystemLifeCycleState, "File system '%s' is in state %s", fs.ID, fs.State)
+	}
+
+	var count int
+
+	for _, point := range m.accessPoints {
+		if point.FileSystemID != fs.ID {
+			continue
+		}
+
+		if point.ClientToken == aws.StringValue(input.ClientToken) {
+			return nil, newError(efs.ErrCodeAccessPointAlreadyExists, "Access point '%s' already exists with client token", point.ID)
+		}
+
+		count++
+	}
+
+	if count >= m.params.AccessPointLimit {
+		return nil, newError(efs.ErrCodeAccessPointLimitExceeded, "File system '%s' has reached the maximum number of access points", fs.ID)
+	}
+
+	point := &AccessPoint{
+		ID:            m.id("fsap"),
+		FileSystemID:  fs.ID,
+		ClientToken:   aws.StringValue(input.ClientToken),
+		PosixUser:     input.PosixUser,
+		RootDirectory: input.RootDirectory,
+	}
+
+	point.ARN = m.arn("access-point/" + point.ID)
+
+	for _, tag := range input.Tags {
+		point.Tags = setTag(point.Tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+	}
+
+	m.accessPoints[point.ID] = point
+
+	description := m.describeAccessPoint(point)
+
+	return &efs.CreateAccessPointOutput{
+		AccessPointId:  description.AccessPointId,
+		AccessPointArn: description.AccessPointArn,
+		ClientToken:    description.ClientToken,
+		FileSystemId:   description.FileSystemId,
+		LifeCycleState: description.LifeCycleState,
+		Name:           description.Name,
+		OwnerId:        description.OwnerId,
+		PosixUser:      description.PosixUser,
+		RootDirectory:  description.RootDirectory,
+		Tags:           description.Tags,
+	}, nil
+}
+
+// DescribeAccessPoints mock.
+func (m *Client) DescribeAccessPoints(input *efs.DescribeAccessPointsInput) (output *efs.DescribeAccessPointsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeAccessPoints", input, err) }()
+
+	if err := m.begin("DescribeAccessPoints", input); err != nil {
+		return nil, err
+	}
+
+	output = &efs.DescribeAccessPointsOutput{}
+
+	if input.AccessPointId != nil {
+		point, err := m.accessPoint(input.AccessPointId)
+		if err != nil {
+			return nil, err
+		}
+
+		output.AccessPoints = []*efs.AccessPointDescription{m.describeAccessPoint(point)}
+
+		return output, nil
+	}
+
+	if _, err := m.filesystem(input.FileSystemId); err != nil {
+		return nil, err
+	}
+
+	var list []*AccessPoint
+
+	for _, point := range m.accessPoints {
+		if point.FileSystemID == *input.FileSystemId {
+			list = append(list, point)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	start, end, next := m.paginate(len(list), input.NextToken, input.MaxResults)
+
+	for _, point := range list[start:end] {
+		output.AccessPoints = append(output.AccessPoints, m.describeAccessPoint(point))
+	}
+
+	output.NextToken = next
+
+	return output, nil
+}
+
+// Helper function to convert an in memory access point into a description.
+func (m *Client) describeAccessPoint(point *AccessPoint) *efs.AccessPointDescription {
+	description := &efs.AccessPointDescription{
+		AccessPointId:  aws.String(point.ID),
+		AccessPointArn: aws.String(point.ARN),
+		ClientToken:    aws.String(point.ClientToken),
+		FileSystemId:   aws.String(point.FileSystemID),
+		LifeCycleState: aws.String(efs.LifeCycleStateAvailable),
+		OwnerId:        aws.String(m.params.Account),
+		PosixUser:      point.PosixUser,
+		RootDirectory:  point.RootDirectory,
+		Tags:           describeTags(point.Tags),
+	}
+
+	for _, tag := range point.Tags {
+		if tag.Key == "Name" {
+			description.Name = aws.String(tag.Value)
+		}
+	}
+
+	return description
+}
+
+// DeleteAccessPoint mock.
+func (m *Client) DeleteAccessPoint(input *efs.DeleteAccessPointInput) (output *efs.DeleteAccessPointOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DeleteAccessPoint", input, err) }()
+
+	if err := m.begin("DeleteAccessPoint", input); err != nil {
+		return nil, err
+	}
+
+	point, err := m.accessPoint(input.AccessPointId)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(m.accessPoints, point.ID)
+
+	return &efs.DeleteAccessPointOutput{}, nil
+}
+
+// Helper function to lookup an access point by ID.
+func (m *Client) accessPoint(id *string) (*AccessPoint, error) {
+	if id == nil {
+		return nil, newError(efs.ErrCodeBadRequest, "AccessPointId is required")
+	}
+
+	point, ok := m.accessPoints[*id]
+	if !ok {
+		return nil, newError(efs.ErrCodeAccessPointNotFound, "Access point '%s' does not exist.", *id)
+	}
+
+	return point, nil
+}
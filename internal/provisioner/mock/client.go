@@ -1,132 +1,263 @@
 package mock
 
 import (
-	"errors"
+	"fmt"
+	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/efs"
 	"github.com/aws/aws-sdk-go/service/efs/efsiface"
 )
 
-// Client which mocks the EFS client.
+var _ efsiface.EFSAPI = &Client{}
+
+// Client which fakes the EFS API in memory.
+//
+// Filesystems, mount targets and access points move through their lifecycle states
+// as the (simulated) clock is advanced, quotas are enforced and errors are returned
+// with the same codes as AWS. Faults can be injected into any operation and every
+// call is recorded so tests can make assertions about how the API was used.
 type Client struct {
 	efsiface.EFSAPI
-	filesystems map[string]FileSystem
+	mu     sync.Mutex
+	clock  *Clock
+	params Params
+
+	filesystems  map[string]*FileSystem
+	mountTargets map[string]*MountTarget
+	accessPoints map[string]*AccessPoint
+	subnets      map[string]string
+
+	faults map[string][]Fault
+	calls  []Call
+	nextID int
 }
 
-// FileSystem used for in memory mock storage.
-type FileSystem struct {
-	ID          string
-	Tags        []Tag
-	Performance string
-	Mounts      []Mount
+// Params which control the behaviour of the fake.
+type Params struct {
+	Region  string
+	Account string
+
+	// How long it takes for resources to change state.
+	FileSystemCreateDelay  time.Duration
+	FileSystemDeleteDelay  time.Duration
+	MountTargetCreateDelay time.Duration
+	MountTargetDeleteDelay time.Duration
+
+	// Quotas which are enforced.
+	FileSystemLimit            int
+	AccessPointLimit           int
+	SecurityGroupLimit         int
+	ThroughputDecreaseCooldown time.Duration
+
+	// Size of a page of results when the caller does not ask for one.
+	PageSize int
 }
 
-// Tag used for in memory mock storage.
-type Tag struct {
-	Key   string
-	Value string
+// DefaultParams which behave like AWS, except that resources change state immediately.
+func DefaultParams() Params {
+	return Params{
+		Region:                     "ap-southeast-2",
+		Account:                    "123456789012",
+		FileSystemLimit:            1000,
+		AccessPointLimit:           120,
+		SecurityGroupLimit:         5,
+		ThroughputDecreaseCooldown: 24 * time.Hour,
+		PageSize:                   100,
+	}
 }
 
-// Mount used for in memory mock storage.
-type Mount struct {
-	SubnetID string
+// Option for configuring the fake.
+type Option func(*Client)
+
+// WithParams replaces the default params.
+func WithParams(params Params) Option {
+	return func(m *Client) {
+		m.params = params
+	}
 }
 
-// New mock EFS client.
-func New() *Client {
-	return &Client{
-		filesystems: make(map[string]FileSystem),
+// WithClock uses a clock which is shared with the test.
+func WithClock(clock *Clock) Option {
+	return func(m *Client) {
+		m.clock = clock
+	}
+}
+
+// WithSubnet registers a subnet and its availability zone. Once any subnet has been
+// registered, mount targets can only be created in registered subnets.
+func WithSubnet(id, zone string) Option {
+	return func(m *Client) {
+		m.subnets[id] = zone
 	}
 }
 
-// DescribeFileSystems mock.
-func (m *Client) DescribeFileSystems(input *efs.DescribeFileSystemsInput) (*efs.DescribeFileSystemsOutput, error) {
-	output := &efs.DescribeFileSystemsOutput{}
+// New mock EFS client.
+func New(options ...Option) *Client {
+	m := &Client{
+		clock:        NewClock(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)),
+		params:       DefaultParams(),
+		filesystems:  make(map[string]*FileSystem),
+		mountTargets: make(map[string]*MountTarget),
+		accessPoints: make(map[string]*AccessPoint),
+		subnets:      make(map[string]string),
+		faults:       make(map[string][]Fault),
+	}
 
-	if fs, ok := m.filesystems[*input.CreationToken]; ok {
-		output.FileSystems = []*efs.FileSystemDescription{
-			{
-				FileSystemId:    aws.String(fs.ID),
-				LifeCycleState:  aws.String(efs.LifeCycleStateAvailable),
-				PerformanceMode: aws.String(fs.Performance),
-			},
-		}
+	for _, option := range options {
+		option(m)
 	}
 
-	return output, nil
+	return m
 }
 
-// CreateFileSystem mock.
-func (m *Client) CreateFileSystem(input *efs.CreateFileSystemInput) (*efs.FileSystemDescription, error) {
-	output := &efs.FileSystemDescription{}
+// Clock used by the fake.
+func (m *Client) Clock() *Clock {
+	return m.clock
+}
 
-	m.filesystems[*input.CreationToken] = FileSystem{
-		ID:          *input.CreationToken,
-		Performance: *input.PerformanceMode,
-	}
+// Advance the clock used by the fake.
+func (m *Client) Advance(d time.Duration) {
+	m.clock.Advance(d)
+}
+
+// Fault which is checked before an operation is performed. Returning an error causes
+// the operation to fail with that error. Faults are called while the fake is locked,
+// so they must not call the fake.
+type Fault func(input interface{}) error
 
-	output.FileSystemId = input.CreationToken
+// Inject a fault into an operation eg. "CreateFileSystem".
+func (m *Client) Inject(operation string, fault Fault) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	return output, nil
+	m.faults[operation] = append(m.faults[operation], fault)
 }
 
-// CreateTags mock.
-func (m *Client) CreateTags(input *efs.CreateTagsInput) (*efs.CreateTagsOutput, error) {
-	output := &efs.CreateTagsOutput{}
+// FailNext causes the next call to an operation to fail with an error.
+func (m *Client) FailNext(operation string, err error) {
+	var done bool
 
-	if fs, ok := m.filesystems[*input.FileSystemId]; ok {
-		for _, tag := range input.Tags {
-			fs.Tags = append(fs.Tags, Tag{
-				Key:   *tag.Key,
-				Value: *tag.Value,
-			})
+	m.Inject(operation, func(input interface{}) error {
+		if done {
+			return nil
 		}
 
-		m.filesystems[*input.FileSystemId] = fs
+		done = true
 
-		return output, nil
-	}
+		return err
+	})
+}
+
+// Fail causes every call to an operation to fail with an error, until the faults are cleared.
+func (m *Client) Fail(operation string, err error) {
+	m.Inject(operation, func(input interface{}) error {
+		return err
+	})
+}
 
-	return output, errors.New("not found")
+// ClearFaults removes all injected faults.
+func (m *Client) ClearFaults() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults = make(map[string][]Fault)
+}
+
+// Call which was made to the fake.
+type Call struct {
+	Operation string
+	Input     interface{}
+	Err       error
+	Time      time.Time
 }
 
-// DescribeMountTargets mock.
-func (m *Client) DescribeMountTargets(input *efs.DescribeMountTargetsInput) (*efs.DescribeMountTargetsOutput, error) {
-	output := &efs.DescribeMountTargetsOutput{}
+// Calls returns the calls which have been made, optionally filtered by operation.
+func (m *Client) Calls(operations ...string) []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	if fs, ok := m.filesystems[*input.FileSystemId]; ok {
-		for _, mount := range fs.Mounts {
-			output.MountTargets = []*efs.MountTargetDescription{
-				{
-					SubnetId:       aws.String(mount.SubnetID),
-					LifeCycleState: aws.String(efs.LifeCycleStateAvailable),
-				},
-			}
+	var calls []Call
+
+	for _, call := range m.calls {
+		if len(operations) == 0 || contains(operations, call.Operation) {
+			calls = append(calls, call)
 		}
+	}
+
+	return calls
+}
+
+// CallCount returns the number of calls which have been made to an operation.
+func (m *Client) CallCount(operation string) int {
+	return len(m.Calls(operation))
+}
 
-		return output, nil
+// Helper function which must be called (with the lock held) at the start of each operation.
+// It moves resources through their lifecycle and checks for injected faults.
+func (m *Client) begin(operation string, input interface{}) error {
+	m.advance()
+
+	for _, fault := range m.faults[operation] {
+		if err := fault(input); err != nil {
+			return err
+		}
 	}
 
-	return output, errors.New("filesystem not found")
+	return nil
+}
+
+// Helper function to record a call once it has finished.
+func (m *Client) record(operation string, input interface{}, err error) {
+	m.calls = append(m.calls, Call{
+		Operation: operation,
+		Input:     input,
+		Err:       err,
+		Time:      m.clock.Now(),
+	})
 }
 
-// CreateMountTarget mock.
-func (m *Client) CreateMountTarget(input *efs.CreateMountTargetInput) (*efs.MountTargetDescription, error) {
-	output := &efs.MountTargetDescription{}
+// Helper function to move resources through their lifecycle, based on the current time.
+func (m *Client) advance() {
+	now := m.clock.Now()
+
+	for id, fs := range m.filesystems {
+		if fs.transition(now) && fs.State == efs.LifeCycleStateDeleted {
+			delete(m.filesystems, id)
+		}
+	}
+
+	for id, target := range m.mountTargets {
+		if target.transition(now) && target.State == efs.LifeCycleStateDeleted {
+			delete(m.mountTargets, id)
+		}
+	}
+}
 
-	if fs, ok := m.filesystems[*input.FileSystemId]; ok {
-		fs.Mounts = append(fs.Mounts, Mount{
-			SubnetID: *input.SubnetId,
-		})
+// Helper function to generate a resource ID.
+func (m *Client) id(prefix string) string {
+	m.nextID++
+	return fmt.Sprintf("%s-%08x", prefix, m.nextID)
+}
 
-		m.filesystems[*input.FileSystemId] = fs
+// Helper function to generate a resource ARN.
+func (m *Client) arn(resource string) string {
+	return fmt.Sprintf("arn:aws:elasticfilesystem:%s:%s:%s", m.params.Region, m.params.Account, resource)
+}
 
-		output.SubnetId = input.SubnetId
-		output.LifeCycleState = aws.String(efs.LifeCycleStateCreating)
+// Helper function to return an error in the same way as the AWS SDK.
+func newError(code, format string, args ...interface{}) error {
+	return awserr.New(code, fmt.Sprintf(format, args...), nil)
+}
 
-		return output, nil
+// Helper function to check if a list contains a value.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
 	}
 
-	return output, errors.New("filesystem not found")
+	return false
 }
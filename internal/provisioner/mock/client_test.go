@@ -0,0 +1,371 @@
+package mock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to check the AWS error code of an error.
+func assertCode(t *testing.T, code string, err error) {
+	aerr, ok := err.(awserr.Error)
+	if assert.True(t, ok, "expected an AWS error, got: %v", err) {
+		assert.Equal(t, code, aerr.Code())
+	}
+}
+
+func TestFileSystemLifecycle(t *testing.T) {
+	params := DefaultParams()
+	params.FileSystemCreateDelay = time.Minute
+	params.FileSystemDeleteDelay = time.Minute
+	params.MountTargetCreateDelay = time.Minute
+	params.MountTargetDeleteDelay = time.Minute
+
+	m := New(WithParams(params))
+
+	fs, err := m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "fs-00000001", *fs.FileSystemId)
+	assert.Equal(t, efs.LifeCycleStateCreating, *fs.LifeCycleState)
+
+	_, err = m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+	})
+	assertCode(t, efs.ErrCodeFileSystemAlreadyExists, err)
+
+	// Mount targets can't be created until the filesystem is available.
+	_, err = m.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId: fs.FileSystemId,
+		SubnetId:     aws.String("subnet-xxxxxxxx"),
+	})
+	assertCode(t, efs.ErrCodeIncorrectFileSystemLifeCycleState, err)
+
+	m.Advance(time.Minute)
+
+	describe, err := m.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, efs.LifeCycleStateAvailable, *describe.FileSystems[0].LifeCycleState)
+
+	target, err := m.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId: fs.FileSystemId,
+		SubnetId:     aws.String("subnet-xxxxxxxx"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, efs.LifeCycleStateCreating, *target.LifeCycleState)
+
+	// Only one mount target can exist per availability zone.
+	_, err = m.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId: fs.FileSystemId,
+		SubnetId:     aws.String("subnet-xxxxxxxx"),
+	})
+	assertCode(t, efs.ErrCodeMountTargetConflict, err)
+
+	m.Advance(time.Minute)
+
+	// Filesystems with mount targets can't be deleted.
+	_, err = m.DeleteFileSystem(&efs.DeleteFileSystemInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assertCode(t, efs.ErrCodeFileSystemInUse, err)
+
+	_, err = m.DeleteMountTarget(&efs.DeleteMountTargetInput{
+		MountTargetId: target.MountTargetId,
+	})
+	assert.Nil(t, err)
+
+	m.Advance(time.Minute)
+
+	_, err = m.DeleteFileSystem(&efs.DeleteFileSystemInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assert.Nil(t, err)
+
+	existing, _ := m.FileSystem(*fs.FileSystemId)
+	assert.Equal(t, efs.LifeCycleStateDeleting, existing.State)
+
+	m.Advance(time.Minute)
+
+	_, err = m.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assertCode(t, efs.ErrCodeFileSystemNotFound, err)
+}
+
+func TestDescribeMountTargets(t *testing.T) {
+	m := New()
+
+	fs, err := m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.Nil(t, err)
+
+	for _, subnet := range []string{"subnet-a", "subnet-b", "subnet-c"} {
+		_, err := m.CreateMountTarget(&efs.CreateMountTargetInput{
+			FileSystemId:   fs.FileSystemId,
+			SubnetId:       aws.String(subnet),
+			SecurityGroups: aws.StringSlice([]string{"sg-xxxxxxxx"}),
+		})
+		assert.Nil(t, err)
+	}
+
+	page, err := m.DescribeMountTargets(&efs.DescribeMountTargetsInput{
+		FileSystemId: fs.FileSystemId,
+		MaxItems:     aws.Int64(2),
+	})
+	assert.Nil(t, err)
+	assert.Len(t, page.MountTargets, 2)
+	assert.NotNil(t, page.NextMarker)
+
+	page, err = m.DescribeMountTargets(&efs.DescribeMountTargetsInput{
+		FileSystemId: fs.FileSystemId,
+		Marker:       page.NextMarker,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, page.MountTargets, 1)
+	assert.Nil(t, page.NextMarker)
+	assert.Equal(t, "subnet-c", *page.MountTargets[0].SubnetId)
+
+	_, err = m.ModifyMountTargetSecurityGroups(&efs.ModifyMountTargetSecurityGroupsInput{
+		MountTargetId:  page.MountTargets[0].MountTargetId,
+		SecurityGroups: aws.StringSlice([]string{"sg-1", "sg-2", "sg-3", "sg-4", "sg-5", "sg-6"}),
+	})
+	assertCode(t, efs.ErrCodeSecurityGroupLimitExceeded, err)
+
+	groups, err := m.DescribeMountTargetSecurityGroups(&efs.DescribeMountTargetSecurityGroupsInput{
+		MountTargetId: page.MountTargets[0].MountTargetId,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sg-xxxxxxxx"}, aws.StringValueSlice(groups.SecurityGroups))
+}
+
+func TestQuotas(t *testing.T) {
+	params := DefaultParams()
+	params.FileSystemLimit = 1
+
+	m := New(WithParams(params), WithSubnet("subnet-a", "ap-southeast-2a"), WithSubnet("subnet-b", "ap-southeast-2a"))
+
+	fs, err := m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("one"),
+	})
+	assert.Nil(t, err)
+
+	_, err = m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("two"),
+	})
+	assertCode(t, efs.ErrCodeFileSystemLimitExceeded, err)
+
+	_, err = m.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId: fs.FileSystemId,
+		SubnetId:     aws.String("subnet-unknown"),
+	})
+	assertCode(t, efs.ErrCodeSubnetNotFound, err)
+
+	_, err = m.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId: fs.FileSystemId,
+		SubnetId:     aws.String("subnet-a"),
+	})
+	assert.Nil(t, err)
+
+	// Both subnets are in the same availability zone.
+	_, err = m.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId: fs.FileSystemId,
+		SubnetId:     aws.String("subnet-b"),
+	})
+	assertCode(t, efs.ErrCodeMountTargetConflict, err)
+}
+
+func TestUpdateFileSystem(t *testing.T) {
+	m := New()
+
+	fs, err := m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.Nil(t, err)
+
+	_, err = m.UpdateFileSystem(&efs.UpdateFileSystemInput{
+		FileSystemId:                 fs.FileSystemId,
+		ThroughputMode:               aws.String(efs.ThroughputModeProvisioned),
+		ProvisionedThroughputInMibps: aws.Float64(100),
+	})
+	assert.Nil(t, err)
+
+	// Increases are always allowed.
+	_, err = m.UpdateFileSystem(&efs.UpdateFileSystemInput{
+		FileSystemId:                 fs.FileSystemId,
+		ProvisionedThroughputInMibps: aws.Float64(200),
+	})
+	assert.Nil(t, err)
+
+	// Changing the throughput mode started the cooldown.
+	_, err = m.UpdateFileSystem(&efs.UpdateFileSystemInput{
+		FileSystemId:                 fs.FileSystemId,
+		ProvisionedThroughputInMibps: aws.Float64(50),
+	})
+	assertCode(t, efs.ErrCodeTooManyRequests, err)
+
+	m.Advance(24 * time.Hour)
+
+	_, err = m.UpdateFileSystem(&efs.UpdateFileSystemInput{
+		FileSystemId:                 fs.FileSystemId,
+		ProvisionedThroughputInMibps: aws.Float64(50),
+	})
+	assert.Nil(t, err)
+
+	existing, _ := m.FileSystem(*fs.FileSystemId)
+	assert.Equal(t, float64(50), existing.ProvisionedThroughput)
+}
+
+func TestTagsAndPolicy(t *testing.T) {
+	m := New()
+
+	fs, err := m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+		Tags: []*efs.Tag{
+			{Key: aws.String("Name"), Value: aws.String("test")},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "test", *fs.Name)
+
+	_, err = m.TagResource(&efs.TagResourceInput{
+		ResourceId: fs.FileSystemId,
+		Tags: []*efs.Tag{
+			{Key: aws.String("Name"), Value: aws.String("renamed")},
+			{Key: aws.String("team"), Value: aws.String("platform")},
+		},
+	})
+	assert.Nil(t, err)
+
+	_, err = m.UntagResource(&efs.UntagResourceInput{
+		ResourceId: fs.FileSystemId,
+		TagKeys:    aws.StringSlice([]string{"team"}),
+	})
+	assert.Nil(t, err)
+
+	tags, err := m.ListTagsForResource(&efs.ListTagsForResourceInput{
+		ResourceId: fs.FileSystemId,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []*efs.Tag{{Key: aws.String("Name"), Value: aws.String("renamed")}}, tags.Tags)
+
+	_, err = m.DescribeFileSystemPolicy(&efs.DescribeFileSystemPolicyInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assertCode(t, efs.ErrCodePolicyNotFound, err)
+
+	_, err = m.PutFileSystemPolicy(&efs.PutFileSystemPolicyInput{
+		FileSystemId: fs.FileSystemId,
+		Policy:       aws.String("not json"),
+	})
+	assertCode(t, efs.ErrCodeInvalidPolicyException, err)
+
+	_, err = m.PutFileSystemPolicy(&efs.PutFileSystemPolicyInput{
+		FileSystemId: fs.FileSystemId,
+		Policy:       aws.String(`{"Statement":[]}`),
+	})
+	assert.Nil(t, err)
+
+	policy, err := m.DescribeFileSystemPolicy(&efs.DescribeFileSystemPolicyInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"Statement":[]}`, *policy.Policy)
+}
+
+func TestAccessPoints(t *testing.T) {
+	m := New()
+
+	fs, err := m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.Nil(t, err)
+
+	point, err := m.CreateAccessPoint(&efs.CreateAccessPointInput{
+		FileSystemId: fs.FileSystemId,
+		ClientToken:  aws.String("token"),
+	})
+	assert.Nil(t, err)
+
+	_, err = m.CreateAccessPoint(&efs.CreateAccessPointInput{
+		FileSystemId: fs.FileSystemId,
+		ClientToken:  aws.String("token"),
+	})
+	assertCode(t, efs.ErrCodeAccessPointAlreadyExists, err)
+
+	_, err = m.TagResource(&efs.TagResourceInput{
+		ResourceId: point.AccessPointId,
+		Tags: []*efs.Tag{
+			{Key: aws.String("Name"), Value: aws.String("test")},
+		},
+	})
+	assert.Nil(t, err)
+
+	describe, err := m.DescribeAccessPoints(&efs.DescribeAccessPointsInput{
+		FileSystemId: fs.FileSystemId,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, describe.AccessPoints, 1)
+	assert.Equal(t, "test", *describe.AccessPoints[0].Name)
+
+	_, err = m.DeleteAccessPoint(&efs.DeleteAccessPointInput{
+		AccessPointId: point.AccessPointId,
+	})
+	assert.Nil(t, err)
+
+	_, err = m.DescribeAccessPoints(&efs.DescribeAccessPointsInput{
+		AccessPointId: point.AccessPointId,
+	})
+	assertCode(t, efs.ErrCodeAccessPointNotFound, err)
+}
+
+func TestFaultsAndCalls(t *testing.T) {
+	m := New()
+
+	m.FailNext("CreateFileSystem", errors.New("injected"))
+
+	_, err := m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.EqualError(t, err, "injected")
+
+	_, err = m.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.Nil(t, err)
+
+	m.Inject("DescribeFileSystems", func(input interface{}) error {
+		if aws.StringValue(input.(*efs.DescribeFileSystemsInput).CreationToken) == "broken" {
+			return awserr.New("ThrottlingException", "Rate exceeded", nil)
+		}
+
+		return nil
+	})
+
+	_, err = m.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		CreationToken: aws.String("broken"),
+	})
+	assertCode(t, "ThrottlingException", err)
+
+	_, err = m.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		CreationToken: aws.String("test"),
+	})
+	assert.Nil(t, err)
+
+	m.ClearFaults()
+
+	calls := m.Calls("CreateFileSystem")
+	assert.Len(t, calls, 2)
+	assert.EqualError(t, calls[0].Err, "injected")
+	assert.Nil(t, calls[1].Err)
+	assert.Equal(t, 4, len(m.Calls()))
+	assert.Equal(t, 2, m.CallCount("DescribeFileSystems"))
+}
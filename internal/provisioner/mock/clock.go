@@ -0,0 +1,61 @@
+package mock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock which only moves when it is advanced.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock starting at a point in time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{
+		now: now,
+	}
+}
+
+// Now returns the current (simulated) time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance the clock.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Lifecycle of a resource which changes state over time.
+type lifecycle struct {
+	State string
+	next  string
+	at    time.Time
+}
+
+// Helper function to schedule a change of state.
+func (l *lifecycle) schedule(now time.Time, current, next string, delay time.Duration) {
+	l.State = current
+	l.next = next
+	l.at = now.Add(delay)
+}
+
+// Helper function to apply a scheduled change of state, returning true if the state changed.
+func (l *lifecycle) transition(now time.Time) bool {
+	if l.next == "" || now.Before(l.at) {
+		return false
+	}
+
+	l.State = l.next
+	l.next = ""
+
+	return true
+}
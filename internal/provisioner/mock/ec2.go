@@ -0,0 +1,508 @@
+package mock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+var _ ec2iface.EC2API = &EC2{}
+
+// EC2 which fakes the parts of the EC2 API used to manage networking for filesystems.
+type EC2 struct {
+	ec2iface.EC2API
+	mu sync.Mutex
+
+	account string
+	efs     *Client
+
+	subnets        map[string]*Subnet
+	securityGroups map[string]*SecurityGroup
+	instances      map[string]*Instance
+
+	calls  []Call
+	nextID int
+}
+
+// Subnet used for in memory mock storage.
+type Subnet struct {
+	ID               string
+	VpcID            string
+	AvailabilityZone string
+	Tags             []Tag
+}
+
+// SecurityGroup used for in memory mock storage.
+type SecurityGroup struct {
+	ID          string
+	Name        string
+	Description string
+	VpcID       string
+	Ingress     []*ec2.IpPermission
+	Tags        []Tag
+}
+
+// Instance used for in memory mock storage.
+type Instance struct {
+	ID             string
+	SubnetID       string
+	SecurityGroups []string
+}
+
+// EC2Option for configuring the fake.
+type EC2Option func(*EC2)
+
+// WithEC2Subnet registers a subnet in a VPC.
+func WithEC2Subnet(id, vpc, zone string, tags ...Tag) EC2Option {
+	return func(m *EC2) {
+		m.subnets[id] = &Subnet{
+			ID:               id,
+			VpcID:            vpc,
+			AvailabilityZone: zone,
+			Tags:             tags,
+		}
+	}
+}
+
+// WithEC2SecurityGroup registers an existing security group in a VPC.
+func WithEC2SecurityGroup(id, name, vpc string, tags ...Tag) EC2Option {
+	return func(m *EC2) {
+		m.securityGroups[id] = &SecurityGroup{
+			ID:    id,
+			Name:  name,
+			VpcID: vpc,
+			Tags:  tags,
+		}
+	}
+}
+
+// WithEC2Instance registers an instance running in a subnet.
+func WithEC2Instance(id, subnet string, groups ...string) EC2Option {
+	return func(m *EC2) {
+		m.instances[id] = &Instance{
+			ID:             id,
+			SubnetID:       subnet,
+			SecurityGroups: groups,
+		}
+	}
+}
+
+// WithEFS links the fake to an EFS fake, so security groups which are used by
+// mount targets can't be deleted.
+func WithEFS(client *Client) EC2Option {
+	return func(m *EC2) {
+		m.efs = client
+	}
+}
+
+// NewEC2 mock EC2 client.
+func NewEC2(options ...EC2Option) *EC2 {
+	m := &EC2{
+		account:        DefaultParams().Account,
+		subnets:        make(map[string]*Subnet),
+		securityGroups: make(map[string]*SecurityGroup),
+		instances:      make(map[string]*Instance),
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// SecurityGroup returns a snapshot of a security group.
+func (m *EC2) SecurityGroup(id string) (SecurityGroup, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.securityGroups[id]
+	if !ok {
+		return SecurityGroup{}, false
+	}
+
+	return *group, true
+}
+
+// SecurityGroups returns snapshots of all the security groups.
+func (m *EC2) SecurityGroups() []SecurityGroup {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []SecurityGroup
+
+	for _, group := range m.sortedSecurityGroups() {
+		list = append(list, *group)
+	}
+
+	return list
+}
+
+// CallCount returns the number of calls which have been made to an operation.
+func (m *EC2) CallCount(operation string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int
+
+	for _, call := range m.calls {
+		if call.Operation == operation {
+			count++
+		}
+	}
+
+	return count
+}
+
+// DescribeSubnets mock.
+func (m *EC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (output *ec2.DescribeSubnetsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeSubnets", input, err) }()
+
+	var ids []string
+
+	for id := range m.subnets {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range aws.StringValueSlice(input.SubnetIds) {
+		if _, ok := m.subnets[id]; !ok {
+			return nil, newError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", id)
+		}
+	}
+
+	output = &ec2.DescribeSubnetsOutput{}
+
+	for _, id := range ids {
+		subnet := m.subnets[id]
+
+		if len(input.SubnetIds) > 0 && !contains(aws.StringValueSlice(input.SubnetIds), id) {
+			continue
+		}
+
+		attributes := map[string]string{
+			"subnet-id":         subnet.ID,
+			"vpc-id":            subnet.VpcID,
+			"availability-zone": subnet.AvailabilityZone,
+		}
+
+		if !matchFilters(input.Filters, attributes, subnet.Tags) {
+			continue
+		}
+
+		output.Subnets = append(output.Subnets, &ec2.Subnet{
+			SubnetId:         aws.String(subnet.ID),
+			VpcId:            aws.String(subnet.VpcID),
+			AvailabilityZone: aws.String(subnet.AvailabilityZone),
+			Tags:             describeEC2Tags(subnet.Tags),
+		})
+	}
+
+	return output, nil
+}
+
+// DescribeInstances mock, which returns each instance in its own reservation.
+func (m *EC2) DescribeInstances(input *ec2.DescribeInstancesInput) (output *ec2.DescribeInstancesOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeInstances", input, err) }()
+
+	for _, id := range aws.StringValueSlice(input.InstanceIds) {
+		if _, ok := m.instances[id]; !ok {
+			return nil, newError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", id)
+		}
+	}
+
+	var ids []string
+
+	for id := range m.instances {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	output = &ec2.DescribeInstancesOutput{}
+
+	for _, id := range ids {
+		if len(input.InstanceIds) > 0 && !contains(aws.StringValueSlice(input.InstanceIds), id) {
+			continue
+		}
+
+		var (
+			instance = m.instances[id]
+			subnet   = m.subnets[instance.SubnetID]
+			groups   []*ec2.GroupIdentifier
+		)
+
+		if subnet == nil {
+			return nil, newError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", instance.SubnetID)
+		}
+
+		for _, group := range instance.SecurityGroups {
+			groups = append(groups, &ec2.GroupIdentifier{GroupId: aws.String(group)})
+		}
+
+		output.Reservations = append(output.Reservations, &ec2.Reservation{
+			OwnerId: aws.String(m.account),
+			Instances: []*ec2.Instance{
+				{
+					InstanceId:     aws.String(instance.ID),
+					SubnetId:       aws.String(subnet.ID),
+					VpcId:          aws.String(subnet.VpcID),
+					Placement:      &ec2.Placement{AvailabilityZone: aws.String(subnet.AvailabilityZone)},
+					SecurityGroups: groups,
+				},
+			},
+		})
+	}
+
+	return output, nil
+}
+
+// DescribeSecurityGroups mock.
+func (m *EC2) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (output *ec2.DescribeSecurityGroupsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeSecurityGroups", input, err) }()
+
+	for _, id := range aws.StringValueSlice(input.GroupIds) {
+		if _, ok := m.securityGroups[id]; !ok {
+			return nil, newError("InvalidGroup.NotFound", "The security group '%s' does not exist", id)
+		}
+	}
+
+	output = &ec2.DescribeSecurityGroupsOutput{}
+
+	for _, group := range m.sortedSecurityGroups() {
+		if len(input.GroupIds) > 0 && !contains(aws.StringValueSlice(input.GroupIds), group.ID) {
+			continue
+		}
+
+		attributes := map[string]string{
+			"group-id":   group.ID,
+			"group-name": group.Name,
+			"vpc-id":     group.VpcID,
+		}
+
+		if !matchFilters(input.Filters, attributes, group.Tags) {
+			continue
+		}
+
+		output.SecurityGroups = append(output.SecurityGroups, &ec2.SecurityGroup{
+			GroupId:       aws.String(group.ID),
+			GroupName:     aws.String(group.Name),
+			Description:   aws.String(group.Description),
+			VpcId:         aws.String(group.VpcID),
+			OwnerId:       aws.String(m.account),
+			IpPermissions: group.Ingress,
+			Tags:          describeEC2Tags(group.Tags),
+		})
+	}
+
+	return output, nil
+}
+
+// CreateSecurityGroup mock.
+func (m *EC2) CreateSecurityGroup(input *ec2.CreateSecurityGroupInput) (output *ec2.CreateSecurityGroupOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("CreateSecurityGroup", input, err) }()
+
+	name := aws.StringValue(input.GroupName)
+	vpc := aws.StringValue(input.VpcId)
+
+	for _, group := range m.securityGroups {
+		if group.Name == name && group.VpcID == vpc {
+			return nil, newError("InvalidGroup.Duplicate", "The security group '%s' already exists for VPC '%s'", name, vpc)
+		}
+	}
+
+	m.nextID++
+
+	group := &SecurityGroup{
+		ID:          fmt.Sprintf("sg-%08x", m.nextID),
+		Name:        name,
+		Description: aws.StringValue(input.Description),
+		VpcID:       vpc,
+	}
+
+	m.securityGroups[group.ID] = group
+
+	return &ec2.CreateSecurityGroupOutput{
+		GroupId: aws.String(group.ID),
+	}, nil
+}
+
+// AuthorizeSecurityGroupIngress mock.
+func (m *EC2) AuthorizeSecurityGroupIngress(input *ec2.AuthorizeSecurityGroupIngressInput) (output *ec2.AuthorizeSecurityGroupIngressOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("AuthorizeSecurityGroupIngress", input, err) }()
+
+	group, err := m.securityGroup(input.GroupId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, permission := range input.IpPermissions {
+		for _, existing := range group.Ingress {
+			if existing.String() == permission.String() {
+				return nil, newError("InvalidPermission.Duplicate", "The specified rule already exists")
+			}
+		}
+	}
+
+	group.Ingress = append(group.Ingress, input.IpPermissions...)
+
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+// DeleteSecurityGroup mock.
+func (m *EC2) DeleteSecurityGroup(input *ec2.DeleteSecurityGroupInput) (output *ec2.DeleteSecurityGroupOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DeleteSecurityGroup", input, err) }()
+
+	group, err := m.securityGroup(input.GroupId)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.efs != nil && m.efs.usesSecurityGroup(group.ID) {
+		return nil, newError("DependencyViolation", "resource %s has a dependent object", group.ID)
+	}
+
+	delete(m.securityGroups, group.ID)
+
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+// CreateTags mock.
+func (m *EC2) CreateTags(input *ec2.CreateTagsInput) (output *ec2.CreateTagsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("CreateTags", input, err) }()
+
+	for _, id := range aws.StringValueSlice(input.Resources) {
+		var tags *[]Tag
+
+		if group, ok := m.securityGroups[id]; ok {
+			tags = &group.Tags
+		} else if subnet, ok := m.subnets[id]; ok {
+			tags = &subnet.Tags
+		} else {
+			return nil, newError("InvalidID", "The ID '%s' is not valid", id)
+		}
+
+		for _, tag := range input.Tags {
+			*tags = setTag(*tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+		}
+	}
+
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// Helper function to lookup a security group by ID.
+func (m *EC2) securityGroup(id *string) (*SecurityGroup, error) {
+	group, ok := m.securityGroups[aws.StringValue(id)]
+	if !ok {
+		return nil, newError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.StringValue(id))
+	}
+
+	return group, nil
+}
+
+// Helper function to return the security groups in a consistent order.
+func (m *EC2) sortedSecurityGroups() []*SecurityGroup {
+	var list []*SecurityGroup
+
+	for _, group := range m.securityGroups {
+		list = append(list, group)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
+
+// Helper function to record a call once it has finished.
+func (m *EC2) record(operation string, input interface{}, err error) {
+	m.calls = append(m.calls, Call{
+		Operation: operation,
+		Input:     input,
+		Err:       err,
+	})
+}
+
+// Helper function to check if a resource matches all of the filters of a describe call.
+func matchFilters(filters []*ec2.Filter, attributes map[string]string, tags []Tag) bool {
+	for _, filter := range filters {
+		var (
+			name   = aws.StringValue(filter.Name)
+			values = aws.StringValueSlice(filter.Values)
+			actual []string
+		)
+
+		switch {
+		case name == "tag-key":
+			for _, tag := range tags {
+				actual = append(actual, tag.Key)
+			}
+		case strings.HasPrefix(name, "tag:"):
+			for _, tag := range tags {
+				if tag.Key == strings.TrimPrefix(name, "tag:") {
+					actual = append(actual, tag.Value)
+				}
+			}
+		default:
+			if value, ok := attributes[name]; ok {
+				actual = append(actual, value)
+			}
+		}
+
+		var matched bool
+
+		for _, value := range actual {
+			if contains(values, value) {
+				matched = true
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Helper function to convert in memory tags into EC2 tags.
+func describeEC2Tags(tags []Tag) []*ec2.Tag {
+	var list []*ec2.Tag
+
+	for _, tag := range tags {
+		list = append(list, &ec2.Tag{
+			Key:   aws.String(tag.Key),
+			Value: aws.String(tag.Value),
+		})
+	}
+
+	return list
+}
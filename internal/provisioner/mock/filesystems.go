@@ -0,0 +1,432 @@
+package mock
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// FileSystem used for in memory mock storage.
+type FileSystem struct {
+	lifecycle
+
+	ID                    string
+	ARN                   string
+	CreationToken         string
+	CreationTime          time.Time
+	Performance           string
+	ThroughputMode        string
+	ProvisionedThroughput float64
+	Encrypted             bool
+	KmsKeyID              string
+	Tags                  []Tag
+	Policy                string
+	LifecyclePolicies     []*efs.LifecyclePolicy
+
+	// Metered size of the data stored in each storage class.
+	SizeStandard int64
+	SizeIA       int64
+
+	// When throughput was last decreased (or the throughput mode changed), which limits further decreases.
+	ThroughputDecreased time.Time
+}
+
+// Tag used for in memory mock storage.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// FileSystem returns a copy of a filesystem, for making assertions in tests.
+func (m *Client) FileSystem(id string) (FileSystem, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advance()
+
+	fs, ok := m.filesystems[id]
+	if !ok {
+		return FileSystem{}, false
+	}
+
+	return *fs, true
+}
+
+// SetSize sets the metered size of a filesystem.
+func (m *Client) SetSize(id string, standard, ia int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if fs, ok := m.filesystems[id]; ok {
+		fs.SizeStandard = standard
+		fs.SizeIA = ia
+	}
+}
+
+// DescribeFileSystems mock.
+func (m *Client) DescribeFileSystems(input *efs.DescribeFileSystemsInput) (output *efs.DescribeFileSystemsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeFileSystems", input, err) }()
+
+	if err := m.begin("DescribeFileSystems", input); err != nil {
+		return nil, err
+	}
+
+	if input.FileSystemId != nil && input.CreationToken != nil {
+		return nil, newError(efs.ErrCodeBadRequest, "FileSystemId and CreationToken cannot both be specified")
+	}
+
+	output = &efs.DescribeFileSystemsOutput{}
+
+	if input.FileSystemId != nil {
+		fs, ok := m.filesystems[*input.FileSystemId]
+		if !ok {
+			return nil, newError(efs.ErrCodeFileSystemNotFound, "File system '%s' does not exist.", *input.FileSystemId)
+		}
+
+		output.FileSystems = []*efs.FileSystemDescription{m.describeFileSystem(fs)}
+
+		return output, nil
+	}
+
+	var list []*FileSystem
+
+	for _, fs := range m.filesystems {
+		if input.CreationToken != nil && *input.CreationToken != fs.CreationToken {
+			continue
+		}
+
+		list = append(list, fs)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	start, end, next := m.paginate(len(list), input.Marker, input.MaxItems)
+
+	for _, fs := range list[start:end] {
+		output.FileSystems = append(output.FileSystems, m.describeFileSystem(fs))
+	}
+
+	output.NextMarker = next
+
+	return output, nil
+}
+
+// Helper function to convert an in memory filesystem into a description.
+func (m *Client) describeFileSystem(fs *FileSystem) *efs.FileSystemDescription {
+	var mounts int64
+
+	for _, target := range m.mountTargets {
+		if target.FileSystemID == fs.ID {
+			mounts++
+		}
+	}
+
+	description := &efs.FileSystemDescription{
+		FileSystemId:         aws.String(fs.ID),
+		CreationToken:        aws.String(fs.CreationToken),
+		CreationTime:         aws.Time(fs.CreationTime),
+		LifeCycleState:       aws.String(fs.State),
+		OwnerId:              aws.String(m.params.Account),
+		PerformanceMode:      aws.String(fs.Performance),
+		ThroughputMode:       aws.String(fs.ThroughputMode),
+		Encrypted:            aws.Bool(fs.Encrypted),
+		NumberOfMountTargets: aws.Int64(mounts),
+		SizeInBytes: &efs.FileSystemSize{
+			Value:           aws.Int64(fs.SizeStandard + fs.SizeIA),
+			ValueInStandard: aws.Int64(fs.SizeStandard),
+			ValueInIA:       aws.Int64(fs.SizeIA),
+		},
+		Tags: describeTags(fs.Tags),
+	}
+
+	if fs.ThroughputMode == efs.ThroughputModeProvisioned {
+		description.ProvisionedThroughputInMibps = aws.Float64(fs.ProvisionedThroughput)
+	}
+
+	if fs.KmsKeyID != "" {
+		description.KmsKeyId = aws.String(fs.KmsKeyID)
+	}
+
+	for _, tag := range fs.Tags {
+		if tag.Key == "Name" {
+			description.Name = aws.String(tag.Value)
+		}
+	}
+
+	return description
+}
+
+// CreateFileSystem mock.
+func (m *Client) CreateFileSystem(input *efs.CreateFileSystemInput) (output *efs.FileSystemDescription, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("CreateFileSystem", input, err) }()
+
+	if err := m.begin("CreateFileSystem", input); err != nil {
+		return nil, err
+	}
+
+	if aws.StringValue(input.CreationToken) == "" {
+		return nil, newError(efs.ErrCodeBadRequest, "CreationToken is required")
+	}
+
+	for _, fs := range m.filesystems {
+		if fs.CreationToken == *input.CreationToken {
+			return nil, awsAlreadyExists(fs.ID)
+		}
+	}
+
+	if len(m.filesystems) >= m.params.FileSystemLimit {
+		return nil, newError(efs.ErrCodeFileSystemLimitExceeded, "You have reached the maximum number of file systems allowed")
+	}
+
+	fs := &FileSystem{
+		ID:             m.id("fs"),
+		CreationToken:  *input.CreationToken,
+		CreationTime:   m.clock.Now(),
+		Performance:    aws.StringValue(input.PerformanceMode),
+		ThroughputMode: aws.StringValue(input.ThroughputMode),
+		Encrypted:      aws.BoolValue(input.Encrypted),
+		KmsKeyID:       aws.StringValue(input.KmsKeyId),
+	}
+
+	fs.ARN = m.arn("file-system/" + fs.ID)
+
+	if fs.Performance == "" {
+		fs.Performance = efs.PerformanceModeGeneralPurpose
+	}
+
+	if fs.ThroughputMode == "" {
+		fs.ThroughputMode = efs.ThroughputModeBursting
+	}
+
+	if fs.ThroughputMode == efs.ThroughputModeProvisioned {
+		if input.ProvisionedThroughputInMibps == nil {
+			return nil, newError(efs.ErrCodeBadRequest, "ProvisionedThroughputInMibps is required when ThroughputMode is provisioned")
+		}
+
+		fs.ProvisionedThroughput = *input.ProvisionedThroughputInMibps
+	}
+
+	for _, tag := range input.Tags {
+		fs.Tags = setTag(fs.Tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+	}
+
+	fs.schedule(m.clock.Now(), efs.LifeCycleStateCreating, efs.LifeCycleStateAvailable, m.params.FileSystemCreateDelay)
+
+	m.filesystems[fs.ID] = fs
+
+	return m.describeFileSystem(fs), nil
+}
+
+// Helper function to return the error AWS returns when a CreationToken is reused.
+func awsAlreadyExists(id string) error {
+	return newError(efs.ErrCodeFileSystemAlreadyExists, "File system '%s' already exists with creation token", id)
+}
+
+// DeleteFileSystem mock.
+func (m *Client) DeleteFileSystem(input *efs.DeleteFileSystemInput) (output *efs.DeleteFileSystemOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DeleteFileSystem", input, err) }()
+
+	if err := m.begin("DeleteFileSystem", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, target := range m.mountTargets {
+		if target.FileSystemID == fs.ID {
+			return nil, newError(efs.ErrCodeFileSystemInUse, "File system '%s' has mount targets", fs.ID)
+		}
+	}
+
+	if fs.State != efs.LifeCycleStateAvailable {
+		return nil, newError(efs.ErrCodeIncorrectFileSystemLifeCycleState, "File system '%s' is in state %s", fs.ID, fs.State)
+	}
+
+	for id, point := range m.accessPoints {
+		if point.FileSystemID == fs.ID {
+			delete(m.accessPoints, id)
+		}
+	}
+
+	fs.schedule(m.clock.Now(), efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted, m.params.FileSystemDeleteDelay)
+
+	return &efs.DeleteFileSystemOutput{}, nil
+}
+
+// UpdateFileSystem mock.
+func (m *Client) UpdateFileSystem(input *efs.UpdateFileSystemInput) (output *efs.UpdateFileSystemOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("UpdateFileSystem", input, err) }()
+
+	if err := m.begin("UpdateFileSystem", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.State != efs.LifeCycleStateAvailable {
+		return nil, newError(efs.ErrCodeIncorrectFileSystemLifeCycleState, "File system '%s' is in state %s", fs.ID, fs.State)
+	}
+
+	mode := fs.ThroughputMode
+	if input.ThroughputMode != nil {
+		mode = *input.ThroughputMode
+	}
+
+	throughput := fs.ProvisionedThroughput
+	if input.ProvisionedThroughputInMibps != nil {
+		throughput = *input.ProvisionedThroughputInMibps
+	}
+
+	if mode == efs.ThroughputModeProvisioned && throughput <= 0 {
+		return nil, newError(efs.ErrCodeBadRequest, "ProvisionedThroughputInMibps is required when ThroughputMode is provisioned")
+	}
+
+	if mode == efs.ThroughputModeBursting {
+		throughput = 0
+	}
+
+	// Changing the throughput mode, or decreasing throughput, can only happen once per cooldown period.
+	decrease := mode != fs.ThroughputMode || throughput < fs.ProvisionedThroughput
+
+	if decrease && !fs.ThroughputDecreased.IsZero() && m.clock.Now().Before(fs.ThroughputDecreased.Add(m.params.ThroughputDecreaseCooldown)) {
+		return nil, newError(efs.ErrCodeTooManyRequests, "File system '%s' throughput can not be decreased until %s", fs.ID, fs.ThroughputDecreased.Add(m.params.ThroughputDecreaseCooldown).Format(time.RFC3339))
+	}
+
+	if decrease {
+		fs.ThroughputDecreased = m.clock.Now()
+	}
+
+	fs.ThroughputMode = mode
+	fs.ProvisionedThroughput = throughput
+
+	description := m.describeFileSystem(fs)
+
+	return &efs.UpdateFileSystemOutput{
+		FileSystemId:                 description.FileSystemId,
+		CreationToken:                description.CreationToken,
+		CreationTime:                 description.CreationTime,
+		LifeCycleState:               description.LifeCycleState,
+		OwnerId:                      description.OwnerId,
+		PerformanceMode:              description.PerformanceMode,
+		ThroughputMode:               description.ThroughputMode,
+		ProvisionedThroughputInMibps: description.ProvisionedThroughputInMibps,
+		Encrypted:                    description.Encrypted,
+		KmsKeyId:                     description.KmsKeyId,
+		NumberOfMountTargets:         description.NumberOfMountTargets,
+		SizeInBytes:                  description.SizeInBytes,
+		Tags:                         description.Tags,
+		Name:                         description.Name,
+	}, nil
+}
+
+// PutLifecycleConfiguration mock.
+func (m *Client) PutLifecycleConfiguration(input *efs.PutLifecycleConfigurationInput) (output *efs.PutLifecycleConfigurationOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("PutLifecycleConfiguration", input, err) }()
+
+	if err := m.begin("PutLifecycleConfiguration", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.LifecyclePolicies = input.LifecyclePolicies
+
+	return &efs.PutLifecycleConfigurationOutput{
+		LifecyclePolicies: fs.LifecyclePolicies,
+	}, nil
+}
+
+// DescribeLifecycleConfiguration mock.
+func (m *Client) DescribeLifecycleConfiguration(input *efs.DescribeLifecycleConfigurationInput) (output *efs.DescribeLifecycleConfigurationOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeLifecycleConfiguration", input, err) }()
+
+	if err := m.begin("DescribeLifecycleConfiguration", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &efs.DescribeLifecycleConfigurationOutput{
+		LifecyclePolicies: fs.LifecyclePolicies,
+	}, nil
+}
+
+// Helper function to lookup a filesystem by ID.
+func (m *Client) filesystem(id *string) (*FileSystem, error) {
+	if id == nil {
+		return nil, newError(efs.ErrCodeBadRequest, "FileSystemId is required")
+	}
+
+	fs, ok := m.filesystems[*id]
+	if !ok {
+		return nil, newError(efs.ErrCodeFileSystemNotFound, "File system '%s' does not exist.", *id)
+	}
+
+	return fs, nil
+}
+
+// Helper function to return the bounds of a page of results and the marker for the next page.
+func (m *Client) paginate(total int, marker *string, max *int64) (int, int, *string) {
+	start := 0
+
+	if marker != nil {
+		start, _ = strconv.Atoi(*marker)
+	}
+
+	if start > total {
+		start = total
+	}
+
+	size := m.params.PageSize
+
+	if max != nil && *max > 0 {
+		size = int(*max)
+	}
+
+	end := total
+
+	if size > 0 && start+size < total {
+		end = start + size
+	}
+
+	if end < total {
+		return start, end, aws.String(strconv.Itoa(end))
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,292 @@
+package mock
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// MountTarget used for in memory mock storage.
+type MountTarget struct {
+	lifecycle
+
+	ID               string
+	FileSystemID     string
+	SubnetID         string
+	AvailabilityZone string
+	IPAddress        string
+	SecurityGroups   []string
+}
+
+// MountTargets returns copies of the mount targets of a filesystem, for making assertions in tests.
+func (m *Client) MountTargets(id string) []MountTarget {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advance()
+
+	var list []MountTarget
+
+	for _, target := range m.listMountTargets(id) {
+		list = append(list, *target)
+	}
+
+	return list
+}
+
+// DescribeMountTargets mock.
+func (m *Client) DescribeMountTargets(input *efs.DescribeMountTargetsInput) (output *efs.DescribeMountTargetsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeMountTargets", input, err) }()
+
+	if err := m.begin("DescribeMountTargets", input); err != nil {
+		return nil, err
+	}
+
+	output = &efs.DescribeMountTargetsOutput{}
+
+	if input.MountTargetId != nil {
+		target, err := m.mountTarget(input.MountTargetId)
+		if err != nil {
+			return nil, err
+		}
+
+		output.MountTargets = []*efs.MountTargetDescription{m.describeMountTarget(target)}
+
+		return output, nil
+	}
+
+	if _, err := m.filesystem(input.FileSystemId); err != nil {
+		return nil, err
+	}
+
+	list := m.listMountTargets(*input.FileSystemId)
+
+	start, end, next := m.paginate(len(list), input.Marker, input.MaxItems)
+
+	for _, target := range list[start:end] {
+		output.MountTargets = append(output.MountTargets, m.describeMountTarget(target))
+	}
+
+	output.NextMarker = next
+
+	return output, nil
+}
+
+// Helper function to return the mount targets of a filesystem in a consistent order.
+func (m *Client) listMountTargets(id string) []*MountTarget {
+	var list []*MountTarget
+
+	for _, target := range m.mountTargets {
+		if target.FileSystemID == id {
+			list = append(list, target)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
+
+// Helper function to convert an in memory mount target into a description.
+func (m *Client) describeMountTarget(target *MountTarget) *efs.MountTargetDescription {
+	return &efs.MountTargetDescription{
+		MountTargetId:        aws.String(target.ID),
+		FileSystemId:         aws.String(target.FileSystemID),
+		SubnetId:             aws.String(target.SubnetID),
+		AvailabilityZoneName: aws.String(target.AvailabilityZone),
+		IpAddress:            aws.String(target.IPAddress),
+		LifeCycleState:       aws.String(target.State),
+		OwnerId:              aws.String(m.params.Account),
+	}
+}
+
+// CreateMountTarget mock.
+func (m *Client) CreateMountTarget(input *efs.CreateMountTargetInput) (output *efs.MountTargetDescription, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("CreateMountTarget", input, err) }()
+
+	if err := m.begin("CreateMountTarget", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.State != efs.LifeCycleStateAvailable {
+		return nil, newError(efs.ErrCodeIncorrectFileSystemLifeCycleState, "File system '%s' is in state %s", fs.ID, fs.State)
+	}
+
+	subnet := aws.StringValue(input.SubnetId)
+
+	zone, err := m.zone(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := aws.StringValueSlice(input.SecurityGroups)
+
+	if len(groups) > m.params.SecurityGroupLimit {
+		return nil, newError(efs.ErrCodeSecurityGroupLimitExceeded, "A mount target can have at most %d security groups", m.params.SecurityGroupLimit)
+	}
+
+	// Only one mount target can exist for a filesystem per availability zone.
+	for _, target := range m.mountTargets {
+		if target.FileSystemID == fs.ID && target.AvailabilityZone == zone {
+			return nil, newError(efs.ErrCodeMountTargetConflict, "File system '%s' already has a mount target in availability zone %s", fs.ID, zone)
+		}
+	}
+
+	target := &MountTarget{
+		ID:               m.id("fsmt"),
+		FileSystemID:     fs.ID,
+		SubnetID:         subnet,
+		AvailabilityZone: zone,
+		IPAddress:        fmt.Sprintf("10.0.%d.%d", (m.nextID/250)%250, m.nextID%250+1),
+		SecurityGroups:   groups,
+	}
+
+	target.schedule(m.clock.Now(), efs.LifeCycleStateCreating, efs.LifeCycleStateAvailable, m.params.MountTargetCreateDelay)
+
+	m.mountTargets[target.ID] = target
+
+	return m.describeMountTarget(target), nil
+}
+
+// Helper function to lookup the availability zone of a subnet.
+func (m *Client) zone(subnet string) (string, error) {
+	if subnet == "" {
+		return "", newError(efs.ErrCodeBadRequest, "SubnetId is required")
+	}
+
+	// Without any registered subnets, every subnet is treated as being in its own zone.
+	if len(m.subnets) == 0 {
+		return "zone-" + subnet, nil
+	}
+
+	zone, ok := m.subnets[subnet]
+	if !ok {
+		return "", newError(efs.ErrCodeSubnetNotFound, "Subnet '%s' does not exist", subnet)
+	}
+
+	return zone, nil
+}
+
+// DeleteMountTarget mock.
+func (m *Client) DeleteMountTarget(input *efs.DeleteMountTargetInput) (output *efs.DeleteMountTargetOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DeleteMountTarget", input, err) }()
+
+	if err := m.begin("DeleteMountTarget", input); err != nil {
+		return nil, err
+	}
+
+	target, err := m.mountTarget(input.MountTargetId)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.State != efs.LifeCycleStateAvailable {
+		return nil, newError(efs.ErrCodeIncorrectMountTargetState, "Mount target '%s' is in state %s", target.ID, target.State)
+	}
+
+	target.schedule(m.clock.Now(), efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted, m.params.MountTargetDeleteDelay)
+
+	return &efs.DeleteMountTargetOutput{}, nil
+}
+
+// DescribeMountTargetSecurityGroups mock.
+func (m *Client) DescribeMountTargetSecurityGroups(input *efs.DescribeMountTargetSecurityGroupsInput) (output *efs.DescribeMountTargetSecurityGroupsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeMountTargetSecurityGroups", input, err) }()
+
+	if err := m.begin("DescribeMountTargetSecurityGroups", input); err != nil {
+		return nil, err
+	}
+
+	target, err := m.mountTarget(input.MountTargetId)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.State != efs.LifeCycleStateAvailable {
+		return nil, newError(efs.ErrCodeIncorrectMountTargetState, "Mount target '%s' is in state %s", target.ID, target.State)
+	}
+
+	return &efs.DescribeMountTargetSecurityGroupsOutput{
+		SecurityGroups: aws.StringSlice(target.SecurityGroups),
+	}, nil
+}
+
+// ModifyMountTargetSecurityGroups mock.
+func (m *Client) ModifyMountTargetSecurityGroups(input *efs.ModifyMountTargetSecurityGroupsInput) (output *efs.ModifyMountTargetSecurityGroupsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("ModifyMountTargetSecurityGroups", input, err) }()
+
+	if err := m.begin("ModifyMountTargetSecurityGroups", input); err != nil {
+		return nil, err
+	}
+
+	target, err := m.mountTarget(input.MountTargetId)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.State != efs.LifeCycleStateAvailable {
+		return nil, newError(efs.ErrCodeIncorrectMountTargetState, "Mount target '%s' is in state %s", target.ID, target.State)
+	}
+
+	if len(input.SecurityGroups) > m.params.SecurityGroupLimit {
+		return nil, newError(efs.ErrCodeSecurityGroupLimitExceeded, "A mount target can have at most %d security groups", m.params.SecurityGroupLimit)
+	}
+
+	target.SecurityGroups = aws.StringValueSlice(input.SecurityGroups)
+
+	return &efs.ModifyMountTargetSecurityGroupsOutput{}, nil
+}
+
+// Helper function to lookup a mount target by ID.
+func (m *Client) mountTarget(id *string) (*MountTarget, error) {
+	if id == nil {
+		return nil, newError(efs.ErrCodeBadRequest, "MountTargetId is required")
+	}
+
+	target, ok := m.mountTargets[*id]
+	if !ok {
+		return nil, newError(efs.ErrCodeMountTargetNotFound, "Mount target '%s' does not exist.", *id)
+	}
+
+	return target, nil
+}
+
+// Helper function to check if any mount target uses a security group.
+func (m *Client) usesSecurityGroup(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advance()
+
+	for _, target := range m.mountTargets {
+		if contains(target.SecurityGroups, id) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package mock
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// PutFileSystemPolicy mock.
+func (m *Client) PutFileSystemPolicy(input *efs.PutFileSystemPolicyInput) (output *efs.PutFileSystemPolicyOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("PutFileSystemPolicy", input, err) }()
+
+	if err := m.begin("PutFileSystemPolicy", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy map[string]interface{}
+
+	err = json.Unmarshal([]byte(aws.StringValue(input.Policy)), &policy)
+	if err != nil {
+		return nil, newError(efs.ErrCodeInvalidPolicyException, "Policy is not valid JSON: %s", err)
+	}
+
+	if _, ok := policy["Statement"]; !ok {
+		return nil, newError(efs.ErrCodeInvalidPolicyException, "Policy does not contain a Statement")
+	}
+
+	fs.Policy = *input.Policy
+
+	return &efs.PutFileSystemPolicyOutput{
+		FileSystemId: aws.String(fs.ID),
+		Policy:       aws.String(fs.Policy),
+	}, nil
+}
+
+// DescribeFileSystemPolicy mock.
+func (m *Client) DescribeFileSystemPolicy(input *efs.DescribeFileSystemPolicyInput) (output *efs.DescribeFileSystemPolicyOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeFileSystemPolicy", input, err) }()
+
+	if err := m.begin("DescribeFileSystemPolicy", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.Policy == "" {
+		return nil, newError(efs.ErrCodePolicyNotFound, "File system '%s' does not have a policy", fs.ID)
+	}
+
+	return &efs.DescribeFileSystemPolicyOutput{
+		FileSystemId: aws.String(fs.ID),
+		Policy:       aws.String(fs.Policy),
+	}, nil
+}
+
+// DeleteFileSystemPolicy mock.
+func (m *Client) DeleteFileSystemPolicy(input *efs.DeleteFileSystemPolicyInput) (output *efs.DeleteFileSystemPolicyOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DeleteFileSystemPolicy", input, err) }()
+
+	if err := m.begin("DeleteFileSystemPolicy", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Policy = ""
+
+	return &efs.DeleteFileSystemPolicyOutput{}, nil
+}
@@ -0,0 +1,215 @@
+package mock
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+)
+
+// CreateTags mock.
+func (m *Client) CreateTags(input *efs.CreateTagsInput) (output *efs.CreateTagsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("CreateTags", input, err) }()
+
+	if err := m.begin("CreateTags", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range input.Tags {
+		fs.Tags = setTag(fs.Tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+	}
+
+	return &efs.CreateTagsOutput{}, nil
+}
+
+// DeleteTags mock.
+func (m *Client) DeleteTags(input *efs.DeleteTagsInput) (output *efs.DeleteTagsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DeleteTags", input, err) }()
+
+	if err := m.begin("DeleteTags", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Tags = removeTags(fs.Tags, aws.StringValueSlice(input.TagKeys))
+
+	return &efs.DeleteTagsOutput{}, nil
+}
+
+// DescribeTags mock.
+func (m *Client) DescribeTags(input *efs.DescribeTagsInput) (output *efs.DescribeTagsOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("DescribeTags", input, err) }()
+
+	if err := m.begin("DescribeTags", input); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(input.FileSystemId)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, next := m.paginate(len(fs.Tags), input.Marker, input.MaxItems)
+
+	return &efs.DescribeTagsOutput{
+		Tags:       describeTags(fs.Tags[start:end]),
+		NextMarker: next,
+	}, nil
+}
+
+// TagResource mock.
+func (m *Client) TagResource(input *efs.TagResourceInput) (output *efs.TagResourceOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("TagResource", input, err) }()
+
+	if err := m.begin("TagResource", input); err != nil {
+		return nil, err
+	}
+
+	tags, err := m.resourceTags(input.ResourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range input.Tags {
+		*tags = setTag(*tags, aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+	}
+
+	return &efs.TagResourceOutput{}, nil
+}
+
+// UntagResource mock.
+func (m *Client) UntagResource(input *efs.UntagResourceInput) (output *efs.UntagResourceOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("UntagResource", input, err) }()
+
+	if err := m.begin("UntagResource", input); err != nil {
+		return nil, err
+	}
+
+	tags, err := m.resourceTags(input.ResourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	*tags = removeTags(*tags, aws.StringValueSlice(input.TagKeys))
+
+	return &efs.UntagResourceOutput{}, nil
+}
+
+// ListTagsForResource mock.
+func (m *Client) ListTagsForResource(input *efs.ListTagsForResourceInput) (output *efs.ListTagsForResourceOutput, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer func() { m.record("ListTagsForResource", input, err) }()
+
+	if err := m.begin("ListTagsForResource", input); err != nil {
+		return nil, err
+	}
+
+	tags, err := m.resourceTags(input.ResourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	var max *int64
+
+	if input.MaxResults != nil {
+		max = input.MaxResults
+	}
+
+	start, end, next := m.paginate(len(*tags), input.NextToken, max)
+
+	return &efs.ListTagsForResourceOutput{
+		Tags:      describeTags((*tags)[start:end]),
+		NextToken: next,
+	}, nil
+}
+
+// Helper function to lookup the tags of a filesystem or access point.
+func (m *Client) resourceTags(id *string) (*[]Tag, error) {
+	if id == nil {
+		return nil, newError(efs.ErrCodeBadRequest, "ResourceId is required")
+	}
+
+	if strings.HasPrefix(*id, "fsap-") {
+		point, err := m.accessPoint(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return &point.Tags, nil
+	}
+
+	fs, err := m.filesystem(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fs.Tags, nil
+}
+
+// Helper function to add a tag, replacing any existing tag with the same key.
+func setTag(tags []Tag, key, value string) []Tag {
+	for i, tag := range tags {
+		if tag.Key == key {
+			tags[i].Value = value
+			return tags
+		}
+	}
+
+	return append(tags, Tag{
+		Key:   key,
+		Value: value,
+	})
+}
+
+// Helper function to remove tags by key.
+func removeTags(tags []Tag, keys []string) []Tag {
+	var kept []Tag
+
+	for _, tag := range tags {
+		if !contains(keys, tag.Key) {
+			kept = append(kept, tag)
+		}
+	}
+
+	return kept
+}
+
+// Helper function to convert in memory tags into EFS tags.
+func describeTags(tags []Tag) []*efs.Tag {
+	list := []*efs.Tag{}
+
+	for _, tag := range tags {
+		list = append(list, &efs.Tag{
+			Key:   aws.String(tag.Key),
+			Value: aws.String(tag.Value),
+		})
+	}
+
+	return list
+}
@@ -0,0 +1,248 @@
+package provisioner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+)
+
+const (
+	// MaxCreationTokenLength is the longest CreationToken that EFS will accept.
+	MaxCreationTokenLength = 64
+
+	// MaxTagKeyLength is the longest tag key that EFS will accept.
+	MaxTagKeyLength = 128
+
+	// MaxTagValueLength is the longest tag value that EFS will accept.
+	MaxTagValueLength = 256
+
+	// MaxTags is the most tags that EFS will accept on a single resource.
+	MaxTags = 50
+
+	// Number of characters from the hash which are appended to shortened names.
+	hashLength = 8
+)
+
+const (
+	// VolumeNamingFilesystemID names volumes after the ID of their filesystem.
+	VolumeNamingFilesystemID = "filesystem-id"
+
+	// VolumeNamingPVName names volumes with the name generated by the controller eg. pvc-<claim uid>.
+	VolumeNamingPVName = "pv-name"
+
+	// VolumeNamingTemplate names volumes by rendering EFS_VOLUME_NAME_FORMAT.
+	VolumeNamingTemplate = "template"
+)
+
+// Functions which are available to name templates.
+var nameFuncs = template.FuncMap{
+	"truncate":   truncate,
+	"hash":       hash,
+	"lower":      strings.ToLower,
+	"label":      label,
+	"annotation": annotation,
+}
+
+// Provisioning options used to validate name templates.
+var exampleOptions = controller.ProvisionOptions{
+	PVName: "pvc-00000000-0000-0000-0000-000000000000",
+	PVC: &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "example",
+		},
+	},
+	StorageClass: &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "example",
+		},
+	},
+}
+
+// Namer renders the names used when provisioning a filesystem.
+type Namer struct {
+	template *template.Template
+}
+
+// NewNamer parses and validates a name format eg. EFS_NAME_FORMAT.
+func NewNamer(format string) (*Namer, error) {
+	t, err := template.New("name").Funcs(nameFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse name format: %s", err)
+	}
+
+	namer := &Namer{
+		template: t,
+	}
+
+	// Render the template once up front so a bad format is found on startup
+	// instead of while provisioning a volume.
+	_, _, err = namer.Name(exampleOptions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name format: %s", err)
+	}
+
+	return namer, nil
+}
+
+// Name returns a human readable name (used for the Name tag) and the
+// CreationToken for a filesystem.
+//
+// Names which are too long to be used as a CreationToken are shortened and suffixed
+// with a hash of the full name. This is deterministic, so the same claim will always
+// result in the same CreationToken.
+func (n *Namer) Name(options controller.ProvisionOptions) (string, string, error) {
+	var formatted bytes.Buffer
+
+	err := n.template.Execute(&formatted, options)
+	if err != nil {
+		return "", "", err
+	}
+
+	name := strings.TrimSpace(formatted.String())
+	if name == "" {
+		return "", "", fmt.Errorf("name is empty")
+	}
+
+	token := name
+
+	if len(token) > MaxCreationTokenLength {
+		token = fmt.Sprintf("%s-%s", truncate(MaxCreationTokenLength-hashLength-1, token), hash(name))
+	}
+
+	return truncate(MaxTagValueLength, name), token, nil
+}
+
+// VolumeNameData is used to render the name of a volume, eg. {{ .PVC.ObjectMeta.Namespace }}-{{ .FileSystemID }}
+type VolumeNameData struct {
+	controller.ProvisionOptions
+	FileSystemID string
+}
+
+// VolumeNamer decides the names of the volumes returned by Provision.
+type VolumeNamer struct {
+	strategy string
+	template *template.Template
+}
+
+// NewVolumeNamer validates a naming strategy eg. EFS_VOLUME_NAMING, and the format used by the template strategy.
+func NewVolumeNamer(strategy, format string) (*VolumeNamer, error) {
+	namer := &VolumeNamer{
+		strategy: strategy,
+	}
+
+	switch strategy {
+	case "", VolumeNamingFilesystemID, VolumeNamingPVName:
+		return namer, nil
+	case VolumeNamingTemplate:
+		if format == "" {
+			return nil, fmt.Errorf("a volume name format is required to name volumes with a template")
+		}
+	default:
+		return nil, fmt.Errorf("unknown volume naming strategy: %s", strategy)
+	}
+
+	t, err := template.New("volume").Funcs(nameFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse volume name format: %s", err)
+	}
+
+	namer.template = t
+
+	_, err = namer.Name(exampleOptions, "fs-00000000")
+	if err != nil {
+		return nil, fmt.Errorf("invalid volume name format: %s", err)
+	}
+
+	return namer, nil
+}
+
+// Name returns the name of the volume for a filesystem provisioned for a claim.
+func (n *VolumeNamer) Name(options controller.ProvisionOptions, id string) (string, error) {
+	switch n.strategy {
+	case VolumeNamingPVName:
+		return options.PVName, nil
+	case VolumeNamingTemplate:
+		var formatted bytes.Buffer
+
+		err := n.template.Execute(&formatted, VolumeNameData{
+			ProvisionOptions: options,
+			FileSystemID:     id,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		name := strings.TrimSpace(formatted.String())
+
+		// Unlike filesystem names, volume names can't be shortened without risking a collision.
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return "", fmt.Errorf("%q is not a valid volume name: %s", name, strings.Join(errs, ", "))
+		}
+
+		return name, nil
+	}
+
+	return id, nil
+}
+
+// Helper function to shorten a string to a maximum number of bytes without splitting a character.
+func truncate(length int, value string) string {
+	if len(value) <= length {
+		return value
+	}
+
+	value = value[:length]
+
+	for len(value) > 0 && !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+
+	return value
+}
+
+// Helper function to return a short, deterministic hash of a string.
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// Helper function to lookup a label on an object eg. {{ .PVC | label "app" }}
+func label(key string, obj metav1.Object) string {
+	if isNil(obj) {
+		return ""
+	}
+
+	return obj.GetLabels()[key]
+}
+
+// Helper function to lookup an annotation on an object eg. {{ .PVC | annotation "owner" }}
+func annotation(key string, obj metav1.Object) string {
+	if isNil(obj) {
+		return ""
+	}
+
+	return obj.GetAnnotations()[key]
+}
+
+// Helper function to check if an object is missing, including a nil pointer eg. options without a claim.
+func isNil(obj metav1.Object) bool {
+	if obj == nil {
+		return true
+	}
+
+	value := reflect.ValueOf(obj)
+
+	return value.Kind() == reflect.Ptr && value.IsNil()
+}
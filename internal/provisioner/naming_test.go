@@ -0,0 +1,167 @@
+package provisioner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+)
+
+func TestNamer(t *testing.T) {
+	foo := controller.ProvisionOptions{
+		PVName: "bar",
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "foo",
+				Name:      "baz",
+				Labels: map[string]string{
+					"team": "Platform",
+				},
+				Annotations: map[string]string{
+					"owner": "nick",
+				},
+			},
+		},
+	}
+
+	namer, err := NewNamer("{{ .PVC.ObjectMeta.Namespace }}-{{ .PVName }}")
+	assert.Nil(t, err)
+
+	name, token, err := namer.Name(foo)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo-bar", name)
+	assert.Equal(t, "foo-bar", token)
+
+	namer, err = NewNamer("{{ .PVC.ObjectMeta.Namespace }}-{{ .PVC.ObjectMeta.Name }}")
+	assert.Nil(t, err)
+
+	name, _, err = namer.Name(foo)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo-baz", name)
+
+	namer, err = NewNamer(`{{ .PVC | label "team" | lower }}-{{ .PVC | annotation "owner" }}-{{ .PVName | hash | truncate 4 }}`)
+	assert.Nil(t, err)
+
+	name, _, err = namer.Name(foo)
+	assert.Nil(t, err)
+	assert.Equal(t, "platform-nick-"+hash("bar")[:4], name)
+}
+
+func TestNamerMissingClaim(t *testing.T) {
+	assert.Equal(t, "", label("team", (*v1.PersistentVolumeClaim)(nil)))
+	assert.Equal(t, "", annotation("owner", (*v1.PersistentVolumeClaim)(nil)))
+
+	namer, err := NewNamer(`{{ .PVName }}{{ .PVC | label "team" }}{{ .PVC | annotation "owner" }}`)
+	assert.Nil(t, err)
+
+	name, _, err := namer.Name(controller.ProvisionOptions{PVName: "bar"})
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", name)
+}
+
+func TestNamerInvalid(t *testing.T) {
+	_, err := NewNamer("{{ .PVC.ObjectMeta.Namespace ")
+	assert.NotNil(t, err)
+
+	_, err = NewNamer("{{ .DoesNotExist }}")
+	assert.NotNil(t, err)
+
+	_, err = NewNamer("{{ \"\" }}")
+	assert.NotNil(t, err)
+}
+
+func TestNamerLongNames(t *testing.T) {
+	namer, err := NewNamer("{{ .PVC.ObjectMeta.Namespace }}-{{ .PVC.ObjectMeta.Name }}")
+	assert.Nil(t, err)
+
+	options := func(name string) controller.ProvisionOptions {
+		return controller.ProvisionOptions{
+			PVName: "pvc-123",
+			PVC: &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: strings.Repeat("n", 63),
+					Name:      name,
+				},
+			},
+		}
+	}
+
+	name, token, err := namer.Name(options(strings.Repeat("a", 253)))
+	assert.Nil(t, err)
+	assert.Len(t, name, MaxTagValueLength)
+	assert.Len(t, token, MaxCreationTokenLength)
+
+	// The same claim must always produce the same token.
+	_, again, err := namer.Name(options(strings.Repeat("a", 253)))
+	assert.Nil(t, err)
+	assert.Equal(t, token, again)
+
+	// Claims which share a long prefix must not collide.
+	_, other, err := namer.Name(options(strings.Repeat("a", 252) + "b"))
+	assert.Nil(t, err)
+	assert.Len(t, other, MaxCreationTokenLength)
+	assert.NotEqual(t, token, other)
+}
+
+func TestVolumeNamer(t *testing.T) {
+	options := controller.ProvisionOptions{
+		PVName: "pvc-123",
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "foo",
+				Name:      "Baz",
+			},
+		},
+	}
+
+	tests := []struct {
+		strategy string
+		format   string
+		want     string
+	}{
+		{strategy: "", want: "fs-00000001"},
+		{strategy: VolumeNamingFilesystemID, want: "fs-00000001"},
+		{strategy: VolumeNamingPVName, want: "pvc-123"},
+		{strategy: VolumeNamingTemplate, format: "{{ .PVC.ObjectMeta.Namespace }}-{{ .PVC.ObjectMeta.Name | lower }}-{{ .FileSystemID }}", want: "foo-baz-fs-00000001"},
+	}
+
+	for _, test := range tests {
+		namer, err := NewVolumeNamer(test.strategy, test.format)
+		assert.Nil(t, err)
+
+		name, err := namer.Name(options, "fs-00000001")
+		assert.Nil(t, err)
+		assert.Equal(t, test.want, name)
+	}
+
+	// Names which aren't valid for a volume are refused rather than changed.
+	namer, err := NewVolumeNamer(VolumeNamingTemplate, "{{ .PVC.ObjectMeta.Name }}")
+	assert.Nil(t, err)
+
+	_, err = namer.Name(options, "fs-00000001")
+	assert.Error(t, err)
+}
+
+func TestVolumeNamerInvalid(t *testing.T) {
+	_, err := NewVolumeNamer("uuid", "")
+	assert.EqualError(t, err, "unknown volume naming strategy: uuid")
+
+	_, err = NewVolumeNamer(VolumeNamingTemplate, "")
+	assert.EqualError(t, err, "a volume name format is required to name volumes with a template")
+
+	_, err = NewVolumeNamer(VolumeNamingTemplate, "{{ .FileSystemID ")
+	assert.Error(t, err)
+
+	_, err = NewVolumeNamer(VolumeNamingTemplate, "{{ .PVC.ObjectMeta.Namespace }}_{{ .FileSystemID }}")
+	assert.Error(t, err)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", truncate(5, "abc"))
+	assert.Equal(t, "ab", truncate(2, "abc"))
+	// Multi-byte characters are not split.
+	assert.Equal(t, "a", truncate(2, "aé"))
+}
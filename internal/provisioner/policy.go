@@ -0,0 +1,256 @@
+package provisioner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
+)
+
+const (
+	// PolicyPresetEnforceTLS would deny clients which don't use in-transit encryption. It is rejected, as
+	// volumes are mounted over plain NFS which can't use TLS.
+	PolicyPresetEnforceTLS = "enforce-tls"
+
+	// PolicyPresetDenyRoot stops clients from having root access to the filesystem.
+	PolicyPresetDenyRoot = "deny-root"
+
+	// PolicyPresetDenyAnonymous only allows clients which connect through a mount target.
+	PolicyPresetDenyAnonymous = "deny-anonymous"
+)
+
+// Policy renders the resource policy applied to filesystems, from either presets or a template.
+type Policy struct {
+	presets  []string
+	template *template.Template
+}
+
+// PolicyData is passed to policy templates.
+type PolicyData struct {
+	FileSystemID  string
+	FileSystemArn string
+	Region        string
+	Account       string
+	PVC           *corev1.PersistentVolumeClaim
+}
+
+// Statement in an IAM policy document.
+type policyStatement struct {
+	Sid       string                 `json:"Sid,omitempty"`
+	Effect    string                 `json:"Effect"`
+	Principal map[string]interface{} `json:"Principal"`
+	Action    []string               `json:"Action"`
+	Resource  string                 `json:"Resource"`
+	Condition map[string]interface{} `json:"Condition,omitempty"`
+}
+
+// NewPolicy validates the policy configuration eg. EFS_POLICY_PRESETS. A nil policy is returned
+// when nothing is configured, in which case the policies of filesystems are left alone.
+//
+// Volumes are mounted over plain NFS, so anything which requires TLS or IAM authorization when
+// mounting is rejected rather than locking every volume out of its filesystem.
+func NewPolicy(presets, roles []string, format string) (*Policy, error) {
+	if len(presets) == 0 && len(roles) == 0 && format == "" {
+		return nil, nil
+	}
+
+	if format != "" && (len(presets) > 0 || len(roles) > 0) {
+		return nil, fmt.Errorf("a policy template can't be combined with policy presets or roles")
+	}
+
+	for _, preset := range presets {
+		switch preset {
+		case PolicyPresetDenyRoot, PolicyPresetDenyAnonymous:
+		case PolicyPresetEnforceTLS:
+			return nil, fmt.Errorf("the %s policy preset would deny every volume, they are mounted over NFS without TLS", preset)
+		default:
+			return nil, fmt.Errorf("unknown policy preset: %s", preset)
+		}
+	}
+
+	if len(roles) > 0 {
+		return nil, fmt.Errorf("policy roles would deny every volume, they are mounted over NFS without IAM authorization")
+	}
+
+	policy := &Policy{
+		presets: presets,
+	}
+
+	if format != "" {
+		t, err := template.New("policy").Funcs(template.FuncMap{
+			"label":      label,
+			"annotation": annotation,
+			"json":       toJSON,
+		}).Parse(format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse policy template: %s", err)
+		}
+
+		policy.template = t
+	}
+
+	// Render the policy once up front so a bad template is found on startup
+	// instead of while provisioning a volume.
+	_, err := policy.Render(PolicyData{
+		FileSystemID:  "fs-00000000",
+		FileSystemArn: "arn:aws:elasticfilesystem:ap-southeast-2:000000000000:file-system/fs-00000000",
+		Region:        "ap-southeast-2",
+		Account:       "000000000000",
+		PVC: &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "example",
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %s", err)
+	}
+
+	return policy, nil
+}
+
+// Render the policy for a filesystem.
+func (p *Policy) Render(data PolicyData) (string, error) {
+	if p.template != nil {
+		var rendered bytes.Buffer
+
+		err := p.template.Execute(&rendered, data)
+		if err != nil {
+			return "", err
+		}
+
+		var document map[string]interface{}
+
+		err = json.Unmarshal(rendered.Bytes(), &document)
+		if err != nil {
+			return "", fmt.Errorf("policy is not valid JSON: %s", err)
+		}
+
+		return strings.TrimSpace(rendered.String()), nil
+	}
+
+	allow := policyStatement{
+		Sid:       "AllowClients",
+		Effect:    "Allow",
+		Principal: map[string]interface{}{"AWS": "*"},
+		Action:    []string{"elasticfilesystem:ClientMount", "elasticfilesystem:ClientWrite"},
+		Resource:  data.FileSystemArn,
+	}
+
+	if !p.hasPreset(PolicyPresetDenyRoot) {
+		allow.Action = append(allow.Action, "elasticfilesystem:ClientRootAccess")
+	}
+
+	if p.hasPreset(PolicyPresetDenyAnonymous) {
+		allow.Condition = map[string]interface{}{
+			"Bool": map[string]string{"elasticfilesystem:AccessedViaMountTarget": "true"},
+		}
+	}
+
+	document, err := json.Marshal(map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": []policyStatement{allow},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(document), nil
+}
+
+// Helper function to check if a preset is enabled.
+func (p *Policy) hasPreset(preset string) bool {
+	return containsString(p.presets, preset)
+}
+
+// Helper function to apply the configured policy to a filesystem, if it has drifted.
+//
+// Returns true if the policy was changed.
+func (p *Provisioner) putPolicy(fs *efs.FileSystemDescription, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if p.policy == nil {
+		return false, nil
+	}
+
+	var (
+		id      = aws.StringValue(fs.FileSystemId)
+		account = aws.StringValue(fs.OwnerId)
+	)
+
+	desired, err := p.policy.Render(PolicyData{
+		FileSystemID:  id,
+		FileSystemArn: fmt.Sprintf("arn:aws:elasticfilesystem:%s:%s:file-system/%s", p.params.Region, account, id),
+		Region:        p.params.Region,
+		Account:       account,
+		PVC:           pvc,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to render policy: %s", err)
+	}
+
+	// The policy is only described again after the cache is resynced, until then it remembers what we applied.
+	current, ok := p.cache.Policy(id)
+	if !ok {
+		describe, err := p.client.DescribeFileSystemPolicy(&efs.DescribeFileSystemPolicyInput{
+			FileSystemId: aws.String(id),
+		})
+		if err != nil && !efsclient.IsCode(err, efs.ErrCodePolicyNotFound) {
+			return false, fmt.Errorf("failed to describe policy: %s", err)
+		}
+
+		if err == nil {
+			current = aws.StringValue(describe.Policy)
+		}
+
+		p.cache.SetPolicy(id, current)
+	}
+
+	if current != "" && samePolicy(current, desired) {
+		return false, nil
+	}
+
+	glog.Infof("Applying policy to filesystem: %s", id)
+
+	_, err = p.client.PutFileSystemPolicy(&efs.PutFileSystemPolicyInput{
+		FileSystemId: aws.String(id),
+		Policy:       aws.String(desired),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to put policy: %s", err)
+	}
+
+	p.cache.SetPolicy(id, desired)
+
+	return true, nil
+}
+
+// Helper function to compare two policy documents, ignoring formatting.
+func samePolicy(a, b string) bool {
+	var x, y interface{}
+
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return a == b
+	}
+
+	return reflect.DeepEqual(x, y)
+}
+
+// Helper function to encode a value as JSON eg. {{ .PVC | label "roles" | json }}
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
@@ -0,0 +1,206 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return the data used to render policies in tests.
+func testPolicyData() PolicyData {
+	return PolicyData{
+		FileSystemID:  "fs-00000001",
+		FileSystemArn: "arn:aws:elasticfilesystem:ap-southeast-2:123456789012:file-system/fs-00000001",
+		Region:        "ap-southeast-2",
+		Account:       "123456789012",
+		PVC: &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "namespace",
+				Name:      "test",
+				Annotations: map[string]string{
+					"role": "arn:aws:iam::123456789012:role/app",
+				},
+			},
+		},
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	policy, err := NewPolicy(nil, nil, "")
+	assert.Nil(t, err)
+	assert.Nil(t, policy)
+
+	_, err = NewPolicy([]string{"allow-all"}, nil, "")
+	assert.EqualError(t, err, "unknown policy preset: allow-all")
+
+	_, err = NewPolicy([]string{PolicyPresetDenyRoot}, nil, "{}")
+	assert.EqualError(t, err, "a policy template can't be combined with policy presets or roles")
+
+	// Volumes are mounted over plain NFS, so these would lock every volume out of its filesystem.
+	_, err = NewPolicy([]string{PolicyPresetEnforceTLS}, nil, "")
+	assert.EqualError(t, err, "the enforce-tls policy preset would deny every volume, they are mounted over NFS without TLS")
+
+	_, err = NewPolicy(nil, []string{"arn:aws:iam::123456789012:role/nodes"}, "")
+	assert.EqualError(t, err, "policy roles would deny every volume, they are mounted over NFS without IAM authorization")
+
+	_, err = NewPolicy(nil, nil, "{{ .PVC")
+	assert.Contains(t, err.Error(), "failed to parse policy template")
+
+	_, err = NewPolicy(nil, nil, `{"Statement": [{{ .Missing }}]}`)
+	assert.Contains(t, err.Error(), "invalid policy")
+
+	_, err = NewPolicy(nil, nil, `{"Statement": [`)
+	assert.EqualError(t, err, "invalid policy: policy is not valid JSON: unexpected end of JSON input")
+}
+
+func TestPolicyPresets(t *testing.T) {
+	policy, err := NewPolicy([]string{PolicyPresetDenyRoot, PolicyPresetDenyAnonymous}, nil, "")
+	assert.Nil(t, err)
+
+	rendered, err := policy.Render(testPolicyData())
+	assert.Nil(t, err)
+
+	assert.JSONEq(t, `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{
+				"Sid": "AllowClients",
+				"Effect": "Allow",
+				"Principal": {"AWS": "*"},
+				"Action": ["elasticfilesystem:ClientMount", "elasticfilesystem:ClientWrite"],
+				"Resource": "arn:aws:elasticfilesystem:ap-southeast-2:123456789012:file-system/fs-00000001",
+				"Condition": {"Bool": {"elasticfilesystem:AccessedViaMountTarget": "true"}}
+			}
+		]
+	}`, rendered)
+}
+
+func TestPolicyTemplate(t *testing.T) {
+	policy, err := NewPolicy(nil, nil, `{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Principal": {"AWS": {{ .PVC | annotation "role" | json }}},
+			"Action": ["elasticfilesystem:ClientMount"],
+			"Resource": "{{ .FileSystemArn }}"
+		}]
+	}`)
+	assert.Nil(t, err)
+
+	rendered, err := policy.Render(testPolicyData())
+	assert.Nil(t, err)
+
+	assert.JSONEq(t, `{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Principal": {"AWS": "arn:aws:iam::123456789012:role/app"},
+			"Action": ["elasticfilesystem:ClientMount"],
+			"Resource": "arn:aws:elasticfilesystem:ap-southeast-2:123456789012:file-system/fs-00000001"
+		}]
+	}`, rendered)
+}
+
+func TestProvisionPolicy(t *testing.T) {
+	client := mock.New()
+
+	params := testParams()
+	params.PolicyPresets = []string{PolicyPresetDenyAnonymous}
+	params.ResyncInterval = time.Hour
+
+	recorder := record.NewFakeRecorder(100)
+	now := time.Now()
+
+	provisioner, err := New(client, params, WithRecorder(recorder), WithClock(func() time.Time { return now }))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	volume, err := provisioner.Provision(testOptions("namespace", "test"))
+	assert.Nil(t, err)
+	assert.Contains(t, testEvents(recorder), "Normal PolicyApplied Applied policy to filesystem fs-00000001")
+
+	fs, _ := client.FileSystem(volume.ObjectMeta.Name)
+	assert.Contains(t, fs.Policy, "elasticfilesystem:AccessedViaMountTarget")
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+
+	// Policies which are changed outside of the provisioner are put back once the cache is resynced.
+	_, err = client.PutFileSystemPolicy(&efs.PutFileSystemPolicyInput{
+		FileSystemId: aws.String(volume.ObjectMeta.Name),
+		Policy:       aws.String(`{"Statement": []}`),
+	})
+	assert.Nil(t, err)
+
+	calls := client.CallCount("DescribeFileSystemPolicy")
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+	assert.Equal(t, calls, client.CallCount("DescribeFileSystemPolicy"))
+
+	now = now.Add(time.Hour)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"Normal PolicyReconciled Policy of filesystem fs-00000001 had drifted and was reapplied",
+	}, testEvents(recorder))
+
+	fs, _ = client.FileSystem(volume.ObjectMeta.Name)
+	assert.Contains(t, fs.Policy, "elasticfilesystem:AccessedViaMountTarget")
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+	assert.Equal(t, 3, client.CallCount("PutFileSystemPolicy"))
+}
+
+func TestReconcilePolicyTemplate(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	kubernetes := fake.NewSimpleClientset(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "namespace",
+			Name:      "test",
+			Labels: map[string]string{
+				"access": "ClientWrite",
+			},
+		},
+	})
+
+	params := testParams()
+	params.PolicyTemplate = `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "*"}, "Action": ["elasticfilesystem:{{ .PVC | label "access" }}"]}]}`
+
+	provisioner, err := New(client, params, WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	// The claim is looked up so the template can use its labels.
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	fs, _ := client.FileSystem(id)
+	assert.JSONEq(t, `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "*"}, "Action": ["elasticfilesystem:ClientWrite"]}]}`, fs.Policy)
+}
@@ -0,0 +1,531 @@
+package provisioner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
+)
+
+// ParameterWarmPoolSize is the StorageClass parameter which keeps a number of unclaimed filesystems
+// (and their mount targets) ready, so claims can be given one straight away.
+const ParameterWarmPoolSize = "warmPoolSize"
+
+// Prefix of the CreationToken of filesystems created for a warm pool.
+const poolTokenPrefix = "warm-pool-"
+
+const (
+	// States reported by the WarmPoolFilesystems metric.
+	poolReady   = "ready"
+	poolPending = "pending"
+)
+
+// Helper function to return the size of the warm pool requested by a StorageClass.
+func warmPoolSize(class *storagev1.StorageClass) (int, error) {
+	value, ok := class.Parameters[ParameterWarmPoolSize]
+	if !ok {
+		return 0, nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid %s parameter: %s", ParameterWarmPoolSize, value)
+	}
+
+	return size, nil
+}
+
+// Helper function to give a claim a filesystem from the warm pool of its StorageClass, by tagging it
+// as provisioned for the claim.
+//
+// Returns nil if the StorageClass doesn't have a warm pool, none of its filesystems are ready, or a
+// filesystem was already created for the claim with its CreationToken.
+func (p *Provisioner) claimFromPool(options controller.ProvisionOptions, token string, tags map[string]string) (*efs.FileSystemDescription, error) {
+	class := options.StorageClass
+	if class == nil {
+		return nil, nil
+	}
+
+	size, err := warmPoolSize(class)
+	if err != nil {
+		return nil, err
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	// A previous attempt (or the claim before its StorageClass had a warm pool) might have created a filesystem.
+	existing, err := describeFilesystem(p.client, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		glog.Infof("Filesystem %s already exists for CreationToken %s, not claiming from the warm pool", aws.StringValue(existing.FileSystemId), token)
+		return nil, nil
+	}
+
+	fs := p.reservePooled(options.PVC, class.Name)
+	if fs == nil {
+		glog.Infof("Warm pool of %s is empty, provisioning a new filesystem", class.Name)
+		return nil, nil
+	}
+
+	id := aws.StringValue(fs.FileSystemId)
+
+	glog.Infof("Claiming filesystem %s from the warm pool of %s", id, class.Name)
+
+	_, err = syncTags(p.client, id, tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to tag filesystem %s: %s", id, err)
+	}
+
+	if _, ok := tagValue(fs.Tags, TagKeyWarmPool); ok {
+		_, err = p.client.UntagResource(&efs.UntagResourceInput{
+			ResourceId: aws.String(id),
+			TagKeys:    []*string{aws.String(TagKeyWarmPool)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove filesystem %s from the warm pool: %s", id, err)
+		}
+	}
+
+	_, err = p.putPolicy(fs, options.PVC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply policy to filesystem %s: %s", id, err)
+	}
+
+	// Filesystems in the pool don't belong to a namespace yet, so they might need its security group.
+	err = p.claimMountTargets(id, options.PVC.ObjectMeta.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	p.recorder.Eventf(options.PVC, corev1.EventTypeNormal, EventReasonFilesystemClaimed, "Claimed filesystem %s from the warm pool of %s", id, class.Name)
+
+	return fs, nil
+}
+
+// Helper function to reserve a filesystem from the warm pool of a StorageClass for a claim.
+//
+// The lock is only held while choosing the filesystem, not while calling AWS, so claims aren't held up.
+// A reservation is kept if claiming the filesystem fails, so the claim's next attempt carries on with it.
+func (p *Provisioner) reservePooled(pvc *corev1.PersistentVolumeClaim, class string) *efs.FileSystemDescription {
+	p.poolMu.Lock()
+	defer p.poolMu.Unlock()
+
+	// A previous attempt might have claimed a filesystem, but not finished.
+	fs := p.claimedFilesystem(pvc)
+	if fs == nil {
+		fs = p.readyFilesystem(class)
+	}
+
+	if fs != nil {
+		p.claimed[aws.StringValue(fs.FileSystemId)] = claimKey(pvc.ObjectMeta.Namespace, pvc.ObjectMeta.Name)
+	}
+
+	return fs
+}
+
+// Helper function to return a filesystem from the warm pool which was claimed for a claim, if it is ready to be mounted.
+func (p *Provisioner) claimedFilesystem(pvc *corev1.PersistentVolumeClaim) *efs.FileSystemDescription {
+	key := claimKey(pvc.ObjectMeta.Namespace, pvc.ObjectMeta.Name)
+
+	for _, fs := range p.cache.Filesystems() {
+		if !strings.HasPrefix(aws.StringValue(fs.CreationToken), poolTokenPrefix) {
+			continue
+		}
+
+		namespace, _ := tagValue(fs.Tags, TagKeyClaimNamespace)
+		name, _ := tagValue(fs.Tags, TagKeyClaimName)
+
+		// Filesystems which were reserved might not have been tagged yet.
+		if p.claimed[aws.StringValue(fs.FileSystemId)] != key && (namespace != pvc.ObjectMeta.Namespace || name != pvc.ObjectMeta.Name) {
+			continue
+		}
+
+		if p.poolReady(fs) {
+			return fs
+		}
+	}
+
+	return nil
+}
+
+// Helper function to return a filesystem from the warm pool of a StorageClass which is ready to be claimed.
+func (p *Provisioner) readyFilesystem(class string) *efs.FileSystemDescription {
+	for _, fs := range p.pool(class) {
+		if p.poolReady(fs) {
+			return fs
+		}
+	}
+
+	return nil
+}
+
+// Helper function to return the unclaimed filesystems in the warm pool of a StorageClass, the oldest first.
+func (p *Provisioner) pool(class string) []*efs.FileSystemDescription {
+	var pool []*efs.FileSystemDescription
+
+	for _, fs := range p.cache.Filesystems() {
+		if value, ok := tagValue(fs.Tags, TagKeyWarmPool); !ok || value != class {
+			continue
+		}
+
+		// The filesystem might have been claimed without being removed from the pool.
+		if _, ok := tagValue(fs.Tags, TagKeyClaimName); ok || p.claimed[aws.StringValue(fs.FileSystemId)] != "" {
+			continue
+		}
+
+		if p.draining[aws.StringValue(fs.FileSystemId)] {
+			continue
+		}
+
+		switch aws.StringValue(fs.LifeCycleState) {
+		case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+			continue
+		}
+
+		pool = append(pool, fs)
+	}
+
+	sort.Slice(pool, func(i, j int) bool {
+		a, b := aws.TimeValue(pool[i].CreationTime), aws.TimeValue(pool[j].CreationTime)
+		if a.Equal(b) {
+			return aws.StringValue(pool[i].FileSystemId) < aws.StringValue(pool[j].FileSystemId)
+		}
+
+		return a.Before(b)
+	})
+
+	return pool
+}
+
+// Helper function to check if a filesystem is available, with an available mount target in every subnet.
+func (p *Provisioner) poolReady(fs *efs.FileSystemDescription) bool {
+	if aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateAvailable {
+		return false
+	}
+
+	ready := make(map[string]bool)
+
+	for _, target := range p.cache.MountTargets(aws.StringValue(fs.FileSystemId)) {
+		if aws.StringValue(target.LifeCycleState) == efs.LifeCycleStateAvailable {
+			ready[aws.StringValue(target.SubnetId)] = true
+		}
+	}
+
+	for _, subnet := range p.subnets() {
+		if !ready[subnet] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Helper function to give the mount targets of a claimed filesystem the security groups of its namespace.
+func (p *Provisioner) claimMountTargets(id, namespace string) error {
+	if p.params.SecurityGroupMode != SecurityGroupModeNamespace {
+		return nil
+	}
+
+	groups, err := p.mountTargetSecurityGroups(id, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to prepare security groups: %s", err)
+	}
+
+	for _, target := range p.cache.MountTargets(id) {
+		_, err := p.client.ModifyMountTargetSecurityGroups(&efs.ModifyMountTargetSecurityGroupsInput{
+			MountTargetId:  target.MountTargetId,
+			SecurityGroups: aws.StringSlice(groups),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to modify security groups of mount target in subnet %s: %s", aws.StringValue(target.SubnetId), err)
+		}
+	}
+
+	return nil
+}
+
+// RefillPools keeps the warm pool of each StorageClass at the size it asks for, by creating filesystems
+// (and then their mount targets) which are missing and deleting those which are no longer needed.
+func (p *Provisioner) RefillPools() error {
+	if p.kubernetes == nil {
+		return nil
+	}
+
+	classes, err := p.kubernetes.StorageV1().StorageClasses().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list storage classes: %s", err)
+	}
+
+	var (
+		sizes  = make(map[string]int)
+		failed []string
+	)
+
+	for _, class := range classes.Items {
+		if class.Provisioner != p.name {
+			continue
+		}
+
+		size, err := warmPoolSize(&class)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", class.Name, err))
+			continue
+		}
+
+		sizes[class.Name] = size
+	}
+
+	p.poolMu.Lock()
+
+	for _, fs := range p.cache.Filesystems() {
+		id := aws.StringValue(fs.FileSystemId)
+
+		// Claimed filesystems are no longer in the pool once the cache sees their new tags.
+		if _, ok := tagValue(fs.Tags, TagKeyWarmPool); !ok {
+			delete(p.claimed, id)
+		}
+
+		// Pools of StorageClasses which were deleted (or which didn't parse) are emptied.
+		if class, ok := tagValue(fs.Tags, TagKeyWarmPool); ok {
+			if _, ok := sizes[class]; !ok {
+				sizes[class] = 0
+			}
+		}
+	}
+
+	p.poolMu.Unlock()
+
+	var names []string
+
+	for class := range sizes {
+		names = append(names, class)
+	}
+
+	sort.Strings(names)
+
+	WarmPoolFilesystems.Reset()
+
+	for _, class := range names {
+		err := p.refillPool(class, sizes[class])
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", class, err))
+		}
+	}
+
+	// Filesystems are drained a step at a time, so deleting them never holds up the next refill.
+	for _, fs := range p.drainingFilesystems() {
+		err := p.drainPooled(fs)
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to refill %d warm pools: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Helper function to refill the warm pool of a single StorageClass.
+//
+// Filesystems which are no longer needed are only marked to be drained, which is done once every
+// pool has been refilled.
+func (p *Provisioner) refillPool(class string, size int) error {
+	var (
+		mount []*efs.FileSystemDescription
+		ready int
+	)
+
+	// The lock stops claims from being given a filesystem which is about to be drained, but it isn't
+	// held while calling AWS so that claims aren't held up.
+	p.poolMu.Lock()
+
+	pool := p.pool(class)
+
+	for i, fs := range pool {
+		// The newest filesystems are the least likely to be ready, so they are the first to go.
+		if i >= size {
+			p.draining[aws.StringValue(fs.FileSystemId)] = true
+			continue
+		}
+
+		if p.poolReady(fs) {
+			ready++
+			continue
+		}
+
+		mount = append(mount, fs)
+	}
+
+	p.poolMu.Unlock()
+
+	if size > 0 {
+		WarmPoolFilesystems.WithLabelValues(class, poolReady).Set(float64(ready))
+		WarmPoolFilesystems.WithLabelValues(class, poolPending).Set(float64(size - ready))
+	}
+
+	for _, fs := range mount {
+		err := p.mountPooled(fs)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := len(pool); i < size; i++ {
+		err := p.createPooled(class)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Helper function to return the filesystems which are being drained from the warm pools, forgetting
+// those which are gone.
+func (p *Provisioner) drainingFilesystems() []*efs.FileSystemDescription {
+	p.poolMu.Lock()
+	defer p.poolMu.Unlock()
+
+	var (
+		drain []*efs.FileSystemDescription
+		found = make(map[string]bool)
+	)
+
+	for _, fs := range p.cache.Filesystems() {
+		id := aws.StringValue(fs.FileSystemId)
+
+		if p.draining[id] {
+			drain = append(drain, fs)
+			found[id] = true
+		}
+	}
+
+	for id := range p.draining {
+		if !found[id] {
+			delete(p.draining, id)
+		}
+	}
+
+	return drain
+}
+
+// Helper function to check if a filesystem is being drained from the warm pools.
+func (p *Provisioner) isDraining(id string) bool {
+	p.poolMu.Lock()
+	defer p.poolMu.Unlock()
+
+	return p.draining[id]
+}
+
+// Helper function to create a filesystem for the warm pool of a StorageClass.
+func (p *Provisioner) createPooled(class string) error {
+	if limit := p.params.AccountFilesystemLimit; limit > 0 && p.cache.Total() >= limit {
+		return fmt.Errorf("the account already has %d filesystems (limit %d)", p.cache.Total(), limit)
+	}
+
+	// Filesystems in the pool aren't named after a claim, so they need a token of their own.
+	suffix := make([]byte, 8)
+
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+
+	token := poolTokenPrefix + hex.EncodeToString(suffix)
+
+	tags := make(map[string]string)
+
+	var keys []string
+
+	for key, value := range p.params.Tags {
+		tags[key] = value
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	tags["Name"] = fmt.Sprintf("warm-pool-%s", class)
+	tags[TagKeyOwner] = p.name
+	tags[TagKeyWarmPool] = class
+	tags[TagKeyManagedTags] = strings.Join(keys, ",")
+
+	glog.Infof("Creating filesystem for the warm pool of %s: %s", class, token)
+
+	_, _, err = putFilesystem(p.client, token, filesystemConfig{
+		Performance: p.params.Performance,
+		Encrypted:   p.params.Encrypted,
+		KmsKeyID:    p.params.KmsKeyID,
+		Tags:        tags,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create filesystem: %s", efsclient.Message(err))
+	}
+
+	return nil
+}
+
+// Helper function to create the mount targets of a filesystem in the warm pool, once it is available.
+func (p *Provisioner) mountPooled(fs *efs.FileSystemDescription) error {
+	id := aws.StringValue(fs.FileSystemId)
+
+	if aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateAvailable {
+		return nil
+	}
+
+	groups, err := p.mountTargetSecurityGroups(id, "")
+	if err != nil {
+		return fmt.Errorf("failed to prepare security groups: %s", err)
+	}
+
+	for _, subnet := range p.subnets() {
+		_, err := putMount(p.client, id, subnet, groups)
+		if err != nil {
+			return fmt.Errorf("failed to create mount target for filesystem %s in subnet %s: %s", id, subnet, efsclient.Message(err))
+		}
+	}
+
+	// The number of mount targets might not change, so make sure the next refresh sees what we did.
+	p.cache.Invalidate(id)
+
+	return nil
+}
+
+// Helper function to delete a filesystem which is no longer needed in the warm pool, along with its mount targets.
+//
+// Mount targets take a while to be deleted, so the filesystem is deleted by a later refill once they are gone.
+func (p *Provisioner) drainPooled(fs *efs.FileSystemDescription) error {
+	id := aws.StringValue(fs.FileSystemId)
+
+	switch aws.StringValue(fs.LifeCycleState) {
+	case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+		return nil
+	}
+
+	glog.Infof("Deleting filesystem %s, it is no longer needed in the warm pool", id)
+
+	_, err := p.deleteFilesystem(fs)
+	if err != nil {
+		return fmt.Errorf("failed to delete filesystem %s: %s", id, efsclient.Message(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a StorageClass with a warm pool.
+func testPoolClass(size string) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "efs",
+		},
+		Provisioner: "efs.aws.skpr.io/generalPurpose",
+		Parameters: map[string]string{
+			ParameterWarmPoolSize: size,
+		},
+	}
+}
+
+func TestWarmPoolSize(t *testing.T) {
+	size, err := warmPoolSize(&storagev1.StorageClass{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, size)
+
+	size, err = warmPoolSize(testPoolClass("3"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, size)
+
+	_, err = warmPoolSize(testPoolClass("-1"))
+	assert.EqualError(t, err, "invalid warmPoolSize parameter: -1")
+}
+
+func TestWarmPool(t *testing.T) {
+	client := mock.New()
+
+	class := testPoolClass("2")
+
+	var (
+		kubernetes = fake.NewSimpleClientset(class)
+		recorder   = record.NewFakeRecorder(100)
+	)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.cache.Run(stop)
+
+	// Helper function to refill the pools and return the filesystems which are ready to be claimed.
+	refill := func() []string {
+		err := provisioner.RefillPools()
+		assert.Nil(t, err)
+
+		err = provisioner.cache.Refresh()
+		assert.Nil(t, err)
+
+		var ready []string
+
+		for _, fs := range provisioner.pool(class.Name) {
+			if provisioner.poolReady(fs) {
+				ready = append(ready, *fs.FileSystemId)
+			}
+		}
+
+		return ready
+	}
+
+	// Filesystems are created first, then their mount targets once they are available.
+	assert.Empty(t, refill())
+	assert.Equal(t, []string{"fs-00000001", "fs-00000002"}, refill())
+
+	for _, id := range []string{"fs-00000001", "fs-00000002"} {
+		assert.Len(t, testMountTargets(client, id), 2)
+	}
+
+	// Claims are given the oldest filesystem in the pool.
+	options := testOptions("namespace", "test")
+	options.StorageClass = class
+
+	volume, err := provisioner.Provision(options)
+	assert.Nil(t, err)
+	assert.Equal(t, "fs-00000001", volume.ObjectMeta.Name)
+
+	assert.Equal(t, []string{
+		"Normal FilesystemClaimed Claimed filesystem fs-00000001 from the warm pool of efs",
+	}, testEvents(recorder))
+
+	fs, _ := client.FileSystem("fs-00000001")
+	assert.Contains(t, fs.Tags, mock.Tag{Key: "Name", Value: "namespace-test"})
+	assert.Contains(t, fs.Tags, mock.Tag{Key: TagKeyClaimName, Value: "test"})
+	assert.NotContains(t, fs.Tags, mock.Tag{Key: TagKeyWarmPool, Value: "efs"})
+
+	// The pool is refilled in the background.
+	assert.Equal(t, []string{"fs-00000002"}, refill())
+
+	ready := refill()
+	if assert.Len(t, ready, 2) {
+		assert.Equal(t, "fs-00000002", ready[0])
+	}
+
+	// Shrinking the pool deletes the newest filesystems, but not those which were claimed.
+	class.Parameters[ParameterWarmPoolSize] = "1"
+
+	_, err = kubernetes.StorageV1().StorageClasses().Update(class)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"fs-00000002"}, refill())
+	assert.Empty(t, testMountTargets(client, ready[1]))
+
+	// The filesystem is deleted by the next refill, once its mount targets are gone.
+	_, ok := client.FileSystem(ready[1])
+	assert.True(t, ok)
+
+	assert.Equal(t, []string{"fs-00000002"}, refill())
+
+	_, ok = client.FileSystem(ready[1])
+	assert.False(t, ok)
+
+	_, ok = client.FileSystem("fs-00000001")
+	assert.True(t, ok)
+}
+
+func TestWarmPoolEmpty(t *testing.T) {
+	client := mock.New()
+
+	kubernetes := fake.NewSimpleClientset(testPoolClass("1"))
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	// Claims don't wait on the pool when none of its filesystems are ready.
+	options := testOptions("namespace", "test")
+	options.StorageClass = testPoolClass("1")
+
+	volume, err := provisioner.Provision(options)
+	assert.Nil(t, err)
+
+	fs, _ := client.FileSystem(volume.ObjectMeta.Name)
+	assert.Contains(t, fs.Tags, mock.Tag{Key: TagKeyClaimName, Value: "test"})
+	assert.NotContains(t, fs.Tags, mock.Tag{Key: TagKeyWarmPool, Value: "efs"})
+}
+
+func TestWarmPoolExistingFilesystem(t *testing.T) {
+	client := mock.New()
+
+	class := testPoolClass("1")
+
+	var (
+		kubernetes = fake.NewSimpleClientset(class)
+		recorder   = record.NewFakeRecorder(100)
+	)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	// The claim was provisioned before its StorageClass had a warm pool, but wasn't bound.
+	volume, err := provisioner.Provision(testOptions("namespace", "test"))
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		err = provisioner.RefillPools()
+		assert.Nil(t, err)
+
+		err = provisioner.cache.Refresh()
+		assert.Nil(t, err)
+	}
+
+	testEvents(recorder)
+
+	// The claim is given its own filesystem, rather than a second one from the pool.
+	options := testOptions("namespace", "test")
+	options.StorageClass = class
+
+	retried, err := provisioner.Provision(options)
+	assert.Nil(t, err)
+	assert.Equal(t, volume.ObjectMeta.Name, retried.ObjectMeta.Name)
+	assert.NotContains(t, testEvents(recorder), "Normal FilesystemClaimed Claimed filesystem fs-00000002 from the warm pool of efs")
+	assert.Len(t, provisioner.pool(class.Name), 1)
+}
+
+func TestWarmPoolClaimRetried(t *testing.T) {
+	client := mock.New()
+
+	class := testPoolClass("1")
+
+	provisioner, err := New(client, testParams(), WithKubernetes(fake.NewSimpleClientset(class)))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	for i := 0; i < 2; i++ {
+		err = provisioner.RefillPools()
+		assert.Nil(t, err)
+
+		err = provisioner.cache.Refresh()
+		assert.Nil(t, err)
+	}
+
+	options := testOptions("namespace", "test")
+	options.StorageClass = class
+
+	client.FailNext("TagResource", awserr.New(efs.ErrCodeBadRequest, "Bad request", nil))
+
+	_, err = provisioner.Provision(options)
+	assert.NotNil(t, err)
+
+	// The filesystem stays reserved for the claim, rather than being given to another one.
+	other := testOptions("namespace", "other")
+	other.StorageClass = class
+
+	volume, err := provisioner.Provision(other)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "fs-00000001", volume.ObjectMeta.Name)
+
+	volume, err = provisioner.Provision(options)
+	assert.Nil(t, err)
+	assert.Equal(t, "fs-00000001", volume.ObjectMeta.Name)
+}
@@ -0,0 +1,140 @@
+package provisioner
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
+)
+
+var _ controller.DeletionGuard = &Provisioner{}
+
+// ShouldDelete stops volumes from being deleted when they, their claim or the claim's namespace
+// are annotated with efs.aws.skpr.io/protect=true, or their filesystem is tagged the same way.
+//
+// This is called for every volume the controller sees, so volumes which wouldn't be deleted
+// anyway are skipped without making any calls.
+func (p *Provisioner) ShouldDelete(volume *corev1.PersistentVolume) bool {
+	if volume.Status.Phase != corev1.VolumeReleased || volume.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete {
+		return true
+	}
+
+	if volume.ObjectMeta.Annotations[annProvisionedBy] != p.name {
+		return true
+	}
+
+	reason, err := p.protected(volume)
+	if err != nil {
+		// Err on the side of keeping the data, deletion will be tried again on the next resync.
+		glog.Errorf("Not deleting volume %s, failed to check if it is protected: %s", volume.ObjectMeta.Name, err)
+		return false
+	}
+
+	if reason != "" {
+		// This is checked on every resync, so only record why the volume was kept when the reason changes.
+		if volume.ObjectMeta.Annotations[AnnotationDeletionProtected] != reason {
+			p.recorder.Eventf(volume, corev1.EventTypeWarning, EventReasonDeletionProtected, "Not deleting filesystem %s, %s", filesystemID(volume), reason)
+
+			err := p.markProtected(volume, reason)
+			if err != nil {
+				glog.Errorf("Failed to record why volume %s is protected: %s", volume.ObjectMeta.Name, err)
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Helper function to record why a volume was kept from being deleted.
+func (p *Provisioner) markProtected(volume *corev1.PersistentVolume, reason string) error {
+	if p.kubernetes == nil {
+		return nil
+	}
+
+	// The controller passes volumes from its informer, which must not be modified.
+	volume = volume.DeepCopy()
+	volume.ObjectMeta.Annotations[AnnotationDeletionProtected] = reason
+
+	_, err := p.kubernetes.CoreV1().PersistentVolumes().Update(volume)
+	if err != nil {
+		return fmt.Errorf("failed to update volume: %s", err)
+	}
+
+	return nil
+}
+
+// Helper function to describe why a volume is protected from being deleted, if it is.
+func (p *Provisioner) protected(volume *corev1.PersistentVolume) (string, error) {
+	if isProtected(volume.ObjectMeta) {
+		return fmt.Sprintf("the volume is annotated with %s", AnnotationProtect), nil
+	}
+
+	if claim := volume.Spec.ClaimRef; claim != nil && p.kubernetes != nil {
+		pvc, err := p.kubernetes.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to get claim: %s", err)
+		}
+
+		if err == nil && isProtected(pvc.ObjectMeta) {
+			return fmt.Sprintf("the claim %s/%s is annotated with %s", claim.Namespace, claim.Name, AnnotationProtect), nil
+		}
+
+		namespace, err := p.kubernetes.CoreV1().Namespaces().Get(claim.Namespace, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to get namespace: %s", err)
+		}
+
+		if err == nil && isProtected(namespace.ObjectMeta) {
+			return fmt.Sprintf("the namespace %s is annotated with %s", claim.Namespace, AnnotationProtect), nil
+		}
+	}
+
+	id := filesystemID(volume)
+
+	// Owned filesystems are already tracked by the cache, only others need to be described.
+	fs, ok := p.cache.Filesystem(id)
+	if !ok {
+		describe, err := p.client.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+			FileSystemId: aws.String(id),
+		})
+		if efsclient.IsCode(err, efs.ErrCodeFileSystemNotFound) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to describe filesystem: %s", err)
+		}
+
+		if len(describe.FileSystems) == 0 {
+			return "", nil
+		}
+
+		fs = describe.FileSystems[0]
+	}
+
+	if value, _ := tagValue(fs.Tags, TagKeyProtect); parseProtect(value) {
+		return fmt.Sprintf("the filesystem is tagged with %s", TagKeyProtect), nil
+	}
+
+	return "", nil
+}
+
+// Helper function to check if an object is annotated as protected.
+func isProtected(meta metav1.ObjectMeta) bool {
+	return parseProtect(meta.Annotations[AnnotationProtect])
+}
+
+// Helper function to parse the value of a protect annotation or tag.
+func parseProtect(value string) bool {
+	protect, err := strconv.ParseBool(value)
+	return err == nil && protect
+}
@@ -0,0 +1,155 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+func TestShouldDelete(t *testing.T) {
+	protect := map[string]string{AnnotationProtect: "true"}
+
+	tests := []struct {
+		name    string
+		volume  func(*corev1.PersistentVolume)
+		objects []runtime.Object
+		tagged  bool
+		event   string
+	}{
+		{
+			name: "unprotected",
+		},
+		{
+			name: "volume",
+			volume: func(volume *corev1.PersistentVolume) {
+				volume.ObjectMeta.Annotations[AnnotationProtect] = "true"
+			},
+			event: "Warning DeletionProtected Not deleting filesystem fs-00000001, the volume is annotated with efs.aws.skpr.io/protect",
+		},
+		{
+			name:    "claim",
+			objects: []runtime.Object{testClaim("namespace", "test", protect)},
+			event:   "Warning DeletionProtected Not deleting filesystem fs-00000001, the claim namespace/test is annotated with efs.aws.skpr.io/protect",
+		},
+		{
+			name: "namespace",
+			objects: []runtime.Object{&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "namespace", Annotations: protect},
+			}},
+			event: "Warning DeletionProtected Not deleting filesystem fs-00000001, the namespace namespace is annotated with efs.aws.skpr.io/protect",
+		},
+		{
+			name:   "filesystem",
+			tagged: true,
+			event:  "Warning DeletionProtected Not deleting filesystem fs-00000001, the filesystem is tagged with efs.aws.skpr.io/protect",
+		},
+		{
+			name: "disabled",
+			volume: func(volume *corev1.PersistentVolume) {
+				volume.ObjectMeta.Annotations[AnnotationProtect] = "false"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			client := mock.New()
+
+			fs, err := client.CreateFileSystem(&efs.CreateFileSystemInput{
+				CreationToken: aws.String("test"),
+			})
+			assert.Nil(t, err)
+
+			tags := map[string]string{TagKeyOwner: "efs.aws.skpr.io/generalPurpose"}
+			if test.tagged {
+				tags[TagKeyProtect] = "true"
+			}
+
+			_, err = syncTags(client, *fs.FileSystemId, tags)
+			assert.Nil(t, err)
+
+			volume := testReleasedVolume(*fs.FileSystemId)
+			if test.volume != nil {
+				test.volume(volume)
+			}
+
+			var (
+				kubernetes = fake.NewSimpleClientset(append(test.objects, volume)...)
+				recorder   = record.NewFakeRecorder(100)
+			)
+
+			provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder))
+			assert.Nil(t, err)
+
+			err = provisioner.cache.Refresh()
+			assert.Nil(t, err)
+
+			calls := client.CallCount("DescribeFileSystems")
+
+			assert.Equal(t, test.event == "", provisioner.ShouldDelete(volume))
+
+			// The filesystem's tags are read from the cache.
+			assert.Equal(t, calls, client.CallCount("DescribeFileSystems"))
+
+			if test.event == "" {
+				assert.Empty(t, testEvents(recorder))
+				return
+			}
+
+			assert.Equal(t, []string{test.event}, testEvents(recorder))
+
+			// The reason is only recorded again once it changes.
+			updated, err := kubernetes.CoreV1().PersistentVolumes().Get(volume.ObjectMeta.Name, metav1.GetOptions{})
+			assert.Nil(t, err)
+			assert.NotEmpty(t, updated.ObjectMeta.Annotations[AnnotationDeletionProtected])
+
+			assert.False(t, provisioner.ShouldDelete(updated))
+			assert.Empty(t, testEvents(recorder))
+		})
+	}
+}
+
+func TestShouldDeleteSkipsOtherVolumes(t *testing.T) {
+	client := mock.New()
+
+	provisioner, err := New(client, testParams())
+	assert.Nil(t, err)
+
+	bound := testReleasedVolume("fs-00000001")
+	bound.Status.Phase = corev1.VolumeBound
+
+	retained := testReleasedVolume("fs-00000001")
+	retained.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+
+	other := testReleasedVolume("fs-00000001")
+	other.ObjectMeta.Annotations[annProvisionedBy] = "efs.aws.skpr.io/maxIO"
+
+	// The controller decides what happens to these volumes, without us making any calls.
+	for _, volume := range []*corev1.PersistentVolume{bound, retained, other} {
+		assert.True(t, provisioner.ShouldDelete(volume))
+	}
+
+	assert.Empty(t, client.Calls())
+}
+
+func TestShouldDeleteFailsSafe(t *testing.T) {
+	client := mock.New()
+	client.Fail("DescribeFileSystems", awserr.New(efs.ErrCodeInternalServerError, "failed", nil))
+
+	provisioner, err := New(client, testParams())
+	assert.Nil(t, err)
+
+	assert.False(t, provisioner.ShouldDelete(testReleasedVolume("fs-00000001")))
+}
@@ -2,8 +2,12 @@ package provisioner
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/efs"
 	"github.com/aws/aws-sdk-go/service/efs/efsiface"
 	"github.com/golang/glog"
@@ -11,121 +15,623 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
 )
 
 var _ controller.Provisioner = &Provisioner{}
 
 // Provisioner for creating volumes.
 type Provisioner struct {
-	client efsiface.EFSAPI
-	params Params
+	client     efsiface.EFSAPI
+	ec2        ec2iface.EC2API
+	kubernetes kubernetes.Interface
+	params     Params
+	name       string
+	namer      *Namer
+	volumes    *VolumeNamer
+	policy     *Policy
+	cache      *Cache
+	recorder   record.EventRecorder
+	now        func() time.Time
+	location   *time.Location
+	metrics    MetricsSource
+
+	mu        sync.Mutex
+	vpc       string
+	discovery *Discovery
+	// Managed security groups by scope, which are ready to be used.
+	managedGroups map[string]string
+
+	selector labels.Selector
+	quotaMu  sync.Mutex
+	inflight map[string]int
+	refused  map[string]string
+
+	poolMu   sync.Mutex
+	claimed  map[string]string
+	draining map[string]bool
+
+	// Drift reported by the last reconcile, which isn't reported again while it remains.
+	drifted map[string]bool
+	// When the cached security groups and policies were last forgotten, see EFS_RESYNC_INTERVAL.
+	resynced time.Time
 }
 
 // Params required for provisioning volumes.
 type Params struct {
-	Region        string   `envconfig:"AWS_REGION"         default:"ap-southeast-2"`
-	Format        string   `envconfig:"EFS_NAME_FORMAT"    default:"{{ .PVC.ObjectMeta.Namespace }}-{{ .PVName }}"`
-	Performance   string   `envconfig:"EFS_PERFORMANCE"    default:"generalPurpose"`
-	SecurityGroup string   `envconfig:"AWS_SECURITY_GROUP" required:"true"`
-	Subnets       []string `envconfig:"AWS_SUBNETS"        required:"true"`
+	Region            string            `envconfig:"AWS_REGION"              default:"ap-southeast-2"`
+	Format            string            `envconfig:"EFS_NAME_FORMAT"         default:"{{ .PVC.ObjectMeta.Namespace }}-{{ .PVName }}"`
+	VolumeNaming      string            `envconfig:"EFS_VOLUME_NAMING"       default:"filesystem-id"`
+	VolumeNameFormat  string            `envconfig:"EFS_VOLUME_NAME_FORMAT"`
+	Performance       string            `envconfig:"EFS_PERFORMANCE"         default:"generalPurpose"`
+	Encrypted         bool              `envconfig:"EFS_ENCRYPTED"           default:"false"`
+	KmsKeyID          string            `envconfig:"EFS_KMS_KEY_ID"`
+	SecurityGroup     string            `envconfig:"AWS_SECURITY_GROUP"`
+	SecurityGroups    []string          `envconfig:"AWS_SECURITY_GROUPS"`
+	SecurityGroupMode string            `envconfig:"EFS_SECURITY_GROUP_MODE" default:"static"`
+	NodeSecurityGroup string            `envconfig:"AWS_NODE_SECURITY_GROUP"`
+	Subnets           []string          `envconfig:"AWS_SUBNETS"`
+	Discovery         string            `envconfig:"EFS_DISCOVERY"`
+	DiscoveryInterval time.Duration     `envconfig:"EFS_DISCOVERY_INTERVAL"  default:"10m"`
+	ClusterName       string            `envconfig:"CLUSTER_NAME"`
+	PollInterval      time.Duration     `envconfig:"EFS_POLL_INTERVAL"       default:"15s"`
+	WaitTimeout       time.Duration     `envconfig:"EFS_WAIT_TIMEOUT"        default:"10m"`
+	ReconcileInterval time.Duration     `envconfig:"EFS_RECONCILE_INTERVAL"  default:"5m"`
+	ResyncInterval    time.Duration     `envconfig:"EFS_RESYNC_INTERVAL"     default:"1h"`
+	UsageInterval     time.Duration     `envconfig:"EFS_USAGE_INTERVAL"      default:"15m"`
+	ResizeInterval    time.Duration     `envconfig:"EFS_RESIZE_INTERVAL"     default:"1m"`
+	ScheduleInterval  time.Duration     `envconfig:"EFS_SCHEDULE_INTERVAL"   default:"1m"`
+	ScheduleTimezone  string            `envconfig:"EFS_SCHEDULE_TIMEZONE"   default:"UTC"`
+	PoolInterval      time.Duration     `envconfig:"EFS_POOL_INTERVAL"       default:"1m"`
+	PruneMountTargets bool              `envconfig:"EFS_PRUNE_MOUNT_TARGETS" default:"false"`
+	DeleteFilesystems bool              `envconfig:"EFS_DELETE_FILESYSTEMS"  default:"false"`
+	PolicyPresets     []string          `envconfig:"EFS_POLICY_PRESETS"`
+	PolicyRoles       []string          `envconfig:"EFS_POLICY_ROLES"`
+	PolicyTemplate    string            `envconfig:"EFS_POLICY_TEMPLATE"`
+	Tags              map[string]string `envconfig:"EFS_TAGS"`
+
+	AllowNamespaces            []string `envconfig:"EFS_ALLOW_NAMESPACES"`
+	DenyNamespaces             []string `envconfig:"EFS_DENY_NAMESPACES"`
+	NamespaceSelector          string   `envconfig:"EFS_NAMESPACE_SELECTOR"`
+	MaxFilesystemsPerNamespace int      `envconfig:"EFS_MAX_FILESYSTEMS_PER_NAMESPACE"`
+	MaxThroughputPerNamespace  float64  `envconfig:"EFS_MAX_THROUGHPUT_PER_NAMESPACE"`
+	MaxFilesystems             int      `envconfig:"EFS_MAX_FILESYSTEMS"`
+	AccountFilesystemLimit     int      `envconfig:"EFS_ACCOUNT_FILESYSTEM_LIMIT"     default:"1000"`
+
+	CapacityEnforcement string `envconfig:"EFS_CAPACITY_ENFORCEMENT"`
+
+	CopyImage        string `envconfig:"EFS_COPY_IMAGE"         default:"instrumentisto/rsync-ssh:alpine"`
+	CopyBackoffLimit int    `envconfig:"EFS_COPY_BACKOFF_LIMIT" default:"3"`
+
+	BackupFilesystem string        `envconfig:"EFS_BACKUP_FILESYSTEM"`
+	BackupNamespace  string        `envconfig:"EFS_BACKUP_NAMESPACE"`
+	BackupInterval   time.Duration `envconfig:"EFS_BACKUP_INTERVAL"  default:"1m"`
+	BackupRetention  int           `envconfig:"EFS_BACKUP_RETENTION" default:"7"`
+
+	Autoscale            bool          `envconfig:"EFS_AUTOSCALE"              default:"false"`
+	AutoscaleInterval    time.Duration `envconfig:"EFS_AUTOSCALE_INTERVAL"     default:"5m"`
+	AutoscaleLowCredits  float64       `envconfig:"EFS_AUTOSCALE_LOW_CREDITS"  default:"512"`
+	AutoscaleHighCredits float64       `envconfig:"EFS_AUTOSCALE_HIGH_CREDITS" default:"1024"`
+	AutoscaleThroughput  float64       `envconfig:"EFS_AUTOSCALE_THROUGHPUT"   default:"100"`
+}
+
+// Option for configuring the provisioner.
+type Option func(*Provisioner)
+
+// WithName sets the name of the provisioner, which is used to tag the filesystems that it owns.
+func WithName(name string) Option {
+	return func(p *Provisioner) {
+		p.name = name
+	}
+}
+
+// WithEC2 sets the EC2 client used to manage security groups.
+func WithEC2(client ec2iface.EC2API) Option {
+	return func(p *Provisioner) {
+		p.ec2 = client
+	}
+}
+
+// WithKubernetes sets the Kubernetes client used to look up objects in the cluster.
+func WithKubernetes(client kubernetes.Interface) Option {
+	return func(p *Provisioner) {
+		p.kubernetes = client
+	}
+}
+
+// WithRecorder sets the recorder used to emit events on claims while they are being provisioned.
+func WithRecorder(recorder record.EventRecorder) Option {
+	return func(p *Provisioner) {
+		p.recorder = recorder
+	}
+}
+
+// WithClock sets the function used to tell the time, eg. when throughput can be decreased again.
+func WithClock(now func() time.Time) Option {
+	return func(p *Provisioner) {
+		p.now = now
+	}
+}
+
+// WithMetrics sets the source of the burst credit metrics used to autoscale throughput.
+func WithMetrics(source MetricsSource) Option {
+	return func(p *Provisioner) {
+		p.metrics = source
+	}
 }
 
 // New provisioner for creating and deleting EFS volumes.
-func New(client efsiface.EFSAPI, params Params) (controller.Provisioner, error) {
+func New(client efsiface.EFSAPI, params Params, options ...Option) (*Provisioner, error) {
+	// These are only defaulted when the params are loaded from the environment.
+	if params.PollInterval <= 0 {
+		return nil, fmt.Errorf("the poll interval must be greater than zero")
+	}
+
+	if params.WaitTimeout <= 0 {
+		return nil, fmt.Errorf("the wait timeout must be greater than zero")
+	}
+
+	namer, err := NewNamer(params.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes, err := NewVolumeNamer(params.VolumeNaming, params.VolumeNameFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range params.Tags {
+		if reservedTagKey(key) {
+			return nil, fmt.Errorf("tag %s is reserved", key)
+		}
+	}
+
+	err = validateTags(params.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tags: %s", err)
+	}
+
+	policy, err := NewPolicy(params.PolicyPresets, params.PolicyRoles, params.PolicyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
 	provisioner := &Provisioner{
-		client: client,
-		params: params,
+		client:  client,
+		params:  params,
+		name:    fmt.Sprintf("efs.aws.skpr.io/%s", params.Performance),
+		namer:   namer,
+		volumes: volumes,
+		policy:  policy,
+		// Claims which are being provisioned count towards the quotas.
+		inflight: make(map[string]int),
+		// Why claims (or raising the throughput of their filesystems) were last refused, so they are only warned once.
+		refused: make(map[string]string),
+		// Claims which filesystems from the warm pool were reserved for, until the cache sees their new tags.
+		claimed: make(map[string]string),
+		// Filesystems which are no longer needed in the warm pool, until they are deleted.
+		draining: make(map[string]bool),
+		// Managed security groups which are ready to be used, until the next resync.
+		managedGroups: make(map[string]string),
+		// Events are discarded unless a recorder is provided.
+		recorder: &record.FakeRecorder{},
+		now:      time.Now,
 	}
 
+	for _, option := range options {
+		option(provisioner)
+	}
+
+	if params.NamespaceSelector != "" {
+		if provisioner.kubernetes == nil {
+			return nil, fmt.Errorf("a Kubernetes client is required to select namespaces")
+		}
+
+		provisioner.selector, err = labels.Parse(params.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse namespace selector: %s", err)
+		}
+	}
+
+	provisioner.location, err = time.LoadLocation(params.ScheduleTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schedule timezone: %s", err)
+	}
+
+	switch params.CapacityEnforcement {
+	case "":
+	case CapacityEnforcementSoft, CapacityEnforcementReadOnly:
+		if provisioner.kubernetes == nil {
+			return nil, fmt.Errorf("a Kubernetes client is required to enforce capacity")
+		}
+	default:
+		return nil, fmt.Errorf("unknown capacity enforcement mode: %s", params.CapacityEnforcement)
+	}
+
+	if params.BackupFilesystem != "" {
+		if provisioner.kubernetes == nil {
+			return nil, fmt.Errorf("a Kubernetes client is required to back up volumes")
+		}
+
+		// Backup Jobs mount the whole backup filesystem, so they can't run alongside the claims they back up.
+		if params.BackupNamespace == "" {
+			return nil, fmt.Errorf("a namespace is required to run backup jobs in")
+		}
+	}
+
+	if params.Autoscale {
+		if provisioner.metrics == nil {
+			return nil, fmt.Errorf("a metrics source is required to autoscale throughput")
+		}
+
+		if params.AutoscaleLowCredits >= params.AutoscaleHighCredits {
+			return nil, fmt.Errorf("the low burst credit threshold must be below the high threshold")
+		}
+
+		if params.AutoscaleThroughput < MinProvisionedThroughput || params.AutoscaleThroughput > MaxProvisionedThroughput {
+			return nil, fmt.Errorf("autoscaled throughput must be between %d and %d MiB/s", MinProvisionedThroughput, MaxProvisionedThroughput)
+		}
+	}
+
+	switch params.Discovery {
+	case "":
+		if len(params.Subnets) == 0 {
+			return nil, fmt.Errorf("subnets are required unless they are discovered")
+		}
+	case DiscoveryModeTags, DiscoveryModeNodes:
+		if provisioner.ec2 == nil {
+			return nil, fmt.Errorf("an EC2 client is required to discover subnets")
+		}
+
+		if params.Discovery == DiscoveryModeTags && params.ClusterName == "" {
+			return nil, fmt.Errorf("the cluster name is required to discover subnets by tag")
+		}
+
+		if params.Discovery == DiscoveryModeNodes && provisioner.kubernetes == nil {
+			return nil, fmt.Errorf("a Kubernetes client is required to discover subnets from nodes")
+		}
+
+		err := provisioner.Discover()
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover subnets: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown discovery mode: %s", params.Discovery)
+	}
+
+	switch params.SecurityGroupMode {
+	case "", SecurityGroupModeStatic:
+		if len(provisioner.staticSecurityGroups()) == 0 {
+			return nil, fmt.Errorf("a security group is required unless security groups are managed")
+		}
+	case SecurityGroupModeFilesystem, SecurityGroupModeNamespace:
+		if params.NodeSecurityGroup == "" {
+			return nil, fmt.Errorf("the node security group is required to manage security groups")
+		}
+
+		if provisioner.ec2 == nil {
+			return nil, fmt.Errorf("an EC2 client is required to manage security groups")
+		}
+	default:
+		return nil, fmt.Errorf("unknown security group mode: %s", params.SecurityGroupMode)
+	}
+
+	provisioner.cache = NewCache(client, provisioner.name, params.PollInterval)
+
 	return provisioner, nil
 }
 
+// Run the background tasks of the provisioner until the stop channel is closed.
+func (p *Provisioner) Run(stop <-chan struct{}) {
+	if p.params.Discovery != "" && p.params.DiscoveryInterval > 0 {
+		go wait.Until(func() {
+			err := p.Discover()
+			if err != nil {
+				glog.Errorf("Failed to discover subnets: %s", err)
+			}
+		}, p.params.DiscoveryInterval, stop)
+	}
+
+	if p.params.ReconcileInterval > 0 {
+		go wait.Until(func() {
+			err := p.Reconcile()
+			if err != nil {
+				glog.Errorf("Failed to reconcile filesystems: %s", err)
+			}
+		}, p.params.ReconcileInterval, stop)
+	}
+
+	if p.params.UsageInterval > 0 {
+		go wait.Until(func() {
+			err := p.CollectUsage()
+			if err != nil {
+				glog.Errorf("Failed to collect usage of filesystems: %s", err)
+			}
+		}, p.params.UsageInterval, stop)
+	}
+
+	if p.kubernetes != nil && p.params.ResizeInterval > 0 {
+		go wait.Until(func() {
+			err := p.Resize()
+			if err != nil {
+				glog.Errorf("Failed to resize volumes: %s", err)
+			}
+		}, p.params.ResizeInterval, stop)
+	}
+
+	if p.kubernetes != nil && p.params.ScheduleInterval > 0 {
+		go wait.Until(func() {
+			err := p.ScheduleThroughput()
+			if err != nil {
+				glog.Errorf("Failed to schedule throughput of filesystems: %s", err)
+			}
+		}, p.params.ScheduleInterval, stop)
+	}
+
+	if p.kubernetes != nil && p.params.PoolInterval > 0 {
+		go wait.Until(func() {
+			err := p.RefillPools()
+			if err != nil {
+				glog.Errorf("Failed to refill warm pools: %s", err)
+			}
+		}, p.params.PoolInterval, stop)
+	}
+
+	if p.kubernetes != nil && p.params.BackupFilesystem != "" && p.params.BackupInterval > 0 {
+		go wait.Until(func() {
+			err := p.Backup()
+			if err != nil {
+				glog.Errorf("Failed to back up volumes: %s", err)
+			}
+		}, p.params.BackupInterval, stop)
+	}
+
+	if p.params.Autoscale && p.params.AutoscaleInterval > 0 {
+		go wait.Until(func() {
+			err := p.Autoscale()
+			if err != nil {
+				glog.Errorf("Failed to autoscale throughput of filesystems: %s", err)
+			}
+		}, p.params.AutoscaleInterval, stop)
+	}
+
+	p.cache.Run(stop)
+}
+
 // Provision creates a storage asset and returns a PV object representing it.
 func (p *Provisioner) Provision(options controller.ProvisionOptions) (*corev1.PersistentVolume, error) {
 	// This is a consistent naming pattern for provisioning our EFS objects.
-	name, err := formatName(p.params.Format, options)
+	name, token, err := p.namer.Name(options)
 	if err != nil {
+		return nil, fmt.Errorf("failed to format name: %s", err)
+	}
+
+	requirements, err := ParseRequirements(options.StorageClass)
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Invalid parameters in storage class: %s", err)
+		return nil, fmt.Errorf("invalid parameters in storage class: %s", err)
+	}
+
+	required, err := requirements.Check(options.PVC)
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonLabelsRequired, "Not provisioning a filesystem: %s", err)
+		return nil, requirements.Error(err)
+	}
+
+	// Data sources are checked first, so claims which can't be populated don't leave a filesystem behind.
+	source, err := p.dataSource(options.PVC)
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonCloneFailed, "Not provisioning a filesystem: %s", err)
+		return nil, fmt.Errorf("invalid data source: %s", err)
+	}
+
+	tags, err := p.filesystemTags(options.PVC, required)
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Not provisioning a filesystem, failed to prepare tags: %s", err)
+		return nil, fmt.Errorf("failed to prepare tags: %s", err)
+	}
+
+	// This makes it easier for site admins to see what a filesystem was provisioned for.
+	tags["Name"] = name
+
+	// Other claims might have used up the quota since this claim was qualified.
+	release, err := p.reserveQuota(options.PVC)
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonQuotaExceeded, "Not provisioning a filesystem: %s", err)
 		return nil, err
 	}
+	defer release()
+
+	// Filesystems in the warm pool are already available, along with their mount targets. They are only
+	// claimed when the claim doesn't have a filesystem of its own yet.
+	pooled, err := p.claimFromPool(options, token, tags)
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Failed to claim a filesystem from the warm pool: %s", efsclient.Message(err))
+		return nil, fmt.Errorf("failed to claim a filesystem from the warm pool: %s", err)
+	}
 
-	glog.Infof("Provisioning filesystem: %s", name)
+	if pooled != nil {
+		return p.populate(options, pooled, source)
+	}
 
-	// Limiter used to wait for the a filesystem to get created.
-	limiter := time.Tick(time.Second * 15)
+	glog.Infof("Provisioning filesystem: %s (%s)", name, token)
 
 	// Ensures that we have created a filesystem.
-	fs, err := putFilesystem(p.client, name, p.params.Performance)
+	fs, created, err := putFilesystem(p.client, token, filesystemConfig{
+		Performance: p.params.Performance,
+		Encrypted:   p.params.Encrypted,
+		KmsKeyID:    p.params.KmsKeyID,
+		Tags:        tags,
+	})
+	if _, ok := err.(*MismatchError); ok {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonFilesystemMismatch, "Refusing to use filesystem with CreationToken %s: %s", token, err)
+		return nil, err
+	}
 	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Failed to create filesystem: %s", efsclient.Message(err))
 		return nil, fmt.Errorf("failed to create filesystem: %s", err)
 	}
 
+	if created {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeNormal, EventReasonFilesystemCreating, "Creating filesystem %s with CreationToken %s", *fs.FileSystemId, token)
+	}
+
+	// Filesystems which are reused might have been created with different tags, or none at all.
+	if !created {
+		tagged, err := syncTags(p.client, *fs.FileSystemId, tags)
+		if err != nil {
+			p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Failed to tag filesystem %s: %s", *fs.FileSystemId, efsclient.Message(err))
+			return nil, fmt.Errorf("failed to tag filesystem: %s", err)
+		}
+
+		if tagged {
+			p.recorder.Eventf(options.PVC, corev1.EventTypeNormal, EventReasonFilesystemTagging, "Updated tags of filesystem %s", *fs.FileSystemId)
+		}
+	}
+
+	// Make sure the cache keeps track of this filesystem while we wait on it, even if it
+	// was created before filesystems were tagged with their owner.
+	unwatch := p.cache.Watch(*fs.FileSystemId)
+	defer unwatch()
+
+	glog.Infof("Waiting for filesystem to become ready: %s", name)
+
 	// Wait for the filesystem to become available.
-	for {
-		glog.Infof("Waiting for filesystem to become ready: %s", name)
+	err = p.cache.Wait(p.params.WaitTimeout, func() (bool, error) {
+		fs, ok := p.cache.Filesystem(*fs.FileSystemId)
+		if !ok {
+			return false, nil
+		}
+
+		switch *fs.LifeCycleState {
+		case efs.LifeCycleStateAvailable:
+			return true, nil
+		case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+			return false, fmt.Errorf("filesystem is in state: %s", *fs.LifeCycleState)
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Filesystem %s did not become available: %s", *fs.FileSystemId, err)
+		return nil, fmt.Errorf("failed to create filesystem: %s", err)
+	}
 
-		// Passing this back to the create function means that it will check if the filesystem exists first.
-		// So it is safe for us to rerun this function to get the latest status.
-		fs, err := putFilesystem(p.client, name, p.params.Performance)
+	if available, ok := p.cache.Filesystem(*fs.FileSystemId); ok {
+		changed, err := p.putPolicy(available, options.PVC)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create filesystem: %s", err)
+			p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonPolicyFailed, "Failed to apply policy to filesystem %s: %s", *fs.FileSystemId, efsclient.Message(err))
+			return nil, fmt.Errorf("failed to apply policy: %s", err)
 		}
 
-		// The filesystem is ready!
-		if *fs.LifeCycleState == efs.LifeCycleStateAvailable {
-			break
+		if changed {
+			p.recorder.Eventf(options.PVC, corev1.EventTypeNormal, EventReasonPolicyApplied, "Applied policy to filesystem %s", *fs.FileSystemId)
 		}
+	}
 
-		<-limiter
+	groups, err := p.mountTargetSecurityGroups(*fs.FileSystemId, options.PVC.ObjectMeta.Namespace)
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonSecurityGroupFailed, "Failed to prepare security groups for filesystem %s: %s", *fs.FileSystemId, efsclient.Message(err))
+		return nil, fmt.Errorf("failed to prepare security groups: %s", err)
 	}
 
+	// Discovery might change the subnets while we wait, so stick with the ones we started with.
+	subnets := p.subnets()
+
+	p.recorder.Eventf(options.PVC, corev1.EventTypeNormal, EventReasonFilesystemAvailable, "Filesystem %s is available, creating %d mount targets", *fs.FileSystemId, len(subnets))
+
 	var group errgroup.Group
 
 	// Create the mount targets.
-	for _, subnet := range p.params.Subnets {
+	for _, subnet := range subnets {
+		subnet := subnet
+
 		group.Go(func() error {
-			_, err := putMount(p.client, *fs.FileSystemId, subnet, p.params.SecurityGroup)
+			_, err := putMount(p.client, *fs.FileSystemId, subnet, groups)
 			if err != nil {
+				p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonMountTargetFailed, "Failed to create mount target in subnet %s: %s", subnet, efsclient.Message(err))
 				return err
 			}
 
-			for {
-				glog.Infof("Waiting for mount target to become ready: %s", name)
+			return nil
+		})
+	}
 
-				// Passing this back to the create function means that it will check if the mount target exists first.
-				// So it is safe for us to rerun this function to get the latest status.
-				target, err := putMount(p.client, *fs.FileSystemId, subnet, p.params.SecurityGroup)
-				if err != nil {
-					return fmt.Errorf("failed to create filesystem: %s", err)
-				}
+	err = group.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mount: %s", err)
+	}
 
-				// The filesystem is ready!
-				if *target.LifeCycleState == efs.LifeCycleStateAvailable {
-					break
-				}
+	glog.Infof("Waiting for mount targets to become ready: %s", name)
+
+	ready := make(map[string]bool)
 
-				<-limiter
+	// Wait for a mount target to become available in each subnet.
+	err = p.cache.Wait(p.params.WaitTimeout, func() (bool, error) {
+		for _, target := range p.cache.MountTargets(*fs.FileSystemId) {
+			subnet := *target.SubnetId
+
+			switch *target.LifeCycleState {
+			case efs.LifeCycleStateAvailable:
+				if !ready[subnet] {
+					ready[subnet] = true
+					p.recorder.Eventf(options.PVC, corev1.EventTypeNormal, EventReasonMountTargetAvailable, "Mount target %d/%d is available in subnet %s", len(ready), len(subnets), subnet)
+				}
+			case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+				return false, fmt.Errorf("mount target in subnet %s is in state: %s", subnet, *target.LifeCycleState)
 			}
+		}
 
-			return nil
-		})
-	}
+		for _, subnet := range subnets {
+			if !ready[subnet] {
+				return false, nil
+			}
+		}
 
-	err = group.Wait()
+		return true, nil
+	})
 	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonMountTargetFailed, "Mount targets for filesystem %s did not become available: %s", *fs.FileSystemId, err)
 		return nil, fmt.Errorf("failed to create mount: %s", err)
 	}
 
 	glog.Infof("Responding with persistent volume spec: %s", name)
 
+	// Describe the filesystem as it is now, rather than when it was created.
+	if available, ok := p.cache.Filesystem(*fs.FileSystemId); ok {
+		fs = available
+	}
+
+	return p.populate(options, fs, source)
+}
+
+// Helper function to return the volume for a filesystem which is ready to be mounted.
+func (p *Provisioner) volume(options controller.ProvisionOptions, fs *efs.FileSystemDescription) (*corev1.PersistentVolume, error) {
+	volumeName, err := p.volumes.Name(options, *fs.FileSystemId)
+	if err != nil {
+		p.recorder.Eventf(options.PVC, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Failed to name volume for filesystem %s: %s", *fs.FileSystemId, err)
+		return nil, fmt.Errorf("failed to name volume: %s", err)
+	}
+
+	// The filesystem ID is always recorded in an annotation, whatever the volume is named.
+	labels, annotations := volumeMetadata(fs, p.cache.MountTargets(*fs.FileSystemId), p.params.Region)
+
+	// https://kubernetes.io/docs/concepts/storage/persistent-volumes
+	// http://docs.aws.amazon.com/efs/latest/ug/mounting-fs-mount-cmd-dns-name.html
+	annotations[MountOptionAnnotation] = "nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2"
+
 	pv := &corev1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: *fs.FileSystemId,
-			Annotations: map[string]string{
-				// https://kubernetes.io/docs/concepts/storage/persistent-volumes
-				// http://docs.aws.amazon.com/efs/latest/ug/mounting-fs-mount-cmd-dns-name.html
-				MountOptionAnnotation: "nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2",
-			},
+			Name:        volumeName,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: corev1.PersistentVolumeSpec{
 			// PersistentVolumeReclaimPolicy, AccessModes and Capacity are required fields.
@@ -147,10 +653,169 @@ func (p *Provisioner) Provision(options controller.ProvisionOptions) (*corev1.Pe
 	return pv, nil
 }
 
-// Delete removes the storage asset that was created by Provision represented
-// by the given PV.
-// @todo, Tag FileSystem as "ready for removal"
-// @todo, Tag FileSystem with a date to show how old it is.
+// Delete cleans up after the storage asset that was created by Provision represented
+// by the given PV. Filesystems are retained unless EFS_DELETE_FILESYSTEMS is enabled,
+// then a managed security group which is no longer used is deleted.
+//
+// This is only called for volumes which an administrator has changed the reclaim
+// policy of, volumes are provisioned with the Retain policy.
 func (p *Provisioner) Delete(volume *corev1.PersistentVolume) error {
+	id := filesystemID(volume)
+
+	var namespace string
+
+	if volume.Spec.ClaimRef != nil {
+		namespace = volume.Spec.ClaimRef.Namespace
+	}
+
+	scope, managed := p.securityGroupScope(id, namespace)
+	if !managed && !p.params.DeleteFilesystems {
+		return nil
+	}
+
+	describe, err := p.client.DescribeFileSystems(&efs.DescribeFileSystemsInput{
+		FileSystemId: aws.String(id),
+	})
+	if err != nil && !efsclient.IsCode(err, efs.ErrCodeFileSystemNotFound) {
+		return fmt.Errorf("failed to describe filesystem %s: %s", id, err)
+	}
+
+	if err == nil && len(describe.FileSystems) > 0 {
+		fs := describe.FileSystems[0]
+
+		// Never touch a filesystem which we don't own.
+		if owner, _ := tagValue(fs.Tags, TagKeyOwner); owner != p.name {
+			return &controller.IgnoredError{
+				Reason: fmt.Sprintf("filesystem %s is not owned by %s", id, p.name),
+			}
+		}
+
+		switch aws.StringValue(fs.LifeCycleState) {
+		case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+		case efs.LifeCycleStateAvailable, efs.LifeCycleStateUpdating, efs.LifeCycleStateCreating:
+			if !p.params.DeleteFilesystems {
+				// A retained filesystem still needs its security group to be mounted.
+				glog.Infof("Keeping security group for filesystem %s, the filesystem is retained", id)
+				return nil
+			}
+
+			deleted, err := p.deleteFilesystem(fs)
+			if err != nil {
+				p.recorder.Eventf(volume, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Failed to delete filesystem %s: %s", id, efsclient.Message(err))
+				return fmt.Errorf("failed to delete filesystem: %s", err)
+			}
+
+			// The controller retries deleting the volume, rather than holding a worker while mount targets are deleted.
+			if !deleted {
+				return fmt.Errorf("waiting for the mount targets of filesystem %s to be deleted", id)
+			}
+
+			p.recorder.Eventf(volume, corev1.EventTypeNormal, EventReasonFilesystemDeleted, "Deleted filesystem %s", id)
+		}
+	}
+
+	if !managed {
+		return nil
+	}
+
+	// Security groups which are shared by a namespace are kept until its last filesystem is gone.
+	if p.params.SecurityGroupMode == SecurityGroupModeNamespace {
+		for _, fs := range p.cache.Filesystems() {
+			other, _ := tagValue(fs.Tags, TagKeyClaimNamespace)
+
+			switch {
+			case aws.StringValue(fs.FileSystemId) == id, other != namespace:
+				continue
+			case aws.StringValue(fs.LifeCycleState) == efs.LifeCycleStateDeleting:
+				continue
+			}
+
+			return nil
+		}
+	}
+
+	err = p.deleteSecurityGroup(scope)
+	if efsclient.IsCode(err, "DependencyViolation") && p.params.SecurityGroupMode == SecurityGroupModeNamespace {
+		glog.Infof("Keeping security group for namespace %s, it is still in use", namespace)
+		return nil
+	}
+	if err != nil {
+		p.recorder.Eventf(volume, corev1.EventTypeWarning, EventReasonSecurityGroupFailed, "Failed to delete security group for filesystem %s: %s", id, efsclient.Message(err))
+		return fmt.Errorf("failed to delete security group: %s", err)
+	}
+
 	return nil
 }
+
+// Helper function to delete the mount targets of a filesystem, and then the filesystem once they are gone.
+//
+// Mount targets take a while to be deleted, so this doesn't wait for them. It returns false while
+// any are left, and should be called again later to finish deleting the filesystem.
+func (p *Provisioner) deleteFilesystem(fs *efs.FileSystemDescription) (bool, error) {
+	id := aws.StringValue(fs.FileSystemId)
+
+	switch aws.StringValue(fs.LifeCycleState) {
+	case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+		return true, nil
+	}
+
+	targets, err := describeMountTargets(p.client, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to describe mount targets: %s", err)
+	}
+
+	var remaining int
+
+	for _, target := range targets {
+		switch aws.StringValue(target.LifeCycleState) {
+		case efs.LifeCycleStateDeleted:
+			continue
+		case efs.LifeCycleStateAvailable:
+			glog.Infof("Deleting mount target %s for filesystem %s", aws.StringValue(target.MountTargetId), id)
+
+			_, err := p.client.DeleteMountTarget(&efs.DeleteMountTargetInput{
+				MountTargetId: target.MountTargetId,
+			})
+			if err != nil && !efsclient.IsCode(err, efs.ErrCodeMountTargetNotFound) {
+				return false, fmt.Errorf("failed to delete mount target in subnet %s: %s", aws.StringValue(target.SubnetId), err)
+			}
+		}
+
+		// Mount targets which are still being created are deleted once they are available.
+		remaining++
+	}
+
+	if remaining > 0 {
+		glog.Infof("Waiting for %d mount targets of filesystem %s to be deleted", remaining, id)
+		return false, nil
+	}
+
+	glog.Infof("Deleting filesystem: %s", id)
+
+	_, err = p.client.DeleteFileSystem(&efs.DeleteFileSystemInput{
+		FileSystemId: aws.String(id),
+	})
+	if efsclient.IsCode(err, efs.ErrCodeFileSystemInUse) {
+		return false, nil
+	}
+	if err != nil && !efsclient.IsCode(err, efs.ErrCodeFileSystemNotFound) {
+		return false, err
+	}
+
+	p.cache.Invalidate(id)
+
+	return true, nil
+}
+
+// Helper function to return the ID of the filesystem backing a volume.
+func filesystemID(volume *corev1.PersistentVolume) string {
+	if id, ok := volume.ObjectMeta.Annotations[AnnotationFilesystemID]; ok && id != "" {
+		return id
+	}
+
+	if volume.Spec.NFS != nil {
+		return strings.SplitN(volume.Spec.NFS.Server, ".", 2)[0]
+	}
+
+	return volume.ObjectMeta.Name
+}
@@ -2,11 +2,15 @@ package provisioner
 
 import (
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/efs"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
 
 	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
@@ -22,11 +26,20 @@ func TestProvisioner(t *testing.T) {
 			"subnet-xxxxxxxx",
 			"subnet-yyyyyyyy",
 		},
+		PollInterval: time.Millisecond,
+		WaitTimeout:  time.Minute,
 	}
 
-	provisioner, err := New(mock.New(), params)
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(mock.New(), params, WithRecorder(recorder))
 	assert.Nil(t, err)
 
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
 	options := controller.ProvisionOptions{
 		PVName: "test",
 		PVC: &corev1.PersistentVolumeClaim{
@@ -41,9 +54,23 @@ func TestProvisioner(t *testing.T) {
 
 	want := corev1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "namespace-test",
+			Name: "fs-00000001",
+			Labels: map[string]string{
+				AnnotationFilesystemID:    "fs-00000001",
+				AnnotationPerformanceMode: "generalPurpose",
+				AnnotationThroughputMode:  "bursting",
+				AnnotationEncrypted:       "false",
+				AnnotationRegion:          "ap-southeast-2",
+			},
 			Annotations: map[string]string{
-				MountOptionAnnotation: "nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2",
+				MountOptionAnnotation:     "nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2",
+				AnnotationFilesystemID:    "fs-00000001",
+				AnnotationPerformanceMode: "generalPurpose",
+				AnnotationThroughputMode:  "bursting",
+				AnnotationEncrypted:       "false",
+				AnnotationRegion:          "ap-southeast-2",
+				AnnotationCreationToken:   "namespace-test",
+				AnnotationMountTargets:    "fsmt-00000002,fsmt-00000003",
 			},
 		},
 		Spec: corev1.PersistentVolumeSpec{
@@ -56,7 +83,7 @@ func TestProvisioner(t *testing.T) {
 			},
 			PersistentVolumeSource: corev1.PersistentVolumeSource{
 				NFS: &corev1.NFSVolumeSource{
-					Server: "namespace-test.efs.ap-southeast-2.amazonaws.com",
+					Server: "fs-00000001.efs.ap-southeast-2.amazonaws.com",
 					Path:   "/",
 				},
 			},
@@ -65,6 +92,181 @@ func TestProvisioner(t *testing.T) {
 
 	assert.Equal(t, want, *volume)
 
+	events := testEvents(recorder)
+
+	assert.Equal(t, []string{
+		"Normal FilesystemCreating Creating filesystem fs-00000001 with CreationToken namespace-test",
+		"Normal FilesystemAvailable Filesystem fs-00000001 is available, creating 2 mount targets",
+	}, events[:2])
+	// Mount targets are created in parallel, so they can become available in any order.
+	assert.Len(t, events, 4)
+	assert.Contains(t, events[2], "Normal MountTargetAvailable Mount target 1/2 is available")
+	assert.Contains(t, events[3], "Normal MountTargetAvailable Mount target 2/2 is available")
+
 	err = provisioner.Delete(volume)
 	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+}
+
+// Helper function to return the params used by tests.
+func testParams() Params {
+	return Params{
+		Region:        "ap-southeast-2",
+		Format:        "{{ .PVC.ObjectMeta.Namespace }}-{{ .PVName }}",
+		Performance:   "generalPurpose",
+		SecurityGroup: "sg-xxxxxxxxxxxx",
+		Subnets: []string{
+			"subnet-xxxxxxxx",
+			"subnet-yyyyyyyy",
+		},
+		PollInterval: time.Millisecond,
+		WaitTimeout:  time.Minute,
+	}
+}
+
+// Helper function to return provisioning options used by tests.
+func testOptions(namespace, name string) controller.ProvisionOptions {
+	return controller.ProvisionOptions{
+		PVName: name,
+		PVC: &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+			},
+		},
+	}
+}
+
+func TestNewPolling(t *testing.T) {
+	// Params which aren't loaded from the environment don't get the defaults.
+	params := testParams()
+	params.PollInterval = 0
+
+	_, err := New(mock.New(), params)
+	assert.EqualError(t, err, "the poll interval must be greater than zero")
+
+	params = testParams()
+	params.WaitTimeout = 0
+
+	_, err = New(mock.New(), params)
+	assert.EqualError(t, err, "the wait timeout must be greater than zero")
+}
+
+func TestProvisionVolumeNaming(t *testing.T) {
+	client := mock.New()
+
+	params := testParams()
+	params.VolumeNaming = VolumeNamingPVName
+
+	provisioner, err := New(client, params)
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	options := testOptions("namespace", "test")
+	options.PVName = "pvc-00000000-0000-0000-0000-000000000001"
+
+	volume, err := provisioner.Provision(options)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "pvc-00000000-0000-0000-0000-000000000001", volume.ObjectMeta.Name)
+	assert.Equal(t, "fs-00000001", volume.ObjectMeta.Annotations[AnnotationFilesystemID])
+	assert.Equal(t, "fs-00000001", filesystemID(volume))
+}
+
+// Helper function to drain the events from a recorder.
+func testEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestProvisionerLifecycle(t *testing.T) {
+	client := mock.New(mock.WithParams(mock.Params{
+		Region:                 "ap-southeast-2",
+		Account:                "123456789012",
+		FileSystemCreateDelay:  time.Minute,
+		MountTargetCreateDelay: 2 * time.Minute,
+		FileSystemLimit:        10,
+		SecurityGroupLimit:     5,
+	}))
+
+	provisioner, err := New(client, testParams())
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	done := make(chan error)
+
+	go func() {
+		_, err := provisioner.Provision(testOptions("namespace", "test"))
+		done <- err
+	}()
+
+	// Move the simulated clock forward until provisioning has finished.
+	for {
+		select {
+		case err := <-done:
+			assert.Nil(t, err)
+
+			for _, target := range client.MountTargets("fs-00000001") {
+				assert.Equal(t, "available", target.State)
+			}
+
+			return
+		case <-time.After(time.Millisecond):
+			client.Advance(15 * time.Second)
+		}
+	}
+}
+
+func TestProvisionerMountTargetFailure(t *testing.T) {
+	client := mock.New(mock.WithSubnet("subnet-xxxxxxxx", "ap-southeast-2a"))
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	_, err = provisioner.Provision(testOptions("namespace", "test"))
+	assert.NotNil(t, err)
+
+	assert.Contains(t, testEvents(recorder), "Warning MountTargetFailed Failed to create mount target in subnet subnet-yyyyyyyy: "+
+		"SubnetNotFound: Subnet 'subnet-yyyyyyyy' does not exist A configured subnet does not exist. Check the subnets the provisioner is configured with.")
+}
+
+func TestProvisionerFilesystemFailure(t *testing.T) {
+	client := mock.New()
+	client.FailNext("CreateFileSystem", awserr.New(efs.ErrCodeFileSystemLimitExceeded, "You have reached the maximum number of file systems allowed", nil))
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	_, err = provisioner.Provision(testOptions("namespace", "test"))
+	assert.NotNil(t, err)
+
+	events := testEvents(recorder)
+	assert.Len(t, events, 1)
+	assert.Contains(t, events[0], "Warning FilesystemFailed Failed to create filesystem: FileSystemLimitExceeded")
+	assert.Equal(t, 0, client.CallCount("CreateMountTarget"))
 }
@@ -0,0 +1,254 @@
+package provisioner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+)
+
+var _ controller.Qualifier = &Provisioner{}
+
+// Annotation on a claim which records the provisioner that should provision it.
+const annStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
+
+// QuotaError is returned when provisioning a claim would exceed a quota.
+type QuotaError struct {
+	Reason string
+}
+
+// Error describes which quota would be exceeded.
+func (e *QuotaError) Error() string {
+	return e.Reason
+}
+
+// ShouldProvision only allows claims from namespaces which are allowed to use the provisioner,
+// and which wouldn't exceed a quota, to be provisioned.
+//
+// This is called for every claim the controller sees, so claims for other provisioners are
+// skipped without doing anything.
+func (p *Provisioner) ShouldProvision(claim *corev1.PersistentVolumeClaim) bool {
+	if claim.ObjectMeta.Annotations[annStorageProvisioner] != p.name {
+		return true
+	}
+
+	allowed, err := p.namespaceAllowed(claim.ObjectMeta.Namespace)
+	if err != nil {
+		// The claim is checked again on the next resync.
+		glog.Errorf("Not provisioning claim %s/%s, failed to check its namespace: %s", claim.ObjectMeta.Namespace, claim.ObjectMeta.Name, err)
+		return false
+	}
+
+	p.quotaMu.Lock()
+	defer p.quotaMu.Unlock()
+
+	if !allowed {
+		p.refuseClaim(claim, EventReasonNamespaceNotAllowed, "Namespace %s is not allowed to provision EFS filesystems", claim.ObjectMeta.Namespace)
+		return false
+	}
+
+	err = p.checkQuota(claim)
+	if err != nil {
+		p.refuseClaim(claim, EventReasonQuotaExceeded, "Not provisioning a filesystem: %s", err)
+		return false
+	}
+
+	delete(p.refused, claimKey(claim.ObjectMeta.Namespace, claim.ObjectMeta.Name))
+
+	return true
+}
+
+// Helper function to warn a claim that it won't be provisioned. Claims are checked again on every
+// resync, so they are only warned when the reason they were refused changes.
+//
+// Must be called while holding the quota lock.
+func (p *Provisioner) refuseClaim(claim *corev1.PersistentVolumeClaim, reason, format string, args ...interface{}) {
+	key := claimKey(claim.ObjectMeta.Namespace, claim.ObjectMeta.Name)
+
+	if p.refused[key] == reason {
+		return
+	}
+
+	p.refused[key] = reason
+
+	p.recorder.Eventf(claim, corev1.EventTypeWarning, reason, format, args...)
+}
+
+// Helper function to check if a namespace may provision filesystems.
+func (p *Provisioner) namespaceAllowed(name string) (bool, error) {
+	if containsString(p.params.DenyNamespaces, name) {
+		return false, nil
+	}
+
+	if len(p.params.AllowNamespaces) > 0 && !containsString(p.params.AllowNamespaces, name) {
+		return false, nil
+	}
+
+	if p.selector == nil {
+		return true, nil
+	}
+
+	namespace, err := p.kubernetes.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return p.selector.Matches(labels.Set(namespace.ObjectMeta.Labels)), nil
+}
+
+// Helper function to reserve room for a claim's filesystem within the quotas, while it
+// is provisioned. The returned function must be called once provisioning has finished.
+func (p *Provisioner) reserveQuota(claim *corev1.PersistentVolumeClaim) (func(), error) {
+	p.quotaMu.Lock()
+	defer p.quotaMu.Unlock()
+
+	err := p.checkQuota(claim)
+	if err != nil {
+		return nil, err
+	}
+
+	key := claimKey(claim.ObjectMeta.Namespace, claim.ObjectMeta.Name)
+
+	p.inflight[key]++
+
+	return func() {
+		p.quotaMu.Lock()
+		defer p.quotaMu.Unlock()
+
+		p.inflight[key]--
+
+		if p.inflight[key] <= 0 {
+			delete(p.inflight, key)
+		}
+	}, nil
+}
+
+// Helper function to check if provisioning a claim would exceed a quota. Claims which already
+// have a filesystem (or are being provisioned) are never over quota, so they can be retried.
+//
+// Must be called while holding the quota lock.
+func (p *Provisioner) checkQuota(claim *corev1.PersistentVolumeClaim) error {
+	var (
+		namespace = claim.ObjectMeta.Namespace
+		key       = claimKey(namespace, claim.ObjectMeta.Name)
+		claims    = make(map[string]string)
+		pending   int
+	)
+
+	for _, fs := range p.cache.Filesystems() {
+		switch aws.StringValue(fs.LifeCycleState) {
+		case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+			continue
+		}
+
+		owner, _ := tagValue(fs.Tags, TagKeyClaimNamespace)
+		name, _ := tagValue(fs.Tags, TagKeyClaimName)
+
+		// Filesystems which don't record their claim still count towards the quotas.
+		other := aws.StringValue(fs.FileSystemId)
+		if owner != "" && name != "" {
+			other = claimKey(owner, name)
+		}
+
+		claims[other] = owner
+	}
+
+	// Claims which are being provisioned might already have a filesystem, which is only counted once.
+	for inflight := range p.inflight {
+		if _, ok := claims[inflight]; !ok {
+			claims[inflight] = namespaceOfClaimKey(inflight)
+			pending++
+		}
+	}
+
+	if _, ok := claims[key]; ok {
+		return nil
+	}
+
+	var inNamespace int
+
+	for _, owner := range claims {
+		if owner == namespace {
+			inNamespace++
+		}
+	}
+
+	if max := p.params.MaxFilesystemsPerNamespace; max > 0 && inNamespace >= max {
+		return &QuotaError{
+			Reason: fmt.Sprintf("namespace %s already has %d filesystems (limit %d)", namespace, inNamespace, max),
+		}
+	}
+
+	// New filesystems start out bursting, so they only need the namespace to have some throughput left.
+	err := p.checkThroughputQuota(namespace, "", 0)
+	if err != nil {
+		return err
+	}
+
+	if max := p.params.MaxFilesystems; max > 0 && len(claims) >= max {
+		return &QuotaError{
+			Reason: fmt.Sprintf("the provisioner already has %d filesystems (limit %d)", len(claims), max),
+		}
+	}
+
+	// Filesystems in the account which are owned by someone else count towards the account's limit too.
+	if max := p.params.AccountFilesystemLimit; max > 0 && p.cache.Total()+pending >= max {
+		return &QuotaError{
+			Reason: fmt.Sprintf("the AWS account already has %d filesystems (limit %d)", p.cache.Total()+pending, max),
+		}
+	}
+
+	return nil
+}
+
+// Helper function to check if a filesystem in a namespace can have the given provisioned throughput (MiB/s)
+// without the namespace exceeding its quota. The filesystem's current throughput isn't counted, as it is
+// being replaced.
+//
+// Must be called while holding the quota lock.
+func (p *Provisioner) checkThroughputQuota(namespace, id string, mibps float64) error {
+	max := p.params.MaxThroughputPerNamespace
+	if max <= 0 {
+		return nil
+	}
+
+	var throughput float64
+
+	for _, fs := range p.cache.Filesystems() {
+		switch aws.StringValue(fs.LifeCycleState) {
+		case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+			continue
+		}
+
+		if owner, _ := tagValue(fs.Tags, TagKeyClaimNamespace); owner != namespace || aws.StringValue(fs.FileSystemId) == id {
+			continue
+		}
+
+		throughput += aws.Float64Value(fs.ProvisionedThroughputInMibps)
+	}
+
+	if throughput >= max {
+		return &QuotaError{
+			Reason: fmt.Sprintf("namespace %s already has %g MiB/s of provisioned throughput (limit %g MiB/s)", namespace, throughput, max),
+		}
+	}
+
+	if throughput+mibps > max {
+		return &QuotaError{
+			Reason: fmt.Sprintf("namespace %s already has %g MiB/s of provisioned throughput, %g MiB/s more would exceed its limit of %g MiB/s", namespace, throughput, mibps, max),
+		}
+	}
+
+	return nil
+}
+
+// Helper function to return the namespace from a claim key.
+func namespaceOfClaimKey(key string) string {
+	return strings.SplitN(key, "/", 2)[0]
+}
@@ -0,0 +1,361 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a claim which is waiting on the provisioner.
+func testPendingClaim(namespace, name string) *corev1.PersistentVolumeClaim {
+	return testClaim(namespace, name, map[string]string{
+		annStorageProvisioner: "efs.aws.skpr.io/generalPurpose",
+	})
+}
+
+// Helper function to create a filesystem which was provisioned for a claim.
+func testClaimFilesystem(t *testing.T, client *mock.Client, namespace, name string, throughput float64) {
+	input := &efs.CreateFileSystemInput{
+		CreationToken: aws.String(namespace + "-" + name),
+		Tags: []*efs.Tag{
+			{Key: aws.String(TagKeyOwner), Value: aws.String("efs.aws.skpr.io/generalPurpose")},
+			{Key: aws.String(TagKeyClaimNamespace), Value: aws.String(namespace)},
+			{Key: aws.String(TagKeyClaimName), Value: aws.String(name)},
+		},
+	}
+
+	if throughput > 0 {
+		input.ThroughputMode = aws.String(efs.ThroughputModeProvisioned)
+		input.ProvisionedThroughputInMibps = aws.Float64(throughput)
+	}
+
+	_, err := client.CreateFileSystem(input)
+	assert.Nil(t, err)
+}
+
+func TestNewNamespaceSelector(t *testing.T) {
+	params := testParams()
+	params.NamespaceSelector = "efs=enabled"
+
+	_, err := New(mock.New(), params)
+	assert.EqualError(t, err, "a Kubernetes client is required to select namespaces")
+
+	params.NamespaceSelector = "efs in (enabled"
+
+	_, err = New(mock.New(), params, WithKubernetes(fake.NewSimpleClientset()))
+	assert.Error(t, err)
+}
+
+func TestShouldProvisionNamespaces(t *testing.T) {
+	kubernetes := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "enabled", Labels: map[string]string{"efs": "enabled"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "disabled"}},
+	)
+
+	tests := []struct {
+		name      string
+		params    func(*Params)
+		namespace string
+		allowed   bool
+	}{
+		{
+			name:      "unrestricted",
+			namespace: "disabled",
+			allowed:   true,
+		},
+		{
+			name: "allowed",
+			params: func(params *Params) {
+				params.AllowNamespaces = []string{"enabled"}
+			},
+			namespace: "enabled",
+			allowed:   true,
+		},
+		{
+			name: "not allowed",
+			params: func(params *Params) {
+				params.AllowNamespaces = []string{"enabled"}
+			},
+			namespace: "disabled",
+		},
+		{
+			name: "denied",
+			params: func(params *Params) {
+				params.AllowNamespaces = []string{"enabled"}
+				params.DenyNamespaces = []string{"enabled"}
+			},
+			namespace: "enabled",
+		},
+		{
+			name: "selected",
+			params: func(params *Params) {
+				params.NamespaceSelector = "efs=enabled"
+			},
+			namespace: "enabled",
+			allowed:   true,
+		},
+		{
+			name: "not selected",
+			params: func(params *Params) {
+				params.NamespaceSelector = "efs=enabled"
+			},
+			namespace: "disabled",
+		},
+		{
+			name: "missing",
+			params: func(params *Params) {
+				params.NamespaceSelector = "efs=enabled"
+			},
+			namespace: "missing",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			params := testParams()
+			if test.params != nil {
+				test.params(&params)
+			}
+
+			recorder := record.NewFakeRecorder(100)
+
+			provisioner, err := New(mock.New(), params, WithKubernetes(kubernetes), WithRecorder(recorder))
+			assert.Nil(t, err)
+
+			assert.Equal(t, test.allowed, provisioner.ShouldProvision(testPendingClaim(test.namespace, "test")))
+
+			if test.allowed || test.namespace == "missing" {
+				assert.Empty(t, testEvents(recorder))
+			} else {
+				assert.Equal(t, []string{"Warning NamespaceNotAllowed Namespace " + test.namespace + " is not allowed to provision EFS filesystems"}, testEvents(recorder))
+			}
+		})
+	}
+}
+
+func TestShouldProvisionSkipsOtherClaims(t *testing.T) {
+	params := testParams()
+	params.AllowNamespaces = []string{"enabled"}
+
+	provisioner, err := New(mock.New(), params)
+	assert.Nil(t, err)
+
+	// The controller decides what happens to claims for other provisioners.
+	assert.True(t, provisioner.ShouldProvision(testClaim("disabled", "test", map[string]string{
+		annStorageProvisioner: "efs.aws.skpr.io/maxIO",
+	})))
+}
+
+func TestShouldProvisionQuotas(t *testing.T) {
+	client := mock.New()
+
+	testClaimFilesystem(t, client, "namespace", "first", 100)
+	testClaimFilesystem(t, client, "namespace", "second", 50)
+	testClaimFilesystem(t, client, "other", "first", 0)
+
+	// A filesystem which belongs to someone else.
+	_, err := client.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("unowned"),
+	})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		params func(*Params)
+		claim  string
+		event  string
+	}{
+		{
+			name: "within quotas",
+			params: func(params *Params) {
+				params.MaxFilesystemsPerNamespace = 3
+				params.MaxThroughputPerNamespace = 200
+				params.MaxFilesystems = 4
+				params.AccountFilesystemLimit = 5
+			},
+			claim: "third",
+		},
+		{
+			name: "filesystems per namespace",
+			params: func(params *Params) {
+				params.MaxFilesystemsPerNamespace = 2
+			},
+			claim: "third",
+			event: "Warning QuotaExceeded Not provisioning a filesystem: namespace namespace already has 2 filesystems (limit 2)",
+		},
+		{
+			name: "throughput per namespace",
+			params: func(params *Params) {
+				params.MaxThroughputPerNamespace = 150
+			},
+			claim: "third",
+			event: "Warning QuotaExceeded Not provisioning a filesystem: namespace namespace already has 150 MiB/s of provisioned throughput (limit 150 MiB/s)",
+		},
+		{
+			name: "filesystems",
+			params: func(params *Params) {
+				params.MaxFilesystems = 3
+			},
+			claim: "third",
+			event: "Warning QuotaExceeded Not provisioning a filesystem: the provisioner already has 3 filesystems (limit 3)",
+		},
+		{
+			name: "account",
+			params: func(params *Params) {
+				params.AccountFilesystemLimit = 4
+			},
+			claim: "third",
+			event: "Warning QuotaExceeded Not provisioning a filesystem: the AWS account already has 4 filesystems (limit 4)",
+		},
+		{
+			name: "existing filesystem",
+			params: func(params *Params) {
+				params.MaxFilesystemsPerNamespace = 1
+				params.MaxFilesystems = 1
+			},
+			claim: "second",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			params := testParams()
+			test.params(&params)
+
+			recorder := record.NewFakeRecorder(100)
+
+			provisioner, err := New(client, params, WithRecorder(recorder))
+			assert.Nil(t, err)
+
+			err = provisioner.cache.Refresh()
+			assert.Nil(t, err)
+
+			assert.Equal(t, test.event == "", provisioner.ShouldProvision(testPendingClaim("namespace", test.claim)))
+
+			if test.event == "" {
+				assert.Empty(t, testEvents(recorder))
+			} else {
+				assert.Equal(t, []string{test.event}, testEvents(recorder))
+			}
+		})
+	}
+}
+
+func TestShouldProvisionWarnsOnce(t *testing.T) {
+	client := mock.New()
+
+	testClaimFilesystem(t, client, "namespace", "first", 0)
+
+	params := testParams()
+	params.MaxFilesystemsPerNamespace = 1
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	// Claims are checked again on every resync, which shouldn't repeat the warning.
+	for i := 0; i < 3; i++ {
+		assert.False(t, provisioner.ShouldProvision(testPendingClaim("namespace", "second")))
+	}
+
+	assert.Equal(t, []string{
+		"Warning QuotaExceeded Not provisioning a filesystem: namespace namespace already has 1 filesystems (limit 1)",
+	}, testEvents(recorder))
+
+	// Claims are warned again if they are refused after being allowed.
+	provisioner.params.MaxFilesystemsPerNamespace = 2
+	assert.True(t, provisioner.ShouldProvision(testPendingClaim("namespace", "second")))
+
+	provisioner.params.MaxFilesystemsPerNamespace = 1
+	assert.False(t, provisioner.ShouldProvision(testPendingClaim("namespace", "second")))
+
+	provisioner.params.AllowNamespaces = []string{"other"}
+	assert.False(t, provisioner.ShouldProvision(testPendingClaim("namespace", "second")))
+	assert.False(t, provisioner.ShouldProvision(testPendingClaim("namespace", "second")))
+
+	assert.Equal(t, []string{
+		"Warning QuotaExceeded Not provisioning a filesystem: namespace namespace already has 1 filesystems (limit 1)",
+		"Warning NamespaceNotAllowed Namespace namespace is not allowed to provision EFS filesystems",
+	}, testEvents(recorder))
+}
+
+func TestProvisionQuotaExceeded(t *testing.T) {
+	client := mock.New()
+
+	params := testParams()
+	params.MaxFilesystemsPerNamespace = 1
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	_, err = provisioner.Provision(testOptions("namespace", "first"))
+	assert.Nil(t, err)
+
+	testEvents(recorder)
+
+	// The first claim's filesystem counts towards the quota once it has been provisioned.
+	_, err = provisioner.Provision(testOptions("namespace", "second"))
+	assert.IsType(t, &QuotaError{}, err)
+	assert.Equal(t, []string{"Warning QuotaExceeded Not provisioning a filesystem: namespace namespace already has 1 filesystems (limit 1)"}, testEvents(recorder))
+	assert.Equal(t, 1, client.CallCount("CreateFileSystem"))
+
+	// Retrying the first claim is always allowed.
+	_, err = provisioner.Provision(testOptions("namespace", "first"))
+	assert.Nil(t, err)
+
+	// Other namespaces have quotas of their own.
+	_, err = provisioner.Provision(testOptions("other", "first"))
+	assert.Nil(t, err)
+
+	assert.Empty(t, provisioner.inflight)
+}
+
+func TestQuotaCountsInflightOnce(t *testing.T) {
+	client := mock.New()
+
+	testClaimFilesystem(t, client, "namespace", "first", 0)
+
+	params := testParams()
+	params.AccountFilesystemLimit = 2
+
+	provisioner, err := New(client, params)
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	// A retry of a claim which already has a filesystem doesn't use up any more of the account's limit.
+	release, err := provisioner.reserveQuota(testPendingClaim("namespace", "first"))
+	assert.Nil(t, err)
+	defer release()
+
+	release, err = provisioner.reserveQuota(testPendingClaim("namespace", "second"))
+	assert.Nil(t, err)
+	defer release()
+
+	_, err = provisioner.reserveQuota(testPendingClaim("namespace", "third"))
+	assert.EqualError(t, err, "the AWS account already has 2 filesystems (limit 2)")
+}
@@ -0,0 +1,497 @@
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
+)
+
+const (
+	// Kinds of drift reported by the MountTargetDrift metric.
+	driftMissing        = "missing"
+	driftExtra          = "extra"
+	driftSecurityGroups = "security_groups"
+
+	// Actions reported by the ReconcileActionsTotal metric.
+	actionCreateMountTarget    = "create_mount_target"
+	actionDeleteMountTarget    = "delete_mount_target"
+	actionModifySecurityGroups = "modify_security_groups"
+	actionPutPolicy            = "put_policy"
+	actionSyncTags             = "sync_tags"
+	actionUpdateThroughput     = "update_throughput"
+	actionUpdateVolume         = "update_volume"
+)
+
+// Reconcile the mount targets of every owned filesystem with the configured subnets and
+// security groups, so configuration changes also reach volumes which were provisioned earlier.
+func (p *Provisioner) Reconcile() error {
+	// Filesystems which no longer exist shouldn't keep reporting drift.
+	MountTargetDrift.Reset()
+
+	// Security groups and policies are described again now and then, to find changes made outside of the provisioner.
+	// Managed security groups are looked up again too, in case they were deleted.
+	if now := p.now(); now.Sub(p.resynced) >= p.params.ResyncInterval {
+		p.cache.Resync()
+		p.forgetSecurityGroups()
+		p.resynced = now
+	}
+
+	var (
+		failed  []string
+		zones   = p.subnetZones()
+		drifted = make(map[string]bool)
+		volumes []*corev1.PersistentVolume
+		claims  *volumeClaims
+	)
+
+	// Volumes and claims are listed once per pass, rather than fetched for every filesystem.
+	if p.kubernetes != nil {
+		var err error
+
+		volumes, err = p.listVolumes()
+		if err != nil {
+			return err
+		}
+
+		claims, err = p.listClaims()
+		if err != nil {
+			return err
+		}
+	}
+
+	released := releasedFilesystems(volumes)
+
+	for _, fs := range p.cache.Filesystems() {
+		id := aws.StringValue(fs.FileSystemId)
+
+		if aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateAvailable {
+			continue
+		}
+
+		// Filesystems which are being provisioned still have mount targets on the way.
+		if p.cache.isWatched(id) {
+			continue
+		}
+
+		// Filesystems which are being deleted have their mount targets removed, which mustn't be undone.
+		if released[id] || p.isDraining(id) {
+			continue
+		}
+
+		err := p.reconcileMountTargets(fs, zones, drifted)
+		if err == nil {
+			err = p.reconcilePolicy(fs, claims)
+		}
+		if err == nil {
+			err = p.reconcileTags(fs, claims)
+		}
+		if err != nil {
+			ReconcileErrorsTotal.Inc()
+			failed = append(failed, fmt.Sprintf("%s: %s", id, err))
+		}
+	}
+
+	// Volumes are reconciled after their filesystems, so they describe any changes which were made.
+	err := p.reconcileVolumes(volumes)
+	if err != nil {
+		ReconcileErrorsTotal.Inc()
+		failed = append(failed, fmt.Sprintf("volumes: %s", err))
+	}
+
+	// Drift which can't be reconciled is only reported again once it has gone away.
+	p.drifted = drifted
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to reconcile %d filesystems: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Helper function to return the availability zones of the subnets which any filesystem has a mount target in.
+//
+// Filesystems can only have one mount target per availability zone, which is the only way to tell whether a
+// configured subnet can have a mount target without asking EC2.
+func (p *Provisioner) subnetZones() map[string]string {
+	zones := make(map[string]string)
+
+	for _, fs := range p.cache.Filesystems() {
+		for _, target := range p.cache.MountTargets(aws.StringValue(fs.FileSystemId)) {
+			if zone := aws.StringValue(target.AvailabilityZoneName); zone != "" {
+				zones[aws.StringValue(target.SubnetId)] = zone
+			}
+		}
+	}
+
+	return zones
+}
+
+// Helper function to return the filesystems of volumes which have been released from their claim, and may be
+// being deleted.
+func releasedFilesystems(volumes []*corev1.PersistentVolume) map[string]bool {
+	released := make(map[string]bool)
+
+	for _, volume := range volumes {
+		switch volume.Status.Phase {
+		case corev1.VolumeReleased, corev1.VolumeFailed:
+			released[filesystemID(volume)] = true
+		}
+	}
+
+	return released
+}
+
+// Helper function to reconcile the mount targets of a single filesystem.
+//
+// A failure to change one mount target doesn't stop the others from being reconciled.
+func (p *Provisioner) reconcileMountTargets(fs *efs.FileSystemDescription, zones map[string]string, drifted map[string]bool) error {
+	var (
+		id         = aws.StringValue(fs.FileSystemId)
+		claim      = claimReference(fs)
+		targets    = p.cache.MountTargets(id)
+		configured = make(map[string]bool)
+		existing   = make(map[string]bool)
+		occupied   = make(map[string]string)
+		missing    []string
+		extra      []*efs.MountTargetDescription
+		failed     []string
+	)
+
+	for _, target := range targets {
+		// Wait for mount targets to settle before making any changes.
+		if aws.StringValue(target.LifeCycleState) != efs.LifeCycleStateAvailable {
+			return nil
+		}
+
+		existing[aws.StringValue(target.SubnetId)] = true
+		occupied[aws.StringValue(target.AvailabilityZoneName)] = aws.StringValue(target.SubnetId)
+	}
+
+	for _, subnet := range p.subnets() {
+		configured[subnet] = true
+
+		if !existing[subnet] {
+			missing = append(missing, subnet)
+		}
+	}
+
+	for _, target := range targets {
+		if !configured[aws.StringValue(target.SubnetId)] {
+			extra = append(extra, target)
+		}
+	}
+
+	MountTargetDrift.WithLabelValues(id, driftMissing).Set(float64(len(missing)))
+	MountTargetDrift.WithLabelValues(id, driftExtra).Set(float64(len(extra)))
+
+	namespace, _ := tagValue(fs.Tags, TagKeyClaimNamespace)
+
+	groups, err := p.mountTargetSecurityGroups(id, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to prepare security groups: %s", err)
+	}
+
+	var changed bool
+
+	// The number of mount targets might not change, so make sure the next refresh sees what we did.
+	defer func() {
+		if changed {
+			p.cache.Invalidate(id)
+		}
+	}()
+
+	for _, subnet := range missing {
+		if other, ok := occupied[zones[subnet]]; ok && zones[subnet] != "" {
+			// Pruned mount targets make room for the configured subnet once they have been deleted.
+			if !configured[other] && p.params.PruneMountTargets {
+				glog.Infof("Not creating mount target for filesystem %s in subnet %s until the mount target in subnet %s is deleted", id, subnet, other)
+				continue
+			}
+
+			p.drift(claim, drifted, "Filesystem %s can't have a mount target in subnet %s, availability zone %s already has one in subnet %s", id, subnet, zones[subnet], other)
+			continue
+		}
+
+		glog.Infof("Creating mount target for filesystem %s in subnet %s", id, subnet)
+
+		_, err := p.client.CreateMountTarget(&efs.CreateMountTargetInput{
+			FileSystemId:   aws.String(id),
+			SubnetId:       aws.String(subnet),
+			SecurityGroups: aws.StringSlice(groups),
+		})
+		if efsclient.IsCode(err, efs.ErrCodeMountTargetConflict) {
+			// The subnet's availability zone wasn't known, as no filesystem has a mount target in it yet.
+			p.drift(claim, drifted, "Filesystem %s can't have a mount target in subnet %s: %s", id, subnet, efsclient.Message(err))
+			continue
+		}
+		if err != nil {
+			p.event(claim, corev1.EventTypeWarning, EventReasonMountTargetFailed, "Failed to create mount target for filesystem %s in subnet %s: %s", id, subnet, efsclient.Message(err))
+			failed = append(failed, fmt.Sprintf("failed to create mount target in subnet %s: %s", subnet, err))
+			continue
+		}
+
+		changed = true
+		ReconcileActionsTotal.WithLabelValues(actionCreateMountTarget).Inc()
+		p.event(claim, corev1.EventTypeNormal, EventReasonMountTargetReconciled, "Created mount target for filesystem %s in subnet %s", id, subnet)
+	}
+
+	for _, target := range extra {
+		subnet := aws.StringValue(target.SubnetId)
+
+		if !p.params.PruneMountTargets {
+			p.drift(claim, drifted, "Filesystem %s has a mount target in subnet %s which is no longer configured", id, subnet)
+			continue
+		}
+
+		glog.Infof("Deleting mount target %s for filesystem %s in subnet %s", aws.StringValue(target.MountTargetId), id, subnet)
+
+		_, err := p.client.DeleteMountTarget(&efs.DeleteMountTargetInput{
+			MountTargetId: target.MountTargetId,
+		})
+		if err != nil {
+			p.event(claim, corev1.EventTypeWarning, EventReasonMountTargetFailed, "Failed to delete mount target for filesystem %s in subnet %s: %s", id, subnet, efsclient.Message(err))
+			failed = append(failed, fmt.Sprintf("failed to delete mount target in subnet %s: %s", subnet, err))
+			continue
+		}
+
+		changed = true
+		ReconcileActionsTotal.WithLabelValues(actionDeleteMountTarget).Inc()
+		p.event(claim, corev1.EventTypeNormal, EventReasonMountTargetReconciled, "Deleted mount target for filesystem %s in subnet %s", id, subnet)
+	}
+
+	var modified int
+
+	for _, target := range targets {
+		subnet := aws.StringValue(target.SubnetId)
+
+		if !configured[subnet] {
+			continue
+		}
+
+		current, err := p.securityGroups(target)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("failed to describe security groups of mount target in subnet %s: %s", subnet, err))
+			continue
+		}
+
+		if sameStrings(current, groups) {
+			continue
+		}
+
+		modified++
+
+		glog.Infof("Changing security groups of mount target %s for filesystem %s from %v to %v", aws.StringValue(target.MountTargetId), id, current, groups)
+
+		_, err = p.client.ModifyMountTargetSecurityGroups(&efs.ModifyMountTargetSecurityGroupsInput{
+			MountTargetId:  target.MountTargetId,
+			SecurityGroups: aws.StringSlice(groups),
+		})
+		if err != nil {
+			p.event(claim, corev1.EventTypeWarning, EventReasonMountTargetFailed, "Failed to change security groups of mount target for filesystem %s in subnet %s: %s", id, subnet, efsclient.Message(err))
+			failed = append(failed, fmt.Sprintf("failed to modify security groups of mount target in subnet %s: %s", subnet, err))
+			continue
+		}
+
+		changed = true
+		p.cache.SetSecurityGroups(aws.StringValue(target.MountTargetId), groups)
+		ReconcileActionsTotal.WithLabelValues(actionModifySecurityGroups).Inc()
+		p.event(claim, corev1.EventTypeNormal, EventReasonMountTargetReconciled, "Changed security groups of mount target for filesystem %s in subnet %s to %s", id, subnet, strings.Join(groups, ", "))
+	}
+
+	MountTargetDrift.WithLabelValues(id, driftSecurityGroups).Set(float64(modified))
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Helper function to return the security groups of a mount target, which are only described again when the
+// mount targets of the filesystem change or the cache is resynced.
+func (p *Provisioner) securityGroups(target *efs.MountTargetDescription) ([]string, error) {
+	id := aws.StringValue(target.MountTargetId)
+
+	if groups, ok := p.cache.SecurityGroups(id); ok {
+		return groups, nil
+	}
+
+	describe, err := p.client.DescribeMountTargetSecurityGroups(&efs.DescribeMountTargetSecurityGroupsInput{
+		MountTargetId: target.MountTargetId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := aws.StringValueSlice(describe.SecurityGroups)
+
+	p.cache.SetSecurityGroups(id, groups)
+
+	return groups, nil
+}
+
+// Helper function to record a MountTargetDrift event, unless the same drift was already reported by the last pass.
+func (p *Provisioner) drift(claim *corev1.ObjectReference, drifted map[string]bool, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	drifted[message] = true
+
+	if p.drifted[message] {
+		glog.V(2).Infof("%s: %s", EventReasonMountTargetDrift, message)
+		return
+	}
+
+	p.event(claim, corev1.EventTypeWarning, EventReasonMountTargetDrift, "%s", message)
+}
+
+// Helper function to reapply the configured policy to a filesystem, if it has drifted.
+func (p *Provisioner) reconcilePolicy(fs *efs.FileSystemDescription, claims *volumeClaims) error {
+	if p.policy == nil {
+		return nil
+	}
+
+	var (
+		id    = aws.StringValue(fs.FileSystemId)
+		claim = claimReference(fs)
+	)
+
+	pvc, _ := claimOf(fs, claims)
+
+	changed, err := p.putPolicy(fs, pvc)
+	if err != nil {
+		p.event(claim, corev1.EventTypeWarning, EventReasonPolicyFailed, "Failed to apply policy to filesystem %s: %s", id, efsclient.Message(err))
+		return err
+	}
+
+	if changed {
+		ReconcileActionsTotal.WithLabelValues(actionPutPolicy).Inc()
+		p.event(claim, corev1.EventTypeNormal, EventReasonPolicyReconciled, "Policy of filesystem %s had drifted and was reapplied", id)
+	}
+
+	return nil
+}
+
+// Helper function to update the tags of a filesystem when the configuration or its claim has changed.
+//
+// Tags are only updated while the claim still exists, so they aren't lost when a claim is deleted.
+func (p *Provisioner) reconcileTags(fs *efs.FileSystemDescription, claims *volumeClaims) error {
+	pvc, found := claimOf(fs, claims)
+	if !found {
+		return nil
+	}
+
+	required, err := requiredTags(fs, pvc, claims.StorageClass(pvc))
+	if err != nil {
+		return err
+	}
+
+	var (
+		id    = aws.StringValue(fs.FileSystemId)
+		claim = claimReference(fs)
+	)
+
+	desired, err := p.filesystemTags(pvc, required)
+	if err != nil {
+		p.event(claim, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Not updating tags of filesystem %s: %s", id, err)
+		return fmt.Errorf("failed to prepare tags: %s", err)
+	}
+
+	// Tags are described along with the filesystem, so most of the time there's nothing to do.
+	if tagsInSync(fs.Tags, desired) {
+		return nil
+	}
+
+	changed, err := syncTags(p.client, id, desired)
+	if err != nil {
+		p.event(claim, corev1.EventTypeWarning, EventReasonFilesystemFailed, "Failed to update tags of filesystem %s: %s", id, efsclient.Message(err))
+		return err
+	}
+
+	if changed {
+		ReconcileActionsTotal.WithLabelValues(actionSyncTags).Inc()
+		p.event(claim, corev1.EventTypeNormal, EventReasonTagsReconciled, "Updated tags of filesystem %s", id)
+	}
+
+	return nil
+}
+
+// Helper function to return the claim a filesystem was provisioned for, and whether it was found.
+//
+// The claim is looked up when claims were listed, otherwise only its namespace and name are known
+// (from the filesystem's tags).
+func claimOf(fs *efs.FileSystemDescription, claims *volumeClaims) (*corev1.PersistentVolumeClaim, bool) {
+	if claims != nil {
+		if pvc := claims.Filesystem(fs); pvc != nil {
+			return pvc, true
+		}
+	}
+
+	namespace, _ := tagValue(fs.Tags, TagKeyClaimNamespace)
+	name, _ := tagValue(fs.Tags, TagKeyClaimName)
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}, false
+}
+
+// Helper function to record an event on a claim, if the filesystem records which claim it belongs to.
+func (p *Provisioner) event(claim *corev1.ObjectReference, eventtype, reason, format string, args ...interface{}) {
+	if claim == nil {
+		glog.Infof("%s: %s", reason, fmt.Sprintf(format, args...))
+		return
+	}
+
+	p.recorder.Eventf(claim, eventtype, reason, format, args...)
+}
+
+// Helper function to return a reference to the claim a filesystem was provisioned for.
+func claimReference(fs *efs.FileSystemDescription) *corev1.ObjectReference {
+	namespace, ok := tagValue(fs.Tags, TagKeyClaimNamespace)
+	if !ok {
+		return nil
+	}
+
+	name, ok := tagValue(fs.Tags, TagKeyClaimName)
+	if !ok {
+		return nil
+	}
+
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Namespace:  namespace,
+		Name:       name,
+	}
+}
+
+// Helper function to compare two lists of strings, ignoring their order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,332 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to provision a filesystem with the test params, returning its ID.
+func testProvision(t *testing.T, client *mock.Client) string {
+	provisioner, err := New(client, testParams())
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	volume, err := provisioner.Provision(testOptions("namespace", "test"))
+	assert.Nil(t, err)
+
+	return volume.ObjectMeta.Name
+}
+
+// Helper function to return the subnets and security groups of a filesystem's mount targets.
+func testMountTargets(client *mock.Client, id string) map[string][]string {
+	targets := make(map[string][]string)
+
+	for _, target := range client.MountTargets(id) {
+		targets[target.SubnetID] = target.SecurityGroups
+	}
+
+	return targets
+}
+
+func TestReconcile(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	// A subnet has been replaced and the security group has changed.
+	params := testParams()
+	params.Subnets = []string{"subnet-yyyyyyyy", "subnet-zzzzzzzz"}
+	params.SecurityGroup = "sg-zzzzzzzzzzzz"
+	params.PruneMountTargets = true
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"subnet-yyyyyyyy": {"sg-zzzzzzzzzzzz"},
+		"subnet-zzzzzzzz": {"sg-zzzzzzzzzzzz"},
+	}, testMountTargets(client, id))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(MountTargetDrift.WithLabelValues(id, driftMissing)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(MountTargetDrift.WithLabelValues(id, driftExtra)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(MountTargetDrift.WithLabelValues(id, driftSecurityGroups)))
+
+	assert.Equal(t, []string{
+		"Normal MountTargetReconciled Created mount target for filesystem fs-00000001 in subnet subnet-zzzzzzzz",
+		"Normal MountTargetReconciled Deleted mount target for filesystem fs-00000001 in subnet subnet-xxxxxxxx",
+		"Normal MountTargetReconciled Changed security groups of mount target for filesystem fs-00000001 in subnet subnet-yyyyyyyy to sg-zzzzzzzzzzzz",
+	}, testEvents(recorder))
+
+	// Once everything has settled there is nothing left to change.
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	assert.Empty(t, testEvents(recorder))
+	assert.Equal(t, float64(0), testutil.ToFloat64(MountTargetDrift.WithLabelValues(id, driftMissing)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(MountTargetDrift.WithLabelValues(id, driftExtra)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(MountTargetDrift.WithLabelValues(id, driftSecurityGroups)))
+}
+
+func TestReconcileWithoutPrune(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	params := testParams()
+	params.Subnets = []string{"subnet-yyyyyyyy"}
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	// Mount targets in subnets which are no longer configured are reported, but kept.
+	assert.Len(t, testMountTargets(client, id), 2)
+	assert.Equal(t, 0, client.CallCount("DeleteMountTarget"))
+	assert.Equal(t, []string{
+		"Warning MountTargetDrift Filesystem fs-00000001 has a mount target in subnet subnet-xxxxxxxx which is no longer configured",
+	}, testEvents(recorder))
+
+	// The drift is only reported again once it has gone away and come back.
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Empty(t, testEvents(recorder))
+}
+
+func TestReconcileSkipsWatched(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	params := testParams()
+	params.Subnets = append(params.Subnets, "subnet-zzzzzzzz")
+
+	provisioner, err := New(client, params)
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	// Filesystems which are still being provisioned are left alone.
+	unwatch := provisioner.cache.Watch(id)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, client.CallCount("CreateMountTarget"))
+
+	unwatch()
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, client.CallCount("CreateMountTarget"))
+}
+
+func TestReconcileSkipsDeleted(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	params := testParams()
+	params.Subnets = append(params.Subnets, "subnet-zzzzzzzz")
+
+	// The volume of the filesystem has been released, so its mount targets may be being deleted.
+	kubernetes := fake.NewSimpleClientset(testReleasedVolume(id))
+
+	provisioner, err := New(client, params, WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, client.CallCount("CreateMountTarget"))
+
+	// Filesystems which are being drained from a warm pool are being deleted as well.
+	provisioner, err = New(client, params)
+	assert.Nil(t, err)
+
+	provisioner.draining[id] = true
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, client.CallCount("CreateMountTarget"))
+}
+
+func TestReconcileDescribesOnce(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	params := testParams()
+	params.ResyncInterval = time.Hour
+
+	pvc, volume := testBoundClaim("namespace", "test", id)
+	kubernetes := fake.NewSimpleClientset(pvc, volume)
+
+	now := time.Now()
+
+	provisioner, err := New(client, params, WithKubernetes(kubernetes), WithClock(func() time.Time { return now }))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = provisioner.Reconcile()
+		assert.Nil(t, err)
+	}
+
+	// Security groups are only described again once the cache is resynced.
+	assert.Equal(t, 2, client.CallCount("DescribeMountTargetSecurityGroups"))
+
+	now = now.Add(time.Hour)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, client.CallCount("DescribeMountTargetSecurityGroups"))
+
+	// Volumes and claims are listed once per pass, rather than fetched for every filesystem.
+	for _, action := range kubernetes.Actions() {
+		assert.NotEqual(t, "get", action.GetVerb(), "%s %s", action.GetVerb(), action.GetResource().Resource)
+	}
+}
+
+func TestReconcileZoneConflict(t *testing.T) {
+	client := mock.New(
+		mock.WithSubnet("subnet-xxxxxxxx", "ap-southeast-2a"),
+		mock.WithSubnet("subnet-yyyyyyyy", "ap-southeast-2b"),
+		mock.WithSubnet("subnet-zzzzzzzz", "ap-southeast-2a"),
+	)
+
+	id := testProvision(t, client)
+
+	// The replacement subnet is in the same availability zone as a mount target which is kept.
+	params := testParams()
+	params.Subnets = []string{"subnet-yyyyyyyy", "subnet-zzzzzzzz"}
+	params.SecurityGroup = "sg-zzzzzzzzzzzz"
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	// The conflict is reported as drift, and doesn't stop the security groups from being changed.
+	assert.Equal(t, map[string][]string{
+		"subnet-xxxxxxxx": {"sg-xxxxxxxxxxxx"},
+		"subnet-yyyyyyyy": {"sg-zzzzzzzzzzzz"},
+	}, testMountTargets(client, id))
+
+	assert.Equal(t, []string{
+		"Warning MountTargetDrift Filesystem fs-00000001 can't have a mount target in subnet subnet-zzzzzzzz: MountTargetConflict: File system 'fs-00000001' already has a mount target in availability zone ap-southeast-2a",
+		"Warning MountTargetDrift Filesystem fs-00000001 has a mount target in subnet subnet-xxxxxxxx which is no longer configured",
+		"Normal MountTargetReconciled Changed security groups of mount target for filesystem fs-00000001 in subnet subnet-yyyyyyyy to sg-zzzzzzzzzzzz",
+	}, testEvents(recorder))
+
+	// Subnets in availability zones which are known to have a mount target aren't tried again.
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	fs, ok := provisioner.cache.Filesystem(id)
+	assert.True(t, ok)
+
+	calls := client.CallCount("CreateMountTarget")
+
+	err = provisioner.reconcileMountTargets(fs, map[string]string{"subnet-zzzzzzzz": "ap-southeast-2a"}, make(map[string]bool))
+	assert.Nil(t, err)
+	assert.Equal(t, calls, client.CallCount("CreateMountTarget"))
+
+	// Drift which was reported by the last pass isn't reported again.
+	assert.Equal(t, []string{
+		"Warning MountTargetDrift Filesystem fs-00000001 can't have a mount target in subnet subnet-zzzzzzzz, availability zone ap-southeast-2a already has one in subnet subnet-xxxxxxxx",
+	}, testEvents(recorder))
+
+	// Once the old mount target is pruned, the next pass creates the replacement.
+	provisioner.params.PruneMountTargets = true
+
+	err = provisioner.reconcileMountTargets(fs, map[string]string{"subnet-zzzzzzzz": "ap-southeast-2a"}, make(map[string]bool))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"Normal MountTargetReconciled Deleted mount target for filesystem fs-00000001 in subnet subnet-xxxxxxxx",
+	}, testEvents(recorder))
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"subnet-yyyyyyyy": {"sg-zzzzzzzzzzzz"},
+		"subnet-zzzzzzzz": {"sg-zzzzzzzzzzzz"},
+	}, testMountTargets(client, id))
+}
+
+func TestReconcileContinuesAfterFailure(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	params := testParams()
+	params.Subnets = []string{"subnet-xxxxxxxx", "subnet-yyyyyyyy", "subnet-zzzzzzzz"}
+	params.SecurityGroup = "sg-zzzzzzzzzzzz"
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	client.FailNext("CreateMountTarget", awserr.New(efs.ErrCodeSubnetNotFound, "The subnet does not exist", nil))
+
+	err = provisioner.Reconcile()
+	assert.EqualError(t, err, "failed to reconcile 1 filesystems: fs-00000001: failed to create mount target in subnet subnet-zzzzzzzz: SubnetNotFound: The subnet does not exist")
+
+	// The security groups of the existing mount targets are still changed.
+	assert.Equal(t, map[string][]string{
+		"subnet-xxxxxxxx": {"sg-zzzzzzzzzzzz"},
+		"subnet-yyyyyyyy": {"sg-zzzzzzzzzzzz"},
+	}, testMountTargets(client, id))
+}
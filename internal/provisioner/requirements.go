@@ -0,0 +1,195 @@
+package provisioner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/efs"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+)
+
+const (
+	// ParameterRequiredLabelPrefix is the prefix of StorageClass parameters which require claims to have
+	// a label (or annotation), eg. requiredLabel.cost-centre: "[0-9]{4}". The value is a regular expression
+	// which must match the whole value, or empty to allow any value.
+	ParameterRequiredLabelPrefix = "requiredLabel."
+
+	// ParameterRequiredLabelsAction is the StorageClass parameter which decides what happens to claims
+	// which don't have the required labels.
+	ParameterRequiredLabelsAction = "requiredLabelsAction"
+
+	// RequiredLabelsActionPending keeps retrying claims until they have the required labels.
+	RequiredLabelsActionPending = "pending"
+
+	// RequiredLabelsActionReject gives up on claims until they are updated with the required labels.
+	RequiredLabelsActionReject = "reject"
+)
+
+// Annotation on a claim which records its StorageClass, for claims created before StorageClassName.
+const annStorageClass = "volume.beta.kubernetes.io/storage-class"
+
+// Requirement for a label on a claim, and the pattern its value must match (if any).
+type Requirement struct {
+	Key     string
+	Pattern *regexp.Regexp
+}
+
+// Requirements for the labels on claims, from the parameters of a StorageClass.
+type Requirements struct {
+	Labels []Requirement
+	Action string
+}
+
+// RequirementError is returned when a claim does not have the labels required by its StorageClass.
+type RequirementError struct {
+	Reasons []string
+}
+
+// Error describes the labels which are missing or invalid.
+func (e *RequirementError) Error() string {
+	return strings.Join(e.Reasons, ", ")
+}
+
+// ParseRequirements returns the label requirements in the parameters of a StorageClass.
+func ParseRequirements(class *storagev1.StorageClass) (*Requirements, error) {
+	requirements := &Requirements{
+		Action: RequiredLabelsActionPending,
+	}
+
+	if class == nil {
+		return requirements, nil
+	}
+
+	for key, value := range class.Parameters {
+		if key == ParameterRequiredLabelsAction {
+			switch value {
+			case RequiredLabelsActionPending, RequiredLabelsActionReject:
+				requirements.Action = value
+			default:
+				return nil, fmt.Errorf("unknown required labels action: %s", value)
+			}
+
+			continue
+		}
+
+		if !strings.HasPrefix(key, ParameterRequiredLabelPrefix) {
+			continue
+		}
+
+		label := strings.TrimPrefix(key, ParameterRequiredLabelPrefix)
+
+		if label == "" || reservedTagKey(label) {
+			return nil, fmt.Errorf("label %q can't be required", label)
+		}
+
+		requirement := Requirement{
+			Key: label,
+		}
+
+		if value != "" {
+			// Patterns must match the whole value, otherwise "[0-9]{4}" would allow "cc-12345".
+			pattern, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern for label %s: %s", label, err)
+			}
+
+			requirement.Pattern = pattern
+		}
+
+		requirements.Labels = append(requirements.Labels, requirement)
+	}
+
+	sort.Slice(requirements.Labels, func(i, j int) bool {
+		return requirements.Labels[i].Key < requirements.Labels[j].Key
+	})
+
+	return requirements, nil
+}
+
+// Check that a claim has the required labels, and return their values so they can be added as tags.
+func (r *Requirements) Check(pvc *corev1.PersistentVolumeClaim) (map[string]string, error) {
+	var (
+		tags    = make(map[string]string)
+		reasons []string
+	)
+
+	for _, requirement := range r.Labels {
+		value, ok := claimLabel(pvc, requirement.Key)
+		if !ok || value == "" {
+			reasons = append(reasons, fmt.Sprintf("label %s is required", requirement.Key))
+			continue
+		}
+
+		if requirement.Pattern != nil && !requirement.Pattern.MatchString(value) {
+			reasons = append(reasons, fmt.Sprintf("label %s=%s does not match %s", requirement.Key, value, requirement.Pattern))
+			continue
+		}
+
+		tags[requirement.Key] = value
+	}
+
+	if len(reasons) > 0 {
+		return tags, &RequirementError{Reasons: reasons}
+	}
+
+	return tags, nil
+}
+
+// Error to return when a claim doesn't meet the requirements, depending on the action.
+func (r *Requirements) Error(err error) error {
+	if r.Action == RequiredLabelsActionReject {
+		// The controller won't retry until the claim is updated or resynced.
+		return &controller.IgnoredError{Reason: err.Error()}
+	}
+
+	return err
+}
+
+// Helper function to return the value of a label on a claim, falling back to its annotations.
+func claimLabel(pvc *corev1.PersistentVolumeClaim, key string) (string, bool) {
+	if value, ok := pvc.ObjectMeta.Labels[key]; ok {
+		return value, true
+	}
+
+	value, ok := pvc.ObjectMeta.Annotations[key]
+
+	return value, ok
+}
+
+// Helper function to return the values of the required labels of an existing filesystem's claim.
+//
+// Values which are no longer valid keep the value they were tagged with, so filesystems don't
+// drop out of cost allocation reports when someone removes a label.
+func requiredTags(fs *efs.FileSystemDescription, pvc *corev1.PersistentVolumeClaim, class *storagev1.StorageClass) (map[string]string, error) {
+	requirements, err := ParseRequirements(class)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, _ := requirements.Check(pvc)
+
+	for _, requirement := range requirements.Labels {
+		if _, ok := tags[requirement.Key]; ok {
+			continue
+		}
+
+		if value, ok := tagValue(fs.Tags, requirement.Key); ok {
+			tags[requirement.Key] = value
+		}
+	}
+
+	return tags, nil
+}
+
+// Helper function to return the name of a claim's StorageClass, which older claims set with an annotation.
+func storageClassName(pvc *corev1.PersistentVolumeClaim) string {
+	if pvc.Spec.StorageClassName != nil {
+		return *pvc.Spec.StorageClassName
+	}
+
+	return pvc.ObjectMeta.Annotations[annStorageClass]
+}
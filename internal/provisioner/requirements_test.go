@@ -0,0 +1,204 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a StorageClass which requires cost allocation labels.
+func testRequiredLabelsClass(action string) *storagev1.StorageClass {
+	class := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "efs",
+		},
+		Parameters: map[string]string{
+			ParameterRequiredLabelPrefix + "cost-centre": "[0-9]{4}",
+			ParameterRequiredLabelPrefix + "owner":       "",
+		},
+	}
+
+	if action != "" {
+		class.Parameters[ParameterRequiredLabelsAction] = action
+	}
+
+	return class
+}
+
+func TestParseRequirements(t *testing.T) {
+	requirements, err := ParseRequirements(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, requirements.Labels)
+
+	requirements, err = ParseRequirements(testRequiredLabelsClass(RequiredLabelsActionReject))
+	assert.Nil(t, err)
+	assert.Equal(t, RequiredLabelsActionReject, requirements.Action)
+
+	if assert.Len(t, requirements.Labels, 2) {
+		assert.Equal(t, "cost-centre", requirements.Labels[0].Key)
+		assert.Equal(t, "owner", requirements.Labels[1].Key)
+	}
+
+	tests := map[string]string{
+		ParameterRequiredLabelPrefix + "cost-centre": "[0-9",
+		ParameterRequiredLabelPrefix + "Name":        "",
+		ParameterRequiredLabelPrefix:                 "",
+		ParameterRequiredLabelsAction:                "delete",
+	}
+
+	for key, value := range tests {
+		_, err := ParseRequirements(&storagev1.StorageClass{
+			Parameters: map[string]string{key: value},
+		})
+		assert.Error(t, err, key)
+	}
+}
+
+func TestRequirementsCheck(t *testing.T) {
+	requirements, err := ParseRequirements(testRequiredLabelsClass(""))
+	assert.Nil(t, err)
+
+	pvc := testClaim("namespace", "test", map[string]string{"owner": "platform"})
+	pvc.ObjectMeta.Labels = map[string]string{"cost-centre": "1234"}
+
+	tags, err := requirements.Check(pvc)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"cost-centre": "1234", "owner": "platform"}, tags)
+
+	// The pattern has to match the whole value.
+	pvc = testClaim("namespace", "test", map[string]string{"cost-centre": "cc-12345"})
+
+	_, err = requirements.Check(pvc)
+	assert.EqualError(t, err, "label cost-centre=cc-12345 does not match ^(?:[0-9]{4})$, label owner is required")
+}
+
+func TestProvisionRequiredLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		ignored bool
+	}{
+		{
+			name:   "pending",
+			action: "",
+		},
+		{
+			name:    "reject",
+			action:  RequiredLabelsActionReject,
+			ignored: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			client := mock.New()
+
+			recorder := record.NewFakeRecorder(100)
+
+			provisioner, err := New(client, testParams(), WithRecorder(recorder))
+			assert.Nil(t, err)
+
+			stop := make(chan struct{})
+			defer close(stop)
+
+			go provisioner.Run(stop)
+
+			options := testOptions("namespace", "test")
+			options.StorageClass = testRequiredLabelsClass(test.action)
+			options.PVC.ObjectMeta.Labels = map[string]string{"cost-centre": "1234"}
+
+			_, err = provisioner.Provision(options)
+			if test.ignored {
+				assert.IsType(t, &controller.IgnoredError{}, err)
+			} else {
+				assert.IsType(t, &RequirementError{}, err)
+			}
+
+			assert.Equal(t, []string{"Warning LabelsRequired Not provisioning a filesystem: label owner is required"}, testEvents(recorder))
+			assert.Equal(t, 0, client.CallCount("CreateFileSystem"))
+
+			// Once the claim has been labelled the values are added as tags.
+			options.PVC.ObjectMeta.Labels["owner"] = "platform"
+
+			volume, err := provisioner.Provision(options)
+			assert.Nil(t, err)
+
+			tags := testTags(client, volume.ObjectMeta.Name)
+			assert.Equal(t, "1234", tags["cost-centre"])
+			assert.Equal(t, "platform", tags["owner"])
+			assert.Equal(t, "cost-centre,owner", tags[TagKeyManagedTags])
+		})
+	}
+}
+
+func TestReconcileRequiredLabels(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	class := "efs"
+
+	pvc := testClaim("namespace", "test", nil)
+	pvc.ObjectMeta.Labels = map[string]string{"cost-centre": "1234", "owner": "platform"}
+	pvc.Spec.StorageClassName = &class
+
+	kubernetes := fake.NewSimpleClientset(testRequiredLabelsClass(""), pvc)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	tags := testTags(client, id)
+	assert.Equal(t, "1234", tags["cost-centre"])
+	assert.Equal(t, "platform", tags["owner"])
+
+	// Changed values are updated, while values which are no longer valid are kept.
+	pvc.ObjectMeta.Labels = map[string]string{"cost-centre": "5678", "owner": ""}
+
+	_, err = kubernetes.CoreV1().PersistentVolumeClaims("namespace").Update(pvc)
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	tags = testTags(client, id)
+	assert.Equal(t, "5678", tags["cost-centre"])
+	assert.Equal(t, "platform", tags["owner"])
+}
+
+func TestStorageClassOfClaim(t *testing.T) {
+	provisioner, err := New(mock.New(), testParams(), WithKubernetes(fake.NewSimpleClientset(testRequiredLabelsClass(""))))
+	assert.Nil(t, err)
+
+	claims, err := provisioner.listClaims()
+	assert.Nil(t, err)
+
+	// Older claims record their class in an annotation.
+	class := claims.StorageClass(testClaim("namespace", "test", map[string]string{annStorageClass: "efs"}))
+	assert.Equal(t, "efs", class.ObjectMeta.Name)
+
+	missing := "missing"
+
+	class = claims.StorageClass(&corev1.PersistentVolumeClaim{
+		Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &missing},
+	})
+	assert.Nil(t, class)
+}
@@ -0,0 +1,322 @@
+package provisioner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+
+	"github.com/previousnext/k8s-aws-efs/internal/cron"
+)
+
+const (
+	// ParameterThroughputSchedule is the StorageClass parameter which changes the throughput of filesystems on a schedule.
+	ParameterThroughputSchedule = "throughputSchedule"
+
+	// AnnotationThroughputSchedule is the annotation on a claim which changes the throughput of its filesystem on a
+	// schedule, instead of the schedule of its StorageClass.
+	AnnotationThroughputSchedule = "efs.aws.skpr.io/throughput-schedule"
+)
+
+const (
+	// AnnotationScheduleActive is the annotation on a volume which records the entry of its schedule which is active.
+	AnnotationScheduleActive = "efs.aws.skpr.io/throughput-schedule-active"
+
+	// AnnotationScheduleState is the annotation on a volume which records whether the active entry has been applied.
+	AnnotationScheduleState = "efs.aws.skpr.io/throughput-schedule-state"
+
+	// AnnotationScheduleNext is the annotation on a volume which records when the next entry of its schedule starts.
+	AnnotationScheduleNext = "efs.aws.skpr.io/throughput-schedule-next"
+
+	// AnnotationScheduleDeferred is the annotation on a volume which records when a deferred decrease can be applied.
+	AnnotationScheduleDeferred = "efs.aws.skpr.io/throughput-schedule-deferred-until"
+)
+
+const (
+	// ScheduleStateApplied is recorded when the filesystem has the throughput of the active entry.
+	ScheduleStateApplied = "applied"
+
+	// ScheduleStateDeferred is recorded when the active entry decreases throughput, which EFS doesn't allow yet.
+	ScheduleStateDeferred = "deferred"
+
+	// ScheduleStateFailed is recorded when the throughput of the active entry could not be applied.
+	ScheduleStateFailed = "failed"
+
+	// ScheduleStateInvalid is recorded when the schedule could not be parsed.
+	ScheduleStateInvalid = "invalid"
+
+	// ScheduleStatePending is recorded when none of the entries have started.
+	ScheduleStatePending = "pending"
+)
+
+// Annotations on volumes which are managed by the schedule.
+var scheduleAnnotations = []string{
+	AnnotationScheduleActive,
+	AnnotationScheduleState,
+	AnnotationScheduleNext,
+	AnnotationScheduleDeferred,
+}
+
+// ScheduleEntry sets the throughput of a filesystem from the times its cron expression matches.
+type ScheduleEntry struct {
+	Spec       string
+	Schedule   *cron.Schedule
+	Throughput Throughput
+}
+
+// String returns the entry as it was written.
+func (e ScheduleEntry) String() string {
+	return e.Spec
+}
+
+// ThroughputSchedule of entries which change the throughput of a filesystem.
+type ThroughputSchedule struct {
+	Entries []ScheduleEntry
+}
+
+// ParseThroughputSchedule parses a list of entries separated by semicolons or new lines. Each entry is a cron
+// expression followed by the throughput from then on, which is "bursting" or the provisioned throughput in MiB/s
+// eg. "0 8 * * 1-5 100; 0 18 * * 1-5 bursting".
+func ParseThroughputSchedule(value string) (*ThroughputSchedule, error) {
+	schedule := &ThroughputSchedule{}
+
+	for _, line := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("entry %q needs a cron expression and a throughput", line)
+		}
+
+		spec := strings.Join(fields[:len(fields)-1], " ")
+
+		parsed, err := cron.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q has an invalid cron expression: %s", line, err)
+		}
+
+		throughput, err := parseThroughput(fields[len(fields)-1])
+		if err != nil {
+			return nil, fmt.Errorf("entry %q has an invalid throughput: %s", line, err)
+		}
+
+		schedule.Entries = append(schedule.Entries, ScheduleEntry{
+			Spec:       strings.Join(fields, " "),
+			Schedule:   parsed,
+			Throughput: throughput,
+		})
+	}
+
+	if len(schedule.Entries) == 0 {
+		return nil, fmt.Errorf("schedule has no entries")
+	}
+
+	return schedule, nil
+}
+
+// Helper function to parse the throughput of a schedule entry.
+func parseThroughput(value string) (Throughput, error) {
+	if value == efs.ThroughputModeBursting {
+		return Throughput{Mode: efs.ThroughputModeBursting}, nil
+	}
+
+	mibps, err := strconv.ParseFloat(value, 64)
+	if err != nil || mibps < MinProvisionedThroughput || mibps > MaxProvisionedThroughput {
+		return Throughput{}, fmt.Errorf("expected %q or between %d and %d MiB/s but found %q", efs.ThroughputModeBursting, MinProvisionedThroughput, MaxProvisionedThroughput, value)
+	}
+
+	return Throughput{Mode: efs.ThroughputModeProvisioned, Provisioned: mibps}, nil
+}
+
+// Active returns the entry which started most recently, or false if none of them have started.
+func (s *ThroughputSchedule) Active(now time.Time) (ScheduleEntry, bool) {
+	var (
+		active  ScheduleEntry
+		started time.Time
+	)
+
+	for _, entry := range s.Entries {
+		// Later entries win when they start at the same time.
+		if prev := entry.Schedule.Prev(now); !prev.IsZero() && !prev.Before(started) {
+			active, started = entry, prev
+		}
+	}
+
+	return active, !started.IsZero()
+}
+
+// Next returns when the next entry starts, or the zero time if none of them will.
+func (s *ThroughputSchedule) Next(now time.Time) time.Time {
+	var next time.Time
+
+	for _, entry := range s.Entries {
+		if t := entry.Schedule.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+
+	return next
+}
+
+// Helper function to return the throughput schedule of a claim, from its annotation or its StorageClass.
+//
+// Returns nil if it doesn't have one.
+func throughputSchedule(pvc *corev1.PersistentVolumeClaim, class *storagev1.StorageClass) (*ThroughputSchedule, error) {
+	value, ok := pvc.ObjectMeta.Annotations[AnnotationThroughputSchedule]
+
+	if !ok && class != nil {
+		value, ok = class.Parameters[ParameterThroughputSchedule]
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	return ParseThroughputSchedule(value)
+}
+
+// ScheduleThroughput changes the throughput of filesystems whose claims (or StorageClasses) have a schedule,
+// and records the state of the schedule on their volumes.
+func (p *Provisioner) ScheduleThroughput() error {
+	if p.kubernetes == nil {
+		return nil
+	}
+
+	volumes, err := p.listVolumes()
+	if err != nil {
+		return err
+	}
+
+	claims, err := p.listClaims()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+
+	for _, volume := range volumes {
+		if volume.Status.Phase != corev1.VolumeBound {
+			continue
+		}
+
+		pvc := claims.Claim(volume)
+		if pvc == nil {
+			continue
+		}
+
+		err := p.scheduleVolume(volume, pvc, claims.StorageClass(pvc))
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", volume.ObjectMeta.Name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to schedule throughput of %d volumes: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Helper function to apply the active entry of a claim's schedule to its filesystem.
+func (p *Provisioner) scheduleVolume(volume *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim, class *storagev1.StorageClass) error {
+	var (
+		id       = filesystemID(volume)
+		previous = volume.ObjectMeta.Annotations[AnnotationScheduleState]
+		state    = make(map[string]string)
+	)
+
+	schedule, err := throughputSchedule(pvc, class)
+	if err != nil {
+		state[AnnotationScheduleState] = ScheduleStateInvalid
+
+		if previous != ScheduleStateInvalid {
+			p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonThroughputScheduleInvalid, "Throughput schedule is invalid: %s", err)
+		}
+
+		return p.setScheduleState(volume, state, err)
+	}
+
+	// Clean up after schedules which were removed.
+	if schedule == nil {
+		return p.setScheduleState(volume, state, nil)
+	}
+
+	now := p.now().In(p.location)
+
+	if next := schedule.Next(now); !next.IsZero() {
+		state[AnnotationScheduleNext] = next.Format(time.RFC3339)
+	}
+
+	entry, ok := schedule.Active(now)
+	if !ok {
+		state[AnnotationScheduleState] = ScheduleStatePending
+		return p.setScheduleState(volume, state, nil)
+	}
+
+	state[AnnotationScheduleActive] = entry.String()
+
+	fs, ok := p.cache.Filesystem(id)
+	if !ok || aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateAvailable {
+		state[AnnotationScheduleState] = ScheduleStatePending
+		return p.setScheduleState(volume, state, nil)
+	}
+
+	changed, err := p.updateThroughput(fs, entry.Throughput)
+	if deferred, ok := err.(*ThroughputDeferredError); ok {
+		state[AnnotationScheduleState] = ScheduleStateDeferred
+
+		if !deferred.Until.IsZero() {
+			state[AnnotationScheduleDeferred] = deferred.Until.Format(time.RFC3339)
+		}
+
+		if previous != ScheduleStateDeferred {
+			glog.Infof("Deferred throughput of filesystem %s for schedule %q: %s", id, entry, err)
+
+			p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonThroughputDeferred, "Throughput of filesystem %s will be changed to %s for schedule %q once EFS allows it: %s", id, entry.Throughput, entry, err)
+		}
+
+		return p.setScheduleState(volume, state, nil)
+	}
+	if err != nil {
+		state[AnnotationScheduleState] = ScheduleStateFailed
+
+		if previous != ScheduleStateFailed {
+			p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonThroughputFailed, "Failed to change throughput of filesystem %s to %s for schedule %q: %s", id, entry.Throughput, entry, err)
+		}
+
+		return p.setScheduleState(volume, state, fmt.Errorf("failed to update throughput: %s", err))
+	}
+
+	state[AnnotationScheduleState] = ScheduleStateApplied
+
+	if changed {
+		glog.Infof("Changed throughput of filesystem %s from %s to %s for schedule %q", id, currentThroughput(fs), entry.Throughput, entry)
+
+		ReconcileActionsTotal.WithLabelValues(actionUpdateThroughput).Inc()
+
+		p.recorder.Eventf(pvc, corev1.EventTypeNormal, EventReasonThroughputUpdated, "Changed throughput of filesystem %s from %s to %s for schedule %q", id, currentThroughput(fs), entry.Throughput, entry)
+	}
+
+	return p.setScheduleState(volume, state, nil)
+}
+
+// Helper function to record the state of a schedule on a volume, returning the error which led to it.
+func (p *Provisioner) setScheduleState(volume *corev1.PersistentVolume, state map[string]string, cause error) error {
+	if setMetadata(&volume.ObjectMeta.Annotations, scheduleAnnotations, state) {
+		_, err := p.kubernetes.CoreV1().PersistentVolumes().Update(volume)
+		if err != nil {
+			return fmt.Errorf("failed to update volume: %s", err)
+		}
+	}
+
+	return cause
+}
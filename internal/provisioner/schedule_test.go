@@ -0,0 +1,189 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+func TestParseThroughputSchedule(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                    "schedule has no entries",
+		"100":                 `entry "100" needs a cron expression and a throughput`,
+		"0 8 * * 100":         `entry "0 8 * * 100" has an invalid cron expression: expected 5 fields but found 4: "0 8 * *"`,
+		"0 8 * * * fast":      `entry "0 8 * * * fast" has an invalid throughput: expected "bursting" or between 1 and 1024 MiB/s but found "fast"`,
+		"0 8 * * * 2048":      `entry "0 8 * * * 2048" has an invalid throughput: expected "bursting" or between 1 and 1024 MiB/s but found "2048"`,
+		"@daily bursting":     "",
+		"0 8 * * 1-5   100 ;": "",
+	} {
+		_, err := ParseThroughputSchedule(value)
+		if want == "" {
+			assert.Nil(t, err, value)
+		} else {
+			assert.EqualError(t, err, want, value)
+		}
+	}
+}
+
+func TestThroughputScheduleActive(t *testing.T) {
+	schedule, err := ParseThroughputSchedule("0 8 * * 1-5 100\n0 18 * * 1-5 bursting")
+	assert.Nil(t, err)
+
+	// Monday morning.
+	now := time.Date(2020, time.January, 6, 10, 30, 0, 0, time.UTC)
+
+	entry, ok := schedule.Active(now)
+	assert.True(t, ok)
+	assert.Equal(t, "0 8 * * 1-5 100", entry.String())
+	assert.Equal(t, Throughput{Mode: efs.ThroughputModeProvisioned, Provisioned: 100}, entry.Throughput)
+	assert.Equal(t, time.Date(2020, time.January, 6, 18, 0, 0, 0, time.UTC), schedule.Next(now))
+
+	// Sunday, which is still in Friday evening's entry.
+	now = time.Date(2020, time.January, 5, 10, 30, 0, 0, time.UTC)
+
+	entry, ok = schedule.Active(now)
+	assert.True(t, ok)
+	assert.Equal(t, "0 18 * * 1-5 bursting", entry.String())
+	assert.Equal(t, time.Date(2020, time.January, 6, 8, 0, 0, 0, time.UTC), schedule.Next(now))
+}
+
+func TestScheduleThroughput(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+
+	kubernetes := fake.NewSimpleClientset(volume, testClaim("namespace", "test", map[string]string{
+		AnnotationThroughputSchedule: "0 8 * * * 100; 0 18 * * * bursting",
+	}))
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder), WithClock(client.Clock().Now))
+	assert.Nil(t, err)
+
+	// Helper function to run the schedule and return the state recorded on the volume.
+	schedule := func() map[string]string {
+		err := provisioner.cache.Refresh()
+		assert.Nil(t, err)
+
+		err = provisioner.ScheduleThroughput()
+		assert.Nil(t, err)
+
+		updated, err := kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+		assert.Nil(t, err)
+
+		state := make(map[string]string)
+
+		for _, key := range scheduleAnnotations {
+			if value, ok := updated.ObjectMeta.Annotations[key]; ok {
+				state[key] = value
+			}
+		}
+
+		return state
+	}
+
+	// Midnight is still in the evening's entry, which the filesystem already has.
+	assert.Equal(t, map[string]string{
+		AnnotationScheduleActive: "0 18 * * * bursting",
+		AnnotationScheduleState:  ScheduleStateApplied,
+		AnnotationScheduleNext:   "2020-01-01T08:00:00Z",
+	}, schedule())
+	assert.Empty(t, testEvents(recorder))
+
+	client.Advance(8 * time.Hour)
+
+	assert.Equal(t, map[string]string{
+		AnnotationScheduleActive: "0 8 * * * 100",
+		AnnotationScheduleState:  ScheduleStateApplied,
+		AnnotationScheduleNext:   "2020-01-01T18:00:00Z",
+	}, schedule())
+	assert.Equal(t, []string{
+		`Normal ThroughputUpdated Changed throughput of filesystem fs-00000001 from bursting to provisioned (100 MiB/s) for schedule "0 8 * * * 100"`,
+	}, testEvents(recorder))
+
+	fs, _ := client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeProvisioned, fs.ThroughputMode)
+	assert.Equal(t, float64(100), fs.ProvisionedThroughput)
+
+	// Changing the throughput mode in the morning means it can't be changed back in the evening.
+	client.Advance(10 * time.Hour)
+
+	assert.Equal(t, map[string]string{
+		AnnotationScheduleActive:   "0 18 * * * bursting",
+		AnnotationScheduleState:    ScheduleStateDeferred,
+		AnnotationScheduleNext:     "2020-01-02T08:00:00Z",
+		AnnotationScheduleDeferred: "2020-01-02T08:00:00Z",
+	}, schedule())
+	assert.Equal(t, []string{
+		`Normal ThroughputDeferred Throughput of filesystem fs-00000001 will be changed to bursting for schedule "0 18 * * * bursting" once EFS allows it: throughput can't be decreased or switched between modes until 2020-01-02T08:00:00Z`,
+	}, testEvents(recorder))
+
+	// The event isn't repeated while it is deferred.
+	client.Advance(time.Hour)
+
+	assert.Equal(t, ScheduleStateDeferred, schedule()[AnnotationScheduleState])
+	assert.Empty(t, testEvents(recorder))
+
+	fs, _ = client.FileSystem(id)
+	assert.Equal(t, efs.ThroughputModeProvisioned, fs.ThroughputMode)
+
+	// Removing the schedule removes its state.
+	pvc, err := kubernetes.CoreV1().PersistentVolumeClaims("namespace").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	delete(pvc.ObjectMeta.Annotations, AnnotationThroughputSchedule)
+
+	_, err = kubernetes.CoreV1().PersistentVolumeClaims("namespace").Update(pvc)
+	assert.Nil(t, err)
+
+	assert.Empty(t, schedule())
+}
+
+func TestScheduleThroughputInvalid(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+
+	kubernetes := fake.NewSimpleClientset(volume, testClaim("namespace", "test", map[string]string{
+		AnnotationThroughputSchedule: "0 8 * * * fast",
+	}))
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.ScheduleThroughput()
+	assert.EqualError(t, err, `failed to schedule throughput of 1 volumes: fs-00000001: entry "0 8 * * * fast" has an invalid throughput: expected "bursting" or between 1 and 1024 MiB/s but found "fast"`)
+
+	assert.Equal(t, []string{
+		`Warning ThroughputScheduleInvalid Throughput schedule is invalid: entry "0 8 * * * fast" has an invalid throughput: expected "bursting" or between 1 and 1024 MiB/s but found "fast"`,
+	}, testEvents(recorder))
+
+	updated, err := kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, ScheduleStateInvalid, updated.ObjectMeta.Annotations[AnnotationScheduleState])
+}
+
+func TestNewScheduleTimezone(t *testing.T) {
+	params := testParams()
+	params.ScheduleTimezone = "Mars/Olympus_Mons"
+
+	_, err := New(mock.New(), params)
+	assert.EqualError(t, err, "failed to load schedule timezone: unknown time zone Mars/Olympus_Mons")
+}
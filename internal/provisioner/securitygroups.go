@@ -0,0 +1,324 @@
+package provisioner
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
+)
+
+const (
+	// SecurityGroupModeStatic applies the configured security groups to every mount target.
+	SecurityGroupModeStatic = "static"
+
+	// SecurityGroupModeFilesystem creates a security group for each filesystem.
+	SecurityGroupModeFilesystem = "filesystem"
+
+	// SecurityGroupModeNamespace creates a security group for each namespace, which is
+	// shared by all of the filesystems provisioned for claims in that namespace.
+	SecurityGroupModeNamespace = "namespace"
+)
+
+// Port which NFS clients connect to mount targets on.
+const nfsPort = 2049
+
+// Helper function to return the security groups which are applied to every mount target,
+// including any which were discovered.
+func (p *Provisioner) staticSecurityGroups() []string {
+	var (
+		groups     []string
+		configured = append([]string{p.params.SecurityGroup}, p.params.SecurityGroups...)
+	)
+
+	for _, group := range append(configured, p.discoveredSecurityGroups()...) {
+		if group != "" && !containsString(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// Helper function to return the security groups for the mount targets of a filesystem,
+// creating a managed security group if required.
+//
+// Mount targets with a managed security group only have that group, so access isn't widened
+// by the static security groups.
+func (p *Provisioner) mountTargetSecurityGroups(id, namespace string) ([]string, error) {
+	scope, ok := p.securityGroupScope(id, namespace)
+	if !ok {
+		return p.staticSecurityGroups(), nil
+	}
+
+	group, err := p.putSecurityGroup(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{group}, nil
+}
+
+// Helper function to return the scope of the managed security group for a filesystem, if
+// security groups are being managed.
+func (p *Provisioner) securityGroupScope(id, namespace string) (string, bool) {
+	switch p.params.SecurityGroupMode {
+	case SecurityGroupModeFilesystem:
+		return fmt.Sprintf("filesystem-%s", id), true
+	case SecurityGroupModeNamespace:
+		if namespace == "" {
+			return "", false
+		}
+
+		return fmt.Sprintf("namespace-%s", namespace), true
+	}
+
+	return "", false
+}
+
+// Helper function to find or create the managed security group for a scope, which allows
+// NFS traffic from the cluster's nodes.
+//
+// This is safe to call multiple times for the same scope. Groups which are ready are remembered until
+// the next resync, so reconciling doesn't look them up for every filesystem.
+func (p *Provisioner) putSecurityGroup(scope string) (string, error) {
+	if id, ok := p.managedSecurityGroup(scope); ok {
+		return id, nil
+	}
+
+	vpc, err := p.vpcID()
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("efs-%s", scope)
+
+	group, err := p.findSecurityGroup(vpc, name)
+	if err != nil {
+		return "", err
+	}
+
+	if group == nil {
+		glog.Infof("Creating security group %s in VPC %s", name, vpc)
+
+		_, err := p.ec2.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+			GroupName:   aws.String(name),
+			Description: aws.String(fmt.Sprintf("NFS access to EFS filesystems provisioned by %s (%s)", p.name, scope)),
+			VpcId:       aws.String(vpc),
+		})
+		if err != nil && !efsclient.IsCode(err, "InvalidGroup.Duplicate") {
+			return "", fmt.Errorf("failed to create security group %s: %s", name, err)
+		}
+
+		group, err = p.findSecurityGroup(vpc, name)
+		if err != nil {
+			return "", err
+		}
+
+		if group == nil {
+			return "", fmt.Errorf("security group %s was created but could not be found", name)
+		}
+	}
+
+	id := aws.StringValue(group.GroupId)
+
+	// Groups are tagged once they have been created, or by a later attempt if tagging them failed.
+	if owner, _ := ec2TagValue(group.Tags, TagKeyOwner); owner != p.name {
+		_, err = p.ec2.CreateTags(&ec2.CreateTagsInput{
+			Resources: []*string{group.GroupId},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("Name"), Value: aws.String(name)},
+				{Key: aws.String(TagKeyOwner), Value: aws.String(p.name)},
+				{Key: aws.String(TagKeySecurityGroupScope), Value: aws.String(scope)},
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to tag security group %s: %s", id, err)
+		}
+	}
+
+	// The rule is checked in case a previous attempt failed after creating the group.
+	if hasNFSIngress(group, p.params.NodeSecurityGroup) {
+		p.rememberSecurityGroup(scope, id)
+		return id, nil
+	}
+
+	_, err = p.ec2.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: group.GroupId,
+		IpPermissions: []*ec2.IpPermission{
+			{
+				IpProtocol: aws.String("tcp"),
+				FromPort:   aws.Int64(nfsPort),
+				ToPort:     aws.Int64(nfsPort),
+				UserIdGroupPairs: []*ec2.UserIdGroupPair{
+					{
+						GroupId:     aws.String(p.params.NodeSecurityGroup),
+						Description: aws.String("NFS from cluster nodes"),
+					},
+				},
+			},
+		},
+	})
+	if err != nil && !efsclient.IsCode(err, "InvalidPermission.Duplicate") {
+		return "", fmt.Errorf("failed to allow NFS to security group %s: %s", id, err)
+	}
+
+	p.rememberSecurityGroup(scope, id)
+
+	return id, nil
+}
+
+// Helper function to return the managed security group of a scope, if it is known to be ready.
+func (p *Provisioner) managedSecurityGroup(scope string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id, ok := p.managedGroups[scope]
+
+	return id, ok
+}
+
+// Helper function to remember the managed security group of a scope once it is ready, or forget it
+// when the ID is empty.
+func (p *Provisioner) rememberSecurityGroup(scope, id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id == "" {
+		delete(p.managedGroups, scope)
+		return
+	}
+
+	p.managedGroups[scope] = id
+}
+
+// Helper function to forget every managed security group, so they are looked up again.
+func (p *Provisioner) forgetSecurityGroups() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.managedGroups = make(map[string]string)
+}
+
+// Helper function to delete the managed security group for a scope, if it exists.
+func (p *Provisioner) deleteSecurityGroup(scope string) error {
+	p.rememberSecurityGroup(scope, "")
+
+	vpc, err := p.vpcID()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("efs-%s", scope)
+
+	group, err := p.findSecurityGroup(vpc, name)
+	if err != nil {
+		return err
+	}
+
+	if group == nil {
+		return nil
+	}
+
+	glog.Infof("Deleting security group %s (%s)", name, aws.StringValue(group.GroupId))
+
+	_, err = p.ec2.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+		GroupId: group.GroupId,
+	})
+
+	return err
+}
+
+// Helper function to lookup a security group by name.
+func (p *Provisioner) findSecurityGroup(vpc, name string) (*ec2.SecurityGroup, error) {
+	describe, err := p.ec2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{vpc})},
+			{Name: aws.String("group-name"), Values: aws.StringSlice([]string{name})},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe security group %s: %s", name, err)
+	}
+
+	if len(describe.SecurityGroups) == 0 {
+		return nil, nil
+	}
+
+	return describe.SecurityGroups[0], nil
+}
+
+// Helper function to lookup the VPC which the configured subnets belong to.
+func (p *Provisioner) vpcID() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Discovery also sets the VPC.
+	if p.vpc != "" {
+		return p.vpc, nil
+	}
+
+	if len(p.params.Subnets) == 0 {
+		return "", fmt.Errorf("no subnets are configured")
+	}
+
+	describe, err := p.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(p.params.Subnets[:1]),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe subnet %s: %s", p.params.Subnets[0], err)
+	}
+
+	if len(describe.Subnets) == 0 {
+		return "", fmt.Errorf("subnet %s does not exist", p.params.Subnets[0])
+	}
+
+	p.vpc = aws.StringValue(describe.Subnets[0].VpcId)
+
+	return p.vpc, nil
+}
+
+// Helper function to check if a security group allows NFS from another security group.
+func hasNFSIngress(group *ec2.SecurityGroup, source string) bool {
+	for _, permission := range group.IpPermissions {
+		if aws.StringValue(permission.IpProtocol) != "tcp" {
+			continue
+		}
+
+		if aws.Int64Value(permission.FromPort) > nfsPort || aws.Int64Value(permission.ToPort) < nfsPort {
+			continue
+		}
+
+		for _, pair := range permission.UserIdGroupPairs {
+			if aws.StringValue(pair.GroupId) == source {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Helper function to return the value of an EC2 tag.
+func ec2TagValue(tags []*ec2.Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true
+		}
+	}
+
+	return "", false
+}
+
+// Helper function to check if a list contains a string.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,292 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a fake EC2 API which knows about the test subnets.
+func testEC2(client *mock.Client) *mock.EC2 {
+	return mock.NewEC2(
+		mock.WithEFS(client),
+		mock.WithEC2Subnet("subnet-xxxxxxxx", "vpc-xxxxxxxx", "ap-southeast-2a"),
+		mock.WithEC2Subnet("subnet-yyyyyyyy", "vpc-xxxxxxxx", "ap-southeast-2b"),
+	)
+}
+
+// Helper function to return params for managing security groups.
+func testManagedParams(mode string) Params {
+	params := testParams()
+	params.SecurityGroupMode = mode
+	params.NodeSecurityGroup = "sg-nodes"
+
+	return params
+}
+
+// Helper function to provision a volume and bind it to the claim.
+func testProvisionVolume(t *testing.T, provisioner *Provisioner, namespace, name string) *corev1.PersistentVolume {
+	options := testOptions(namespace, name)
+
+	volume, err := provisioner.Provision(options)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	volume.Spec.ClaimRef = &corev1.ObjectReference{
+		Namespace: namespace,
+		Name:      name,
+	}
+
+	return volume
+}
+
+// Helper function to delete a filesystem and its mount targets, as an administrator would.
+func testDeleteFilesystem(t *testing.T, client *mock.Client, id string) {
+	for _, target := range client.MountTargets(id) {
+		_, err := client.DeleteMountTarget(&efs.DeleteMountTargetInput{
+			MountTargetId: aws.String(target.ID),
+		})
+		assert.Nil(t, err)
+	}
+
+	_, err := client.DeleteFileSystem(&efs.DeleteFileSystemInput{
+		FileSystemId: aws.String(id),
+	})
+	assert.Nil(t, err)
+}
+
+func TestNewSecurityGroups(t *testing.T) {
+	params := testParams()
+	params.SecurityGroup = ""
+
+	_, err := New(mock.New(), params)
+	assert.EqualError(t, err, "a security group is required unless security groups are managed")
+
+	params.SecurityGroups = []string{"sg-1", "sg-2"}
+
+	provisioner, err := New(mock.New(), params)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sg-1", "sg-2"}, provisioner.staticSecurityGroups())
+
+	_, err = New(mock.New(), testManagedParams(SecurityGroupModeFilesystem))
+	assert.EqualError(t, err, "an EC2 client is required to manage security groups")
+
+	params = testManagedParams(SecurityGroupModeNamespace)
+	params.NodeSecurityGroup = ""
+
+	_, err = New(mock.New(), params, WithEC2(mock.NewEC2()))
+	assert.EqualError(t, err, "the node security group is required to manage security groups")
+
+	_, err = New(mock.New(), testManagedParams("cluster"), WithEC2(mock.NewEC2()))
+	assert.EqualError(t, err, "unknown security group mode: cluster")
+}
+
+func TestDelete(t *testing.T) {
+	client := mock.New()
+
+	provisioner, err := New(client, testParams())
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	volume := testProvisionVolume(t, provisioner, "namespace", "test")
+
+	// Filesystems are retained.
+	err = provisioner.Delete(volume)
+	assert.Nil(t, err)
+	assert.Len(t, client.MountTargets("fs-00000001"), 2)
+	assert.Equal(t, 0, client.CallCount("DeleteFileSystem"))
+
+	_, ok := client.FileSystem("fs-00000001")
+	assert.True(t, ok)
+}
+
+func TestDeleteFilesystems(t *testing.T) {
+	params := mock.DefaultParams()
+	params.MountTargetDeleteDelay = time.Minute
+
+	client := mock.New(mock.WithParams(params))
+	cloud := testEC2(client)
+	recorder := record.NewFakeRecorder(100)
+
+	provisionerParams := testManagedParams(SecurityGroupModeFilesystem)
+	provisionerParams.DeleteFilesystems = true
+
+	provisioner, err := New(client, provisionerParams, WithEC2(cloud), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	volume := testProvisionVolume(t, provisioner, "namespace", "test")
+	testEvents(recorder)
+
+	// Deletion is retried until the mount targets are gone, rather than waiting for them.
+	err = provisioner.Delete(volume)
+	assert.EqualError(t, err, "waiting for the mount targets of filesystem fs-00000001 to be deleted")
+	assert.Equal(t, 2, client.CallCount("DeleteMountTarget"))
+	assert.Len(t, cloud.SecurityGroups(), 1)
+
+	err = provisioner.Delete(volume)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, client.CallCount("DeleteMountTarget"))
+
+	client.Advance(time.Minute)
+
+	err = provisioner.Delete(volume)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Normal FilesystemDeleted Deleted filesystem fs-00000001"}, testEvents(recorder))
+	assert.Empty(t, cloud.SecurityGroups())
+
+	// Deleting a volume again is safe.
+	err = provisioner.Delete(volume)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, client.CallCount("DeleteFileSystem"))
+}
+
+func TestDeleteNotOwned(t *testing.T) {
+	client := mock.New()
+
+	fs, err := client.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken: aws.String("other"),
+	})
+	assert.Nil(t, err)
+
+	cloud := testEC2(client)
+
+	provisioner, err := New(client, testManagedParams(SecurityGroupModeFilesystem), WithEC2(cloud))
+	assert.Nil(t, err)
+
+	err = provisioner.Delete(&corev1.PersistentVolume{
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{
+					Server: *fs.FileSystemId + ".efs.ap-southeast-2.amazonaws.com",
+				},
+			},
+		},
+	})
+	assert.IsType(t, &controller.IgnoredError{}, err)
+	assert.Equal(t, 0, cloud.CallCount("DeleteSecurityGroup"))
+}
+
+func TestSecurityGroupModeFilesystem(t *testing.T) {
+	client := mock.New()
+	cloud := testEC2(client)
+
+	provisioner, err := New(client, testManagedParams(SecurityGroupModeFilesystem), WithEC2(cloud))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	volume := testProvisionVolume(t, provisioner, "namespace", "test")
+
+	groups := cloud.SecurityGroups()
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "efs-filesystem-fs-00000001", groups[0].Name)
+		assert.Equal(t, "vpc-xxxxxxxx", groups[0].VpcID)
+
+		if assert.Len(t, groups[0].Ingress, 1) {
+			assert.Equal(t, int64(2049), *groups[0].Ingress[0].FromPort)
+			assert.Equal(t, "sg-nodes", *groups[0].Ingress[0].UserIdGroupPairs[0].GroupId)
+		}
+	}
+
+	// Only the managed group is attached, not the static security groups as well.
+	for _, target := range client.MountTargets("fs-00000001") {
+		assert.Equal(t, []string{groups[0].ID}, target.SecurityGroups)
+	}
+
+	// Preparing the security group again doesn't change anything, or look it up again until the next resync.
+	calls := cloud.CallCount("DescribeSecurityGroups")
+
+	_, err = provisioner.putSecurityGroup("filesystem-fs-00000001")
+	assert.Nil(t, err)
+	assert.Equal(t, calls, cloud.CallCount("DescribeSecurityGroups"))
+
+	provisioner.forgetSecurityGroups()
+
+	_, err = provisioner.putSecurityGroup("filesystem-fs-00000001")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cloud.CallCount("CreateSecurityGroup"))
+	assert.Equal(t, 1, cloud.CallCount("CreateTags"))
+	assert.Equal(t, 1, cloud.CallCount("AuthorizeSecurityGroupIngress"))
+
+	// The group is kept while the filesystem is retained.
+	err = provisioner.Delete(volume)
+	assert.Nil(t, err)
+	assert.Len(t, cloud.SecurityGroups(), 1)
+
+	testDeleteFilesystem(t, client, "fs-00000001")
+
+	err = provisioner.Delete(volume)
+	assert.Nil(t, err)
+	assert.Empty(t, cloud.SecurityGroups())
+
+	// Deleting a volume again is safe.
+	err = provisioner.Delete(volume)
+	assert.Nil(t, err)
+}
+
+func TestSecurityGroupModeNamespace(t *testing.T) {
+	client := mock.New()
+	cloud := testEC2(client)
+
+	provisioner, err := New(client, testManagedParams(SecurityGroupModeNamespace), WithEC2(cloud))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	first := testProvisionVolume(t, provisioner, "namespace", "first")
+	second := testProvisionVolume(t, provisioner, "namespace", "second")
+	testProvisionVolume(t, provisioner, "other", "test")
+
+	groups := cloud.SecurityGroups()
+	if assert.Len(t, groups, 2) {
+		assert.Equal(t, "efs-namespace-namespace", groups[0].Name)
+		assert.Equal(t, "efs-namespace-other", groups[1].Name)
+	}
+
+	// The group is kept while other filesystems in the namespace use it.
+	testDeleteFilesystem(t, client, filesystemID(first))
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Delete(first)
+	assert.Nil(t, err)
+	assert.Len(t, cloud.SecurityGroups(), 2)
+
+	testDeleteFilesystem(t, client, filesystemID(second))
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Delete(second)
+	assert.Nil(t, err)
+
+	groups = cloud.SecurityGroups()
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "efs-namespace-other", groups[0].Name)
+	}
+}
@@ -0,0 +1,244 @@
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/aws/aws-sdk-go/service/efs/efsiface"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnotationTagPrefix is the prefix of annotations on a claim which are added as tags
+// to its filesystem eg. tag.efs.aws.skpr.io/cost-centre: engineering
+const AnnotationTagPrefix = "tag.efs.aws.skpr.io/"
+
+// Number of tags the provisioner may add to a filesystem of its own accord: Name, the owner, the claim's namespace
+// and name, the managed tags, the warm pool and the tags left by the autoscaler and throughput decreases.
+const provisionerTagCount = 8
+
+// Helper function to check if a tag key is reserved for the provisioner or AWS.
+func reservedTagKey(key string) bool {
+	return key == "Name" || strings.HasPrefix(key, "efs.aws.skpr.io/") || strings.HasPrefix(key, "aws:")
+}
+
+// Helper function to check tags against the limits of EFS, leaving room for the tags which the provisioner adds.
+func validateTags(tags map[string]string) error {
+	if len(tags) > MaxTags-provisionerTagCount {
+		return fmt.Errorf("%d tags were requested but at most %d can be added to a filesystem", len(tags), MaxTags-provisionerTagCount)
+	}
+
+	var keys []string
+
+	for key := range tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if utf8.RuneCountInString(key) > MaxTagKeyLength {
+			return fmt.Errorf("tag %s is longer than %d characters", key, MaxTagKeyLength)
+		}
+
+		if utf8.RuneCountInString(tags[key]) > MaxTagValueLength {
+			return fmt.Errorf("the value of tag %s is longer than %d characters", key, MaxTagValueLength)
+		}
+	}
+
+	return nil
+}
+
+// Helper function to return the tags for a filesystem provisioned for a claim, from the
+// configuration, the claim's annotations and the values of the labels required by its StorageClass.
+//
+// The keys of these tags are recorded in a tag of their own, so tags which are no
+// longer wanted can be removed without touching tags which were added by someone else.
+func (p *Provisioner) filesystemTags(pvc *corev1.PersistentVolumeClaim, required map[string]string) (map[string]string, error) {
+	tags := make(map[string]string)
+
+	for key, value := range p.params.Tags {
+		tags[key] = value
+	}
+
+	for key, value := range pvc.ObjectMeta.Annotations {
+		if !strings.HasPrefix(key, AnnotationTagPrefix) {
+			continue
+		}
+
+		key = strings.TrimPrefix(key, AnnotationTagPrefix)
+
+		if reservedTagKey(key) {
+			glog.Warningf("Ignoring reserved tag %s on claim %s/%s", key, pvc.ObjectMeta.Namespace, pvc.ObjectMeta.Name)
+			continue
+		}
+
+		tags[key] = value
+	}
+
+	for key, value := range required {
+		tags[key] = value
+	}
+
+	// Tags are checked up front, so a claim asking for too many tags doesn't leave a filesystem behind.
+	err := validateTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+
+	for key := range tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	managed := strings.Join(keys, ",")
+	if len(managed) > MaxTagValueLength {
+		return nil, fmt.Errorf("the keys of %d tags are too long to keep track of", len(keys))
+	}
+
+	tags[TagKeyOwner] = p.name
+	tags[TagKeyClaimNamespace] = pvc.ObjectMeta.Namespace
+	tags[TagKeyClaimName] = pvc.ObjectMeta.Name
+	tags[TagKeyManagedTags] = managed
+
+	return tags, nil
+}
+
+// Helper function to check if a resource already has the desired tags, including
+// whether any tags need to be removed.
+func tagsInSync(current []*efs.Tag, desired map[string]string) bool {
+	set, remove := diffTags(current, desired)
+	return len(set) == 0 && len(remove) == 0
+}
+
+// Helper function to work out which tags need to be set and removed.
+func diffTags(current []*efs.Tag, desired map[string]string) ([]*efs.Tag, []string) {
+	var (
+		set    []*efs.Tag
+		remove []string
+		keys   []string
+	)
+
+	for key := range desired {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if value, ok := tagValue(current, key); ok && value == desired[key] {
+			continue
+		}
+
+		set = append(set, &efs.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(desired[key]),
+		})
+	}
+
+	managed, _ := tagValue(current, TagKeyManagedTags)
+
+	for _, key := range strings.Split(managed, ",") {
+		if key == "" {
+			continue
+		}
+
+		if _, ok := desired[key]; ok {
+			continue
+		}
+
+		if _, ok := tagValue(current, key); ok {
+			remove = append(remove, key)
+		}
+	}
+
+	return set, remove
+}
+
+// Helper function to update the tags of a filesystem or access point to match the desired tags.
+//
+// Returns true if any tags were changed.
+func syncTags(svc efsiface.EFSAPI, id string, desired map[string]string) (bool, error) {
+	current, err := listTags(svc, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to list tags: %s", err)
+	}
+
+	set, remove := diffTags(current, desired)
+
+	if len(set) > 0 {
+		_, err := svc.TagResource(&efs.TagResourceInput{
+			ResourceId: aws.String(id),
+			Tags:       set,
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to tag: %s", err)
+		}
+	}
+
+	if len(remove) > 0 {
+		_, err := svc.UntagResource(&efs.UntagResourceInput{
+			ResourceId: aws.String(id),
+			TagKeys:    aws.StringSlice(remove),
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to untag: %s", err)
+		}
+	}
+
+	return len(set) > 0 || len(remove) > 0, nil
+}
+
+// Helper function to list all of the tags of a filesystem or access point.
+func listTags(svc efsiface.EFSAPI, id string) ([]*efs.Tag, error) {
+	var (
+		tags  []*efs.Tag
+		input = &efs.ListTagsForResourceInput{
+			ResourceId: aws.String(id),
+		}
+	)
+
+	for {
+		list, err := svc.ListTagsForResource(input)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, list.Tags...)
+
+		if aws.StringValue(list.NextToken) == "" {
+			return tags, nil
+		}
+
+		input.NextToken = list.NextToken
+	}
+}
+
+// Helper function to convert tags into the form used by the EFS API, in a consistent order.
+func efsTags(tags map[string]string) []*efs.Tag {
+	var keys []string
+
+	for key := range tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var list []*efs.Tag
+
+	for _, key := range keys {
+		list = append(list, &efs.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(tags[key]),
+		})
+	}
+
+	return list
+}
@@ -0,0 +1,285 @@
+package provisioner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return the tags of a filesystem as a map.
+func testTags(client *mock.Client, id string) map[string]string {
+	fs, _ := client.FileSystem(id)
+
+	tags := make(map[string]string)
+
+	for _, tag := range fs.Tags {
+		tags[tag.Key] = tag.Value
+	}
+
+	return tags
+}
+
+func TestNewReservedTags(t *testing.T) {
+	for _, key := range []string{"Name", "aws:cloudformation:stack-name", TagKeyOwner} {
+		params := testParams()
+		params.Tags = map[string]string{key: "value"}
+
+		_, err := New(mock.New(), params)
+		assert.EqualError(t, err, "tag "+key+" is reserved")
+	}
+}
+
+func TestNewInvalidTags(t *testing.T) {
+	params := testParams()
+	params.Tags = map[string]string{strings.Repeat("k", MaxTagKeyLength+1): "value"}
+
+	_, err := New(mock.New(), params)
+	assert.EqualError(t, err, fmt.Sprintf("invalid tags: tag %s is longer than 128 characters", strings.Repeat("k", MaxTagKeyLength+1)))
+
+	params.Tags = make(map[string]string)
+
+	for i := 0; i <= MaxTags-provisionerTagCount; i++ {
+		params.Tags[fmt.Sprintf("tag-%d", i)] = "value"
+	}
+
+	_, err = New(mock.New(), params)
+	assert.EqualError(t, err, "invalid tags: 43 tags were requested but at most 42 can be added to a filesystem")
+}
+
+func TestValidateTags(t *testing.T) {
+	assert.Nil(t, validateTags(map[string]string{
+		strings.Repeat("k", MaxTagKeyLength): strings.Repeat("v", MaxTagValueLength),
+	}))
+
+	// Limits are in characters rather than bytes.
+	assert.Nil(t, validateTags(map[string]string{"team": strings.Repeat("é", MaxTagValueLength)}))
+
+	assert.EqualError(t, validateTags(map[string]string{
+		"team": strings.Repeat("v", MaxTagValueLength+1),
+	}), "the value of tag team is longer than 256 characters")
+}
+
+func TestProvisionInvalidTags(t *testing.T) {
+	client := mock.New()
+
+	params := testParams()
+	params.Tags = map[string]string{"team": "platform"}
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	// The configured tags and the claim's tags are too many for EFS between them.
+	annotations := make(map[string]string)
+
+	for i := 0; i < MaxTags-provisionerTagCount; i++ {
+		annotations[fmt.Sprintf("%stag-%d", AnnotationTagPrefix, i)] = "value"
+	}
+
+	options := testOptions("namespace", "test")
+	options.PVC.ObjectMeta.Annotations = annotations
+
+	_, err = provisioner.Provision(options)
+	assert.EqualError(t, err, "failed to prepare tags: 43 tags were requested but at most 42 can be added to a filesystem")
+	assert.Equal(t, []string{
+		"Warning FilesystemFailed Not provisioning a filesystem, failed to prepare tags: 43 tags were requested but at most 42 can be added to a filesystem",
+	}, testEvents(recorder))
+	assert.Equal(t, 0, client.CallCount("CreateFileSystem"))
+}
+
+func TestProvisionTags(t *testing.T) {
+	client := mock.New()
+
+	params := testParams()
+	params.Tags = map[string]string{"team": "platform"}
+
+	provisioner, err := New(client, params)
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	options := testOptions("namespace", "test")
+	options.PVC.ObjectMeta.Annotations = map[string]string{
+		AnnotationTagPrefix + "cost-centre": "engineering",
+		AnnotationTagPrefix + "Name":        "ignored",
+	}
+
+	volume, err := provisioner.Provision(options)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]string{
+		"Name":               "namespace-test",
+		"team":               "platform",
+		"cost-centre":        "engineering",
+		TagKeyOwner:          "efs.aws.skpr.io/generalPurpose",
+		TagKeyClaimNamespace: "namespace",
+		TagKeyClaimName:      "test",
+		TagKeyManagedTags:    "cost-centre,team",
+	}, testTags(client, volume.ObjectMeta.Name))
+
+	// The filesystem was tagged when it was created.
+	create := client.Calls("CreateFileSystem")
+	if assert.Len(t, create, 1) {
+		assert.Len(t, create[0].Input.(*efs.CreateFileSystemInput).Tags, 7)
+	}
+
+	assert.Equal(t, 0, client.CallCount("TagResource"))
+	assert.Equal(t, 0, client.CallCount("CreateTags"))
+}
+
+func TestProvisionReusedFilesystemTags(t *testing.T) {
+	client := mock.New()
+
+	// A filesystem which was created by an earlier version, without any tags.
+	_, err := client.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken:   aws.String("namespace-test"),
+		PerformanceMode: aws.String(efs.PerformanceModeGeneralPurpose),
+	})
+	assert.Nil(t, err)
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go provisioner.Run(stop)
+
+	volume, err := provisioner.Provision(testOptions("namespace", "test"))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "efs.aws.skpr.io/generalPurpose", testTags(client, volume.ObjectMeta.Name)[TagKeyOwner])
+	assert.Contains(t, testEvents(recorder), "Normal FilesystemTagging Updated tags of filesystem fs-00000001")
+}
+
+func TestReconcileTags(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	// Tags which were added by someone else are left alone.
+	_, err := client.TagResource(&efs.TagResourceInput{
+		ResourceId: aws.String(id),
+		Tags:       []*efs.Tag{{Key: aws.String("backup"), Value: aws.String("daily")}},
+	})
+	assert.Nil(t, err)
+
+	kubernetes := fake.NewSimpleClientset(testClaim("namespace", "test", map[string]string{
+		AnnotationTagPrefix + "cost-centre": "engineering",
+	}))
+
+	params := testParams()
+	params.Tags = map[string]string{"team": "platform"}
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, params, WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	tags := testTags(client, id)
+	assert.Equal(t, "platform", tags["team"])
+	assert.Equal(t, "engineering", tags["cost-centre"])
+	assert.Equal(t, "daily", tags["backup"])
+	assert.Equal(t, "namespace-test", tags["Name"])
+	assert.Equal(t, []string{"Normal TagsReconciled Updated tags of filesystem fs-00000001"}, testEvents(recorder))
+
+	// Tags which are removed from the configuration and the claim are removed from the filesystem.
+	_, err = kubernetes.CoreV1().PersistentVolumeClaims("namespace").Update(testClaim("namespace", "test", nil))
+	assert.Nil(t, err)
+
+	params.Tags = map[string]string{"environment": "production"}
+
+	provisioner, err = New(client, params, WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	tags = testTags(client, id)
+	assert.NotContains(t, tags, "team")
+	assert.NotContains(t, tags, "cost-centre")
+	assert.Equal(t, "production", tags["environment"])
+	assert.Equal(t, "daily", tags["backup"])
+	assert.Equal(t, "environment", tags[TagKeyManagedTags])
+
+	// Nothing is listed or changed once the tags are in sync.
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	calls := client.CallCount("ListTagsForResource")
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+	assert.Equal(t, calls, client.CallCount("ListTagsForResource"))
+}
+
+func TestReconcileInvalidTags(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	kubernetes := fake.NewSimpleClientset(testClaim("namespace", "test", map[string]string{
+		AnnotationTagPrefix + "description": strings.Repeat("v", MaxTagValueLength+1),
+	}))
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.EqualError(t, err, "failed to reconcile 1 filesystems: fs-00000001: failed to prepare tags: the value of tag description is longer than 256 characters")
+	assert.Equal(t, []string{
+		"Warning FilesystemFailed Not updating tags of filesystem fs-00000001: the value of tag description is longer than 256 characters",
+	}, testEvents(recorder))
+
+	assert.NotContains(t, testTags(client, id), "description")
+	assert.Equal(t, 0, client.CallCount("TagResource"))
+}
+
+func TestReconcileTagsClaimDeleted(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	params := testParams()
+	params.Tags = map[string]string{"team": "platform"}
+
+	provisioner, err := New(client, params, WithKubernetes(fake.NewSimpleClientset()))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.Reconcile()
+	assert.Nil(t, err)
+
+	assert.NotContains(t, testTags(client, id), "team")
+	assert.Equal(t, 0, client.CallCount("TagResource"))
+}
@@ -0,0 +1,195 @@
+package provisioner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
+)
+
+const (
+	// ThroughputDecreaseCooldown is how long EFS makes you wait after decreasing the throughput of a
+	// filesystem (or changing its throughput mode) before it can be decreased again.
+	ThroughputDecreaseCooldown = 24 * time.Hour
+
+	// MinProvisionedThroughput is the least throughput (MiB/s) which can be provisioned.
+	MinProvisionedThroughput = 1
+
+	// MaxProvisionedThroughput is the most throughput (MiB/s) which can be provisioned.
+	MaxProvisionedThroughput = 1024
+)
+
+// TagKeyThroughputDecreased is the tag on a filesystem which records when the provisioner last
+// decreased its throughput or changed its throughput mode.
+const TagKeyThroughputDecreased = "efs.aws.skpr.io/throughput-decreased"
+
+// Throughput of a filesystem.
+type Throughput struct {
+	Mode        string
+	Provisioned float64
+}
+
+// String describes the throughput eg. "provisioned (10 MiB/s)"
+func (t Throughput) String() string {
+	if t.Mode == efs.ThroughputModeProvisioned {
+		return fmt.Sprintf("%s (%g MiB/s)", t.Mode, t.Provisioned)
+	}
+
+	return t.Mode
+}
+
+// ThroughputDeferredError is returned when the throughput of a filesystem can't be decreased yet.
+type ThroughputDeferredError struct {
+	Until time.Time
+}
+
+// Error describes when the throughput can be decreased.
+func (e *ThroughputDeferredError) Error() string {
+	if e.Until.IsZero() {
+		return "throughput can't be decreased or switched between modes yet"
+	}
+
+	return fmt.Sprintf("throughput can't be decreased or switched between modes until %s", e.Until.Format(time.RFC3339))
+}
+
+// Helper function to return the current throughput of a filesystem.
+func currentThroughput(fs *efs.FileSystemDescription) Throughput {
+	throughput := Throughput{
+		Mode: aws.StringValue(fs.ThroughputMode),
+	}
+
+	if throughput.Mode == "" {
+		throughput.Mode = efs.ThroughputModeBursting
+	}
+
+	if throughput.Mode == efs.ThroughputModeProvisioned {
+		throughput.Provisioned = aws.Float64Value(fs.ProvisionedThroughputInMibps)
+	}
+
+	return throughput
+}
+
+// Helper function to keep provisioned throughput within the limits of EFS.
+func clampThroughput(mibps float64) float64 {
+	if mibps < MinProvisionedThroughput {
+		return MinProvisionedThroughput
+	}
+
+	if mibps > MaxProvisionedThroughput {
+		return MaxProvisionedThroughput
+	}
+
+	return mibps
+}
+
+// Helper function to change the throughput of a filesystem, while respecting the cooldown between decreases.
+//
+// Returns true if the throughput was changed, or a ThroughputDeferredError if it can't be decreased yet.
+func (p *Provisioner) updateThroughput(fs *efs.FileSystemDescription, desired Throughput) (bool, error) {
+	var (
+		id      = aws.StringValue(fs.FileSystemId)
+		current = currentThroughput(fs)
+	)
+
+	if desired.Mode != efs.ThroughputModeProvisioned {
+		desired.Provisioned = 0
+	}
+
+	if current == desired {
+		return false, nil
+	}
+
+	decrease := desired.Mode != current.Mode || desired.Provisioned < current.Provisioned
+
+	// Raising provisioned throughput counts towards the quota of the filesystem's namespace.
+	if desired.Mode == efs.ThroughputModeProvisioned && desired.Provisioned > current.Provisioned {
+		err := p.checkFilesystemThroughput(fs, desired)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if decrease {
+		if value, ok := tagValue(fs.Tags, TagKeyThroughputDecreased); ok {
+			decreased, err := time.Parse(time.RFC3339, value)
+			if err == nil && p.now().Before(decreased.Add(ThroughputDecreaseCooldown)) {
+				return false, &ThroughputDeferredError{Until: decreased.Add(ThroughputDecreaseCooldown)}
+			}
+		}
+	}
+
+	input := &efs.UpdateFileSystemInput{
+		FileSystemId:   aws.String(id),
+		ThroughputMode: aws.String(desired.Mode),
+	}
+
+	if desired.Mode == efs.ThroughputModeProvisioned {
+		input.ProvisionedThroughputInMibps = aws.Float64(desired.Provisioned)
+	}
+
+	_, err := p.client.UpdateFileSystem(input)
+	if decrease && efsclient.IsCode(err, efs.ErrCodeTooManyRequests) {
+		// The throughput was decreased by someone else.
+		return false, &ThroughputDeferredError{}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	p.quotaMu.Lock()
+	delete(p.refused, throughputKey(id))
+	p.quotaMu.Unlock()
+
+	if decrease {
+		_, err := p.client.TagResource(&efs.TagResourceInput{
+			ResourceId: aws.String(id),
+			Tags: []*efs.Tag{
+				{
+					Key:   aws.String(TagKeyThroughputDecreased),
+					Value: aws.String(p.now().UTC().Format(time.RFC3339)),
+				},
+			},
+		})
+		if err != nil {
+			return true, fmt.Errorf("failed to record when throughput was decreased: %s", err)
+		}
+	}
+
+	return true, nil
+}
+
+// Helper function to check if the provisioned throughput of a filesystem can be raised within the quota of its
+// namespace. Throughput is checked on every pass, so its claim is only warned until the throughput is changed.
+func (p *Provisioner) checkFilesystemThroughput(fs *efs.FileSystemDescription, desired Throughput) error {
+	namespace, ok := tagValue(fs.Tags, TagKeyClaimNamespace)
+	if !ok {
+		return nil
+	}
+
+	p.quotaMu.Lock()
+	defer p.quotaMu.Unlock()
+
+	id := aws.StringValue(fs.FileSystemId)
+
+	err := p.checkThroughputQuota(namespace, id, desired.Provisioned)
+	if err == nil {
+		return nil
+	}
+
+	if p.refused[throughputKey(id)] != EventReasonQuotaExceeded {
+		p.refused[throughputKey(id)] = EventReasonQuotaExceeded
+
+		p.event(claimReference(fs), corev1.EventTypeWarning, EventReasonQuotaExceeded, "Not changing throughput of filesystem %s to %s: %s", id, desired, err)
+	}
+
+	return err
+}
+
+// Helper function to return the key which records why the throughput of a filesystem wasn't raised.
+func throughputKey(id string) string {
+	return "throughput/" + id
+}
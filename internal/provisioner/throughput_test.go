@@ -0,0 +1,56 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+func TestUpdateThroughput(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	provisioner, err := New(client, testParams(), WithClock(client.Clock().Now))
+	assert.Nil(t, err)
+
+	// Someone else changed the throughput mode, without tagging the filesystem.
+	_, err = client.UpdateFileSystem(&efs.UpdateFileSystemInput{
+		FileSystemId:                 aws.String(id),
+		ThroughputMode:               aws.String(efs.ThroughputModeProvisioned),
+		ProvisionedThroughputInMibps: aws.Float64(10),
+	})
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	fs, _ := provisioner.cache.Filesystem(id)
+
+	// Increases are always allowed.
+	changed, err := provisioner.updateThroughput(fs, Throughput{Mode: efs.ThroughputModeProvisioned, Provisioned: 20})
+	assert.Nil(t, err)
+	assert.True(t, changed)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	fs, _ = provisioner.cache.Filesystem(id)
+
+	changed, err = provisioner.updateThroughput(fs, Throughput{Mode: efs.ThroughputModeProvisioned, Provisioned: 20})
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	changed, err = provisioner.updateThroughput(fs, Throughput{Mode: efs.ThroughputModeBursting})
+	assert.IsType(t, &ThroughputDeferredError{}, err)
+	assert.False(t, changed)
+}
+
+func TestThroughputString(t *testing.T) {
+	assert.Equal(t, "bursting", Throughput{Mode: efs.ThroughputModeBursting}.String())
+	assert.Equal(t, "provisioned (2.5 MiB/s)", Throughput{Mode: efs.ThroughputModeProvisioned, Provisioned: 2.5}.String())
+}
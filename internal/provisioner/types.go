@@ -1,5 +1,10 @@
 package provisioner
 
+import (
+	"fmt"
+	"strings"
+)
+
 // LifeCycleState contains all the states which an EFS can be.
 type LifeCycleState string
 
@@ -13,3 +18,22 @@ const (
 	// LifeCycleStateUnknown shows when we are not sure what the state is of the Elastic Filesystem.
 	LifeCycleStateUnknown LifeCycleState = "Unknown"
 )
+
+// Settings which a filesystem is created with.
+type filesystemConfig struct {
+	Performance string
+	Encrypted   bool
+	KmsKeyID    string
+	Tags        map[string]string
+}
+
+// MismatchError is returned when an existing filesystem does not have the settings being requested.
+type MismatchError struct {
+	FileSystemID string
+	Problems     []string
+}
+
+// Error describes why the filesystem cannot be used.
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("existing filesystem %s cannot be used: %s", e.FileSystemID, strings.Join(e.Problems, ", "))
+}
@@ -0,0 +1,144 @@
+package provisioner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// AnnotationUsedBytes is the annotation on a volume which records the metered size of its filesystem.
+const AnnotationUsedBytes = "efs.aws.skpr.io/used-bytes"
+
+const (
+	// Storage classes reported by the FilesystemUsedBytes metric.
+	usageTotal            = "total"
+	usageStandard         = "standard"
+	usageInfrequentAccess = "infrequent_access"
+)
+
+// CollectUsage records the metered size of every owned filesystem, and warns claims which use
+// more than the storage they requested.
+//
+// EFS updates the metered size of a filesystem roughly once an hour, and it is described along
+// with the filesystem, so this doesn't make any calls to EFS.
+func (p *Provisioner) CollectUsage() error {
+	// Filesystems which no longer exist shouldn't keep reporting their size.
+	FilesystemUsedBytes.Reset()
+
+	for _, fs := range p.cache.Filesystems() {
+		if fs.SizeInBytes == nil {
+			continue
+		}
+
+		var (
+			id           = aws.StringValue(fs.FileSystemId)
+			namespace, _ = tagValue(fs.Tags, TagKeyClaimNamespace)
+			claim, _     = tagValue(fs.Tags, TagKeyClaimName)
+		)
+
+		FilesystemUsedBytes.WithLabelValues(id, namespace, claim, usageTotal).Set(float64(aws.Int64Value(fs.SizeInBytes.Value)))
+		FilesystemUsedBytes.WithLabelValues(id, namespace, claim, usageStandard).Set(float64(aws.Int64Value(fs.SizeInBytes.ValueInStandard)))
+		FilesystemUsedBytes.WithLabelValues(id, namespace, claim, usageInfrequentAccess).Set(float64(aws.Int64Value(fs.SizeInBytes.ValueInIA)))
+	}
+
+	if p.kubernetes == nil {
+		return nil
+	}
+
+	volumes, err := p.listVolumes()
+	if err != nil {
+		return err
+	}
+
+	claims, err := p.listClaims()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+
+	for _, volume := range volumes {
+		fs, ok := p.cache.Filesystem(filesystemID(volume))
+		if !ok || fs.SizeInBytes == nil || aws.StringValue(fs.LifeCycleState) != efs.LifeCycleStateAvailable {
+			continue
+		}
+
+		err := p.collectVolumeUsage(volume, claims.Claim(volume), aws.Int64Value(fs.SizeInBytes.Value))
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", volume.ObjectMeta.Name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to collect usage of %d volumes: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Helper function to record the usage of a single volume, and warn its claim when it has used up its request.
+func (p *Provisioner) collectVolumeUsage(volume *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim, used int64) error {
+	previous, recorded := usedBytes(volume)
+
+	changed := !recorded || previous != used
+
+	// Capacity is enforced even when usage hasn't changed, as the request might have been raised.
+	if !changed && p.params.CapacityEnforcement == "" {
+		return nil
+	}
+
+	if changed {
+		if volume.ObjectMeta.Annotations == nil {
+			volume.ObjectMeta.Annotations = make(map[string]string)
+		}
+
+		volume.ObjectMeta.Annotations[AnnotationUsedBytes] = strconv.FormatInt(used, 10)
+
+		updated, err := p.kubernetes.CoreV1().PersistentVolumes().Update(volume)
+		if err != nil {
+			return fmt.Errorf("failed to update volume: %s", err)
+		}
+
+		volume = updated
+	}
+
+	if pvc == nil {
+		return nil
+	}
+
+	request := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+
+	// Only warn when the request is first exceeded, rather than every time usage is collected.
+	if !request.IsZero() && used > request.Value() && (!recorded || previous <= request.Value()) {
+		glog.Infof("Filesystem %s of claim %s/%s uses more than the storage it requested", filesystemID(volume), pvc.ObjectMeta.Namespace, pvc.ObjectMeta.Name)
+
+		p.recorder.Eventf(pvc, corev1.EventTypeWarning, EventReasonUsageExceeded, "Filesystem %s uses %s, more than the %s requested", filesystemID(volume), resource.NewQuantity(used, resource.BinarySI), request.String())
+	}
+
+	if p.params.CapacityEnforcement == "" {
+		return nil
+	}
+
+	return p.enforceCapacity(volume, pvc, used, request)
+}
+
+// Helper function to return the usage which was last recorded on a volume.
+func usedBytes(volume *corev1.PersistentVolume) (int64, bool) {
+	value, ok := volume.ObjectMeta.Annotations[AnnotationUsedBytes]
+	if !ok {
+		return 0, false
+	}
+
+	used, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return used, true
+}
@@ -0,0 +1,102 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a claim which requests an amount of storage.
+func testSizedClaim(namespace, name, size string) *corev1.PersistentVolumeClaim {
+	pvc := testClaim(namespace, name, nil)
+	pvc.Spec.Resources.Requests = corev1.ResourceList{
+		corev1.ResourceStorage: resource.MustParse(size),
+	}
+
+	return pvc
+}
+
+func TestCollectUsage(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+
+	kubernetes := fake.NewSimpleClientset(volume, testSizedClaim("namespace", "test", "1Gi"))
+
+	recorder := record.NewFakeRecorder(100)
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(recorder))
+	assert.Nil(t, err)
+
+	client.SetSize(id, 512*1024*1024, 256*1024*1024)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.CollectUsage()
+	assert.Nil(t, err)
+
+	assert.Equal(t, float64(768*1024*1024), testutil.ToFloat64(FilesystemUsedBytes.WithLabelValues(id, "namespace", "test", usageTotal)))
+	assert.Equal(t, float64(512*1024*1024), testutil.ToFloat64(FilesystemUsedBytes.WithLabelValues(id, "namespace", "test", usageStandard)))
+	assert.Equal(t, float64(256*1024*1024), testutil.ToFloat64(FilesystemUsedBytes.WithLabelValues(id, "namespace", "test", usageInfrequentAccess)))
+
+	updated, err := kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "805306368", updated.ObjectMeta.Annotations[AnnotationUsedBytes])
+	assert.Empty(t, testEvents(recorder))
+
+	// The claim is warned when it first uses more than it requested.
+	client.SetSize(id, 2*1024*1024*1024, 0)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.CollectUsage()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"Warning UsageExceeded Filesystem fs-00000001 uses 2Gi, more than the 1Gi requested"}, testEvents(recorder))
+
+	client.SetSize(id, 3*1024*1024*1024, 0)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.CollectUsage()
+	assert.Nil(t, err)
+
+	assert.Empty(t, testEvents(recorder))
+
+	updated, err = kubernetes.CoreV1().PersistentVolumes().Get(id, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "3221225472", updated.ObjectMeta.Annotations[AnnotationUsedBytes])
+}
+
+func TestCollectUsageWithoutKubernetes(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	provisioner, err := New(client, testParams())
+	assert.Nil(t, err)
+
+	client.SetSize(id, 1024, 0)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	err = provisioner.CollectUsage()
+	assert.Nil(t, err)
+
+	assert.Equal(t, float64(1024), testutil.ToFloat64(FilesystemUsedBytes.WithLabelValues(id, "namespace", "test", usageTotal)))
+}
@@ -1,90 +1,186 @@
 package provisioner
 
 import (
-	"bytes"
-	"text/template"
+	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/efs"
 	"github.com/aws/aws-sdk-go/service/efs/efsiface"
-	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
 )
 
-// Helper function for building hostname.
-func formatName(format string, options controller.ProvisionOptions) (string, error) {
-	var formatted bytes.Buffer
+// Helper function to check if a filesystem exists before creating.
+//
+// This is safe to call multiple times for the same CreationToken. An existing filesystem is only
+// returned if it was created with the same settings as the ones being requested.
+func putFilesystem(svc efsiface.EFSAPI, token string, config filesystemConfig) (*efs.FileSystemDescription, bool, error) {
+	fs, err := describeFilesystem(svc, token)
+	if err != nil {
+		return nil, false, err
+	}
 
-	t := template.Must(template.New("name").Parse(format))
+	// We have found the filesystem! Give this back to the provisioner.
+	if fs != nil {
+		return fs, false, verifyFilesystem(fs, config)
+	}
 
-	err := t.Execute(&formatted, options)
+	// We dont hav the filesystem, lets provision it now.
+	create, err := svc.CreateFileSystem(&efs.CreateFileSystemInput{
+		CreationToken:   aws.String(token),
+		PerformanceMode: aws.String(config.Performance),
+		Encrypted:       aws.Bool(config.Encrypted),
+		KmsKeyId:        kmsKeyID(config.KmsKeyID),
+		// Tagging on creation means the filesystem is never untagged, even briefly.
+		Tags: efsTags(config.Tags),
+	})
+	if efsclient.IsCode(err, efs.ErrCodeFileSystemAlreadyExists) {
+		// The filesystem was created after we looked for it eg. by a previous attempt
+		// which was retried. Look it up again so we can use it.
+		fs, err := describeFilesystem(svc, token)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if fs == nil {
+			return nil, false, fmt.Errorf("filesystem with CreationToken %s already exists but could not be found", token)
+		}
+
+		return fs, false, verifyFilesystem(fs, config)
+	}
 	if err != nil {
-		return "", err
+		return nil, false, err
 	}
 
-	return formatted.String(), nil
+	return create, true, nil
 }
 
-// Helper function to check if a filesystem exists before creating.
-func putFilesystem(svc efsiface.EFSAPI, name string, performance string) (*efs.FileSystemDescription, error) {
+// Helper function to lookup a filesystem by its CreationToken.
+func describeFilesystem(svc efsiface.EFSAPI, token string) (*efs.FileSystemDescription, error) {
 	describe, err := svc.DescribeFileSystems(&efs.DescribeFileSystemsInput{
-		CreationToken: aws.String(name),
+		CreationToken: aws.String(token),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	// We have found the filesystem! Give this back to the provisioner.
-	if len(describe.FileSystems) == 1 {
+	switch len(describe.FileSystems) {
+	case 0:
+		return nil, nil
+	case 1:
 		return describe.FileSystems[0], nil
 	}
 
-	// We dont hav the filesystem, lets provision it now.
-	create, err := svc.CreateFileSystem(&efs.CreateFileSystemInput{
-		CreationToken:   aws.String(name),
-		PerformanceMode: aws.String(string(performance)),
-	})
-	if err != nil {
-		return nil, err
+	// A CreationToken should only ever match one filesystem. If it doesn't, we can't
+	// tell which one belongs to the claim, so we refuse to pick one.
+	var ids []string
+
+	for _, fs := range describe.FileSystems {
+		ids = append(ids, aws.StringValue(fs.FileSystemId))
 	}
 
-	// Add tags to the filesystem, this makes it easier for site admins
-	// to see what a filesystem was provisioned for.
-	_, err = svc.CreateTags(&efs.CreateTagsInput{
-		FileSystemId: create.FileSystemId,
-		Tags: []*efs.Tag{
-			{
-				Key:   aws.String("Name"),
-				Value: aws.String(name),
-			},
-		},
-	})
+	return nil, fmt.Errorf("found %d filesystems with CreationToken %s: %s", len(ids), token, strings.Join(ids, ", "))
+}
+
+// Helper function to check an existing filesystem can be used for a claim.
+func verifyFilesystem(fs *efs.FileSystemDescription, config filesystemConfig) error {
+	var problems []string
+
+	switch aws.StringValue(fs.LifeCycleState) {
+	case efs.LifeCycleStateDeleting, efs.LifeCycleStateDeleted:
+		problems = append(problems, fmt.Sprintf("it is in state %s", aws.StringValue(fs.LifeCycleState)))
+	}
+
+	if aws.StringValue(fs.PerformanceMode) != config.Performance {
+		problems = append(problems, fmt.Sprintf("performance mode is %s instead of %s", aws.StringValue(fs.PerformanceMode), config.Performance))
+	}
+
+	if aws.BoolValue(fs.Encrypted) != config.Encrypted {
+		problems = append(problems, fmt.Sprintf("encrypted is %t instead of %t", aws.BoolValue(fs.Encrypted), config.Encrypted))
+	}
+
+	if config.Encrypted && config.KmsKeyID != "" && !matchesKmsKey(aws.StringValue(fs.KmsKeyId), config.KmsKeyID) {
+		problems = append(problems, fmt.Sprintf("KMS key is %s instead of %s", aws.StringValue(fs.KmsKeyId), config.KmsKeyID))
+	}
+
+	if len(problems) > 0 {
+		return &MismatchError{
+			FileSystemID: aws.StringValue(fs.FileSystemId),
+			Problems:     problems,
+		}
+	}
+
+	return nil
+}
+
+// Helper function to check if a KMS key ARN (as returned by EFS) refers to a configured key ID or ARN.
+func matchesKmsKey(arn, key string) bool {
+	return arn == key || strings.HasSuffix(arn, "/"+key)
+}
+
+// Helper function to return a KMS key ID, if one has been configured.
+func kmsKeyID(key string) *string {
+	if key == "" {
+		return nil
+	}
+
+	return aws.String(key)
+}
+
+// Helper function to lookup the value of a tag.
+func tagValue(tags []*efs.Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true
+		}
+	}
 
-	return create, nil
+	return "", false
 }
 
 // Helper function to check if a mount exists before creating.
-func putMount(svc efsiface.EFSAPI, id, subnet, security string) (*efs.MountTargetDescription, error) {
+func putMount(svc efsiface.EFSAPI, id, subnet string, groups []string) (*efs.MountTargetDescription, error) {
 	// Check if a mount exists in this subnet.
-	mnts, err := svc.DescribeMountTargets(&efs.DescribeMountTargetsInput{
-		FileSystemId: aws.String(id),
+	mount, err := subnetMount(svc, id, subnet)
+	if err != nil || mount != nil {
+		return mount, err
+	}
+
+	// Create one if it does not exist.
+	created, err := svc.CreateMountTarget(&efs.CreateMountTargetInput{
+		FileSystemId:   aws.String(id),
+		SubnetId:       aws.String(subnet),
+		SecurityGroups: aws.StringSlice(groups),
 	})
+	if efsclient.IsCode(err, efs.ErrCodeMountTargetConflict) {
+		// The mount target was created since we checked eg. by a request which was retried. Any other
+		// conflict is a mount target in another subnet of the same availability zone.
+		mount, lookup := subnetMount(svc, id, subnet)
+		if lookup != nil {
+			return nil, lookup
+		}
+
+		if mount != nil {
+			return mount, nil
+		}
+	}
+
+	return created, err
+}
+
+// Helper function to return the mount target of a filesystem in a subnet, or nil if there isn't one.
+func subnetMount(svc efsiface.EFSAPI, id, subnet string) (*efs.MountTargetDescription, error) {
+	mounts, err := describeMountTargets(svc, id)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, mount := range mnts.MountTargets {
-		// Check if we have already setup a mount point on a specific subnet.
-		if *mount.SubnetId == subnet {
+	for _, mount := range mounts {
+		if aws.StringValue(mount.SubnetId) == subnet {
 			return mount, nil
 		}
 	}
 
-	// Create one if it does not exist.
-	return svc.CreateMountTarget(&efs.CreateMountTargetInput{
-		FileSystemId: aws.String(id),
-		SubnetId:     aws.String(subnet),
-		SecurityGroups: []*string{
-			aws.String(security),
-		},
-	})
+	return nil, nil
 }
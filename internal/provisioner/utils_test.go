@@ -3,28 +3,154 @@ package provisioner
 import (
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/efs"
+	"github.com/aws/aws-sdk-go/service/efs/efsiface"
 	"github.com/stretchr/testify/assert"
-	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
 )
 
-func TestFormatName(t *testing.T) {
-	foo := controller.ProvisionOptions{
-		PVName: "bar",
-		PVC: &v1.PersistentVolumeClaim{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: "foo",
-				Name:      "baz",
-			},
+// Client which hides filesystems from the first lookup, simulating another
+// attempt creating the filesystem at the same time.
+type raceClient struct {
+	efsiface.EFSAPI
+	hidden bool
+}
+
+func (c *raceClient) DescribeFileSystems(input *efs.DescribeFileSystemsInput) (*efs.DescribeFileSystemsOutput, error) {
+	if !c.hidden {
+		c.hidden = true
+		return &efs.DescribeFileSystemsOutput{}, nil
+	}
+
+	return c.EFSAPI.DescribeFileSystems(input)
+}
+
+// Client which returns the same filesystem twice.
+type ambiguousClient struct {
+	efsiface.EFSAPI
+}
+
+func (c *ambiguousClient) DescribeFileSystems(input *efs.DescribeFileSystemsInput) (*efs.DescribeFileSystemsOutput, error) {
+	return &efs.DescribeFileSystemsOutput{
+		FileSystems: []*efs.FileSystemDescription{
+			{FileSystemId: aws.String("fs-11111111")},
+			{FileSystemId: aws.String("fs-22222222")},
 		},
+	}, nil
+}
+
+// Client which hides mount targets from the first lookup, simulating a request
+// which created the mount target but was retried.
+type raceMountClient struct {
+	efsiface.EFSAPI
+	hidden bool
+}
+
+func (c *raceMountClient) DescribeMountTargets(input *efs.DescribeMountTargetsInput) (*efs.DescribeMountTargetsOutput, error) {
+	if !c.hidden {
+		c.hidden = true
+		return &efs.DescribeMountTargetsOutput{}, nil
+	}
+
+	return c.EFSAPI.DescribeMountTargets(input)
+}
+
+func TestPutFilesystem(t *testing.T) {
+	client := mock.New()
+
+	config := filesystemConfig{
+		Performance: efs.PerformanceModeGeneralPurpose,
+	}
+
+	fs, created, err := putFilesystem(client, "test", config)
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "test", *fs.CreationToken)
+
+	fs, created, err = putFilesystem(client, "test", config)
+	assert.Nil(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "test", *fs.CreationToken)
+}
+
+func TestPutFilesystemAlreadyExists(t *testing.T) {
+	client := mock.New()
+
+	config := filesystemConfig{
+		Performance: efs.PerformanceModeGeneralPurpose,
 	}
 
-	name, err := formatName("{{ .PVC.ObjectMeta.Namespace }}-{{ .PVName }}", foo)
+	_, _, err := putFilesystem(client, "test", config)
+	assert.Nil(t, err)
+
+	fs, created, err := putFilesystem(&raceClient{EFSAPI: client}, "test", config)
+	assert.Nil(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "test", *fs.CreationToken)
+}
+
+func TestPutFilesystemMismatch(t *testing.T) {
+	client := mock.New()
+
+	_, _, err := putFilesystem(client, "test", filesystemConfig{
+		Performance: efs.PerformanceModeGeneralPurpose,
+	})
+	assert.Nil(t, err)
+
+	_, _, err = putFilesystem(client, "test", filesystemConfig{
+		Performance: efs.PerformanceModeMaxIo,
+		Encrypted:   true,
+	})
+	assert.IsType(t, &MismatchError{}, err)
+	assert.Equal(t, "existing filesystem fs-00000001 cannot be used: performance mode is generalPurpose instead of maxIO, encrypted is false instead of true", err.Error())
+}
+
+func TestPutFilesystemAmbiguous(t *testing.T) {
+	_, _, err := putFilesystem(&ambiguousClient{EFSAPI: mock.New()}, "test", filesystemConfig{
+		Performance: efs.PerformanceModeGeneralPurpose,
+	})
+	assert.Equal(t, "found 2 filesystems with CreationToken test: fs-11111111, fs-22222222", err.Error())
+}
+
+func TestPutMount(t *testing.T) {
+	params := mock.DefaultParams()
+	params.PageSize = 1
+
+	client := mock.New(mock.WithParams(params))
+
+	fs, _, err := putFilesystem(client, "test", filesystemConfig{
+		Performance: efs.PerformanceModeGeneralPurpose,
+	})
+	assert.Nil(t, err)
+
+	id := aws.StringValue(fs.FileSystemId)
+
+	for _, subnet := range []string{"subnet-a", "subnet-b", "subnet-b"} {
+		mount, err := putMount(client, id, subnet, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, subnet, aws.StringValue(mount.SubnetId))
+	}
+
+	// The mount target in the second subnet is on the second page.
+	assert.Equal(t, 2, client.CallCount("CreateMountTarget"))
+}
+
+func TestPutMountConflict(t *testing.T) {
+	client := mock.New()
+
+	fs, _, err := putFilesystem(client, "test", filesystemConfig{
+		Performance: efs.PerformanceModeGeneralPurpose,
+	})
+	assert.Nil(t, err)
+
+	id := aws.StringValue(fs.FileSystemId)
+
+	existing, err := putMount(client, id, "subnet-a", nil)
 	assert.Nil(t, err)
-	assert.Equal(t, "foo-bar", name)
 
-	name, err = formatName("{{ .PVC.ObjectMeta.Namespace }}-{{ .PVC.ObjectMeta.Name }}", foo)
+	mount, err := putMount(&raceMountClient{EFSAPI: client}, id, "subnet-a", nil)
 	assert.Nil(t, err)
-	assert.Equal(t, "foo-baz", name)
+	assert.Equal(t, aws.StringValue(existing.MountTargetId), aws.StringValue(mount.MountTargetId))
 }
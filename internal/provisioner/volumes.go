@@ -0,0 +1,135 @@
+package provisioner
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/efs"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Number of objects requested per page when listing volumes, claims and storage classes.
+const listPageSize = 500
+
+// Annotation which records the provisioner that created a volume.
+const annProvisionedBy = "pv.kubernetes.io/provisioned-by"
+
+// Claims and StorageClasses which were listed once per pass, so they can be looked up for every volume
+// without asking the API server about each of them.
+type volumeClaims struct {
+	claims  map[string]*corev1.PersistentVolumeClaim
+	classes map[string]*storagev1.StorageClass
+}
+
+// Helper function to return the claim a volume is bound to, or nil if it no longer exists.
+func (c *volumeClaims) Claim(volume *corev1.PersistentVolume) *corev1.PersistentVolumeClaim {
+	ref := volume.Spec.ClaimRef
+	if ref == nil {
+		return nil
+	}
+
+	return c.Get(ref.Namespace, ref.Name)
+}
+
+// Helper function to return the claim a filesystem was provisioned for, or nil if it no longer exists.
+func (c *volumeClaims) Filesystem(fs *efs.FileSystemDescription) *corev1.PersistentVolumeClaim {
+	namespace, _ := tagValue(fs.Tags, TagKeyClaimNamespace)
+	name, _ := tagValue(fs.Tags, TagKeyClaimName)
+
+	return c.Get(namespace, name)
+}
+
+// Helper function to return a claim by its namespace and name, or nil if it doesn't exist.
+func (c *volumeClaims) Get(namespace, name string) *corev1.PersistentVolumeClaim {
+	return c.claims[claimKey(namespace, name)]
+}
+
+// Helper function to return the StorageClass of a claim, or nil if it no longer exists.
+func (c *volumeClaims) StorageClass(pvc *corev1.PersistentVolumeClaim) *storagev1.StorageClass {
+	return c.classes[storageClassName(pvc)]
+}
+
+// Helper function to list the volumes provisioned by this provisioner, a page at a time.
+func (p *Provisioner) listVolumes() ([]*corev1.PersistentVolume, error) {
+	var (
+		volumes []*corev1.PersistentVolume
+		options = metav1.ListOptions{Limit: listPageSize}
+	)
+
+	for {
+		list, err := p.kubernetes.CoreV1().PersistentVolumes().List(options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list volumes: %s", err)
+		}
+
+		for i := range list.Items {
+			volume := &list.Items[i]
+
+			if volume.ObjectMeta.Annotations[annProvisionedBy] != p.name {
+				continue
+			}
+
+			volumes = append(volumes, volume)
+		}
+
+		if list.Continue == "" {
+			return volumes, nil
+		}
+
+		options.Continue = list.Continue
+	}
+}
+
+// Helper function to list every claim and StorageClass, a page at a time.
+func (p *Provisioner) listClaims() (*volumeClaims, error) {
+	claims := &volumeClaims{
+		claims:  make(map[string]*corev1.PersistentVolumeClaim),
+		classes: make(map[string]*storagev1.StorageClass),
+	}
+
+	options := metav1.ListOptions{Limit: listPageSize}
+
+	for {
+		list, err := p.kubernetes.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list claims: %s", err)
+		}
+
+		for i := range list.Items {
+			pvc := &list.Items[i]
+			claims.claims[claimKey(pvc.ObjectMeta.Namespace, pvc.ObjectMeta.Name)] = pvc
+		}
+
+		if list.Continue == "" {
+			break
+		}
+
+		options.Continue = list.Continue
+	}
+
+	options = metav1.ListOptions{Limit: listPageSize}
+
+	for {
+		list, err := p.kubernetes.StorageV1().StorageClasses().List(options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list storage classes: %s", err)
+		}
+
+		for i := range list.Items {
+			class := &list.Items[i]
+			claims.classes[class.ObjectMeta.Name] = class
+		}
+
+		if list.Continue == "" {
+			return claims, nil
+		}
+
+		options.Continue = list.Continue
+	}
+}
+
+// Helper function to return a key which identifies a claim.
+func claimKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
@@ -0,0 +1,112 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+// Helper function to return a volume which the controller would delete.
+func testReleasedVolume(id string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: id,
+			Annotations: map[string]string{
+				annProvisionedBy: "efs.aws.skpr.io/generalPurpose",
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+			ClaimRef: &corev1.ObjectReference{
+				Namespace: "namespace",
+				Name:      "test",
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				NFS: &corev1.NFSVolumeSource{
+					Server: id + ".efs.ap-southeast-2.amazonaws.com",
+				},
+			},
+		},
+		Status: corev1.PersistentVolumeStatus{
+			Phase: corev1.VolumeReleased,
+		},
+	}
+}
+
+func TestListVolumes(t *testing.T) {
+	other := testReleasedVolume("fs-00000003")
+	other.ObjectMeta.Annotations[annProvisionedBy] = "example.com/other"
+
+	pages := []*corev1.PersistentVolumeList{
+		{
+			ListMeta: metav1.ListMeta{Continue: "2"},
+			Items:    []corev1.PersistentVolume{*testReleasedVolume("fs-00000001"), *other},
+		},
+		{
+			Items: []corev1.PersistentVolume{*testReleasedVolume("fs-00000002")},
+		},
+	}
+
+	// The fake client doesn't pass on the continue token, so pages are returned in the order they're asked for.
+	kubernetes := fake.NewSimpleClientset()
+	kubernetes.PrependReactor("list", "persistentvolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		page := pages[0]
+		pages = pages[1:]
+
+		return true, page, nil
+	})
+
+	provisioner, err := New(mock.New(), testParams(), WithKubernetes(kubernetes))
+	assert.Nil(t, err)
+
+	volumes, err := provisioner.listVolumes()
+	assert.Nil(t, err)
+
+	var names []string
+	for _, volume := range volumes {
+		names = append(names, volume.ObjectMeta.Name)
+	}
+
+	assert.Equal(t, []string{"fs-00000001", "fs-00000002"}, names)
+}
+
+func TestListVolumesClaims(t *testing.T) {
+	client := mock.New()
+
+	id := testProvision(t, client)
+
+	volume := testReleasedVolume(id)
+	volume.Status.Phase = corev1.VolumeBound
+	volume.Spec.Capacity = corev1.ResourceList{
+		corev1.ResourceStorage: resource.MustParse("1Gi"),
+	}
+
+	kubernetes := fake.NewSimpleClientset(volume, testExpandedClaim("100Gi"), testExpandableClass(map[string]string{
+		ParameterThroughputPerTiB: "100",
+	}))
+
+	provisioner, err := New(client, testParams(), WithKubernetes(kubernetes), WithRecorder(record.NewFakeRecorder(100)))
+	assert.Nil(t, err)
+
+	err = provisioner.cache.Refresh()
+	assert.Nil(t, err)
+
+	for _, feature := range []func() error{provisioner.Reconcile, provisioner.Resize, provisioner.ScheduleThroughput, provisioner.CollectUsage} {
+		err = feature()
+		assert.Nil(t, err)
+	}
+
+	// Claims and StorageClasses are listed once per pass, rather than fetched for every volume.
+	for _, action := range kubernetes.Actions() {
+		assert.NotEqual(t, "get", action.GetVerb(), "%s %s", action.GetVerb(), action.GetResource().Resource)
+	}
+}
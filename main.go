@@ -4,17 +4,26 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/efs"
 	"github.com/golang/glog"
 	"github.com/kelseyhightower/envconfig"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/controller"
 
+	"github.com/previousnext/k8s-aws-efs/internal/efsclient"
 	"github.com/previousnext/k8s-aws-efs/internal/provisioner"
 )
 
@@ -56,16 +65,65 @@ func main() {
 		apiVersion = fmt.Sprintf("efs.aws.skpr.io/%s", params.Performance)
 	}
 
-	client := efs.New(session.New())
+	var clientParams efsclient.Params
 
-	provisioner, err := provisioner.New(client, params)
+	err = envconfig.Process("provisioner", &clientParams)
+	if err != nil {
+		glog.Fatalf("Failed to load client params: %s", err)
+	}
+
+	// Retries are handled by our own client, which classifies errors and shares a rate limit
+	// across all requests, instead of by the AWS SDK.
+	awsConfig := aws.NewConfig().WithMaxRetries(0)
+
+	// Allows the provisioner to be pointed at the EFS emulator (see tools/efs-emulator).
+	if endpoint := os.Getenv("AWS_EFS_ENDPOINT"); endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(endpoint)
+	}
+
+	client := efsclient.New(efs.New(session.New(awsConfig)), clientParams)
+
+	// Only used when security groups are managed or subnets are discovered by the provisioner.
+	ec2Client := ec2.New(session.New(aws.NewConfig().WithMaxRetries(0)))
+	efsclient.Wrap(ec2Client.Client, clientParams)
+
+	// Events are recorded against claims so users can follow the progress of provisioning.
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(glog.Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events(corev1.NamespaceAll)})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: apiVersion})
+
+	// Only used when throughput is autoscaled.
+	cloudwatchClient := cloudwatch.New(session.New(aws.NewConfig().WithMaxRetries(0)))
+	efsclient.Wrap(cloudwatchClient.Client, clientParams)
+	metrics := provisioner.NewCloudWatchMetrics(cloudwatchClient)
+
+	provisioner, err := provisioner.New(client, params, provisioner.WithName(apiVersion), provisioner.WithEC2(ec2Client), provisioner.WithKubernetes(clientset), provisioner.WithRecorder(recorder), provisioner.WithMetrics(metrics))
 	if err != nil {
 		glog.Fatalf("Failed to create provisioner: %s", err)
 	}
 
+	// Polls the state of filesystems on behalf of all the claims being provisioned.
+	go provisioner.Run(wait.NeverStop)
+
 	glog.Infof("Running provisioner: %s", apiVersion)
 
+	options := []func(*controller.ProvisionController) error{
+		controller.CreateProvisionedPVInterval(time.Minute * 10),
+		controller.LeaseDuration(time.Minute * 10),
+	}
+
+	// Metrics from the controller and the provisioner are served together.
+	if port := os.Getenv("METRICS_PORT"); port != "" {
+		metricsPort, err := strconv.Atoi(port)
+		if err != nil {
+			glog.Fatalf("Invalid metrics port: %s", port)
+		}
+
+		options = append(options, controller.MetricsPort(int32(metricsPort)))
+	}
+
 	// Start the provision controller which will dynamically provision NFS PVs
-	pc := controller.NewProvisionController(clientset, apiVersion, provisioner, serverVersion.GitVersion, controller.CreateProvisionedPVInterval(time.Minute*10), controller.LeaseDuration(time.Minute*10))
+	pc := controller.NewProvisionController(clientset, apiVersion, provisioner, serverVersion.GitVersion, options...)
 	pc.Run(wait.NeverStop)
 }
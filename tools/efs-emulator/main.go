@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/previousnext/k8s-aws-efs/internal/emulator"
+	"github.com/previousnext/k8s-aws-efs/internal/provisioner/mock"
+)
+
+func main() {
+	params := mock.DefaultParams()
+
+	var (
+		listen  = flag.String("listen", ":8080", "Address to serve the EFS API on")
+		subnets = flag.String("subnets", "", "Comma separated list of subnets and their availability zones eg. subnet-a=ap-southeast-2a")
+	)
+
+	flag.StringVar(&params.Region, "region", params.Region, "Region reported in filesystem ARNs")
+	flag.StringVar(&params.Account, "account", params.Account, "Account which owns the filesystems")
+	flag.DurationVar(&params.FileSystemCreateDelay, "filesystem-create-delay", 5*time.Second, "How long filesystems take to become available")
+	flag.DurationVar(&params.FileSystemDeleteDelay, "filesystem-delete-delay", 5*time.Second, "How long filesystems take to be deleted")
+	flag.DurationVar(&params.MountTargetCreateDelay, "mount-target-create-delay", 5*time.Second, "How long mount targets take to become available")
+	flag.DurationVar(&params.MountTargetDeleteDelay, "mount-target-delete-delay", 5*time.Second, "How long mount targets take to be deleted")
+	flag.IntVar(&params.FileSystemLimit, "filesystem-limit", params.FileSystemLimit, "Maximum number of filesystems")
+
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	options := []mock.Option{
+		mock.WithParams(params),
+		mock.WithClock(mock.NewClock(time.Now())),
+	}
+
+	for _, subnet := range strings.Split(*subnets, ",") {
+		if subnet == "" {
+			continue
+		}
+
+		parts := strings.SplitN(subnet, "=", 2)
+		if len(parts) != 2 {
+			glog.Fatalf("Subnet must be in the format id=zone: %s", subnet)
+		}
+
+		options = append(options, mock.WithSubnet(parts[0], parts[1]))
+	}
+
+	client := mock.New(options...)
+
+	// The mock only moves forward when its clock is advanced, so keep it in step with
+	// the wall clock for resources to change state.
+	go func() {
+		last := time.Now()
+
+		for now := range time.Tick(time.Second) {
+			client.Advance(now.Sub(last))
+			last = now
+		}
+	}()
+
+	glog.Infof("Serving the EFS API on %s", *listen)
+
+	err := http.ListenAndServe(*listen, emulator.New(client))
+	if err != nil {
+		glog.Fatalf("Failed to serve: %s", err)
+	}
+}